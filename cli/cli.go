@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -20,10 +21,15 @@ var (
 
 	dump         = app.Command("dump", "Display information about a certificate from a file or stdin.")
 	dumpFiles    = dump.Arg("file", "Certificate file to dump (or stdin if not specified).").ExistingFiles()
-	dumpType     = dump.Flag("format", "Format of given input (PEM, DER, JCEKS, PKCS12; heuristic if missing).").Short('f').String()
+	dumpType     = dump.Flag("format", "Format of given input (PEM, DER, HEX, TLS, PE, JSON, KUBECONFIG, LDIF, CERTDATA, JCEKS, BKS, PKCS12; heuristic if missing).").Short('f').String()
 	dumpPassword = dump.Flag("password", "Password for PKCS12/JCEKS key stores (reads from TTY if missing).").Short('p').String()
 	dumpPem      = dump.Flag("pem", "Write output as PEM blocks instead of human-readable format.").Short('m').Bool()
 	dumpJSON     = dump.Flag("json", "Write output as machine-readable JSON format.").Short('j').Bool()
+	dumpStats    = dump.Flag("stats", "Only print counts of certificates, keys, expired certificates, etc.").Bool()
+	dumpSummary  = dump.Flag("summary", "Print one tab-separated line per certificate: SHA-256 fingerprint, subject CN, expiry, issuer CN.").Bool()
+	dumpGzip     = dump.Flag("gzip", "With --pem, compress the output with gzip.").Bool()
+	dumpGzLevel  = dump.Flag("gzip-level", "Compression level for --gzip, from 1 (fastest) to 9 (smallest).").Default("-1").Int()
+	dumpAnnotate = dump.Flag("annotate", "With --pem, precede each certificate with comment lines describing it.").Bool()
 
 	connect         = app.Command("connect", "Connect to a server and print its certificate(s).")
 	connectTo       = connect.Arg("server[:port]", "Hostname or IP to connect to, with optional port.").Required().String()
@@ -38,10 +44,11 @@ var (
 	connectPem      = connect.Flag("pem", "Write output as PEM blocks instead of human-readable format.").Short('m').Bool()
 	connectJSON     = connect.Flag("json", "Write output as machine-readable JSON format.").Short('j').Bool()
 	connectVerify   = connect.Flag("verify", "Verify certificate chain.").Bool()
+	connectPinning  = connect.Flag("http-headers", "Fetch HTTP response headers, and report key pinning (HPKP) and Expect-CT policies.").Bool()
 
 	verify         = app.Command("verify", "Verify a certificate chain from file/stdin against a name.")
 	verifyFile     = verify.Arg("file", "Certificate file to dump (or stdin if not specified).").ExistingFile()
-	verifyType     = verify.Flag("format", "Format of given input (PEM, DER, JCEKS, PKCS12; heuristic if missing).").Short('f').String()
+	verifyType     = verify.Flag("format", "Format of given input (PEM, DER, HEX, TLS, PE, JSON, KUBECONFIG, LDIF, CERTDATA, JCEKS, BKS, PKCS12; heuristic if missing).").Short('f').String()
 	verifyPassword = verify.Flag("password", "Password for PKCS12/JCEKS key stores (reads from TTY if missing).").Short('p').String()
 	verifyName     = verify.Flag("name", "Server name to verify certificate against.").Short('n').Required().String()
 	verifyCaPath   = verify.Flag("ca", "Path to CA bundle (system default if unspecified).").ExistingFile()
@@ -80,6 +87,12 @@ func Run(args []string, tty terminal.Terminal) int {
 	}
 	switch command {
 	case dump.FullCommand(): // Dump certificate
+		if !*dumpPem && (*dumpGzip || *dumpGzLevel != -1) {
+			return printErr("error: --gzip and --gzip-level can only be used with --pem, try --help\n")
+		}
+		if !*dumpPem && *dumpAnnotate {
+			return printErr("error: --annotate can only be used with --pem, try --help\n")
+		}
 		if dumpPassword != nil && *dumpPassword != "" {
 			tty.SetDefaultPassword(*dumpPassword)
 		}
@@ -90,14 +103,45 @@ func Run(args []string, tty terminal.Terminal) int {
 				file.Close()
 			}
 		}()
+		opts := lib.ReadOptions{Format: *dumpType, Password: tty.ReadPassword, PasswordPrompts: *dumpPassword == ""}
 
-		if *dumpPem {
-			err = lib.ReadAsPEMFromFiles(files, *dumpType, tty.ReadPassword, func(block *pem.Block, format string) error {
+		if *dumpStats {
+			var stats *lib.BundleStats
+			stats, err = lib.ReadStats(lib.FilesToReaders(files), opts)
+			if err == nil {
+				if *dumpJSON {
+					blob, _ := json.Marshal(stats)
+					fmt.Fprintln(stdout, string(blob))
+				} else {
+					fmt.Fprint(stdout, lib.EncodeStatsToText(stats))
+				}
+			}
+		} else if *dumpSummary {
+			err = lib.ReadX509WithOptions(lib.FilesToReaders(files), opts, lib.SummaryWriter(stdout))
+		} else if *dumpPem {
+			out := stdout
+			var gz io.WriteCloser
+			if *dumpGzip {
+				gz, err = lib.CompressedWriter(stdout, *dumpGzLevel)
+				if err != nil {
+					return printErr("error: %s\n", err)
+				}
+				out = gz
+			}
+			err = lib.ReadPEMWithOptions(lib.FilesToReaders(files), opts, func(block *pem.Block, format string) error {
 				block.Headers = nil
-				return pem.Encode(stdout, block)
+				if *dumpAnnotate {
+					return lib.EncodeAnnotatedPEM(out, block)
+				}
+				return pem.Encode(out, block)
 			})
+			if gz != nil {
+				if closeErr := gz.Close(); err == nil {
+					err = closeErr
+				}
+			}
 		} else {
-			err = lib.ReadAsX509FromFiles(files, *dumpType, tty.ReadPassword, func(cert *x509.Certificate, format string, err error) error {
+			err = lib.ReadX509WithOptions(lib.FilesToReaders(files), opts, func(cert *x509.Certificate, format string, err error) error {
 				if err != nil {
 					return fmt.Errorf("error parsing block: %s\n", strings.TrimSuffix(err.Error(), "\n"))
 				} else {
@@ -120,7 +164,7 @@ func Run(args []string, tty terminal.Terminal) int {
 		}
 		if err != nil {
 			return printErr("error: %s\n", strings.TrimSuffix(err.Error(), "\n"))
-		} else if len(result.Certificates) == 0 && !*dumpPem {
+		} else if len(result.Certificates) == 0 && !*dumpPem && !*dumpStats && !*dumpSummary {
 			printErr("warning: no certificates found in input\n")
 		}
 
@@ -128,6 +172,9 @@ func Run(args []string, tty terminal.Terminal) int {
 		if connectStartTLS == nil && connectIdentity != nil {
 			return printErr("error: --identity can only be used with --start-tls")
 		}
+		if *connectStartTLS != "" && *connectPinning {
+			return printErr("error: --http-headers can't be used with --start-tls\n")
+		}
 		connState, cri, err := starttls.GetConnectionState(
 			*connectStartTLS, *connectName, *connectTo, *connectIdentity,
 			*connectCert, *connectKey, *connectProxy, *connectTimeout)
@@ -153,6 +200,11 @@ func Run(args []string, tty terminal.Terminal) int {
 		verifyResult := lib.VerifyChain(connState.PeerCertificates, connState.OCSPResponse, hostname, *connectCaPath)
 		result.VerifyResult = &verifyResult
 
+		var pinningErr error
+		if *connectPinning {
+			result.PinningPolicy, pinningErr = lib.FetchPinningPolicy(*connectTo, *connectName, *connectProxy, *connectTimeout)
+		}
+
 		if *connectJSON {
 			blob, _ := json.Marshal(result)
 			fmt.Println(string(blob))
@@ -166,6 +218,12 @@ func Run(args []string, tty terminal.Terminal) int {
 				fmt.Fprintf(stdout, "%s\n\n", lib.EncodeX509ToText(cert, terminalWidth, *verbose))
 			}
 			lib.PrintVerifyResult(stdout, *result.VerifyResult)
+			if *connectPinning && pinningErr == nil {
+				fmt.Fprintf(stdout, "\n%s", lib.EncodePinningPolicyToText(result.PinningPolicy))
+			}
+		}
+		if pinningErr != nil {
+			printErr("warning: %s\n", pinningErr)
 		}
 
 		if *connectVerify && len(result.VerifyResult.Error) > 0 {
@@ -183,7 +241,8 @@ func Run(args []string, tty terminal.Terminal) int {
 		defer file.Close()
 
 		chain := []*x509.Certificate{}
-		err = lib.ReadAsX509FromFiles([]*os.File{file}, *verifyType, tty.ReadPassword, func(cert *x509.Certificate, format string, err error) error {
+		opts := lib.ReadOptions{Format: *verifyType, Password: tty.ReadPassword, PasswordPrompts: *verifyPassword == ""}
+		err = lib.ReadX509WithOptions([]io.Reader{file}, opts, func(cert *x509.Certificate, format string, err error) error {
 			if err != nil {
 				return err
 			} else {
@@ -154,6 +154,16 @@ func TestDumpMissingFile(t *testing.T) {
 	assert.Empty(t, testTerminal.OutputBuf.Bytes())
 }
 
+func TestDumpFlagsWithoutPem(t *testing.T) {
+	for _, flag := range []string{"--gzip", "--gzip-level=9", "--annotate"} {
+		testTerminal := terminal.TestTerminal{Width: 80}
+		args := []string{"dump", flag, "../test-certs/example-leaf.crt"}
+		assert.EqualValues(t, 2, Run(args, &testTerminal), "process should exit 2 for %s", flag)
+		assert.Contains(t, testTerminal.ErrorBuf.String(), "can only be used with --pem")
+		assert.Empty(t, testTerminal.OutputBuf.Bytes())
+	}
+}
+
 func TestConnect(t *testing.T) {
 	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 	defer ts.Close()
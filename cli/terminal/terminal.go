@@ -18,7 +18,7 @@ type Terminal interface {
 	Output() io.Writer
 	Error() io.Writer
 	SetDefaultPassword(password string)
-	ReadPassword(prompt string) string
+	ReadPassword(prompt string) (string, error)
 	DetermineWidth() int
 }
 
@@ -43,9 +43,9 @@ func (t *TTY) SetDefaultPassword(password string) {
 	t.defaultPassword = &password
 }
 
-func (t *TTY) ReadPassword(prompt string) string {
+func (t *TTY) ReadPassword(prompt string) (string, error) {
 	if t.defaultPassword != nil {
-		return *t.defaultPassword
+		return *t.defaultPassword, nil
 	}
 
 	var tty *os.File
@@ -65,11 +65,10 @@ func (t *TTY) ReadPassword(prompt string) string {
 	password, err := terminal.ReadPassword(int(tty.Fd()))
 	tty.WriteString("\n")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading password: %s\n", err)
-		os.Exit(1)
+		return "", fmt.Errorf("error reading password: %s", err)
 	}
 
-	return strings.TrimSuffix(string(password), "\n")
+	return strings.TrimSuffix(string(password), "\n"), nil
 }
 
 func (t *TTY) DetermineWidth() int {
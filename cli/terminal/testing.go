@@ -30,8 +30,8 @@ func (t *TestTerminal) SetDefaultPassword(password string) {
 	t.Password = password
 }
 
-func (t *TestTerminal) ReadPassword(prompt string) string {
-	return t.Password
+func (t *TestTerminal) ReadPassword(prompt string) (string, error) {
+	return t.Password, nil
 }
 
 func (t TestTerminal) DetermineWidth() int {
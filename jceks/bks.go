@@ -0,0 +1,244 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jceks
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// BKS entry types, as in BouncyCastle's BcKeyStoreSpi.
+const (
+	bksEntryNull        = 0
+	bksEntryCertificate = 1
+	bksEntryKey         = 2
+	bksEntrySecret      = 3
+	bksEntrySealed      = 4
+)
+
+// BKS key types, for key entries.
+const (
+	bksKeyPrivate = 0
+	bksKeyPublic  = 1
+	bksKeySecret  = 2
+)
+
+// maxBKSIterations caps the iteration count of the MAC key derivation.
+// BouncyCastle uses between 1024 and 2047; a huge count from a malformed
+// file would take forever to derive.
+const maxBKSIterations = 1 << 16
+
+// IsBKSVersion checks if the first four bytes of a file (as a big-endian
+// integer) look like the version number that BKS key stores start with.
+func IsBKSVersion(version uint32) bool {
+	return version == 1 || version == 2
+}
+
+// LoadBKSFromReader loads a BKS (BouncyCastle) key store. Only the
+// certificates can be read: trusted certificates, and the certificate
+// chains of key entries. Keys in a BKS key store are encrypted with
+// algorithms this package doesn't implement, so recovering them fails.
+func LoadBKSFromReader(reader io.Reader, password []byte) (*KeyStore, error) {
+	ks := &KeyStore{
+		entries: make(map[string]interface{}),
+	}
+	err := ks.ParseBKS(reader, password)
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// ParseBKS parses a BKS key store. If the password isn't nil, the integrity
+// of the key store is verified with it (for version 2 key stores; version 1
+// used a flawed MAC key derivation that isn't supported).
+func (ks *KeyStore) ParseBKS(r io.Reader, password []byte) error {
+	version, err := readUint32(r)
+	if err != nil {
+		return err
+	}
+	if !IsBKSVersion(version) {
+		return fmt.Errorf("unexpected BKS version: %d", version)
+	}
+	salt, err := readBytes(r)
+	if err != nil {
+		return err
+	}
+	iterations, err := readInt32(r)
+	if err != nil {
+		return err
+	}
+	if iterations < 0 || iterations > maxBKSIterations {
+		return fmt.Errorf("invalid BKS iteration count: %d", iterations)
+	}
+
+	var mac hash.Hash
+	if password != nil && version == 2 {
+		key := pkcs12DeriveMACKey(password, salt, int(iterations))
+		mac = hmac.New(sha1.New, key)
+		r = io.TeeReader(r, mac)
+	}
+
+	for {
+		var tag [1]byte
+		if _, err := io.ReadFull(r, tag[:]); err != nil {
+			return err
+		}
+		if tag[0] == bksEntryNull {
+			break
+		}
+		if err := ks.parseBKSEntry(r, tag[0]); err != nil {
+			return err
+		}
+	}
+
+	if mac != nil {
+		computed := mac.Sum(nil)
+		actual := make([]byte, len(computed))
+		_, err := io.ReadFull(r, actual)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare(computed, actual) != 1 {
+			return fmt.Errorf("keystore was tampered with or password was incorrect")
+		}
+	}
+	return nil
+}
+
+func (ks *KeyStore) parseBKSEntry(r io.Reader, tag byte) error {
+	alias, err := readUTF(r)
+	if err != nil {
+		return err
+	}
+	date, err := readDate(r)
+	if err != nil {
+		return err
+	}
+	nCerts, err := readInt32(r)
+	if err != nil {
+		return err
+	}
+	certs := []*x509.Certificate{}
+	for j := 0; j < int(nCerts); j++ {
+		cert, err := readBKSCertificate(r)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	switch tag {
+	case bksEntryCertificate:
+		cert, err := readBKSCertificate(r)
+		if err != nil {
+			return err
+		}
+		ks.entries[alias] = &trustedCertEntry{date: date, cert: cert}
+	case bksEntryKey:
+		var keyType [1]byte
+		if _, err := io.ReadFull(r, keyType[:]); err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			// Key encoding format and algorithm
+			if _, err := readUTF(r); err != nil {
+				return err
+			}
+		}
+		if _, err := readBytes(r); err != nil {
+			return err
+		}
+		if keyType[0] == bksKeyPrivate {
+			ks.entries[alias] = &privateKeyEntry{date: date, certs: certs}
+		}
+	case bksEntrySecret, bksEntrySealed:
+		if _, err := readBytes(r); err != nil {
+			return err
+		}
+		if len(certs) > 0 {
+			// Sealed private key, with its certificate chain
+			ks.entries[alias] = &privateKeyEntry{date: date, certs: certs}
+		}
+	default:
+		return fmt.Errorf("unimplemented BKS entry type: %d", tag)
+	}
+	return nil
+}
+
+func readBKSCertificate(r io.Reader) (*x509.Certificate, error) {
+	certType, err := readUTF(r)
+	if err != nil {
+		return nil, err
+	}
+	if certType != "X.509" {
+		return nil, fmt.Errorf("unable to handle certificate type: %s", certType)
+	}
+	certBytes, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certBytes)
+}
+
+// pkcs12DeriveMACKey derives the 160-bit HMAC-SHA1 key used to protect the
+// integrity of BKS key stores, with the PKCS#12 key derivation function (see
+// RFC 7292, appendix B.2).
+func pkcs12DeriveMACKey(password, salt []byte, iterations int) []byte {
+	const v = 64 // SHA-1 block size
+	const macKeyID = 3
+
+	// The password is used as a null-terminated BMPString. Like for JCEKS,
+	// each byte of the password is taken as a character.
+	bmpPassword := make([]byte, 2*len(password)+2)
+	for i, c := range password {
+		bmpPassword[2*i+1] = c
+	}
+
+	fill := func(data []byte) []byte {
+		if len(data) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(data)+v-1)/v))
+		for i := range out {
+			out[i] = data[i%len(data)]
+		}
+		return out
+	}
+
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = macKeyID
+	}
+
+	md := sha1.New()
+	md.Write(d)
+	md.Write(fill(salt))
+	md.Write(fill(bmpPassword))
+	key := md.Sum(nil)
+	for i := 1; i < iterations; i++ {
+		md.Reset()
+		md.Write(key)
+		key = md.Sum(key[:0])
+	}
+	return key
+}
@@ -0,0 +1,145 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jceks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestPKCS12DeriveMACKey(t *testing.T) {
+	// Test vector from BouncyCastle's PKCS12 key generator tests
+	salt, _ := hex.DecodeString("3D83C0E4546AC140")
+	key := pkcs12DeriveMACKey([]byte("smeg"), salt, 1)
+	if expected := "8d967d88f6caa9d714800ab3d48051d63f73a312"; hex.EncodeToString(key) != expected {
+		t.Fatalf("unexpected key: %x != %s", key, expected)
+	}
+}
+
+// buildBKS builds a version 2 BKS key store with a trusted cert entry and a
+// sealed private key entry.
+func buildBKS(t *testing.T, trusted, keyCert *x509.Certificate, password string) []byte {
+	salt := []byte("0123456789abcdefghij")
+	iterations := 1024
+
+	var header, body bytes.Buffer
+	write := func(buf *bytes.Buffer, v interface{}) {
+		if err := binary.Write(buf, binary.BigEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeUTF := func(v string) {
+		write(&body, uint16(len(v)))
+		body.WriteString(v)
+	}
+	writeCert := func(cert *x509.Certificate) {
+		writeUTF("X.509")
+		write(&body, uint32(len(cert.Raw)))
+		body.Write(cert.Raw)
+	}
+
+	write(&header, uint32(2))
+	write(&header, uint32(len(salt)))
+	header.Write(salt)
+	write(&header, uint32(iterations))
+
+	body.WriteByte(bksEntryCertificate)
+	writeUTF("trusted")
+	write(&body, int64(0))
+	write(&body, uint32(0))
+	writeCert(trusted)
+
+	body.WriteByte(bksEntrySealed)
+	writeUTF("key")
+	write(&body, int64(0))
+	write(&body, uint32(1))
+	writeCert(keyCert)
+	write(&body, uint32(4))
+	body.WriteString("junk")
+
+	body.WriteByte(bksEntryNull)
+
+	mac := hmac.New(sha1.New, pkcs12DeriveMACKey([]byte(password), salt, iterations))
+	mac.Write(body.Bytes())
+	return append(append(header.Bytes(), body.Bytes()...), mac.Sum(nil)...)
+}
+
+func TestBKS(t *testing.T) {
+	trusted, err := LoadPEMCert(newTestData("trusted-cert").certFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyCert, err := LoadPEMCert(newTestData("private-key").certFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buildBKS(t, trusted, keyCert, "bks-password")
+
+	ks, err := LoadBKSFromReader(bytes.NewReader(data), []byte("bks-password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := ks.ListEntries()
+	if len(entries) != 2 {
+		t.Fatalf("unexpected number of entries: %d", len(entries))
+	}
+	if entries[0].Alias != "key" || entries[0].Type != PrivateKeyEntry || len(entries[0].Certs) != 1 || !entries[0].Certs[0].Equal(keyCert) {
+		t.Errorf("unexpected key entry: %+v", entries[0])
+	}
+	if entries[1].Alias != "trusted" || entries[1].Type != TrustedCertEntry || !entries[1].Certs[0].Equal(trusted) {
+		t.Errorf("unexpected trusted cert entry: %+v", entries[1])
+	}
+
+	if _, _, err := ks.GetPrivateKeyAndCerts("key", []byte("bks-password")); err == nil {
+		t.Error("expected error recovering BKS private key")
+	}
+
+	if _, err := LoadBKSFromReader(bytes.NewReader(data), []byte("wrong")); err == nil || !strings.Contains(err.Error(), "password was incorrect") {
+		t.Errorf("expected integrity check to fail with wrong password, got: %v", err)
+	}
+	if _, err := LoadBKSFromReader(bytes.NewReader(data), nil); err != nil {
+		t.Errorf("unexpected error without password: %s", err)
+	}
+}
+
+func TestBKSMalformed(t *testing.T) {
+	testCases := []struct {
+		name, data, expected string
+	}{
+		{"negative salt length", "00000002" + "ffffffff", "invalid length"},
+		{"truncated salt", "00000002" + "7fffffff" + "0001", "unexpected EOF"},
+		{"huge iteration count", "00000002" + "00000001" + "00" + "7fffffff" + "00", "invalid BKS iteration count"},
+		{"negative iteration count", "00000002" + "00000001" + "00" + "ffffffff" + "00", "invalid BKS iteration count"},
+	}
+	for _, tc := range testCases {
+		data, err := hex.DecodeString(tc.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadBKSFromReader(bytes.NewReader(data), []byte("password"))
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%s: expected error containing %q, got: %v", tc.name, tc.expected, err)
+		}
+	}
+}
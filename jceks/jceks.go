@@ -24,6 +24,7 @@ package jceks
 import (
 	"bytes"
 	"crypto"
+	"crypto/dsa"
 	"crypto/sha1"
 	"crypto/subtle"
 	"crypto/x509"
@@ -33,8 +34,12 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"math/big"
 	"os"
+	"sort"
 	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 const (
@@ -47,8 +52,29 @@ var (
 	oidKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
 	oidPublicKeyRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
 	oidPublicKeyEC  = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidPublicKeyDSA = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 1}
 )
 
+// Named curves the x509 package supports for EC keys.
+var supportedCurves = map[string]string{
+	"1.3.132.0.33":        "P-224",
+	"1.2.840.10045.3.1.7": "P-256",
+	"1.3.132.0.34":        "P-384",
+	"1.3.132.0.35":        "P-521",
+}
+
+// Names of some curves we can't handle, for better error messages.
+var unsupportedCurves = map[string]string{
+	"1.3.36.3.3.2.8.1.1.1":  "brainpoolP160r1",
+	"1.3.36.3.3.2.8.1.1.3":  "brainpoolP192r1",
+	"1.3.36.3.3.2.8.1.1.5":  "brainpoolP224r1",
+	"1.3.36.3.3.2.8.1.1.7":  "brainpoolP256r1",
+	"1.3.36.3.3.2.8.1.1.9":  "brainpoolP320r1",
+	"1.3.36.3.3.2.8.1.1.11": "brainpoolP384r1",
+	"1.3.36.3.3.2.8.1.1.13": "brainpoolP512r1",
+	"1.3.132.0.10":          "secp256k1",
+}
+
 type encryptedPrivateKeyInfo struct {
 	Algo         pkix.AlgorithmIdentifier
 	EncryptedKey []byte
@@ -66,6 +92,11 @@ type privateKeyEntry struct {
 	certs      []*x509.Certificate
 }
 
+// Dss-Parms, see RFC 3279
+type dsaParameters struct {
+	P, Q, G *big.Int
+}
+
 // From https://golang.org/src/crypto/x509/sec1.go (also: see RFC 5915)
 type ecPrivateKey struct {
 	Version       int
@@ -78,7 +109,13 @@ func (e *privateKeyEntry) String() string {
 	return fmt.Sprintf("private-key: %s", e.date)
 }
 
-func (e *privateKeyEntry) Recover(password []byte) (crypto.PrivateKey, error) {
+// decrypt returns the decrypted key of the entry, a PKCS#8 PrivateKeyInfo
+// (without padding).
+func (e *privateKeyEntry) decrypt(password []byte) ([]byte, error) {
+	if e.encodedKey == nil {
+		// Read from a BKS key store, see LoadBKSFromReader
+		return nil, fmt.Errorf("unsupported private-key encryption")
+	}
 	var eKey encryptedPrivateKeyInfo
 	_, err := asn1.Unmarshal(e.encodedKey, &eKey)
 	if err != nil {
@@ -99,6 +136,19 @@ func (e *privateKeyEntry) Recover(password []byte) (crypto.PrivateKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Cut off the block cipher padding
+	rest, err := asn1.Unmarshal(decryptedKey, &asn1.RawValue{})
+	if err != nil {
+		return nil, err
+	}
+	return decryptedKey[:len(decryptedKey)-len(rest)], nil
+}
+
+func (e *privateKeyEntry) Recover(password []byte) (crypto.PrivateKey, error) {
+	decryptedKey, err := e.decrypt(password)
+	if err != nil {
+		return nil, err
+	}
 
 	var pKey privateKeyInfo
 	if _, err := asn1.Unmarshal(decryptedKey, &pKey); err != nil {
@@ -124,11 +174,38 @@ func (e *privateKeyEntry) Recover(password []byte) (crypto.PrivateKey, error) {
 		if err != nil {
 			return nil, fmt.Errorf("problem parsing ec key asn.1 struct: %s", err)
 		}
+		if _, ok := supportedCurves[oid.String()]; !ok {
+			if name, ok := unsupportedCurves[oid.String()]; ok {
+				return nil, fmt.Errorf("unsupported elliptic curve: %s (%v)", name, oid)
+			}
+			return nil, fmt.Errorf("unsupported elliptic curve: %v", oid)
+		}
 		// Update key to add named curve info, re-marshal, and parse
 		key.NamedCurveOID = oid
 		raw, _ := asn1.Marshal(key)
 		return x509.ParseECPrivateKey(raw)
 	}
+	if pKey.Algo.Algorithm.Equal(oidPublicKeyDSA) {
+		// Legacy DSA keys, as found in stores from older Java apps. The
+		// domain parameters are in the algorithm identifier, and the key
+		// blob holds only the private value, so we derive the public one.
+		var params dsaParameters
+		if _, err := asn1.Unmarshal(pKey.Algo.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("problem parsing dsa key parameters: %s", err)
+		}
+		x := new(big.Int)
+		if _, err := asn1.Unmarshal(pKey.PrivateKey, &x); err != nil {
+			return nil, fmt.Errorf("problem parsing dsa key: %s", err)
+		}
+		key := &dsa.PrivateKey{
+			PublicKey: dsa.PublicKey{
+				Parameters: dsa.Parameters{P: params.P, Q: params.Q, G: params.G},
+				Y:          new(big.Int).Exp(params.G, x, params.P),
+			},
+			X: x,
+		}
+		return key, nil
+	}
 	return nil, fmt.Errorf("unsupported private-key algorithm: %v", pKey.Algo.Algorithm)
 }
 
@@ -141,9 +218,77 @@ func (e *trustedCertEntry) String() string {
 	return fmt.Sprintf("trusted-cert: %s", e.date)
 }
 
+type secretKeyEntry struct {
+	date   time.Time
+	sealed *javaObject
+}
+
+func (e *secretKeyEntry) String() string {
+	return fmt.Sprintf("secret-key: %s", e.date)
+}
+
+// Recover unseals the secret key, which is stored as a serialized
+// javax.crypto.SealedObject wrapping a serialized SecretKeySpec (or
+// KeyRep). Returns the raw key bytes and the key algorithm.
+func (e *secretKeyEntry) Recover(password []byte) ([]byte, string, error) {
+	sealAlg, _ := e.sealed.fields["sealAlg"].(string)
+	if sealAlg != "PBEWithMD5AndTripleDES" {
+		return nil, "", fmt.Errorf("unsupported secret-key seal algorithm: %s", sealAlg)
+	}
+	encodedParams, _ := e.sealed.fields["encodedParams"].([]byte)
+	encryptedContent, _ := e.sealed.fields["encryptedContent"].([]byte)
+	if encodedParams == nil || encryptedContent == nil {
+		return nil, "", fmt.Errorf("malformed sealed secret-key entry")
+	}
+
+	algo := pkix.AlgorithmIdentifier{
+		Algorithm:  oidPBEWithMD5AndDES3CBC,
+		Parameters: asn1.RawValue{FullBytes: encodedParams},
+	}
+	decrypted, err := recoverPBEWithMD5AndDES3CBC(algo, encryptedContent, password)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Strip PKCS#5 padding. Invalid padding almost always means that the
+	// password was wrong.
+	padding := 0
+	if len(decrypted) > 0 {
+		padding = int(decrypted[len(decrypted)-1])
+	}
+	if padding < 1 || padding > len(decrypted) || padding > 8 {
+		return nil, "", fmt.Errorf("unable to unseal secret key, password may be incorrect")
+	}
+	for _, b := range decrypted[len(decrypted)-padding:] {
+		if int(b) != padding {
+			return nil, "", fmt.Errorf("unable to unseal secret key, password may be incorrect")
+		}
+	}
+	decrypted = decrypted[:len(decrypted)-padding]
+
+	obj, err := readJavaObject(bytes.NewReader(decrypted))
+	if err != nil {
+		return nil, "", err
+	}
+	key, ok := obj.(*javaObject)
+	if !ok {
+		return nil, "", fmt.Errorf("unexpected sealed content: %T", obj)
+	}
+	algorithm, _ := key.fields["algorithm"].(string)
+	switch key.class.name {
+	case "javax.crypto.spec.SecretKeySpec":
+		raw, _ := key.fields["key"].([]byte)
+		return raw, algorithm, nil
+	case "java.security.KeyRep":
+		raw, _ := key.fields["encoded"].([]byte)
+		return raw, algorithm, nil
+	}
+	return nil, "", fmt.Errorf("unsupported secret-key class: %s", key.class.name)
+}
+
 // KeyStore maintains a map from alias name to the entry for that
-// alias. Entries are currently either privateKeyEntry or
-// trustedCertEntry.
+// alias. Entries are currently either privateKeyEntry,
+// trustedCertEntry or secretKeyEntry.
 type KeyStore struct {
 	entries map[string]interface{}
 }
@@ -161,22 +306,70 @@ func readUTF(r io.Reader) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return string(buf), nil
+	return decodeModifiedUTF8(buf), nil
+}
+
+// decodeModifiedUTF8 decodes Java's "modified UTF-8" (as written by
+// DataOutput.writeUTF), which differs from standard UTF-8 in encoding NUL
+// as two bytes (C0 80), and characters outside the BMP as the two UTF-16
+// surrogates, each encoded as three bytes.
+func decodeModifiedUTF8(buf []byte) string {
+	if utf8.Valid(buf) && bytes.IndexByte(buf, 0xC0) < 0 {
+		return string(buf)
+	}
+
+	var out []rune
+	for len(buf) > 0 {
+		if len(buf) >= 2 && buf[0] == 0xC0 && buf[1] == 0x80 {
+			out = append(out, 0)
+			buf = buf[2:]
+			continue
+		}
+		if high, ok := decodeSurrogate(buf); ok && len(buf) >= 6 {
+			if low, ok := decodeSurrogate(buf[3:]); ok {
+				if r := utf16.DecodeRune(high, low); r != utf8.RuneError {
+					out = append(out, r)
+					buf = buf[6:]
+					continue
+				}
+			}
+		}
+		r, size := utf8.DecodeRune(buf)
+		out = append(out, r)
+		buf = buf[size:]
+	}
+	return string(out)
+}
+
+// decodeSurrogate decodes a UTF-16 surrogate encoded as three bytes, which
+// standard UTF-8 doesn't allow.
+func decodeSurrogate(buf []byte) (rune, bool) {
+	if len(buf) < 3 || buf[0] != 0xED || buf[1]&0xE0 != 0xA0 || buf[2]&0xC0 != 0x80 {
+		return 0, false
+	}
+	return 0xD000 | rune(buf[1]&0x3F)<<6 | rune(buf[2]&0x3F), true
 }
 
 // readBytes reads a byte array from the reader. The encoding provides
-// a 4-byte prefix indicating the number of bytes which follow.
+// a 4-byte prefix indicating the number of bytes which follow. The buffer
+// grows as the bytes are read, so that a bogus length fails at the end of
+// the input rather than allocating that much up front.
 func readBytes(r io.Reader) ([]byte, error) {
 	length, err := readInt32(r)
 	if err != nil {
 		return nil, err
 	}
-	buf := make([]byte, length)
-	_, err = io.ReadFull(r, buf)
-	if err != nil {
+	if length < 0 {
+		return nil, fmt.Errorf("invalid length: %d", length)
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
-	return buf, nil
+	return buf.Bytes(), nil
 }
 
 func readInt32(r io.Reader) (int32, error) {
@@ -304,6 +497,31 @@ func (ks *KeyStore) parseTrustedCert(r io.Reader) error {
 	return nil
 }
 
+func (ks *KeyStore) parseSecretKey(r io.Reader) error {
+	alias, err := readUTF(r)
+	if err != nil {
+		return err
+	}
+	entry := &secretKeyEntry{}
+	entry.date, err = readDate(r)
+	if err != nil {
+		return err
+	}
+
+	obj, err := readJavaObject(r)
+	if err != nil {
+		return fmt.Errorf("unable to read secret-key entry: %s", err)
+	}
+	sealed, ok := obj.(*javaObject)
+	if !ok || sealed.class.name != "javax.crypto.SealedObject" && (sealed.class.super == nil || sealed.class.super.name != "javax.crypto.SealedObject") {
+		return fmt.Errorf("unexpected secret-key entry: %T", obj)
+	}
+	entry.sealed = sealed
+
+	ks.entries[alias] = entry
+	return nil
+}
+
 // Parse parses the key store from the specified reader.
 func (ks *KeyStore) Parse(r io.Reader, password []byte) error {
 	var md hash.Hash
@@ -344,7 +562,10 @@ func (ks *KeyStore) Parse(r io.Reader, password []byte) error {
 			}
 		case 3:
 			// Secret-key entry
-			return fmt.Errorf("unimplemented: Secret-key")
+			err := ks.parseSecretKey(r)
+			if err != nil {
+				return err
+			}
 		default:
 			return fmt.Errorf("unimplemented tag: %d", tag)
 		}
@@ -389,6 +610,23 @@ func (ks *KeyStore) GetPrivateKeyAndCerts(alias string, password []byte) (
 	return
 }
 
+// GetPrivateKeyDER retrieves the specified private key as it is stored,
+// a DER-encoded PKCS#8 PrivateKeyInfo, without parsing it. Note that EC
+// keys in JCEKS key stores don't repeat the named curve in the inner key.
+// Returns nil if the private key does not exist or alias points to a non
+// private key entry.
+func (ks *KeyStore) GetPrivateKeyDER(alias string, password []byte) ([]byte, error) {
+	entry := ks.entries[alias]
+	if entry == nil {
+		return nil, nil
+	}
+	switch t := entry.(type) {
+	case *privateKeyEntry:
+		return t.decrypt(password)
+	}
+	return nil, nil
+}
+
 // GetCert retrieves the specified certificate. Returns nil if the
 // certificate does not exist or alias points to a non certificate
 // entry.
@@ -404,6 +642,21 @@ func (ks *KeyStore) GetCert(alias string) (*x509.Certificate, error) {
 	return nil, nil
 }
 
+// GetSecretKey retrieves the specified secret key, returning the raw key
+// bytes and the name of the key algorithm (e.g. "AES"). Returns nil if the
+// secret key does not exist or alias points to a non secret key entry.
+func (ks *KeyStore) GetSecretKey(alias string, password []byte) (key []byte, algorithm string, err error) {
+	entry := ks.entries[alias]
+	if entry == nil {
+		return
+	}
+	switch t := entry.(type) {
+	case *secretKeyEntry:
+		return t.Recover(password)
+	}
+	return
+}
+
 // ListPrivateKeys lists the names of the private keys stored in the key store.
 func (ks *KeyStore) ListPrivateKeys() []string {
 	var r []string
@@ -426,6 +679,56 @@ func (ks *KeyStore) ListCerts() []string {
 	return r
 }
 
+// ListSecretKeys lists the names of the secret keys stored in the key store.
+func (ks *KeyStore) ListSecretKeys() []string {
+	var r []string
+	for k, v := range ks.entries {
+		if _, ok := v.(*secretKeyEntry); ok {
+			r = append(r, k)
+		}
+	}
+	return r
+}
+
+// Entry types reported by ListEntries, named as keytool names them.
+const (
+	PrivateKeyEntry  = "PrivateKeyEntry"
+	TrustedCertEntry = "trustedCertEntry"
+	SecretKeyEntry   = "SecretKeyEntry"
+)
+
+// EntryInfo describes a key store entry, as returned by ListEntries.
+type EntryInfo struct {
+	Alias string
+	Type  string
+	Date  time.Time
+
+	// Certs holds the certificate chain of a private key entry, or the
+	// certificate of a trusted cert entry. It is empty for secret keys.
+	Certs []*x509.Certificate
+}
+
+// ListEntries lists all entries in the key store, ordered by alias. Since
+// certificates are stored in the clear, this does not require the
+// passwords of the key entries.
+func (ks *KeyStore) ListEntries() []EntryInfo {
+	var r []EntryInfo
+	for alias, v := range ks.entries {
+		info := EntryInfo{Alias: alias}
+		switch t := v.(type) {
+		case *privateKeyEntry:
+			info.Type, info.Date, info.Certs = PrivateKeyEntry, t.date, t.certs
+		case *trustedCertEntry:
+			info.Type, info.Date, info.Certs = TrustedCertEntry, t.date, []*x509.Certificate{t.cert}
+		case *secretKeyEntry:
+			info.Type, info.Date = SecretKeyEntry, t.date
+		}
+		r = append(r, info)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Alias < r[j].Alias })
+	return r
+}
+
 func (ks *KeyStore) String() string {
 	var buf bytes.Buffer
 	for k, v := range ks.entries {
@@ -18,13 +18,20 @@ package jceks
 
 import (
 	"bytes"
+	"crypto/cipher"
+	"crypto/des"
 	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -198,6 +205,72 @@ func TestPrivateKey(t *testing.T) {
 	}
 }
 
+func TestPrivateKeyDER(t *testing.T) {
+	d := newTestData("private-key")
+	ks, err := LoadFromFile(d.jceksFilename, []byte(d.storePassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := ks.GetPrivateKeyDER(d.alias, []byte(d.keyPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rest, err := asn1.Unmarshal(der, &asn1.RawValue{}); err != nil || len(rest) > 0 {
+		t.Fatalf("expected a single DER element, got error %v and %d trailing bytes", err, len(rest))
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := LoadPEMKey(d.keyFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalRSAPrivateKey(key.(*rsa.PrivateKey), expected) {
+		t.Fatalf("keys are not equal")
+	}
+
+	if der, err := ks.GetPrivateKeyDER("missing", []byte(d.keyPassword)); der != nil || err != nil {
+		t.Errorf("expected nothing for a missing alias, got %v, %v", der, err)
+	}
+}
+
+func TestListEntries(t *testing.T) {
+	d := newTestData("private-key")
+
+	// Only the store password is needed to list entries
+	ks, err := LoadFromFile(d.jceksFilename, []byte(d.storePassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := ks.ListEntries()
+	if len(entries) != 1 {
+		t.Fatalf("unexpected number of entries: %d != 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Alias != d.alias || entry.Type != PrivateKeyEntry || entry.Date.IsZero() {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	expectedCert, err := LoadPEMCert(d.certFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Certs) != 1 || !entry.Certs[0].Equal(expectedCert) {
+		t.Fatalf("unexpected certs in entry")
+	}
+
+	d = newTestData("trusted-cert")
+	ks, err = LoadFromFile(d.jceksFilename, []byte(d.storePassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries = ks.ListEntries()
+	if len(entries) != 1 || entries[0].Type != TrustedCertEntry || len(entries[0].Certs) != 1 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
 func TestTrustedCert(t *testing.T) {
 	d := newTestData("trusted-cert")
 	if *generateTestData {
@@ -232,3 +305,196 @@ func TestTrustedCert(t *testing.T) {
 		t.Fatalf("unexpected cert aliases: %s", certAliases)
 	}
 }
+
+// javaStream builds a (tiny subset of a) Java Object Serialization stream.
+type javaStream struct {
+	bytes.Buffer
+}
+
+func (s *javaStream) utf(v string) {
+	binary.Write(s, binary.BigEndian, uint16(len(v)))
+	s.WriteString(v)
+}
+
+func (s *javaStream) classDesc(name string, fields ...string) {
+	s.WriteByte(tcClassDesc)
+	s.utf(name)
+	binary.Write(s, binary.BigEndian, int64(42))
+	s.WriteByte(scSerializable)
+	binary.Write(s, binary.BigEndian, uint16(len(fields)/2))
+	for i := 0; i < len(fields); i += 2 {
+		s.WriteByte(fields[i+1][0])
+		s.utf(fields[i])
+		s.WriteByte(tcString)
+		s.utf(fields[i+1])
+	}
+	s.WriteByte(tcEndBlockData)
+	s.WriteByte(tcNull)
+}
+
+func (s *javaStream) byteArray(v []byte) {
+	s.WriteByte(tcArray)
+	s.classDesc("[B")
+	binary.Write(s, binary.BigEndian, int32(len(v)))
+	s.Write(v)
+}
+
+func (s *javaStream) string(v string) {
+	s.WriteByte(tcString)
+	s.utf(v)
+}
+
+// encryptPBEWithMD5AndDES3CBC encrypts the plaintext with the given password,
+// returning the encoded PBE parameters and the ciphertext.
+func encryptPBEWithMD5AndDES3CBC(t *testing.T, plaintext, password []byte) ([]byte, []byte) {
+	params := pbeParameters{Salt: []byte("saltsalt"), Iterations: 200}
+	encodedParams, err := asn1.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherKey, iv, err := deriveKeyPBEWithMD5AndDES3CBC(params.Salt, params.Iterations, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	des3, err := des.NewTripleDESCipher(cipherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := des.BlockSize - len(plaintext)%des.BlockSize
+	plaintext = append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	encrypted := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(des3, iv).CryptBlocks(encrypted, plaintext)
+	return encodedParams, encrypted
+}
+
+func sealSecretKey(t *testing.T, key []byte, algorithm string, password []byte) []byte {
+	var inner javaStream
+	binary.Write(&inner, binary.BigEndian, uint16(streamMagic))
+	binary.Write(&inner, binary.BigEndian, uint16(streamVersion))
+	inner.WriteByte(tcObject)
+	inner.classDesc("javax.crypto.spec.SecretKeySpec",
+		"algorithm", "Ljava/lang/String;", "key", "[B")
+	inner.string(algorithm)
+	inner.byteArray(key)
+
+	encodedParams, encrypted := encryptPBEWithMD5AndDES3CBC(t, inner.Bytes(), password)
+
+	var outer javaStream
+	binary.Write(&outer, binary.BigEndian, uint16(streamMagic))
+	binary.Write(&outer, binary.BigEndian, uint16(streamVersion))
+	outer.WriteByte(tcObject)
+	outer.classDesc("javax.crypto.SealedObject",
+		"encodedParams", "[B", "encryptedContent", "[B",
+		"paramsAlg", "Ljava/lang/String;", "sealAlg", "Ljava/lang/String;")
+	outer.byteArray(encodedParams)
+	outer.byteArray(encrypted)
+	outer.string("PBEWithMD5AndTripleDES")
+	outer.string("PBEWithMD5AndTripleDES")
+	return outer.Bytes()
+}
+
+func TestSecretKey(t *testing.T) {
+	storePassword := []byte("secret-key-store-password")
+	keyPassword := []byte("secret-key-key-password")
+	secret := []byte("0123456789abcdef")
+
+	var ks javaStream
+	binary.Write(&ks, binary.BigEndian, uint32(jceksMagic))
+	binary.Write(&ks, binary.BigEndian, uint32(jceksVersion))
+	binary.Write(&ks, binary.BigEndian, int32(1))
+	binary.Write(&ks, binary.BigEndian, int32(3))
+	ks.utf("secret-key-some-alias")
+	binary.Write(&ks, binary.BigEndian, int64(1500000000000))
+	ks.Write(sealSecretKey(t, secret, "AES", keyPassword))
+	md := getPreKeyedHash(storePassword)
+	md.Write(ks.Bytes())
+	ks.Write(md.Sum(nil))
+
+	keyStore, err := LoadFromReader(bytes.NewReader(ks.Bytes()), storePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliases := keyStore.ListSecretKeys()
+	if !reflect.DeepEqual(aliases, []string{"secret-key-some-alias"}) {
+		t.Fatalf("unexpected secret key aliases: %s", aliases)
+	}
+
+	key, algorithm, err := keyStore.GetSecretKey("secret-key-some-alias", keyPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, secret) || algorithm != "AES" {
+		t.Fatalf("unexpected secret key: %x (%s)", key, algorithm)
+	}
+
+	if _, _, err := keyStore.GetSecretKey("secret-key-some-alias", []byte("wrong")); err == nil {
+		t.Fatal("expected error with wrong password")
+	}
+}
+
+func TestPrivateKeyUnsupportedCurve(t *testing.T) {
+	password := []byte("password")
+	brainpoolP256r1 := asn1.ObjectIdentifier{1, 3, 36, 3, 3, 2, 8, 1, 1, 7}
+
+	curve, err := asn1.Marshal(brainpoolP256r1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := asn1.Marshal(ecPrivateKey{Version: 1, PrivateKey: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyInfo, err := asn1.Marshal(privateKeyInfo{
+		Algo:       pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyEC, Parameters: asn1.RawValue{FullBytes: curve}},
+		PrivateKey: ecKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, encrypted := encryptPBEWithMD5AndDES3CBC(t, keyInfo, password)
+	encodedKey, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algo:         pkix.AlgorithmIdentifier{Algorithm: oidPBEWithMD5AndDES3CBC, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedKey: encrypted,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &privateKeyEntry{encodedKey: encodedKey}
+	_, err = entry.Recover(password)
+	if err == nil || !strings.Contains(err.Error(), "brainpoolP256r1") {
+		t.Fatalf("expected error naming the curve, got: %v", err)
+	}
+}
+
+func TestNonASCIIAlias(t *testing.T) {
+	ks, err := LoadFromFile("testdata/non-ascii-alias.jceks", []byte("non-ascii-alias-store-password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certAliases := ks.ListCerts()
+	if !reflect.DeepEqual(certAliases, []string{"zertifikat-ä-😀"}) {
+		t.Fatalf("unexpected cert aliases: %q", certAliases)
+	}
+}
+
+func TestDecodeModifiedUTF8(t *testing.T) {
+	testCases := []struct {
+		encoded  []byte
+		expected string
+	}{
+		{[]byte("plain"), "plain"},
+		{[]byte("gr\xc3\xbc\xc3\x9fe"), "grüße"},
+		{[]byte("a\xc0\x80b"), "a\x00b"},
+		{[]byte("\xed\xa0\xbd\xed\xb8\x80"), "😀"},
+		// A lone surrogate can't be decoded
+		{[]byte("\xed\xa0\xbdx"), "���x"},
+	}
+	for _, tc := range testCases {
+		if decoded := decodeModifiedUTF8(tc.encoded); decoded != tc.expected {
+			t.Errorf("decoding %x: expected %q, got %q", tc.encoded, tc.expected, decoded)
+		}
+	}
+}
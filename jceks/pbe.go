@@ -55,15 +55,39 @@ func recoverPBEWithMD5AndDES3CBC(
 		return nil, err
 	}
 
+	cipherKey, iv, err := deriveKeyPBEWithMD5AndDES3CBC(params.Salt, params.Iterations, password)
+	if err != nil {
+		return nil, err
+	}
+
+	des3, err := des.NewTripleDESCipher(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypter := cipher.NewCBCDecrypter(des3, iv)
+	if (len(encryptedKey) % decrypter.BlockSize()) != 0 {
+		return nil, fmt.Errorf("encrypted data must be a multiple of block length: %d %d",
+			len(encryptedKey), decrypter.BlockSize())
+	}
+
+	decryptedKey := make([]byte, len(encryptedKey))
+	decrypter.CryptBlocks(decryptedKey, encryptedKey)
+	return decryptedKey, nil
+}
+
+// deriveKeyPBEWithMD5AndDES3CBC derives the triple DES key and IV from the
+// given salt, iteration count and password, as described above.
+func deriveKeyPBEWithMD5AndDES3CBC(salt []byte, iterations int, password []byte) (key, iv []byte, err error) {
 	// Convert password to byte array, so that it can be digested.
 	passwdBytes := make([]byte, len(password))
 	for i := 0; i < len(password); i++ {
 		passwdBytes[i] = password[i] & 0x7f
 	}
 
-	salt := params.Salt
+	salt = append([]byte{}, salt...)
 	if len(salt) != 8 {
-		return nil, fmt.Errorf("unexpected salt length: %d", len(salt))
+		return nil, nil, fmt.Errorf("unexpected salt length: %d", len(salt))
 	}
 
 	if bytes.Compare(salt[0:4], salt[4:]) == 0 {
@@ -85,7 +109,7 @@ func recoverPBEWithMD5AndDES3CBC(
 	for i := 0; i < 2; i++ {
 		n := len(salt) / 2
 		toBeHashed := salt[i*n : (i+1)*n]
-		for j := 0; j < params.Iterations; j++ {
+		for j := 0; j < iterations; j++ {
 			md.Write(toBeHashed)
 			md.Write(passwdBytes)
 			toBeHashed = md.Sum([]byte{})
@@ -94,21 +118,5 @@ func recoverPBEWithMD5AndDES3CBC(
 		copy(derivedKey[i*len(toBeHashed):], toBeHashed)
 	}
 
-	cipherKey := derivedKey[0:keyLen]
-	iv := derivedKey[keyLen:]
-
-	des3, err := des.NewTripleDESCipher(cipherKey)
-	if err != nil {
-		return nil, err
-	}
-
-	decrypter := cipher.NewCBCDecrypter(des3, iv)
-	if (len(encryptedKey) % decrypter.BlockSize()) != 0 {
-		return nil, fmt.Errorf("encrypted data must be a multiple of block length: %d %d",
-			len(encryptedKey), decrypter.BlockSize())
-	}
-
-	decryptedKey := make([]byte, len(encryptedKey))
-	decrypter.CryptBlocks(decryptedKey, encryptedKey)
-	return decryptedKey, nil
+	return derivedKey[0:keyLen], derivedKey[keyLen:], nil
 }
@@ -0,0 +1,450 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jceks
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// This file implements just enough of the Java Object Serialization Stream
+// Protocol to read the sealed objects that JCEKS uses for secret-key entries.
+// See: https://docs.oracle.com/javase/8/docs/platform/serialization/spec/protocol.html
+
+const (
+	streamMagic   = 0xaced
+	streamVersion = 5
+
+	tcNull          = 0x70
+	tcReference     = 0x71
+	tcClassDesc     = 0x72
+	tcObject        = 0x73
+	tcString        = 0x74
+	tcArray         = 0x75
+	tcClass         = 0x76
+	tcBlockData     = 0x77
+	tcEndBlockData  = 0x78
+	tcBlockDataLong = 0x7a
+	tcLongString    = 0x7c
+	tcEnum          = 0x7e
+
+	baseWireHandle = 0x7e0000
+
+	scWriteMethod    = 0x01
+	scSerializable   = 0x02
+	scExternalizable = 0x04
+	scBlockData      = 0x08
+
+	// Limits for malformed or malicious streams: the nesting depth of
+	// contents, and the number of classes in a hierarchy. Sealed objects
+	// are nowhere near either.
+	maxContentDepth   = 64
+	maxClassHierarchy = 64
+)
+
+type javaField struct {
+	typeCode byte
+	name     string
+}
+
+type javaClassDesc struct {
+	name   string
+	flags  byte
+	fields []javaField
+	super  *javaClassDesc
+}
+
+// javaObject is a deserialized object, with the field values of all classes
+// in its hierarchy merged into a single map.
+type javaObject struct {
+	class  *javaClassDesc
+	fields map[string]interface{}
+}
+
+type javaEnum struct {
+	class    *javaClassDesc
+	constant string
+}
+
+type objectReader struct {
+	r       io.Reader
+	handles []interface{}
+	depth   int
+}
+
+// readJavaObject reads a serialized Java object (including the stream
+// header) from the given reader, consuming no more input than necessary.
+func readJavaObject(r io.Reader) (interface{}, error) {
+	or := &objectReader{r: r}
+
+	var magic, version uint16
+	if err := binary.Read(r, binary.BigEndian, &magic); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	if magic != streamMagic || version != streamVersion {
+		return nil, fmt.Errorf("unexpected serialization stream header: %04x/%d", magic, version)
+	}
+
+	return or.readContent()
+}
+
+func (or *objectReader) readByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(or.r, b[:])
+	return b[0], err
+}
+
+func (or *objectReader) readUTF() (string, error) {
+	return readUTF(or.r)
+}
+
+func (or *objectReader) newHandle(obj interface{}) int {
+	or.handles = append(or.handles, obj)
+	return len(or.handles) - 1
+}
+
+func (or *objectReader) readContent() (interface{}, error) {
+	tc, err := or.readByte()
+	if err != nil {
+		return nil, err
+	}
+	return or.readContentWithCode(tc)
+}
+
+func (or *objectReader) readContentWithCode(tc byte) (interface{}, error) {
+	or.depth++
+	defer func() { or.depth-- }()
+	if or.depth > maxContentDepth {
+		return nil, fmt.Errorf("serialized objects nested too deeply")
+	}
+
+	switch tc {
+	case tcNull:
+		return nil, nil
+	case tcReference:
+		handle, err := readInt32(or.r)
+		if err != nil {
+			return nil, err
+		}
+		index := int(handle) - baseWireHandle
+		if index < 0 || index >= len(or.handles) {
+			return nil, fmt.Errorf("invalid object reference: %x", handle)
+		}
+		return or.handles[index], nil
+	case tcClassDesc:
+		return or.readClassDesc()
+	case tcObject:
+		return or.readNewObject()
+	case tcString:
+		s, err := or.readUTF()
+		if err != nil {
+			return nil, err
+		}
+		or.newHandle(s)
+		return s, nil
+	case tcLongString:
+		var length int64
+		if err := binary.Read(or.r, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		if length < 0 || length > 1<<24 {
+			return nil, fmt.Errorf("invalid string length: %d", length)
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(or.r, buf); err != nil {
+			return nil, err
+		}
+		or.newHandle(string(buf))
+		return string(buf), nil
+	case tcArray:
+		return or.readNewArray()
+	case tcEnum:
+		return or.readNewEnum()
+	case tcClass:
+		desc, err := or.readClassDescRef()
+		if err != nil {
+			return nil, err
+		}
+		or.newHandle(desc)
+		return desc, nil
+	case tcBlockData, tcBlockDataLong:
+		return nil, or.skipBlockData(tc)
+	}
+	return nil, fmt.Errorf("unsupported serialization type code: %02x", tc)
+}
+
+func (or *objectReader) readClassDescRef() (*javaClassDesc, error) {
+	obj, err := or.readContent()
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, nil
+	}
+	desc, ok := obj.(*javaClassDesc)
+	if !ok {
+		return nil, fmt.Errorf("expected class descriptor, got %T", obj)
+	}
+	return desc, nil
+}
+
+func (or *objectReader) readClassDesc() (*javaClassDesc, error) {
+	desc := &javaClassDesc{}
+
+	var err error
+	desc.name, err = or.readUTF()
+	if err != nil {
+		return nil, err
+	}
+	var serialVersionUID int64
+	if err := binary.Read(or.r, binary.BigEndian, &serialVersionUID); err != nil {
+		return nil, err
+	}
+	or.newHandle(desc)
+
+	desc.flags, err = or.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	var count uint16
+	if err := binary.Read(or.r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(count); i++ {
+		var field javaField
+		field.typeCode, err = or.readByte()
+		if err != nil {
+			return nil, err
+		}
+		field.name, err = or.readUTF()
+		if err != nil {
+			return nil, err
+		}
+		if field.typeCode == '[' || field.typeCode == 'L' {
+			// Class name of the field type, as a string object
+			if _, err := or.readContent(); err != nil {
+				return nil, err
+			}
+		}
+		desc.fields = append(desc.fields, field)
+	}
+
+	if err := or.skipAnnotation(); err != nil {
+		return nil, err
+	}
+
+	desc.super, err = or.readClassDescRef()
+	if err != nil {
+		return nil, err
+	}
+	// The descriptor already has a handle, so a (possibly indirect)
+	// reference to it could make it its own superclass
+	if _, err := desc.hierarchy(); err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+// hierarchy returns the classes of the hierarchy of the given class, from
+// the top-most superclass down.
+func (desc *javaClassDesc) hierarchy() ([]*javaClassDesc, error) {
+	var hierarchy []*javaClassDesc
+	seen := map[*javaClassDesc]bool{}
+	for c := desc; c != nil; c = c.super {
+		if seen[c] {
+			return nil, fmt.Errorf("cyclic class hierarchy: %s", c.name)
+		}
+		if len(hierarchy) >= maxClassHierarchy {
+			return nil, fmt.Errorf("class hierarchy too deep: %s", desc.name)
+		}
+		seen[c] = true
+		hierarchy = append([]*javaClassDesc{c}, hierarchy...)
+	}
+	return hierarchy, nil
+}
+
+// skipAnnotation skips over class or object annotations, which are a
+// sequence of contents terminated by an end block marker.
+func (or *objectReader) skipAnnotation() error {
+	for {
+		tc, err := or.readByte()
+		if err != nil {
+			return err
+		}
+		if tc == tcEndBlockData {
+			return nil
+		}
+		if _, err := or.readContentWithCode(tc); err != nil {
+			return err
+		}
+	}
+}
+
+func (or *objectReader) skipBlockData(tc byte) error {
+	var length int64
+	if tc == tcBlockData {
+		b, err := or.readByte()
+		if err != nil {
+			return err
+		}
+		length = int64(b)
+	} else {
+		l, err := readInt32(or.r)
+		if err != nil {
+			return err
+		}
+		length = int64(l)
+	}
+	_, err := io.CopyN(ioutil.Discard, or.r, length)
+	return err
+}
+
+func (or *objectReader) readNewObject() (interface{}, error) {
+	desc, err := or.readClassDescRef()
+	if err != nil {
+		return nil, err
+	}
+	if desc == nil {
+		return nil, fmt.Errorf("object without class descriptor")
+	}
+
+	obj := &javaObject{class: desc, fields: map[string]interface{}{}}
+	or.newHandle(obj)
+
+	// Class data is written from the top-most superclass down
+	hierarchy, err := desc.hierarchy()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range hierarchy {
+		if c.flags&scExternalizable != 0 {
+			if c.flags&scBlockData == 0 {
+				return nil, fmt.Errorf("unsupported externalizable class: %s", c.name)
+			}
+			if err := or.skipAnnotation(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, field := range c.fields {
+			value, err := or.readFieldValue(field.typeCode)
+			if err != nil {
+				return nil, err
+			}
+			obj.fields[field.name] = value
+		}
+		if c.flags&scWriteMethod != 0 {
+			if err := or.skipAnnotation(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return obj, nil
+}
+
+func (or *objectReader) readFieldValue(typeCode byte) (interface{}, error) {
+	var size int
+	switch typeCode {
+	case 'B', 'Z':
+		size = 1
+	case 'C', 'S':
+		size = 2
+	case 'I', 'F':
+		size = 4
+	case 'J', 'D':
+		size = 8
+	case '[', 'L':
+		return or.readContent()
+	default:
+		return nil, fmt.Errorf("unknown field type code: %c", typeCode)
+	}
+
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(or.r, buf[8-size:]); err != nil {
+		return nil, err
+	}
+	return int64(binary.BigEndian.Uint64(buf)), nil
+}
+
+func (or *objectReader) readNewArray() (interface{}, error) {
+	desc, err := or.readClassDescRef()
+	if err != nil {
+		return nil, err
+	}
+	if desc == nil || len(desc.name) < 2 || desc.name[0] != '[' {
+		return nil, fmt.Errorf("array without valid class descriptor")
+	}
+	handle := or.newHandle(nil)
+
+	length, err := readInt32(or.r)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 || length > 1<<24 {
+		return nil, fmt.Errorf("invalid array length: %d", length)
+	}
+
+	if desc.name == "[B" {
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(or.r, buf); err != nil {
+			return nil, err
+		}
+		or.handles[handle] = buf
+		return buf, nil
+	}
+
+	// Grown as elements are read, since the length alone is no evidence
+	// that there's that much input
+	var values []interface{}
+	for i := int32(0); i < length; i++ {
+		value, err := or.readFieldValue(desc.name[1])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	or.handles[handle] = values
+	return values, nil
+}
+
+func (or *objectReader) readNewEnum() (interface{}, error) {
+	desc, err := or.readClassDescRef()
+	if err != nil {
+		return nil, err
+	}
+	enum := &javaEnum{class: desc}
+	or.newHandle(enum)
+
+	constant, err := or.readContent()
+	if err != nil {
+		return nil, err
+	}
+	name, ok := constant.(string)
+	if !ok {
+		return nil, fmt.Errorf("enum constant name is not a string")
+	}
+	enum.constant = name
+	return enum, nil
+}
@@ -0,0 +1,52 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jceks
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestReadJavaObjectMalformed(t *testing.T) {
+	// An object whose class is its own superclass, via a reference to the
+	// class descriptor's own handle
+	cyclic, _ := hex.DecodeString("aced0005" + "73" + "72" + "000141" + "0000000000000001" + "02" + "0000" + "78" + "71007e0000")
+
+	// Arrays of arrays, nested beyond the limit
+	var nested bytes.Buffer
+	nested.Write([]byte{0xac, 0xed, 0x00, 0x05})
+	for i := 0; i < 1000; i++ {
+		// TC_ARRAY, class "[L" without fields or superclass, one element
+		nested.Write([]byte{tcArray, tcClassDesc, 0x00, 0x02, '[', 'L', 0, 0, 0, 0, 0, 0, 0, 1, scSerializable, 0x00, 0x00, tcEndBlockData, tcNull, 0, 0, 0, 1})
+	}
+	nested.WriteByte(tcNull)
+
+	testCases := []struct {
+		name, data, expected string
+	}{
+		{"cyclic hierarchy", string(cyclic), "cyclic class hierarchy"},
+		{"deep nesting", nested.String(), "nested too deeply"},
+	}
+	for _, tc := range testCases {
+		_, err := readJavaObject(strings.NewReader(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%s: expected error containing %q, got: %v", tc.name, tc.expected, err)
+		}
+	}
+}
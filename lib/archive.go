@@ -0,0 +1,119 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// ReadX509FromArchive reads X.509 certificates from the members of a zip
+// or tar archive, as ReadX509WithOptions does for files. The archive type
+// is "zip", "tar", or "tar.gz" (or "tgz"). Only members with a known
+// certificate or key store extension (e.g. ".crt" or ".p12") are read, and
+// the originFile header is set to their path in the archive.
+func ReadX509FromArchive(r io.Reader, archiveType string, opts ReadOptions, callback func(*x509.Certificate, string, error) error) error {
+	members, err := readArchiveMembers(r, archiveType)
+	if err != nil {
+		return err
+	}
+	return ReadX509WithOptions(members, opts, callback)
+}
+
+func readArchiveMembers(r io.Reader, archiveType string) ([]io.Reader, error) {
+	switch strings.ToLower(archiveType) {
+	case "zip":
+		return readZipMembers(r)
+	case "tar":
+		return readTarMembers(r)
+	case "tar.gz", "tgz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read gzip archive: %s", err)
+		}
+		defer gz.Close()
+		return readTarMembers(gz)
+	}
+	return nil, fmt.Errorf("unknown archive type '%s'", archiveType)
+}
+
+func readZipMembers(r io.Reader) ([]io.Reader, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read zip archive: %s", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read zip archive: %s", err)
+	}
+
+	var members []io.Reader
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || !isCertMember(file.Name) {
+			continue
+		}
+		member, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s from zip archive: %s", file.Name, err)
+		}
+		content, err := ioutil.ReadAll(member)
+		member.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s from zip archive: %s", file.Name, err)
+		}
+		members = append(members, namedReader{bytes.NewReader(content), file.Name})
+	}
+	return members, nil
+}
+
+func readTarMembers(r io.Reader) ([]io.Reader, error) {
+	archive := tar.NewReader(r)
+	var members []io.Reader
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read tar archive: %s", err)
+		}
+		if !header.FileInfo().Mode().IsRegular() || !isCertMember(header.Name) {
+			continue
+		}
+		content, err := ioutil.ReadAll(archive)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s from tar archive: %s", header.Name, err)
+		}
+		members = append(members, namedReader{bytes.NewReader(content), header.Name})
+	}
+	return members, nil
+}
+
+// isCertMember checks if an archive member has a known certificate or key
+// store extension.
+func isCertMember(name string) bool {
+	_, ok := fileExtToFormat[strings.ToLower(path.Ext(name))]
+	return ok
+}
@@ -0,0 +1,112 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestReadX509FromArchive(t *testing.T) {
+	key := newTestKey(t)
+	root := issueTestCert(t, "root", key, "root", key)
+	intermediate := issueTestCert(t, "intermediate", key, "root", key)
+
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"bundle/root.crt", pem.EncodeToMemory(EncodeX509ToPEM(root, nil))},
+		{"bundle/README.txt", []byte("not a certificate")},
+		{"bundle/intermediate.der", intermediate.Raw},
+	}
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(file.content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var tarred, gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	for _, w := range []*tar.Writer{tar.NewWriter(&tarred), tar.NewWriter(gw)} {
+		w.WriteHeader(&tar.Header{Name: "bundle/", Typeflag: tar.TypeDir, Mode: 0755})
+		for _, file := range files {
+			w.WriteHeader(&tar.Header{Name: file.name, Size: int64(len(file.content)), Typeflag: tar.TypeReg, Mode: 0644})
+			w.Write(file.content)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gw.Close()
+
+	archives := map[string][]byte{
+		"zip":    zipped.Bytes(),
+		"tar":    tarred.Bytes(),
+		"tar.gz": gzipped.Bytes(),
+	}
+	for archiveType, data := range archives {
+		var names []string
+		err := ReadX509FromArchive(bytes.NewReader(data), archiveType, ReadOptions{}, func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			names = append(names, cert.Subject.CommonName)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", archiveType, err)
+		}
+		if strings.Join(names, ",") != "root,intermediate" {
+			t.Errorf("%s: unexpected certificates: %v", archiveType, names)
+		}
+	}
+
+	members, err := readArchiveMembers(bytes.NewReader(zipped.Bytes()), "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var origins []string
+	err = ReadPEMWithOptions(members, ReadOptions{}, func(block *pem.Block, format string) error {
+		origins = append(origins, block.Headers[fileHeader])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(origins, ",") != "bundle/root.crt,bundle/intermediate.der" {
+		t.Errorf("unexpected origin files: %v", origins)
+	}
+
+	if err := ReadX509FromArchive(bytes.NewReader(nil), "rar", ReadOptions{}, nil); err == nil {
+		t.Error("expected error for unknown archive type")
+	}
+}
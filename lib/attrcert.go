@@ -0,0 +1,224 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// AttributeCertificate is an X.509 attribute certificate (RFC 5755), which
+// binds attributes (such as roles or group memberships) to a holder, rather
+// than a public key. Only the structure is parsed; the signature is not
+// verified.
+type AttributeCertificate struct {
+	Raw []byte
+
+	Version      int
+	SerialNumber *big.Int
+
+	// HolderIssuer and HolderSerial identify the holder's public key
+	// certificate, if the holder is given that way (baseCertificateID).
+	HolderIssuer []string
+	HolderSerial *big.Int
+
+	// HolderNames are the names of the holder, if given (entityName).
+	HolderNames []string
+
+	Issuer []string
+
+	NotBefore, NotAfter time.Time
+
+	Attributes []AttributeCertificateAttribute
+	Extensions []pkix.Extension
+
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+}
+
+// AttributeCertificateAttribute is an attribute in an attribute certificate.
+// String values are decoded; other values are given as '#' followed by the
+// hex encoding of the DER value.
+type AttributeCertificateAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []string
+}
+
+type attributeCertificate struct {
+	Info               attributeCertificateInfo
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	SignatureValue     asn1.BitString
+}
+
+type attributeCertificateInfo struct {
+	Version        int
+	Holder         asn1.RawValue
+	Issuer         asn1.RawValue
+	Signature      pkix.AlgorithmIdentifier
+	SerialNumber   *big.Int
+	Validity       attributeCertificateValidity
+	Attributes     []attributeCertificateAttribute
+	IssuerUniqueID asn1.BitString   `asn1:"optional"`
+	Extensions     []pkix.Extension `asn1:"optional"`
+}
+
+type attributeCertificateValidity struct {
+	NotBefore time.Time `asn1:"generalized"`
+	NotAfter  time.Time `asn1:"generalized"`
+}
+
+type attributeCertificateAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// ParseAttributeCertificate parses a DER-encoded attribute certificate.
+func ParseAttributeCertificate(der []byte) (*AttributeCertificate, error) {
+	var ac attributeCertificate
+	rest, err := asn1.Unmarshal(der, &ac)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after attribute certificate")
+	}
+	info := ac.Info
+	if info.Version != 1 {
+		// Only v2 attribute certificates (encoded as 1) are in use
+		return nil, fmt.Errorf("unsupported attribute certificate version: %d", info.Version+1)
+	}
+
+	out := &AttributeCertificate{
+		Raw:                der,
+		Version:            info.Version + 1,
+		SerialNumber:       info.SerialNumber,
+		NotBefore:          info.Validity.NotBefore,
+		NotAfter:           info.Validity.NotAfter,
+		Extensions:         info.Extensions,
+		SignatureAlgorithm: ac.SignatureAlgorithm,
+	}
+
+	if err := parseACHolder(info.Holder, out); err != nil {
+		return nil, fmt.Errorf("invalid holder in attribute certificate: %s", err)
+	}
+	if out.Issuer, err = parseACIssuer(info.Issuer); err != nil {
+		return nil, fmt.Errorf("invalid issuer in attribute certificate: %s", err)
+	}
+
+	for _, attr := range info.Attributes {
+		values := make([]string, 0, len(attr.Values))
+		for _, value := range attr.Values {
+			s, ok := dnAttributeString(value)
+			if !ok {
+				s = "#" + hex.EncodeToString(value.FullBytes)
+			}
+			values = append(values, s)
+		}
+		out.Attributes = append(out.Attributes, AttributeCertificateAttribute{Type: attr.Type, Values: values})
+	}
+
+	return out, nil
+}
+
+// parseACHolder parses the Holder of an attribute certificate:
+//
+//	Holder ::= SEQUENCE {
+//	  baseCertificateID   [0] IssuerSerial OPTIONAL,
+//	  entityName          [1] GeneralNames OPTIONAL,
+//	  objectDigestInfo    [2] ObjectDigestInfo OPTIONAL }
+func parseACHolder(holder asn1.RawValue, out *AttributeCertificate) error {
+	rest := holder.Bytes
+	for len(rest) > 0 {
+		var field asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &field)
+		if err != nil {
+			return err
+		}
+		if field.Class != asn1.ClassContextSpecific {
+			return errors.New("unexpected field")
+		}
+		switch field.Tag {
+		case 0:
+			out.HolderIssuer, out.HolderSerial, err = parseIssuerSerial(field.Bytes)
+		case 1:
+			out.HolderNames, err = parseImplicitGeneralNames(field.Bytes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseACIssuer parses the issuer of an attribute certificate, which is
+// either a plain GeneralNames (v1Form) or a V2Form:
+//
+//	V2Form ::= SEQUENCE {
+//	  issuerName            GeneralNames  OPTIONAL,
+//	  baseCertificateID     [0] IssuerSerial  OPTIONAL,
+//	  objectDigestInfo      [1] ObjectDigestInfo  OPTIONAL }
+func parseACIssuer(issuer asn1.RawValue) ([]string, error) {
+	if issuer.Class == asn1.ClassUniversal {
+		return parseGeneralNames(issuer.FullBytes)
+	}
+	if issuer.Class != asn1.ClassContextSpecific || issuer.Tag != 0 {
+		return nil, errors.New("unexpected issuer form")
+	}
+	var names asn1.RawValue
+	if _, err := asn1.Unmarshal(issuer.Bytes, &names); err != nil {
+		return nil, err
+	}
+	if names.Class != asn1.ClassUniversal {
+		// No issuerName (not allowed by RFC 5755, but harmless)
+		return nil, nil
+	}
+	return parseGeneralNames(names.FullBytes)
+}
+
+// parseIssuerSerial parses the contents of an (implicitly tagged)
+// IssuerSerial: the GeneralNames of the issuer, and a serial number.
+func parseIssuerSerial(content []byte) ([]string, *big.Int, error) {
+	var names asn1.RawValue
+	rest, err := asn1.Unmarshal(content, &names)
+	if err != nil {
+		return nil, nil, err
+	}
+	issuer, err := parseGeneralNames(names.FullBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial := new(big.Int)
+	if _, err := asn1.Unmarshal(rest, &serial); err != nil {
+		return nil, nil, err
+	}
+	return issuer, serial, nil
+}
+
+// parseImplicitGeneralNames parses the contents of an implicitly tagged
+// GeneralNames.
+func parseImplicitGeneralNames(content []byte) ([]string, error) {
+	der, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: content})
+	if err != nil {
+		return nil, err
+	}
+	return parseGeneralNames(der)
+}
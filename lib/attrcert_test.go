@@ -0,0 +1,151 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// directoryNames encodes a GeneralNames with a single directoryName.
+func directoryNames(t *testing.T, cn string) []byte {
+	name, err := asn1.Marshal(pkix.Name{CommonName: cn}.ToRDNSequence())
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal([]asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: name},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func createTestAttributeCertificate(t *testing.T) []byte {
+	serial, err := asn1.Marshal(big.NewInt(1234))
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseCertificateID := asn1.RawValue{
+		Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+		Bytes: append(directoryNames(t, "Holder CA"), serial...),
+	}
+	holder, err := asn1.Marshal([]asn1.RawValue{baseCertificateID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := asn1.Marshal("admins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clearance, err := asn1.Marshal(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notBefore := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	info := attributeCertificateInfo{
+		Version:      1,
+		Holder:       asn1.RawValue{FullBytes: holder},
+		Issuer:       asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: directoryNames(t, "AC Issuer")},
+		Signature:    pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSAWithSHA256},
+		SerialNumber: big.NewInt(99),
+		Validity:     attributeCertificateValidity{notBefore, notBefore.Add(24 * time.Hour)},
+		Attributes: []attributeCertificateAttribute{
+			{Type: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 10, 4}, Values: []asn1.RawValue{{FullBytes: group}}},
+			{Type: asn1.ObjectIdentifier{2, 5, 1, 5, 55}, Values: []asn1.RawValue{{FullBytes: clearance}}},
+		},
+	}
+	der, err := asn1.Marshal(attributeCertificate{
+		Info:               info,
+		SignatureAlgorithm: info.Signature,
+		SignatureValue:     asn1.BitString{Bytes: []byte{0}, BitLength: 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestParseAttributeCertificate(t *testing.T) {
+	der := createTestAttributeCertificate(t)
+
+	ac, err := ParseAttributeCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac.Version != 2 || ac.SerialNumber.Int64() != 99 {
+		t.Errorf("unexpected version/serial: %d/%s", ac.Version, ac.SerialNumber)
+	}
+	if !reflect.DeepEqual(ac.HolderIssuer, []string{"DirName:CN=Holder CA"}) || ac.HolderSerial.Int64() != 1234 {
+		t.Errorf("unexpected holder: %v/%s", ac.HolderIssuer, ac.HolderSerial)
+	}
+	if !reflect.DeepEqual(ac.Issuer, []string{"DirName:CN=AC Issuer"}) {
+		t.Errorf("unexpected issuer: %v", ac.Issuer)
+	}
+	if ac.NotAfter.Sub(ac.NotBefore) != 24*time.Hour {
+		t.Errorf("unexpected validity: %s - %s", ac.NotBefore, ac.NotAfter)
+	}
+	if len(ac.Attributes) != 2 ||
+		!reflect.DeepEqual(ac.Attributes[0].Values, []string{"admins"}) ||
+		!reflect.DeepEqual(ac.Attributes[1].Values, []string{"#02012a"}) {
+		t.Errorf("unexpected attributes: %+v", ac.Attributes)
+	}
+}
+
+func TestReadAttributeCertificateDER(t *testing.T) {
+	der := createTestAttributeCertificate(t)
+
+	var blocks []*pem.Block
+	err := ReadAsPEM([]io.Reader{bytes.NewReader(der)}, "", nil, func(block *pem.Block, format string) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[0].Type != "ATTRIBUTE CERTIFICATE" || !bytes.Equal(blocks[0].Bytes, der) {
+		t.Fatalf("unexpected blocks: %v", blocks)
+	}
+
+	// X.509 reading reports them to the callback rather than dropping them
+	// silently (or printing a warning), strict or not
+	for _, strict := range []bool{false, true} {
+		var readErr error
+		err = ReadX509WithOptions([]io.Reader{bytes.NewReader(der)}, ReadOptions{Strict: strict}, func(cert *x509.Certificate, format string, err error) error {
+			readErr = err
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if readErr == nil || !strings.Contains(readErr.Error(), "attribute certificates are not supported") {
+			t.Errorf("strict %v: expected error for attribute certificate, got: %v", strict, readErr)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Index of the security (certificate table) entry in the data
+	// directory of a PE file.
+	peSecurityDirectory = 4
+
+	// WIN_CERTIFICATE type for PKCS7 SignedData (Authenticode).
+	winCertTypePKCSSignedData = 0x0002
+
+	// Size of the WIN_CERTIFICATE header (dwLength, wRevision,
+	// wCertificateType).
+	winCertHeaderSize = 8
+)
+
+// extractAuthenticodeSignatures locates the certificate table of a Windows
+// PE binary (.exe, .dll, etc.) and returns the PKCS7 SignedData blobs of the
+// Authenticode signatures in it. Note that the certificate table's address
+// is a file offset, unlike other data directory entries.
+func extractAuthenticodeSignatures(data []byte) ([][]byte, error) {
+	file, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse PE file: %s", err)
+	}
+
+	var dir pe.DataDirectory
+	switch header := file.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if header.NumberOfRvaAndSizes > peSecurityDirectory {
+			dir = header.DataDirectory[peSecurityDirectory]
+		}
+	case *pe.OptionalHeader64:
+		if header.NumberOfRvaAndSizes > peSecurityDirectory {
+			dir = header.DataDirectory[peSecurityDirectory]
+		}
+	}
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		return nil, errors.New("PE file is not signed (no certificate table)")
+	}
+
+	start, end := uint64(dir.VirtualAddress), uint64(dir.VirtualAddress)+uint64(dir.Size)
+	if end > uint64(len(data)) {
+		return nil, errors.New("PE certificate table is out of bounds")
+	}
+	table := data[start:end]
+
+	var blobs [][]byte
+	for len(table) >= winCertHeaderSize {
+		length := binary.LittleEndian.Uint32(table[0:4])
+		certType := binary.LittleEndian.Uint16(table[6:8])
+		if length < winCertHeaderSize || uint64(length) > uint64(len(table)) {
+			return nil, errors.New("invalid entry in PE certificate table")
+		}
+		if certType == winCertTypePKCSSignedData {
+			blob := table[winCertHeaderSize:length]
+			// dwLength usually includes the padding to the 8-byte
+			// alignment, so cut the blob off after the (DER) SignedData.
+			// BER-encoded blobs are left as they are.
+			var signedData asn1.RawValue
+			if _, err := asn1.Unmarshal(blob, &signedData); err == nil {
+				blob = signedData.FullBytes
+			}
+			blobs = append(blobs, blob)
+		}
+
+		// Entries are aligned to 8 bytes
+		next := (uint64(length) + 7) &^ 7
+		if next >= uint64(len(table)) {
+			break
+		}
+		table = table[next:]
+	}
+
+	if len(blobs) == 0 {
+		return nil, errors.New("PE file has no Authenticode signatures")
+	}
+	return blobs, nil
+}
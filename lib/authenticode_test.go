@@ -0,0 +1,138 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"debug/pe"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// buildSignedPE assembles a minimal PE32 image (no sections) with the given
+// PKCS7 blob in its certificate table.
+func buildSignedPE(t *testing.T, signature []byte) []byte {
+	const peOffset = 0x40
+
+	var buf bytes.Buffer
+	dos := make([]byte, peOffset)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], peOffset)
+	buf.Write(dos)
+	buf.WriteString("PE\x00\x00")
+
+	optional := pe.OptionalHeader32{
+		Magic:               0x10b,
+		NumberOfRvaAndSizes: 16,
+	}
+	header := pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_I386,
+		SizeOfOptionalHeader: uint16(binary.Size(optional)),
+	}
+
+	entry := make([]byte, winCertHeaderSize)
+	binary.LittleEndian.PutUint32(entry[0:], uint32(winCertHeaderSize+len(signature)))
+	binary.LittleEndian.PutUint16(entry[4:], 0x0200)
+	binary.LittleEndian.PutUint16(entry[6:], winCertTypePKCSSignedData)
+	entry = append(entry, signature...)
+
+	tableOffset := buf.Len() + binary.Size(header) + binary.Size(optional)
+	optional.DataDirectory[peSecurityDirectory] = pe.DataDirectory{
+		VirtualAddress: uint32(tableOffset),
+		Size:           uint32(len(entry)),
+	}
+
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, optional); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(entry)
+	return buf.Bytes()
+}
+
+func TestReadAuthenticodePE(t *testing.T) {
+	signature, err := ioutil.ReadFile("../pkcs7/testdata/indefinite-length.p7b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := buildSignedPE(t, signature)
+
+	var certs []*x509.Certificate
+	err = ReadAsX509([]io.Reader{bytes.NewReader(image)}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) == 0 {
+		t.Fatal("expected certificates from Authenticode signature")
+	}
+}
+
+func TestReadAuthenticodePadded(t *testing.T) {
+	signature, err := ioutil.ReadFile("../pkcs7/testdata/windows-chain.p7b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signature)%8 == 0 {
+		t.Fatal("test signature should need padding")
+	}
+	// signtool includes the padding to the 8-byte alignment in dwLength
+	padded := append(append([]byte{}, signature...), make([]byte, 8-len(signature)%8)...)
+	image := buildSignedPE(t, padded)
+
+	blobs, err := extractAuthenticodeSignatures(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 1 || !bytes.Equal(blobs[0], signature) {
+		t.Fatalf("padding not removed from signature (%d bytes, expected %d)", len(blobs[0]), len(signature))
+	}
+
+	var names []string
+	err = ReadAsX509([]io.Reader{bytes.NewReader(image)}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, cert.Subject.CommonName)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 {
+		t.Errorf("unexpected certificates: %v", names)
+	}
+}
+
+func TestExtractAuthenticodeUnsigned(t *testing.T) {
+	unsigned := buildSignedPE(t, nil)
+	// Clear the certificate table entry
+	unsigned = unsigned[:len(unsigned)-winCertHeaderSize]
+	if _, err := extractAuthenticodeSignatures(unsigned); err == nil {
+		t.Error("expected error for PE file without valid certificate table")
+	}
+}
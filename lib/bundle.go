@@ -0,0 +1,77 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"sort"
+)
+
+// NormalizeBundle returns the given certificates deduplicated and sorted
+// deterministically: by subject DN, then by SHA-256 fingerprint. This makes
+// it possible to compare bundles regardless of the order of their entries.
+func NormalizeBundle(certs []*x509.Certificate) []*x509.Certificate {
+	type entry struct {
+		cert        *x509.Certificate
+		subject     string
+		fingerprint [sha256.Size]byte
+	}
+
+	seen := map[[sha256.Size]byte]bool{}
+	var entries []entry
+	for _, cert := range certs {
+		fingerprint := sha256.Sum256(cert.Raw)
+		if seen[fingerprint] {
+			continue
+		}
+		seen[fingerprint] = true
+
+		subject, err := FormatDN(cert.RawSubject)
+		if err != nil {
+			subject = cert.Subject.String()
+		}
+		entries = append(entries, entry{cert, subject, fingerprint})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].subject != entries[j].subject {
+			return entries[i].subject < entries[j].subject
+		}
+		return bytes.Compare(entries[i].fingerprint[:], entries[j].fingerprint[:]) < 0
+	})
+
+	out := make([]*x509.Certificate, len(entries))
+	for i, e := range entries {
+		out[i] = e.cert
+	}
+	return out
+}
+
+// WriteNormalizedBundle writes the given certificates to w as a normalized
+// PEM bundle (see NormalizeBundle), without any PEM headers.
+func WriteNormalizedBundle(w io.Writer, certs []*x509.Certificate) error {
+	for _, cert := range NormalizeBundle(certs) {
+		if err := pem.Encode(w, EncodeX509ToPEM(cert, nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeBundle(t *testing.T) {
+	key := newTestKey(t)
+	certA := issueTestCert(t, "Bundle A", key, "Bundle A", key)
+	certB := issueTestCert(t, "Bundle B", key, "Bundle B", key)
+	certC := issueTestCert(t, "Bundle C", key, "Bundle C", key)
+
+	normalized := NormalizeBundle([]*x509.Certificate{certC, certA, certB, certA, certC})
+	var subjects []string
+	for _, cert := range normalized {
+		subjects = append(subjects, cert.Subject.CommonName)
+	}
+	if strings.Join(subjects, ",") != "Bundle A,Bundle B,Bundle C" {
+		t.Fatalf("unexpected order: %v", subjects)
+	}
+
+	var first, second bytes.Buffer
+	if err := WriteNormalizedBundle(&first, []*x509.Certificate{certB, certC, certA}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteNormalizedBundle(&second, []*x509.Certificate{certA, certA, certC, certB}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("normalized bundles differ")
+	}
+	if strings.Count(first.String(), "BEGIN CERTIFICATE") != 3 || strings.Contains(first.String(), ":") {
+		t.Errorf("unexpected bundle:\n%s", first.String())
+	}
+}
@@ -0,0 +1,220 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// certdataTrustPurposes maps the trust attributes of NSS trust objects to the
+// extended key usages they cover.
+var certdataTrustPurposes = []struct {
+	attribute string
+	purpose   asn1.ObjectIdentifier
+}{
+	{"CKA_TRUST_SERVER_AUTH", asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}},
+	{"CKA_TRUST_EMAIL_PROTECTION", asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}},
+	{"CKA_TRUST_CODE_SIGNING", asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3}},
+}
+
+var errNotCertdata = errors.New("input doesn't look like an NSS certdata.txt file with certificates")
+
+// certdataObject is an object definition in a certdata.txt file, mapping
+// attribute names to their (decoded) values.
+type certdataObject map[string][]byte
+
+// readCertdata reads the certificates in a Mozilla/NSS certdata.txt file,
+// passing them to the callback as "TRUSTED CERTIFICATE" blocks with the
+// trust settings of their NSS trust object: purposes the certificate is a
+// trusted issuer for are trusted, and purposes it is explicitly distrusted
+// for are rejected. The label of the certificate is put in the friendlyName
+// header.
+func readCertdata(reader io.Reader, callback func(*pem.Block, string) error) error {
+	objects, err := parseCertdata(reader)
+	if err != nil {
+		return fmt.Errorf("unable to read certdata.txt: %s", err)
+	}
+
+	trustByHash := map[string]certdataObject{}
+	trustByIssuerSerial := map[string]certdataObject{}
+	var certs []certdataObject
+	for _, object := range objects {
+		switch string(object["CKA_CLASS"]) {
+		case "CKO_CERTIFICATE":
+			certs = append(certs, object)
+		case "CKO_NSS_TRUST":
+			if hash, ok := object["CKA_CERT_SHA1_HASH"]; ok {
+				trustByHash[string(hash)] = object
+			}
+			trustByIssuerSerial[string(object["CKA_ISSUER"])+string(object["CKA_SERIAL_NUMBER"])] = object
+		}
+	}
+	if len(certs) == 0 {
+		return errNotCertdata
+	}
+
+	for _, object := range certs {
+		label := string(object["CKA_LABEL"])
+		cert, err := x509.ParseCertificate(object["CKA_VALUE"])
+		if err != nil {
+			return fmt.Errorf("unable to parse certificate %q: %s", label, err)
+		}
+
+		hash := sha1.Sum(cert.Raw)
+		trust, ok := trustByHash[string(hash[:])]
+		if !ok {
+			trust = trustByIssuerSerial[string(object["CKA_ISSUER"])+string(object["CKA_SERIAL_NUMBER"])]
+		}
+		settings := TrustSettings{Alias: label}
+		for _, purpose := range certdataTrustPurposes {
+			switch string(trust[purpose.attribute]) {
+			case "CKT_NSS_TRUSTED_DELEGATOR", "CKT_NSS_TRUSTED":
+				settings.Trusted = append(settings.Trusted, purpose.purpose)
+			case "CKT_NSS_NOT_TRUSTED":
+				settings.Rejected = append(settings.Rejected, purpose.purpose)
+			}
+		}
+
+		block, err := EncodeX509ToTrustedPEM(cert, settings, map[string]string{nameHeader: label})
+		if err != nil {
+			return err
+		}
+		if err := callback(block, "CERTDATA"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCertdata parses the object definitions after the BEGINDATA line of a
+// certdata.txt file. Each object starts with its CKA_CLASS attribute; other
+// attributes are "name type value" lines, or "name MULTILINE_OCTAL"
+// followed by lines of octal escapes up to an END line.
+func parseCertdata(reader io.Reader) ([]certdataObject, error) {
+	var objects []certdataObject
+	var current certdataObject
+	inData := false
+	var octal *bytes.Buffer
+	var octalName string
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if octal != nil {
+			if text == "END" {
+				current[octalName] = octal.Bytes()
+				octal = nil
+				continue
+			}
+			value, err := unquoteCertdata(text)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+			octal.WriteString(value)
+			continue
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if !inData {
+			inData = text == "BEGINDATA"
+			continue
+		}
+
+		fields := strings.SplitN(text, " ", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: malformed attribute: %q", line, text)
+		}
+		name, kind := fields[0], fields[1]
+		if name == "CKA_CLASS" {
+			current = certdataObject{}
+			objects = append(objects, current)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: attribute %s outside of an object", line, name)
+		}
+
+		switch {
+		case kind == "MULTILINE_OCTAL":
+			octal, octalName = &bytes.Buffer{}, name
+		case len(fields) < 3:
+			return nil, fmt.Errorf("line %d: missing value for %s", line, name)
+		case kind == "UTF8":
+			value, err := unquoteCertdata(strings.TrimSuffix(strings.TrimPrefix(fields[2], `"`), `"`))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+			current[name] = []byte(value)
+		default:
+			current[name] = []byte(fields[2])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if octal != nil {
+		return nil, fmt.Errorf("missing END for %s", octalName)
+	}
+	return objects, nil
+}
+
+// unquoteCertdata decodes the backslash escapes of certdata.txt values: three
+// octal digits for a byte, or an escaped quote or backslash.
+func unquoteCertdata(value string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			out.WriteByte(value[i])
+			continue
+		}
+		if i+1 < len(value) && (value[i+1] == '"' || value[i+1] == '\\') {
+			out.WriteByte(value[i+1])
+			i++
+			continue
+		}
+		if i+4 > len(value) {
+			return "", fmt.Errorf("truncated escape in %q", value)
+		}
+		b, err := strconv.ParseUint(value[i+1:i+4], 8, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", value)
+		}
+		out.WriteByte(byte(b))
+		i += 3
+	}
+	return out.String(), nil
+}
+
+// looksLikeCertdata returns true if the start of an input looks like an NSS
+// certdata.txt file, which begins with comments (or directly with the
+// BEGINDATA line).
+func looksLikeCertdata(prefix []byte) bool {
+	return bytes.Contains(prefix, []byte("BEGINDATA")) ||
+		bytes.Contains(prefix, []byte("CKA_CLASS")) ||
+		bytes.Contains(prefix, []byte("# certdata.txt"))
+}
@@ -0,0 +1,88 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCertdata(t *testing.T) {
+	file, err := os.Open("testdata/certdata.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	type entry struct {
+		name, cn string
+		trust    TrustSettings
+	}
+	var entries []entry
+	err = ReadAsPEM([]io.Reader{file}, "", nil, func(block *pem.Block, format string) error {
+		if format != "CERTDATA" || block.Type != PEMTypeTrustedCertificate {
+			t.Errorf("unexpected %s block in %s input", block.Type, format)
+		}
+		cert, trust, err := ParseTrustedCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{block.Headers[nameHeader], cert.Subject.CommonName, trust})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverAuth := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+	emailProtection := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}
+	expected := []entry{
+		{"Example Root — R1", "example-root", TrustSettings{
+			Trusted: []asn1.ObjectIdentifier{serverAuth, emailProtection},
+			Alias:   "Example Root — R1",
+		}},
+		{"Example Leaf", "example-leaf", TrustSettings{
+			Rejected: []asn1.ObjectIdentifier{serverAuth},
+			Alias:    "Example Leaf",
+		}},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected entries:\n%+v\nexpected:\n%+v", entries, expected)
+	}
+}
+
+func TestParseCertdataErrors(t *testing.T) {
+	for _, input := range []string{
+		"BEGINDATA\nCKA_LABEL UTF8 \"orphan\"\n",
+		"BEGINDATA\nCKA_CLASS CK_OBJECT_CLASS CKO_CERTIFICATE\nCKA_VALUE MULTILINE_OCTAL\n\\060\\003\n",
+		"BEGINDATA\nCKA_CLASS CK_OBJECT_CLASS CKO_CERTIFICATE\nCKA_VALUE MULTILINE_OCTAL\n\\06\nEND\n",
+	} {
+		if _, err := parseCertdata(strings.NewReader(input)); err == nil {
+			t.Errorf("expected error parsing %q", input)
+		}
+	}
+
+	err := readCertdata(strings.NewReader("# certdata.txt\nBEGINDATA\n"), nil)
+	if err != errNotCertdata {
+		t.Errorf("expected errNotCertdata, got %v", err)
+	}
+}
@@ -20,19 +20,28 @@ import (
 	"bufio"
 	"bytes"
 	"crypto"
+	"crypto/dsa"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/square/certigo/jceks"
 	"github.com/square/certigo/pkcs7"
@@ -45,18 +54,78 @@ const (
 
 	// fileHeader is the origin file where the key came from (as in file on disk).
 	fileHeader = "originFile"
+
+	// localKeyIDHeader is the PEM header field pkcs12.ToPEM uses to link keys and certificates.
+	localKeyIDHeader = "localKeyId"
+
+	// keyAlgorithmHeader is the PEM header field for the algorithm of a secret key.
+	keyAlgorithmHeader = "keyAlgorithm"
+
+	// entryTypeHeader is the PEM header field for the type of key store entry a certificate came from.
+	entryTypeHeader = "entryType"
+
+	// entryDateHeader is the PEM header field for the creation date of a key store entry.
+	entryDateHeader = "entryDate"
+
+	// chainHeader is the PEM header field for the alias of the leaf of the
+	// chain a trusted-cert entry was linked into, see
+	// ReadOptions.LinkTrustedCerts.
+	chainHeader = "chainAlias"
+
+	// readAtHeader is the PEM header field for the time a block was read,
+	// see ReadOptions.StampReadTime.
+	readAtHeader = "readAt"
+
+	// offsetHeader and lengthHeader are the PEM header fields for the
+	// position of a block in its input, see ReadOptions.RecordOffsets.
+	offsetHeader = "originOffset"
+	lengthHeader = "originLength"
+
+	// headerNamespace prefixes certigo's header fields if the input
+	// already has a field with the same name, see mergeHeaders.
+	headerNamespace = "certigo-"
+
+	// stdinName is the name reported for input read from standard input.
+	stdinName = "stdin"
+
+	// maxPEMBlockSize limits the size of a single PEM block (including any
+	// text skipped before it) when scanning PEM input.
+	maxPEMBlockSize = 16 * 1024 * 1024
+)
+
+var (
+	pemStart = []byte("-----BEGIN")
+	pemEnd   = []byte("-----END")
+	pgpStart = []byte("-----BEGIN PGP")
+	utf8BOM  = []byte{0xef, 0xbb, 0xbf}
+
+	errPGPArmor    = errors.New("this looks like a PGP key (or other PGP armored data), not an X.509 object")
+	errReadTimeout = errors.New("timed out reading input")
+	errNoStdinData = errors.New("no data on stdin")
 )
 
 var fileExtToFormat = map[string]string{
-	".pem":   "PEM",
-	".crt":   "PEM",
-	".p7b":   "PEM",
-	".p7c":   "PEM",
-	".p12":   "PKCS12",
-	".pfx":   "PKCS12",
-	".jceks": "JCEKS",
-	".jks":   "JCEKS", // Only partially supported
-	".der":   "DER",
+	".pem":        "PEM",
+	".crt":        "PEM",
+	".p7b":        "PEM",
+	".p7c":        "PEM",
+	".p12":        "PKCS12",
+	".pfx":        "PKCS12",
+	".jceks":      "JCEKS",
+	".jks":        "JCEKS", // Only partially supported
+	".bks":        "BKS",
+	".der":        "DER",
+	".pkipath":    "DER",
+	".json":       "JSON",
+	".kubeconfig": "KUBECONFIG",
+	".ldif":       "LDIF",
+	".exe":        "PE",
+	".dll":        "PE",
+	// Known extensions whose contents may be either PEM or DER, so the
+	// format is guessed from the data.
+	".cer":  "",
+	".cert": "",
+	".p7":   "",
 }
 
 var badSignatureAlgorithms = [...]x509.SignatureAlgorithm{
@@ -89,230 +158,1761 @@ func errorFromErrors(errs []error) error {
 // envelopes, or PKCS12/JCEKS keystores. All inputs will be converted to PEM
 // blocks and passed to the callback.
 func ReadAsPEMFromFiles(files []*os.File, format string, password func(string) string, callback func(*pem.Block, string) error) error {
-	var errs []error
-	for _, file := range files {
-		reader := bufio.NewReaderSize(file, 4)
-		format, err := formatForFile(reader, file.Name(), format)
-		if err != nil {
-			return fmt.Errorf("unable to guess file type for file %s", file.Name())
-		}
-
-		err = readCertsFromStream(reader, file.Name(), format, password, callback)
-		if err != nil {
-			errs = append(errs, err)
-		}
-	}
-	return errorFromErrors(errs)
+	return ReadPEMWithOptions(FilesToReaders(files), ReadOptions{Format: format, Password: PasswordFunc(password)}, callback)
 }
 
 // ReadAsPEM will read PEM blocks from the given set of inputs. Input data may
 // be in plain-text PEM files, DER-encoded certificates or PKCS7 envelopes, or
 // PKCS12/JCEKS keystores. All inputs will be converted to PEM blocks and
 // passed to the callback.
+//
+// Unlike ReadAsX509, no filtering takes place: every block in a PEM input is
+// passed to the callback as-is, whatever its type (keys, parameters, etc.)
+// and with its headers intact. The only change is the addition of an
+// originFile header for inputs read from named files.
 func ReadAsPEM(readers []io.Reader, format string, password func(string) string, callback func(*pem.Block, string) error) error {
+	return ReadPEMWithOptions(readers, ReadOptions{Format: format, Password: PasswordFunc(password)}, callback)
+}
+
+// certBlockTypes are the types of PEM blocks passed on with the CertsOnly
+// option.
+var certBlockTypes = map[string]bool{
+	"CERTIFICATE":             true,
+	PEMTypeTrustedCertificate: true,
+	"PKCS7":                   true,
+	"CMS":                     true,
+	"PKCS #7":                 true,
+}
+
+// ReadPEMWithOptions will read PEM blocks from the given set of inputs, as
+// configured by the given options. Inputs that have a name (such as
+// *os.File) use it for guessing the format and for error messages. All
+// inputs will be converted to PEM blocks and passed to the callback.
+func ReadPEMWithOptions(inputs []io.Reader, opts ReadOptions, callback func(*pem.Block, string) error) error {
+	opts = opts.withSubrange()
+	if opts.subrange != nil {
+		inner := callback
+		callback = func(block *pem.Block, format string) error {
+			return opts.subrange.emit(func() error { return inner(block, format) })
+		}
+	}
+	if opts.Filter != nil {
+		inner := callback
+		callback = func(block *pem.Block, format string) error {
+			if block.Type == "CERTIFICATE" {
+				if cert, err := x509.ParseCertificate(block.Bytes); err == nil && !opts.Filter(cert) {
+					return nil
+				}
+			}
+			return inner(block, format)
+		}
+	}
+	if opts.RejectWeak {
+		inner := callback
+		// Check the same certificates ReadX509WithOptions would, including
+		// those in PKCS7 envelopes. Parse errors aren't for this check to
+		// report, the block is passed on as usual.
+		checkWeak := pemToX509(func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return nil
+			}
+			return opts.rejectWeak(cert)
+		}, true)
+		callback = func(block *pem.Block, format string) error {
+			if err := checkWeak(block, format); err != nil {
+				return err
+			}
+			return inner(block, format)
+		}
+	}
+	if opts.CertsOnly {
+		inner := callback
+		callback = func(block *pem.Block, format string) error {
+			if !certBlockTypes[block.Type] {
+				return nil
+			}
+			return inner(block, format)
+		}
+	}
+	if opts.StampReadTime {
+		inner := callback
+		callback = func(block *pem.Block, format string) error {
+			block.Headers = mergeHeaders(block.Headers, map[string]string{
+				readAtHeader: opts.now().UTC().Format(time.RFC3339),
+			})
+			return inner(block, format)
+		}
+	}
+	return readInputs(inputs, opts, func(reader io.Reader, name, format string, report func(error)) error {
+		return readCertsFromStream(reader, name, format, opts, callback)
+	})
+}
+
+// readInputs guesses the format of each input and passes it to the given
+// read function, collecting the errors. Per-block errors can be recorded via
+// the report function, which is only used with CollectErrors.
+func readInputs(inputs []io.Reader, opts ReadOptions, read func(reader io.Reader, name, format string, report func(error)) error) error {
 	errs := []error{}
-	for _, r := range readers {
-		reader := bufio.NewReaderSize(r, 4)
-		format, err := formatForFile(reader, "", format)
-		if err != nil {
-			return fmt.Errorf("unable to guess format for input stream")
+	var clearDeadlines []func()
+	defer func() {
+		for _, clear := range clearDeadlines {
+			clear()
+		}
+	}()
+	for _, input := range inputs {
+		if opts.subrange.done() {
+			break
+		}
+		format := opts.Format
+		if formatted, ok := input.(formattedReader); ok {
+			input, format = formatted.Reader, formatted.format
+		}
+		name := inputName(input)
+		report := func(err error) {
+			errs = append(errs, inputError(name, err))
+		}
+		if !opts.Deadline.IsZero() {
+			var clear func()
+			input, clear = withDeadline(input, opts.Deadline)
+			clearDeadlines = append(clearDeadlines, clear)
 		}
 
-		err = readCertsFromStream(reader, "", format, password, callback)
+		reader := bufio.NewReaderSize(input, peekLength)
+		skipBOM(reader)
+		if _, err := reader.Peek(1); err == io.EOF && name == stdinName {
+			if !opts.CollectErrors {
+				return errNoStdinData
+			}
+			errs = append(errs, errNoStdinData)
+			continue
+		}
+		if prefix, err := reader.Peek(len(dataURIPrefix)); err == nil && strings.EqualFold(string(prefix), dataURIPrefix) {
+			// Embedded data: URI, guess the format of its payload instead
+			data, err := decodeDataURI(reader)
+			if err != nil {
+				if !opts.CollectErrors {
+					return err
+				}
+				report(err)
+				continue
+			}
+			reader = bufio.NewReaderSize(bytes.NewReader(data), peekLength)
+		}
+		format, err := formatForFile(reader, name, format)
 		if err != nil {
+			if name == "" {
+				err = fmt.Errorf("unable to guess format for input stream")
+			} else {
+				err = fmt.Errorf("unable to guess file type for file %s, try adding --format flag", name)
+			}
+			if !opts.CollectErrors {
+				return err
+			}
 			errs = append(errs, err)
+			continue
+		}
+
+		err = read(reader, name, format, report)
+		if pwErr, ok := err.(passwordError); ok {
+			return pwErr.err
 		}
+		if weakErr, ok := err.(*WeakSignatureError); ok {
+			return weakErr
+		}
+		if opts.subrange.done() {
+			// Stopped reading at the limit
+			break
+		}
+		if err != nil {
+			if opts.CollectErrors {
+				report(err)
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if opts.CollectErrors && len(errs) > 0 {
+		return ReadErrors(errs)
 	}
 	return errorFromErrors(errs)
 }
 
+// formattedReader is an input with a format of its own, see WithFormat.
+type formattedReader struct {
+	io.Reader
+	format string
+}
+
+// WithFormat sets the format of a single input passed to ReadPEMWithOptions,
+// ReadX509WithOptions and the like, overriding the Format option (and
+// format detection) for that input only. This helps with mixed inputs where
+// detection gets one of them wrong.
+func WithFormat(input io.Reader, format string) io.Reader {
+	return formattedReader{input, format}
+}
+
+// withDeadline makes reads from the given input fail after the deadline,
+// using its SetReadDeadline method if it has one. The returned function
+// clears that deadline again once reading is done, so that the caller can
+// keep using the input (e.g. a connection) afterwards.
+func withDeadline(input io.Reader, deadline time.Time) (io.Reader, func()) {
+	if conn, ok := input.(interface{ SetReadDeadline(time.Time) error }); ok && conn.SetReadDeadline(deadline) == nil {
+		return input, func() { conn.SetReadDeadline(time.Time{}) }
+	}
+	return &deadlineReader{reader: input, deadline: deadline}, func() {}
+}
+
+// deadlineReader does reads in the background, so that it can give up on
+// them at the deadline. A read that was given up on is left running, and
+// the reader fails from then on.
+type deadlineReader struct {
+	reader   io.Reader
+	deadline time.Time
+	err      error
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	timer := time.NewTimer(time.Until(r.deadline))
+	defer timer.Stop()
+
+	// Read into a separate buffer, which the background read may still
+	// write to after we gave up on it
+	result := make(chan readResult, 1)
+	go func() {
+		buf := make([]byte, len(p))
+		n, err := r.reader.Read(buf)
+		result <- readResult{buf[:n], err}
+	}()
+
+	select {
+	case res := <-result:
+		return copy(p, res.data), res.err
+	case <-timer.C:
+		r.err = errReadTimeout
+		return 0, r.err
+	}
+}
+
+// skipBOM skips a UTF-8 byte order mark at the start of the input, which
+// some Windows editors add to (PEM) text files.
+func skipBOM(reader *bufio.Reader) {
+	if prefix, err := reader.Peek(len(utf8BOM)); err == nil && bytes.Equal(prefix, utf8BOM) {
+		reader.Discard(len(utf8BOM))
+	}
+}
+
+// ReadErrors is returned by the Read functions when the CollectErrors option
+// is set, and holds every error encountered while reading.
+type ReadErrors []error
+
+func (errs ReadErrors) Error() string {
+	return errorFromErrors(errs).Error()
+}
+
+// inputError prefixes an error with the name of the input it occurred in.
+func inputError(name string, err error) error {
+	if name == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %s", name, strings.TrimSuffix(err.Error(), "\n"))
+}
+
+// ReadOptions holds settings for reading certificates, for use with
+// ReadPEMWithOptions and ReadX509WithOptions. New reading behaviors should be
+// added here rather than as extra arguments; the zero value must keep the
+// default behavior (guess the input format, use no password), which is what
+// the ReadAs* functions use apart from format and password.
+type ReadOptions struct {
+	// Format of the input (PEM, DER, HEX, TLS, PE, JSON, KUBECONFIG, LDIF,
+	// CERTDATA, JCEKS, BKS, PKCS12); heuristic if empty. JSON is a Vault
+	// PKI certificate bundle, CERTDATA an NSS certdata.txt file.
+	Format string
+
+	// Password is called to obtain passwords for PKCS12/JCEKS key stores.
+	// It receives the alias of the entry, or an empty string for the
+	// store-wide password. An error (e.g. from a cancelled prompt) aborts
+	// reading with that error. Use PasswordFunc to adapt callbacks that
+	// can't fail.
+	Password func(string) (string, error)
+
+	// PasswordPrompts tells that the Password callback asks the user (as
+	// opposed to returning a password given up front). The well-known
+	// default passwords of JCEKS/JKS and BKS key stores are then tried
+	// before calling it, so that e.g. Java's cacerts can be read without a
+	// prompt. A given password is otherwise used instead of the defaults.
+	PasswordPrompts bool
+
+	// Strict causes blocks that can't be read as certificates (for example
+	// a CSR mislabeled as "CERTIFICATE", or an unsupported block type) to
+	// be reported as errors to the callback instead of being skipped.
+	Strict bool
+
+	// GroupByAlias causes entries from PKCS12/JCEKS key stores to be
+	// emitted in per-alias groups, ordered by alias: each private key is
+	// followed by its certificate chain, leaf first. PKCS12 certificates
+	// without an alias of their own join the group of a certificate they
+	// issued. The friendlyName header is set on every block in a group.
+	GroupByAlias bool
+
+	// CollectErrors causes errors (unreadable inputs, and blocks that fail
+	// to parse) to be collected instead of aborting or being passed to the
+	// callback, so that everything readable is still processed. If there
+	// were any, a ReadErrors with all of them is returned at the end.
+	CollectErrors bool
+
+	// MetadataOnly causes key entries in JCEKS key stores to be skipped
+	// rather than decrypted, so only the store password is needed. The
+	// certificates of every entry are still emitted, ordered by alias, with
+	// the alias, entry type (e.g. "PrivateKeyEntry") and creation date in
+	// headers. Secret key entries are emitted as empty SECRET KEY blocks.
+	MetadataOnly bool
+
+	// CertsOnly causes ReadPEMWithOptions to pass only certificates (and
+	// PKCS7 envelopes) to the callback, dropping private and secret keys
+	// and any other blocks, for sharing the public parts of a key store
+	// without risk of leaking keys. Keys in JCEKS key stores aren't even
+	// decrypted, so their passwords aren't needed.
+	CertsOnly bool
+
+	// LinkTrustedCerts causes the trusted-cert entries of JCEKS key stores
+	// to be linked into chains by issuer/subject, for stores that hold a
+	// chain as separate entries rather than attached to a key entry. Each
+	// chain is emitted leaf first (in place of the leaf's alias with
+	// GroupByAlias), with a chainAlias header naming the leaf's alias.
+	// Certificates shared by several chains are only emitted with the first.
+	LinkTrustedCerts bool
+
+	// MultiSegment causes PEM and DER inputs to be read as a sequence of
+	// segments in either format, for files where PEM blocks and DER blobs
+	// (certificates or PKCS7) were concatenated. This is best-effort, and
+	// may let bad data through unnoticed, so it's off by default.
+	MultiSegment bool
+
+	// StampReadTime causes a readAt header with the time each block was
+	// read (in RFC 3339 format) to be added to the blocks passed to the
+	// callback of ReadPEMWithOptions.
+	StampReadTime bool
+
+	// Clock returns the current time for StampReadTime; time.Now if nil.
+	Clock func() time.Time
+
+	// Deadline, if set, is when reading each input must be done by. It's
+	// set as the read deadline of inputs that support it (like net.Conn),
+	// and other inputs are abandoned if a read takes past it, so that a
+	// stalled input doesn't hang forever.
+	Deadline time.Time
+
+	// RecordOffsets causes originOffset and originLength headers with the
+	// position of each PEM block or DER certificate in its input (in bytes)
+	// to be added to the blocks read. Positions in hex dumps and data: URIs
+	// are those in the decoded data, and a byte order mark at the start of
+	// the input isn't counted. Other formats don't have positions.
+	RecordOffsets bool
+
+	// Skip and Limit select a range of what is read: the first Skip
+	// certificates (or blocks, for ReadPEMWithOptions) across all inputs
+	// are skipped, and if Limit is positive, reading stops after Limit
+	// more have been passed to the callback.
+	Skip  int
+	Limit int
+
+	// Filter, if set, selects which certificates are passed to the
+	// callback (before Skip and Limit are applied). For ReadPEMWithOptions,
+	// it applies to CERTIFICATE blocks, and other blocks are passed as is.
+	// See FilterExpired, FilterValid and FilterExpiringWithin.
+	Filter func(*x509.Certificate) bool
+
+	// RejectWeak causes reading to be aborted with a WeakSignatureError as
+	// soon as a certificate signed with a weak algorithm (see
+	// IsWeakSignature) is read, before Filter is applied. For
+	// ReadPEMWithOptions, it applies to CERTIFICATE blocks.
+	RejectWeak bool
+
+	// CRLs, if set, is called by ReadX509WithOptions with the CRLs found
+	// while reading ("X509 CRL" blocks, and CRLs embedded in PKCS7
+	// envelopes), in input order along with the certificates. Errors
+	// parsing a CRL are passed to it as for certificates. Filter, Skip and
+	// Limit don't apply to CRLs. If not set, CRLs are skipped.
+	CRLs func(crl *CRL, format string, err error) error
+
+	// subrange keeps track of Skip and Limit while reading.
+	subrange *subrange
+
+	// offsetBase is added to recorded offsets, for reading segments of an
+	// input.
+	offsetBase int64
+}
+
+// withSubrange sets up tracking of Skip and Limit, if they're set.
+func (opts ReadOptions) withSubrange() ReadOptions {
+	if opts.Skip > 0 || opts.Limit > 0 {
+		opts.subrange = &subrange{skip: opts.Skip, limit: opts.Limit}
+	}
+	return opts
+}
+
+// subrange implements ReadOptions.Skip and Limit.
+type subrange struct {
+	skip, limit int
+	count       int
+	finished    bool
+}
+
+// errLimitReached aborts reading an input once the limit has been reached.
+var errLimitReached = errors.New("limit reached")
+
+// emit calls the given function unless the item is to be skipped. Once the
+// limit is reached, it returns errLimitReached to stop reading.
+func (r *subrange) emit(f func() error) error {
+	if r == nil {
+		return f()
+	}
+	r.count++
+	if r.count <= r.skip {
+		return nil
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	if r.limit > 0 && r.count >= r.skip+r.limit {
+		r.finished = true
+		return errLimitReached
+	}
+	return nil
+}
+
+func (r *subrange) done() bool {
+	return r != nil && r.finished
+}
+
+func (opts ReadOptions) now() time.Time {
+	if opts.Clock == nil {
+		return time.Now()
+	}
+	return opts.Clock()
+}
+
+func (opts ReadOptions) password(alias string) (string, error) {
+	if opts.Password == nil {
+		return "", nil
+	}
+	password, err := opts.Password(alias)
+	if err != nil {
+		return "", passwordError{err}
+	}
+	return password, nil
+}
+
+// WeakSignatureError is returned when reading with the RejectWeak option
+// finds a certificate signed with a weak algorithm.
+type WeakSignatureError struct {
+	Subject   string
+	Algorithm x509.SignatureAlgorithm
+}
+
+func (e *WeakSignatureError) Error() string {
+	return fmt.Sprintf("certificate '%s' is signed with weak signature algorithm %s", e.Subject, algString(e.Algorithm))
+}
+
+// rejectWeak returns a WeakSignatureError for the certificate if the
+// RejectWeak option is set and it has a weak signature.
+func (opts ReadOptions) rejectWeak(cert *x509.Certificate) error {
+	if !opts.RejectWeak || !IsWeakSignature(cert) {
+		return nil
+	}
+	subject := cert.Subject.String()
+	if formatted, err := FormatDN(cert.RawSubject); err == nil {
+		subject = formatted
+	}
+	return &WeakSignatureError{Subject: subject, Algorithm: cert.SignatureAlgorithm}
+}
+
+// passwordError marks an error from the password callback, which aborts
+// reading rather than being collected with the errors of other inputs.
+type passwordError struct {
+	err error
+}
+
+func (e passwordError) Error() string {
+	return e.err.Error()
+}
+
 // ReadAsX509FromFiles will read X.509 certificates from the given set of
 // inputs. Input data may be in plain-text PEM files, DER-encoded certificates
 // or PKCS7 envelopes, or PKCS12/JCEKS keystores. All inputs will be converted
 // to X.509 certificates (private keys are skipped) and passed to the callback.
 func ReadAsX509FromFiles(files []*os.File, format string, password func(string) string, callback func(*x509.Certificate, string, error) error) error {
-	errs := []error{}
-	for _, file := range files {
-		reader := bufio.NewReaderSize(file, 4)
-		format, err := formatForFile(reader, file.Name(), format)
+	return ReadX509WithOptions(FilesToReaders(files), ReadOptions{Format: format, Password: PasswordFunc(password)}, callback)
+}
+
+// ReadAsX509 will read X.509 certificates from the given set of inputs. Input
+// data may be in plain-text PEM files, DER-encoded certificates or PKCS7
+// envelopes, or PKCS12/JCEKS keystores. All inputs will be converted to X.509
+// certificates (private keys are skipped) and passed to the callback.
+func ReadAsX509(readers []io.Reader, format string, password func(string) string, callback func(*x509.Certificate, string, error) error) error {
+	return ReadX509WithOptions(readers, ReadOptions{Format: format, Password: PasswordFunc(password)}, callback)
+}
+
+// ReadX509WithOptions will read X.509 certificates from the given set of
+// inputs, as configured by the given options. Inputs that have a name (such
+// as *os.File) use it for guessing the format and for error messages. All
+// inputs will be converted to X.509 certificates (private keys are skipped)
+// and passed to the callback.
+func ReadX509WithOptions(inputs []io.Reader, opts ReadOptions, callback func(*x509.Certificate, string, error) error) error {
+	opts = opts.withSubrange()
+	return readInputs(inputs, opts, func(reader io.Reader, name, format string, report func(error)) error {
+		blockCallback := func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				if opts.CollectErrors {
+					report(err)
+					return nil
+				}
+				return callback(cert, format, err)
+			}
+			if err := opts.rejectWeak(cert); err != nil {
+				return err
+			}
+			if opts.Filter != nil && !opts.Filter(cert) {
+				return nil
+			}
+			return opts.subrange.emit(func() error { return callback(cert, format, nil) })
+		}
+		// Keep track of PKCS12 key stores with keys only, which would
+		// otherwise silently yield nothing.
+		var keys, others int
+		crlCallback := func(crl *CRL, format string, err error) error {
+			if err != nil && opts.CollectErrors {
+				report(err)
+				return nil
+			}
+			return opts.CRLs(crl, format, err)
+		}
+		toX509 := pemToX509(blockCallback, opts.Strict)
+		err := readCertsFromStream(reader, name, format, opts, func(block *pem.Block, format string) error {
+			if format == "PKCS12" {
+				if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+					keys++
+				} else {
+					others++
+				}
+			}
+			if opts.CRLs != nil {
+				if block.Type == PEMTypeCRL {
+					crl, err := ParseCRL(block.Bytes)
+					return crlCallback(crl, format, err)
+				}
+				if err := toX509(block, format); err != nil {
+					return err
+				}
+				return pemToCRLs(block, format, crlCallback)
+			}
+			return toX509(block, format)
+		})
+		if err == nil && keys > 0 && others == 0 {
+			err = blockCallback(nil, "PKCS12", ErrKeyWithoutCertificate)
+		}
+		return err
+	})
+}
+
+// ErrKeyWithoutCertificate is passed to the callback of ReadX509WithOptions
+// for a PKCS12 key store that holds private keys, but no certificates.
+var ErrKeyWithoutCertificate = errors.New("key store contains a private key, but no certificates")
+
+// SourceInfo describes where a certificate read by ReadX509Detailed came
+// from.
+type SourceInfo struct {
+	// Filename is the name of the input, or empty if it has none.
+	Filename string
+
+	// Format is the format the certificate was read from (PEM, DER,
+	// PKCS12, JCEKS, etc.).
+	Format string
+
+	// Alias is the alias (friendly name) of the entry in a key store that
+	// the certificate came from, if any.
+	Alias string
+
+	// Offset and Length give the position of the PEM block or DER
+	// certificate in the input, see ReadOptions.RecordOffsets. Length is
+	// zero if the position isn't known.
+	Offset int64
+	Length int64
+}
+
+// ReadX509Detailed is like ReadX509WithOptions, but passes information about
+// the source of each certificate to the callback. Certificates that fail to
+// parse abort reading with an error, unless the CollectErrors option is set.
+func ReadX509Detailed(inputs []io.Reader, opts ReadOptions, callback func(cert *x509.Certificate, source SourceInfo) error) error {
+	opts = opts.withSubrange()
+	opts.RecordOffsets = true
+	return readInputs(inputs, opts, func(reader io.Reader, name, format string, report func(error)) error {
+		return readCertsFromStream(reader, name, format, opts, func(block *pem.Block, format string) error {
+			source := SourceInfo{
+				Filename: name,
+				Format:   format,
+				Alias:    ownHeader(block.Headers, nameHeader),
+			}
+			if length, err := strconv.ParseInt(ownHeader(block.Headers, lengthHeader), 10, 64); err == nil {
+				source.Offset, _ = strconv.ParseInt(ownHeader(block.Headers, offsetHeader), 10, 64)
+				source.Length = length
+			}
+			return pemToX509(func(cert *x509.Certificate, format string, err error) error {
+				if err != nil {
+					if opts.CollectErrors {
+						report(err)
+						return nil
+					}
+					return err
+				}
+				if err := opts.rejectWeak(cert); err != nil {
+					return err
+				}
+				if opts.Filter != nil && !opts.Filter(cert) {
+					return nil
+				}
+				return opts.subrange.emit(func() error { return callback(cert, source) })
+			}, opts.Strict)(block, format)
+		})
+	})
+}
+
+// FilesToReaders converts files to readers, for ReadPEMWithOptions,
+// ReadX509WithOptions and the like.
+func FilesToReaders(files []*os.File) []io.Reader {
+	inputs := make([]io.Reader, len(files))
+	for i, file := range files {
+		inputs[i] = file
+	}
+	return inputs
+}
+
+// inputName returns the name of an input if it has one (e.g. for files).
+func inputName(input io.Reader) string {
+	if input == io.Reader(os.Stdin) {
+		return stdinName
+	}
+	if named, ok := input.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return ""
+}
+
+// ParseCertificatesPEM parses all X.509 certificates from the given string
+// of PEM data (plain CERTIFICATE blocks, or PKCS7 envelopes).
+func ParseCertificatesPEM(pemData string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	err := ReadAsX509(
+		[]io.Reader{strings.NewReader(pemData)},
+		"PEM",
+		func(string) string { return "" },
+		func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// ParseCertificatePEM parses the given string of PEM data and returns the
+// first X.509 certificate found in it.
+func ParseCertificatePEM(pemData string) (*x509.Certificate, error) {
+	certs, err := ParseCertificatesPEM(pemData)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found in PEM data")
+	}
+	return certs[0], nil
+}
+
+// ReadX509FromString reads X.509 certificates from the given string, like
+// ReadX509WithOptions. The format is guessed from the data unless set in
+// the options.
+func ReadX509FromString(data string, opts ReadOptions, callback func(*x509.Certificate, string, error) error) error {
+	return ReadX509WithOptions([]io.Reader{strings.NewReader(data)}, opts, callback)
+}
+
+// ReadX509FromEnv reads X.509 certificates from the value of the named
+// environment variable, like ReadX509FromString. As PEM data is often put
+// into environment variables with its line breaks escaped as `\n`, those
+// are unescaped if the value has no real line breaks. It's an error if the
+// variable is unset or empty.
+func ReadX509FromEnv(name string, opts ReadOptions, callback func(*x509.Certificate, string, error) error) error {
+	data := os.Getenv(name)
+	if data == "" {
+		return fmt.Errorf("environment variable %s is not set", name)
+	}
+	if !strings.Contains(data, "\n") {
+		data = strings.Replace(data, `\n`, "\n", -1)
+	}
+	return ReadX509WithOptions([]io.Reader{namedReader{strings.NewReader(data), "$" + name}}, opts, callback)
+}
+
+func pemToX509(callback func(*x509.Certificate, string, error) error, strict bool) func(*pem.Block, string) error {
+	return func(block *pem.Block, format string) error {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil && strict {
+				err = mismatchError(block, err)
+			}
+			return callback(cert, format, err)
+		case PEMTypeTrustedCertificate:
+			// OpenSSL trust settings follow the certificate
+			cert, _, err := ParseTrustedCertificate(block.Bytes)
+			return callback(cert, format, err)
+		case "PKCS7", "CMS", "PKCS #7":
+			// "CMS" is the RFC 7468 label, "PKCS #7" a variant some tools emit
+			certs, err := pkcs7.ExtractCertificates(block.Bytes)
+			if err != nil {
+				return callback(nil, format, err)
+			}
+			// Envelopes hold a set of certificates, in no particular order
+			for _, cert := range OrderChain(certs) {
+				if err := callback(cert, format, nil); err != nil {
+					return err
+				}
+			}
+		case "CERTIFICATE REQUEST":
+			if strict {
+				return callback(nil, format, errors.New("certificate requests are not supported"))
+			}
+			fmt.Println(red.SprintfFunc()("warning: certificate requests are not supported"))
+		case "ATTRIBUTE CERTIFICATE":
+			// Not X.509 public-key certificates; see ParseAttributeCertificate.
+			// Reported even when not strict, so they aren't dropped silently.
+			return callback(nil, format, errors.New("attribute certificates are not supported"))
+		default:
+			if strict && !strings.HasSuffix(block.Type, "PRIVATE KEY") && block.Type != "SECRET KEY" {
+				return callback(nil, format, fmt.Errorf("unsupported PEM block type '%s'", block.Type))
+			}
+		}
+		return nil
+	}
+}
+
+// pemToCRLs passes the CRLs embedded in a PKCS7 envelope to the callback.
+// Other blocks are ignored.
+func pemToCRLs(block *pem.Block, format string, callback func(*CRL, string, error) error) error {
+	switch block.Type {
+	case "PKCS7", "CMS", "PKCS #7":
+		raws, err := pkcs7.ExtractCRLs(block.Bytes)
 		if err != nil {
-			return fmt.Errorf("unable to guess file type for file %s, try adding --format flag", file.Name())
+			// Already reported when extracting the certificates
+			return nil
+		}
+		for _, raw := range raws {
+			crl, err := ParseCRL(raw)
+			if err := callback(crl, format, err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mismatchError explains why a block labeled as a certificate couldn't be
+// parsed, by checking if it holds some other (known) type of object.
+func mismatchError(block *pem.Block, err error) error {
+	if _, csrErr := x509.ParseCertificateRequest(block.Bytes); csrErr == nil {
+		return fmt.Errorf("block labeled '%s' contains a certificate request", block.Type)
+	}
+	if _, p7Err := pkcs7.ParseSignedData(block.Bytes); p7Err == nil {
+		return fmt.Errorf("block labeled '%s' contains a PKCS7 envelope", block.Type)
+	}
+	if _, keyErr := x509.ParsePKIXPublicKey(block.Bytes); keyErr == nil {
+		return fmt.Errorf("block labeled '%s' contains a public key", block.Type)
+	}
+	if _, keyErr := x509.ParsePKCS8PrivateKey(block.Bytes); keyErr == nil {
+		return fmt.Errorf("block labeled '%s' contains a private key", block.Type)
+	}
+	if _, keyErr := x509.ParsePKCS1PrivateKey(block.Bytes); keyErr == nil {
+		return fmt.Errorf("block labeled '%s' contains a private key", block.Type)
+	}
+	if _, keyErr := x509.ParseECPrivateKey(block.Bytes); keyErr == nil {
+		return fmt.Errorf("block labeled '%s' contains a private key", block.Type)
+	}
+	return fmt.Errorf("unable to parse block labeled '%s': %s", block.Type, err)
+}
+
+// readCertsFromStream takes some input and converts it to PEM blocks.
+func readCertsFromStream(reader io.Reader, filename string, format string, opts ReadOptions, callback func(*pem.Block, string) error) error {
+	headers := map[string]string{}
+	if filename != "" {
+		headers[fileHeader] = filename
+	}
+
+	format = strings.TrimSpace(format)
+	if opts.MultiSegment && (format == "PEM" || format == "DER") {
+		return readSegments(reader, filename, opts, callback)
+	}
+
+	switch format {
+	case "PEM":
+		normalizer := newPEMNormalizer(reader)
+		var located func(start, end int64)
+		blockHeaders := headers
+		if opts.RecordOffsets {
+			located = func(start, end int64) {
+				start, end = normalizer.sourceOffset(start), normalizer.sourceOffset(end-1)+1
+				blockHeaders = mergeHeaders(headers, opts.offsetHeaders(start, end-start))
+			}
+		}
+		scanner := pemScanner(normalizer, located)
+		for scanner.Scan() {
+			block, _ := pem.Decode(scanner.Bytes())
+			block.Headers = mergeHeaders(block.Headers, blockHeaders)
+			err := callback(block, format)
+			if err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("unable to read PEM data: %s\n", err)
+		}
+		return nil
+	case "DER":
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("unable to read input: %s\n", err)
+		}
+		return readDER(data, headers, format, opts, callback)
+	case "HEX":
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("unable to read input: %s\n", err)
+		}
+		der, err := decodeHexDump(data)
+		if err != nil {
+			return fmt.Errorf("unable to decode hex dump: %s\n", err)
+		}
+		return readDER(der, headers, format, opts, callback)
+	case "TLS":
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("unable to read input: %s\n", err)
+		}
+		rawCerts, err := parseTLSCertificateList(data)
+		if err != nil {
+			return fmt.Errorf("unable to parse TLS certificate list: %s\n", err)
+		}
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("unable to parse certificate from TLS certificate list: %s\n", err)
+			}
+			if err := callback(EncodeX509ToPEM(cert, headers), format); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "JSON":
+		if err := readVaultBundle(reader, opts, callback); err != nil {
+			return fmt.Errorf("%s\n", err)
+		}
+		return nil
+	case "BKS":
+		// Keys in BKS key stores can't be decrypted, so only read the
+		// certificates
+		keyStore, err := loadKeyStore(reader, opts, jceks.LoadBKSFromReader)
+		if _, ok := err.(passwordError); ok {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse keystore: %s\n", err)
+		}
+		return readJCEKSMetadata(keyStore, headers, format, callback)
+	case "KUBECONFIG":
+		if err := readKubeconfig(reader, opts, callback); err != nil {
+			return fmt.Errorf("%s\n", err)
+		}
+		return nil
+	case "LDIF":
+		if err := readLDIF(reader, opts, callback); err != nil {
+			return fmt.Errorf("%s\n", err)
+		}
+		return nil
+	case "CERTDATA":
+		if err := readCertdata(reader, callback); err != nil {
+			return fmt.Errorf("%s\n", err)
+		}
+		return nil
+	case "PE":
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("unable to read input: %s\n", err)
+		}
+		signatures, err := extractAuthenticodeSignatures(data)
+		if err != nil {
+			return fmt.Errorf("%s\n", err)
+		}
+		for _, signature := range signatures {
+			p7bBlocks, err := pkcs7.ParseSignedData(signature)
+			if err != nil {
+				return fmt.Errorf("unable to parse Authenticode signature: %s\n", err)
+			}
+			for _, block := range p7bBlocks {
+				if err := callback(pkcs7ToPem(block, headers), format); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case "PKCS12":
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("unable to read input: %s\n", err)
+		}
+		blocks, err := decodePKCS12(data, opts)
+		if _, ok := err.(passwordError); ok {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("%s\n", err)
+		}
+		if opts.GroupByAlias {
+			blocks = groupBlocksByAlias(blocks)
+		}
+		for _, block := range blocks {
+			block.Headers = mergeHeaders(block.Headers, headers)
+			err := callback(block, format)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "JCEKS":
+		keyStore, err := loadKeyStore(reader, opts, jceks.LoadFromReader)
+		if _, ok := err.(passwordError); ok {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse keystore: %s\n", err)
+		}
+		if opts.MetadataOnly {
+			return readJCEKSMetadata(keyStore, headers, format, callback)
+		}
+		if opts.GroupByAlias {
+			return readJCEKSGrouped(keyStore, headers, format, opts, callback)
+		}
+		if opts.LinkTrustedCerts {
+			for _, chain := range linkJCEKSCerts(keyStore) {
+				if err := readJCEKSChain(keyStore, chain, headers, format, callback); err != nil {
+					return err
+				}
+			}
+		} else {
+			for _, alias := range keyStore.ListCerts() {
+				if err := readJCEKSCert(keyStore, alias, headers, format, callback); err != nil {
+					return err
+				}
+			}
+		}
+		for _, alias := range keyStore.ListPrivateKeys() {
+			if err := readJCEKSPrivateKey(keyStore, alias, headers, format, opts, callback); err != nil {
+				return err
+			}
+		}
+		for _, alias := range keyStore.ListSecretKeys() {
+			if err := readJCEKSSecretKey(keyStore, alias, headers, format, opts, callback); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown file type '%s'\n", format)
+}
+
+// readDER parses X.509 certificates, PKCS7 envelopes or an attribute
+// certificate from DER data.
+func readDER(data []byte, headers map[string]string, format string, opts ReadOptions, callback func(*pem.Block, string) error) error {
+	if err := checkDERTruncated(data); err != nil {
+		return fmt.Errorf("%s\n", err)
+	}
+	x509Certs, err0 := x509.ParseCertificates(data)
+	offset := int64(0)
+	if err0 != nil {
+		if certs, contents, ok := unwrapCertificateSequence(data); ok {
+			x509Certs, err0 = certs, nil
+			offset = int64(len(data) - len(contents))
+		}
+	}
+	if err0 == nil {
+		for _, cert := range x509Certs {
+			certHeaders := headers
+			if opts.RecordOffsets {
+				certHeaders = mergeHeaders(headers, opts.offsetHeaders(offset, int64(len(cert.Raw))))
+				offset += int64(len(cert.Raw))
+			}
+			err := callback(EncodeX509ToPEM(cert, certHeaders), format)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if opts.RecordOffsets {
+		headers = mergeHeaders(headers, opts.offsetHeaders(0, int64(len(data))))
+	}
+	p7bBlocks, err1 := pkcs7.ParseSignedData(data)
+	if err1 == nil {
+		for _, block := range p7bBlocks {
+			err := callback(pkcs7ToPem(block, headers), format)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, err := ParseAttributeCertificate(data); err == nil {
+		return callback(&pem.Block{Type: "ATTRIBUTE CERTIFICATE", Bytes: data, Headers: headers}, format)
+	}
+	return fmt.Errorf("unable to parse certificates from DER data\n* X.509 parser gave: %s\n* PKCS7 parser gave: %s\n", err0, err1)
+}
+
+// unwrapCertificateSequence parses a chain encoded as a bare SEQUENCE OF
+// Certificate, as returned by some APIs (such as a Java CertPath in
+// "PkiPath" encoding), rather than as concatenated certificates. It returns
+// the certificates and the contents of the SEQUENCE, or false if the data
+// isn't such a chain.
+func unwrapCertificateSequence(data []byte) ([]*x509.Certificate, []byte, bool) {
+	var outer asn1.RawValue
+	rest, err := asn1.Unmarshal(data, &outer)
+	if err != nil || len(rest) > 0 || outer.Class != asn1.ClassUniversal || outer.Tag != asn1.TagSequence {
+		return nil, nil, false
+	}
+	if len(outer.Bytes) == 0 || outer.Bytes[0] != 0x30 {
+		return nil, nil, false
+	}
+	certs, err := x509.ParseCertificates(outer.Bytes)
+	if err != nil || len(certs) == 0 {
+		return nil, nil, false
+	}
+	return certs, outer.Bytes, true
+}
+
+// checkDERTruncated walks the (concatenated) SEQUENCEs at the top level of
+// DER data, and returns an error if the length of one of them runs past the
+// end of the data, as with an incomplete download, which the parsers would
+// only report as a cryptic ASN.1 error. Indefinite lengths (BER) and data
+// after the SEQUENCEs aren't checked.
+func checkDERTruncated(data []byte) error {
+	offset := 0
+	for offset+2 <= len(data) && data[offset] == 0x30 {
+		length, header := int(data[offset+1]), 2
+		if length > 0x80 {
+			size := length & 0x7f
+			if size > 4 || offset+2+size > len(data) {
+				return nil
+			}
+			length = 0
+			for _, b := range data[offset+2 : offset+2+size] {
+				length = length<<8 | int(b)
+			}
+			header += size
+		} else if length == 0x80 {
+			return nil
+		}
+		end := offset + header + length
+		if end > len(data) {
+			return fmt.Errorf("input appears truncated: expected %d bytes, got %d", end, len(data))
+		}
+		offset = end
+	}
+	return nil
+}
+
+// decodeHexDump decodes a hex dump (as copied from Wireshark, or printed by
+// xxd -p), ignoring whitespace and colon separators.
+func decodeHexDump(data []byte) ([]byte, error) {
+	digits := bytes.Map(func(r rune) rune {
+		if isHexSeparator(r) {
+			return -1
+		}
+		return r
+	}, data)
+	return hex.DecodeString(string(digits))
+}
+
+// looksLikeHexDump checks if the data consists of hex digits and separators.
+func looksLikeHexDump(data []byte) bool {
+	digits := 0
+	for _, c := range data {
+		switch {
+		case isHexSeparator(rune(c)):
+		case '0' <= c && c <= '9', 'a' <= c && c <= 'f', 'A' <= c && c <= 'F':
+			digits++
+		default:
+			return false
+		}
+	}
+	return digits > 0
+}
+
+func isHexSeparator(c rune) bool {
+	return c == ' ' || c == ':' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// offsetHeaders returns the headers for the position of a block, see
+// RecordOffsets.
+func (opts ReadOptions) offsetHeaders(offset, length int64) map[string]string {
+	return map[string]string{
+		offsetHeader: strconv.FormatInt(opts.offsetBase+offset, 10),
+		lengthHeader: strconv.FormatInt(length, 10),
+	}
+}
+
+// readSegments splits the input into consecutive PEM and DER segments, and
+// reads each with the matching format. A DER segment is a single ASN.1
+// element; a PEM segment runs until a DER element follows the end of a
+// block.
+func readSegments(reader io.Reader, filename string, opts ReadOptions, callback func(*pem.Block, string) error) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("unable to read input: %s\n", err)
+	}
+
+	opts.MultiSegment = false
+	total := int64(len(data))
+	for {
+		data = bytes.TrimLeft(data, " \t\r\n")
+		if len(data) == 0 {
+			return nil
+		}
+		opts.offsetBase = total - int64(len(data))
+
+		var segment []byte
+		format := "PEM"
+		if startsWithDER(data) {
+			format = "DER"
+			var raw asn1.RawValue
+			rest, err := asn1.Unmarshal(data, &raw)
+			if err != nil {
+				// Can't find the end (e.g. BER), so use everything
+				rest = nil
+			}
+			segment, data = data[:len(data)-len(rest)], rest
+		} else {
+			segment, data = splitPEMSegment(data)
+		}
+
+		if err := readCertsFromStream(bytes.NewReader(segment), filename, format, opts, callback); err != nil {
+			return err
+		}
+	}
+}
+
+// startsWithDER checks if data starts like a DER-encoded SEQUENCE with a
+// long-form length, as certificates and PKCS7 envelopes do. This tells it
+// apart from text starting with '0' (which is 0x30, too).
+func startsWithDER(data []byte) bool {
+	return len(data) > 1 && data[0] == 0x30 && data[1]&0x80 != 0
+}
+
+// splitPEMSegment splits off PEM data up to the end of the first block that
+// is followed by DER data.
+func splitPEMSegment(data []byte) (segment, rest []byte) {
+	offset := 0
+	for {
+		end := bytes.Index(data[offset:], []byte("-----END "))
+		if end < 0 {
+			return data, nil
+		}
+		offset += end + len("-----END ")
+		closing := bytes.Index(data[offset:], []byte("-----"))
+		if closing < 0 {
+			return data, nil
+		}
+		offset += closing + len("-----")
+		if startsWithDER(bytes.TrimLeft(data[offset:], " \t\r\n")) {
+			return data[:offset], data[offset:]
+		}
+	}
+}
+
+// readJCEKSGrouped emits the entries of a JCEKS key store ordered by alias.
+func readJCEKSGrouped(keyStore *jceks.KeyStore, headers map[string]string, format string, opts ReadOptions, callback func(*pem.Block, string) error) error {
+	isKey := map[string]bool{}
+	isSecretKey := map[string]bool{}
+	chains := map[string][]string{}
+	var aliases []string
+	if opts.LinkTrustedCerts {
+		for _, chain := range linkJCEKSCerts(keyStore) {
+			chains[chain[0]] = chain
+			aliases = append(aliases, chain[0])
+		}
+	} else {
+		aliases = keyStore.ListCerts()
+	}
+	for _, alias := range keyStore.ListPrivateKeys() {
+		isKey[alias] = true
+		aliases = append(aliases, alias)
+	}
+	for _, alias := range keyStore.ListSecretKeys() {
+		isSecretKey[alias] = true
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	for _, alias := range aliases {
+		var err error
+		if isKey[alias] {
+			err = readJCEKSPrivateKey(keyStore, alias, headers, format, opts, callback)
+		} else if isSecretKey[alias] {
+			err = readJCEKSSecretKey(keyStore, alias, headers, format, opts, callback)
+		} else if chain, ok := chains[alias]; ok {
+			err = readJCEKSChain(keyStore, chain, headers, format, callback)
+		} else {
+			err = readJCEKSCert(keyStore, alias, headers, format, callback)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pkcs12Decoder is pkcs12.ToPEM, replaceable for tests.
+var pkcs12Decoder = pkcs12.ToPEM
+
+var errMalformedPKCS12 = errors.New("malformed PKCS12 input")
+
+// errPKCS12NeedsPassword is returned when a PKCS12 file isn't protected with
+// an empty password, and no password was given.
+var errPKCS12NeedsPassword = errors.New("keystore is password protected, but no password was given")
+
+// errPKCS12PasswordMismatch is returned when the password verified the
+// integrity (MAC) of a PKCS12 file, but failed to decrypt its contents. The
+// file was then most likely created with separate integrity and privacy
+// passwords, which the pkcs12 package has no way of accepting.
+var errPKCS12PasswordMismatch = errors.New("password is correct for PKCS12 integrity check, but not for decryption (separate integrity and privacy passwords are not supported)")
+
+// decodePKCS12 decodes a PKCS12 file into PEM blocks, trying an empty
+// password before the one obtained from the options.
+func decodePKCS12(data []byte, opts ReadOptions) ([]*pem.Block, error) {
+	// Certs-only trust stores are commonly protected with an empty
+	// password, so try that before asking for one.
+	blocks, err := pkcs12ToPEM(data, "")
+	if err == pkcs12.ErrIncorrectPassword {
+		password, pwErr := opts.password("")
+		if pwErr != nil {
+			return nil, pwErr
+		}
+		if password == "" {
+			return nil, errPKCS12NeedsPassword
+		}
+		blocks, err = pkcs12ToPEM(data, password)
+		if err != nil && err != errMalformedPKCS12 && !isASCII(password) {
+			blocks, err = retryLegacyPKCS12Password(data, password, err)
+		}
+	}
+	switch {
+	case err == errMalformedPKCS12:
+		return nil, err
+	case err == pkcs12.ErrDecryption:
+		return nil, errPKCS12PasswordMismatch
+	case err == pkcs12.ErrIncorrectPassword:
+		return nil, errors.New("password for keystore was incorrect")
+	case err != nil || len(blocks) == 0:
+		return nil, errors.New("keystore appears to be empty or password was incorrect")
+	}
+	return blocks, nil
+}
+
+// pkcs12ToPEM calls pkcs12.ToPEM, turning a panic (which it has been known
+// to do on malformed input) into an error.
+func pkcs12ToPEM(data []byte, password string) (blocks []*pem.Block, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			blocks, err = nil, errMalformedPKCS12
+		}
+	}()
+	return pkcs12Decoder(data, password)
+}
+
+// retryLegacyPKCS12Password retries decoding a PKCS12 file whose password
+// (with non-ASCII characters) didn't work, encoding it the way OpenSSL
+// before 1.1.0 (and later versions in a non-UTF-8 locale) does: each byte
+// of its UTF-8 encoding as a character of its own, rather than the BMPString
+// (UTF-16) of the decoded characters the spec calls for. If that fails too,
+// the original error is returned, unless it was about the encoding itself
+// (e.g. for characters outside the BMP).
+func retryLegacyPKCS12Password(data []byte, password string, err error) ([]*pem.Block, error) {
+	legacy := make([]rune, len(password))
+	for i := 0; i < len(password); i++ {
+		legacy[i] = rune(password[i])
+	}
+	blocks, legacyErr := pkcs12ToPEM(data, string(legacy))
+	switch {
+	case legacyErr == nil:
+		return blocks, nil
+	case err == pkcs12.ErrIncorrectPassword || err == pkcs12.ErrDecryption:
+		return nil, err
+	}
+	return nil, legacyErr
+}
+
+// isASCII returns true if s only contains ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultKeyStorePasswords are tried for the integrity check of JCEKS/JKS
+// and BKS key stores when no password is given, or before prompting for one
+// (see PasswordPrompts). Java's cacerts trust store uses "changeit" by
+// default.
+var defaultKeyStorePasswords = []string{"changeit", ""}
+
+// loadKeyStore loads a JCEKS/JKS (or BKS) key store with the given load
+// function, using the password obtained from the options. The well-known
+// default passwords are tried if there is none (or it's empty), or first
+// if obtaining it means prompting.
+func loadKeyStore(reader io.Reader, opts ReadOptions, load func(io.Reader, []byte) (*jceks.KeyStore, error)) (*jceks.KeyStore, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return loadKeyStoreAt(bytes.NewReader(data), int64(len(data)), opts, load)
+}
+
+// loadKeyStoreAt is like loadKeyStore, but reads the key store from the
+// start again for each password tried, rather than buffering it.
+func loadKeyStoreAt(input io.ReaderAt, size int64, opts ReadOptions, load func(io.Reader, []byte) (*jceks.KeyStore, error)) (*jceks.KeyStore, error) {
+	loadWith := func(password string) (*jceks.KeyStore, error) {
+		return load(bufio.NewReader(io.NewSectionReader(input, 0, size)), []byte(password))
+	}
+	loadWithDefaults := func() (keyStore *jceks.KeyStore, err error) {
+		for _, password := range defaultKeyStorePasswords {
+			keyStore, err = loadWith(password)
+			if err == nil {
+				return keyStore, nil
+			}
+		}
+		return nil, err
+	}
+
+	var defaultsErr error
+	if opts.PasswordPrompts {
+		keyStore, err := loadWithDefaults()
+		if err == nil {
+			return keyStore, nil
+		}
+		defaultsErr = err
+	}
+	password, err := opts.password("")
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		return loadWith(password)
+	}
+	if defaultsErr != nil {
+		return nil, defaultsErr
+	}
+	return loadWithDefaults()
+}
+
+// readJCEKSMetadata emits the certificates of all entries in a JCEKS key
+// store, without decrypting any keys. Secret key entries, which have no
+// certificates, are listed as SECRET KEY blocks without contents.
+func readJCEKSMetadata(keyStore *jceks.KeyStore, headers map[string]string, format string, callback func(*pem.Block, string) error) error {
+	for _, entry := range keyStore.ListEntries() {
+		entryHeaders := mergeHeaders(headers, map[string]string{
+			nameHeader:      entry.Alias,
+			entryTypeHeader: entry.Type,
+			entryDateHeader: entry.Date.UTC().Format(time.RFC3339),
+		})
+		if entry.Type == jceks.SecretKeyEntry {
+			if err := callback(&pem.Block{Type: "SECRET KEY", Headers: entryHeaders}, format); err != nil {
+				return err
+			}
+			continue
 		}
-
-		err = readCertsFromStream(reader, file.Name(), format, password, pemToX509(callback))
-		if err != nil {
-			errs = append(errs, err)
+		for _, cert := range OrderChain(entry.Certs) {
+			if err := callback(EncodeX509ToPEM(cert, entryHeaders), format); err != nil {
+				return err
+			}
 		}
 	}
-	return errorFromErrors(errs)
+	return nil
 }
 
-// ReadAsX509 will read X.509 certificates from the given set of inputs. Input
-// data may be in plain-text PEM files, DER-encoded certificates or PKCS7
-// envelopes, or PKCS12/JCEKS keystores. All inputs will be converted to X.509
-// certificates (private keys are skipped) and passed to the callback.
-func ReadAsX509(readers []io.Reader, format string, password func(string) string, callback func(*x509.Certificate, string, error) error) error {
-	errs := []error{}
-	for _, r := range readers {
-		reader := bufio.NewReaderSize(r, 4)
-		format, err := formatForFile(reader, "", format)
-		if err != nil {
-			return fmt.Errorf("unable to guess format for input stream")
-		}
+func readJCEKSCert(keyStore *jceks.KeyStore, alias string, headers map[string]string, format string, callback func(*pem.Block, string) error) error {
+	cert, _ := keyStore.GetCert(alias)
+	return callback(EncodeX509ToPEM(cert, mergeHeaders(headers, map[string]string{nameHeader: alias})), format)
+}
 
-		err = readCertsFromStream(reader, "", format, password, pemToX509(callback))
-		if err != nil {
-			errs = append(errs, err)
+// readJCEKSChain emits the trusted-cert entries with the given aliases, a
+// chain from linkJCEKSCerts.
+func readJCEKSChain(keyStore *jceks.KeyStore, chain []string, headers map[string]string, format string, callback func(*pem.Block, string) error) error {
+	if len(chain) > 1 {
+		headers = mergeHeaders(headers, map[string]string{chainHeader: chain[0]})
+	}
+	for _, alias := range chain {
+		if err := readJCEKSCert(keyStore, alias, headers, format, callback); err != nil {
+			return err
 		}
 	}
-	return errorFromErrors(errs)
+	return nil
 }
 
-func pemToX509(callback func(*x509.Certificate, string, error) error) func(*pem.Block, string) error {
-	return func(block *pem.Block, format string) error {
-		switch block.Type {
-		case "CERTIFICATE":
-			cert, err := x509.ParseCertificate(block.Bytes)
-			return callback(cert, format, err)
-		case "PKCS7":
-			certs, err := pkcs7.ExtractCertificates(block.Bytes)
-			if err == nil {
-				for _, cert := range certs {
-					return callback(cert, format, nil)
-				}
-			} else {
-				return callback(nil, format, err)
+// linkJCEKSCerts links the trusted-cert entries of a JCEKS key store into
+// chains, see ReadOptions.LinkTrustedCerts. It returns the aliases of each
+// chain, leaf first, ordered by the alias of the leaf. Every entry is in
+// exactly one chain; those that couldn't be linked are on their own.
+func linkJCEKSCerts(keyStore *jceks.KeyStore) [][]string {
+	aliases := keyStore.ListCerts()
+	sort.Strings(aliases)
+
+	var certs []*x509.Certificate
+	aliasOf := map[*x509.Certificate]string{}
+	for _, alias := range aliases {
+		if cert, _ := keyStore.GetCert(alias); cert != nil {
+			certs = append(certs, cert)
+			aliasOf[cert] = alias
+		}
+	}
+
+	var chains [][]string
+	linked := map[string]bool{}
+	for _, cert := range certs {
+		if isIssuerOfAny(cert, certs) {
+			continue
+		}
+		// A chain that's too long is still linked as far as it goes
+		certChain, _ := BuildChain(cert, certs, len(certs))
+		var chain []string
+		for _, c := range certChain {
+			if alias := aliasOf[c]; !linked[alias] {
+				linked[alias] = true
+				chain = append(chain, alias)
 			}
-		case "CERTIFICATE REQUEST":
-			fmt.Println(red.SprintfFunc()("warning: certificate requests are not supported"))
 		}
-		return nil
+		chains = append(chains, chain)
+	}
+	// Self-issued roots not linked to any leaf, and certificates in cycles
+	for _, alias := range aliases {
+		if !linked[alias] {
+			chains = append(chains, []string{alias})
+		}
 	}
+	sort.Slice(chains, func(i, j int) bool { return chains[i][0] < chains[j][0] })
+	return chains
 }
 
-// readCertsFromStream takes some input and converts it to PEM blocks.
-func readCertsFromStream(reader io.Reader, filename string, format string, password func(string) string, callback func(*pem.Block, string) error) error {
-	headers := map[string]string{}
-	if filename != "" && filename != os.Stdin.Name() {
-		headers[fileHeader] = filename
-	}
+func readJCEKSPrivateKey(keyStore *jceks.KeyStore, alias string, headers map[string]string, format string, opts ReadOptions, callback func(*pem.Block, string) error) error {
+	mergedHeaders := mergeHeaders(headers, map[string]string{nameHeader: alias})
 
-	format = strings.TrimSpace(format)
-	switch format {
-	case "PEM":
-		scanner := pemScanner(reader)
-		for scanner.Scan() {
-			block, _ := pem.Decode(scanner.Bytes())
-			block.Headers = mergeHeaders(block.Headers, headers)
-			err := callback(block, format)
-			if err != nil {
-				return err
+	var certs []*x509.Certificate
+	if opts.CertsOnly {
+		// The key would be dropped anyway, so don't decrypt it
+		for _, entry := range keyStore.ListEntries() {
+			if entry.Alias == alias {
+				certs = entry.Certs
 			}
 		}
-		return nil
-	case "DER":
-		data, err := ioutil.ReadAll(reader)
+	} else {
+		password, err := opts.password(alias)
 		if err != nil {
-			return fmt.Errorf("unable to read input: %s\n", err)
-		}
-		x509Certs, err0 := x509.ParseCertificates(data)
-		if err0 == nil {
-			for _, cert := range x509Certs {
-				err := callback(EncodeX509ToPEM(cert, headers), format)
-				if err != nil {
-					return err
-				}
-			}
-			return nil
+			return err
 		}
-		p7bBlocks, err1 := pkcs7.ParseSignedData(data)
-		if err1 == nil {
-			for _, block := range p7bBlocks {
-				err := callback(pkcs7ToPem(block, headers), format)
-				if err != nil {
-					return err
-				}
-			}
-			return nil
+		key, keyCerts, err := keyStore.GetPrivateKeyAndCerts(alias, []byte(password))
+		if err != nil {
+			return fmt.Errorf("unable to parse keystore: %s\n", err)
 		}
-		return fmt.Errorf("unable to parse certificates from DER data\n* X.509 parser gave: %s\n* PKCS7 parser gave: %s\n", err0, err1)
-	case "PKCS12":
-		data, err := ioutil.ReadAll(reader)
+		block, err := keyToPem(key, mergedHeaders)
 		if err != nil {
-			return fmt.Errorf("unable to read input: %s\n", err)
+			return fmt.Errorf("problem reading key: %s\n", err)
 		}
-		blocks, err := pkcs12.ToPEM(data, password(""))
-		if err != nil || len(blocks) == 0 {
-			return fmt.Errorf("keystore appears to be empty or password was incorrect\n")
+		if err := callback(block, format); err != nil {
+			return err
 		}
-		for _, block := range blocks {
-			block.Headers = mergeHeaders(block.Headers, headers)
-			err := callback(block, format)
-			if err != nil {
-				return err
-			}
+		certs = keyCerts
+	}
+
+	// Emit the certificates leaf first, so they form a deployable chain
+	for _, cert := range OrderChain(certs) {
+		if err := callback(EncodeX509ToPEM(cert, mergedHeaders), format); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// readJCEKSSecretKey emits a secret key entry as a "SECRET KEY" pseudo-block
+// holding the raw key bytes, with the key algorithm in a header.
+func readJCEKSSecretKey(keyStore *jceks.KeyStore, alias string, headers map[string]string, format string, opts ReadOptions, callback func(*pem.Block, string) error) error {
+	if opts.CertsOnly {
 		return nil
-	case "JCEKS":
-		keyStore, err := jceks.LoadFromReader(reader, []byte(password("")))
-		if err != nil {
-			return fmt.Errorf("unable to parse keystore: %s\n", err)
+	}
+	password, err := opts.password(alias)
+	if err != nil {
+		return err
+	}
+	key, algorithm, err := keyStore.GetSecretKey(alias, []byte(password))
+	if err != nil {
+		return fmt.Errorf("unable to parse keystore: %s\n", err)
+	}
+
+	block := &pem.Block{
+		Type:    "SECRET KEY",
+		Bytes:   key,
+		Headers: mergeHeaders(headers, map[string]string{nameHeader: alias, keyAlgorithmHeader: algorithm}),
+	}
+	return callback(block, format)
+}
+
+// groupBlocksByAlias reorders PEM blocks from a PKCS12 key store into groups
+// by friendlyName, sorted by name, with private keys first in each group,
+// followed by the certificates ordered as a chain, leaf first. Blocks
+// without a friendlyName are attributed to an alias via their localKeyId
+// attribute if possible. Certificates without either (typically the CA
+// certificates of a chain) go with the group holding a certificate they
+// issued. Anything else is collected at the end.
+func groupBlocksByAlias(blocks []*pem.Block) []*pem.Block {
+	names := map[string]string{}
+	for _, block := range blocks {
+		if name, ok := block.Headers[nameHeader]; ok && block.Headers[localKeyIDHeader] != "" {
+			names[block.Headers[localKeyIDHeader]] = name
 		}
-		for _, alias := range keyStore.ListCerts() {
-			cert, _ := keyStore.GetCert(alias)
-			err := callback(EncodeX509ToPEM(cert, mergeHeaders(headers, map[string]string{nameHeader: alias})), format)
-			if err != nil {
-				return err
+	}
+
+	groups := map[string][]*pem.Block{}
+	var unnamed []*pem.Block
+	for _, block := range blocks {
+		name, ok := block.Headers[nameHeader]
+		if !ok {
+			name, ok = names[block.Headers[localKeyIDHeader]]
+			if ok {
+				block.Headers = mergeHeaders(block.Headers, map[string]string{nameHeader: name})
 			}
 		}
-		for _, alias := range keyStore.ListPrivateKeys() {
-			key, certs, err := keyStore.GetPrivateKeyAndCerts(alias, []byte(password(alias)))
-			if err != nil {
-				return fmt.Errorf("unable to parse keystore: %s\n", err)
-			}
+		if !ok {
+			unnamed = append(unnamed, block)
+			continue
+		}
+		groups[name] = append(groups[name], block)
+	}
 
-			mergedHeaders := mergeHeaders(headers, map[string]string{nameHeader: alias})
+	aliases := make([]string, 0, len(groups))
+	for alias := range groups {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
 
-			block, err := keyToPem(key, mergedHeaders)
-			if err != nil {
-				return fmt.Errorf("problem reading key: %s\n", err)
-			}
+	unnamed = attachIssuersByAlias(groups, aliases, unnamed)
 
-			if err := callback(block, format); err != nil {
-				return err
+	out := make([]*pem.Block, 0, len(blocks))
+	for _, alias := range aliases {
+		out = append(out, orderAliasGroup(groups[alias])...)
+	}
+	return append(out, unnamed...)
+}
+
+// attachIssuersByAlias moves the certificates among the unnamed blocks that
+// issued a certificate in one of the groups (possibly one attached before)
+// to that group, and returns the blocks that are left.
+func attachIssuersByAlias(groups map[string][]*pem.Block, aliases []string, unnamed []*pem.Block) []*pem.Block {
+	parsed := map[*pem.Block]*x509.Certificate{}
+	parse := func(block *pem.Block) *x509.Certificate {
+		if cert, ok := parsed[block]; ok {
+			return cert
+		}
+		var cert *x509.Certificate
+		if block.Type == "CERTIFICATE" {
+			cert, _ = x509.ParseCertificate(block.Bytes)
+		}
+		parsed[block] = cert
+		return cert
+	}
+	issuedInGroup := func(issuer *x509.Certificate, alias string) bool {
+		for _, block := range groups[alias] {
+			if cert := parse(block); cert != nil && !IsSelfIssued(cert) && findIssuer(cert, []*x509.Certificate{issuer}) != nil {
+				return true
 			}
+		}
+		return false
+	}
 
-			for _, cert := range certs {
-				if err = callback(EncodeX509ToPEM(cert, mergedHeaders), format); err != nil {
-					return err
+	for attached := true; attached; {
+		attached = false
+		var left []*pem.Block
+		for _, block := range unnamed {
+			cert := parse(block)
+			alias := ""
+			for _, candidate := range aliases {
+				if cert != nil && issuedInGroup(cert, candidate) {
+					alias = candidate
+					break
 				}
 			}
+			if alias == "" {
+				left = append(left, block)
+				continue
+			}
+			block.Headers = mergeHeaders(block.Headers, map[string]string{nameHeader: alias})
+			groups[alias] = append(groups[alias], block)
+			attached = true
 		}
-		return nil
+		unnamed = left
 	}
-	return fmt.Errorf("unknown file type '%s'\n", format)
+	return unnamed
+}
+
+// orderAliasGroup orders the blocks of an alias group: private keys first,
+// then the certificates as a chain (see OrderChain), then anything else.
+func orderAliasGroup(group []*pem.Block) []*pem.Block {
+	var keys, others []*pem.Block
+	var certs []*x509.Certificate
+	blockOf := map[*x509.Certificate]*pem.Block{}
+	for _, block := range group {
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			keys = append(keys, block)
+			continue
+		}
+		if block.Type == "CERTIFICATE" {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				certs = append(certs, cert)
+				blockOf[cert] = block
+				continue
+			}
+		}
+		others = append(others, block)
+	}
+
+	out := keys
+	for _, cert := range OrderChain(certs) {
+		out = append(out, blockOf[cert])
+	}
+	return append(out, others...)
 }
 
+// mergeHeaders adds the extra headers (set by certigo) to a copy of the base
+// headers (typically from the input). Existing values are preserved: an
+// empty extra value doesn't replace a set one, and a different value is
+// added under a namespaced key (e.g. "certigo-originFile") instead. Values
+// that aren't valid UTF-8 are transcoded by headerToUTF8.
 func mergeHeaders(baseHeaders, extraHeaders map[string]string) (headers map[string]string) {
 	headers = map[string]string{}
 	for k, v := range baseHeaders {
-		headers[k] = v
+		headers[k] = headerToUTF8(v)
 	}
 	for k, v := range extraHeaders {
-		headers[k] = v
+		v = headerToUTF8(v)
+		existing, ok := headers[k]
+		switch {
+		case !ok || existing == "" || existing == v:
+			headers[k] = v
+		case v != "":
+			headers[headerNamespace+k] = v
+		}
 	}
 	return
 }
 
+// windows1252 maps the bytes 0x80 to 0x9F of Windows-1252 to Unicode; the
+// other bytes map to the same code points as in Latin-1. Bytes unassigned
+// in Windows-1252 are kept as the Latin-1 control characters.
+var windows1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+// headerToUTF8 transcodes a header value that isn't valid UTF-8, assuming
+// it's in Windows-1252 (a superset of Latin-1). Some Windows tools write
+// friendly names in the legacy code page, which would otherwise come out
+// as mojibake.
+func headerToUTF8(value string) string {
+	if utf8.ValidString(value) {
+		return value
+	}
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		b := value[i]
+		if b >= 0x80 && b < 0xa0 {
+			out.WriteRune(windows1252[b-0x80])
+		} else {
+			out.WriteRune(rune(b))
+		}
+	}
+	return out.String()
+}
+
+// ownHeader returns a header field set by certigo, which may have been
+// namespaced by mergeHeaders.
+func ownHeader(headers map[string]string, key string) string {
+	if value, ok := headers[headerNamespace+key]; ok {
+		return value
+	}
+	return headers[key]
+}
+
 // EncodeX509ToPEM converts an X.509 certificate into a PEM block for output.
 func EncodeX509ToPEM(cert *x509.Certificate, headers map[string]string) *pem.Block {
+	return EncodeX509ToPEMWithType(cert, PEMTypeCertificate, headers)
+}
+
+// PEM block types that can be used for certificates with
+// EncodeX509ToPEMWithType.
+const (
+	PEMTypeCertificate        = "CERTIFICATE"
+	PEMTypeTrustedCertificate = "TRUSTED CERTIFICATE"
+	PEMTypeX509Certificate    = "X509 CERTIFICATE"
+)
+
+// PEMTypeCRL is the PEM block type of a certificate revocation list.
+const PEMTypeCRL = "X509 CRL"
+
+// EncodeX509ToPEMWithType is like EncodeX509ToPEM, but emits a PEM block
+// with the given type (e.g. "TRUSTED CERTIFICATE" or the legacy "X509
+// CERTIFICATE") instead. An empty block type means "CERTIFICATE".
+func EncodeX509ToPEMWithType(cert *x509.Certificate, blockType string, headers map[string]string) *pem.Block {
+	if blockType == "" {
+		blockType = PEMTypeCertificate
+	}
 	return &pem.Block{
-		Type:    "CERTIFICATE",
+		Type:    blockType,
 		Bytes:   cert.Raw,
 		Headers: headers,
 	}
 }
 
+// EncodeX509ToPKCS7PEM encodes the given certificates into a "certs-only"
+// PKCS7 bundle (the .p7b format preferred by Java/Windows tooling), wrapped
+// in a PEM block. Certificates are kept in the given order.
+func EncodeX509ToPKCS7PEM(certs []*x509.Certificate, headers map[string]string) (*pem.Block, error) {
+	raw, err := pkcs7.BuildCertsOnlyInOrder(certs)
+	if err != nil {
+		return nil, fmt.Errorf("error building PKCS7 bundle: %s", err)
+	}
+	return &pem.Block{
+		Type:    "PKCS7",
+		Bytes:   raw,
+		Headers: headers,
+	}, nil
+}
+
 // Convert a PKCS7 envelope into a PEM block for output.
 func pkcs7ToPem(block *pkcs7.SignedDataEnvelope, headers map[string]string) *pem.Block {
 	return &pem.Block{
@@ -333,11 +1933,35 @@ func keyToPem(key crypto.PrivateKey, headers map[string]string) (*pem.Block, err
 		}, nil
 	case *ecdsa.PrivateKey:
 		raw, err := x509.MarshalECPrivateKey(k)
+		if err == nil {
+			return &pem.Block{
+				Type:    "EC PRIVATE KEY",
+				Bytes:   raw,
+				Headers: headers,
+			}, nil
+		}
+		// PKCS8 might support more curves than SEC1 (depending on the
+		// version of the x509 package), so give that a try as well.
+		raw, err = x509.MarshalPKCS8PrivateKey(k)
+		if err == nil {
+			return &pem.Block{
+				Type:    "PRIVATE KEY",
+				Bytes:   raw,
+				Headers: headers,
+			}, nil
+		}
+		return nil, fmt.Errorf("error marshaling key: unsupported elliptic curve %s\n", curveName(k.Curve))
+	case *dsa.PrivateKey:
+		// The x509 package can't marshal DSA keys, so we produce the
+		// legacy OpenSSL format (as written by "openssl dsa") by hand.
+		raw, err := asn1.Marshal(dsaPrivateKey{
+			P: k.P, Q: k.Q, G: k.G, Y: k.Y, X: k.X,
+		})
 		if err != nil {
 			return nil, fmt.Errorf("error marshaling key: %s\n", reflect.TypeOf(key))
 		}
 		return &pem.Block{
-			Type:    "EC PRIVATE KEY",
+			Type:    "DSA PRIVATE KEY",
 			Bytes:   raw,
 			Headers: headers,
 		}, nil
@@ -345,6 +1969,24 @@ func keyToPem(key crypto.PrivateKey, headers map[string]string) (*pem.Block, err
 	return nil, fmt.Errorf("unknown key type: %s\n", reflect.TypeOf(key))
 }
 
+// curveName returns the name of an elliptic curve, for error messages.
+func curveName(curve elliptic.Curve) string {
+	params := curve.Params()
+	if params == nil {
+		return "(unknown)"
+	}
+	if params.Name != "" {
+		return params.Name
+	}
+	return fmt.Sprintf("(unnamed, %d bits)", params.BitSize)
+}
+
+// dsaPrivateKey is the legacy OpenSSL encoding of a DSA private key.
+type dsaPrivateKey struct {
+	Version       int
+	P, Q, G, Y, X *big.Int
+}
+
 // formatForFile returns the file format (either from flags or
 // based on file extension).
 func formatForFile(file *bufio.Reader, filename, format string) (string, error) {
@@ -355,7 +1997,7 @@ func formatForFile(file *bufio.Reader, filename, format string) (string, error)
 
 	// Second, attempt to guess based on extension
 	guess, ok := fileExtToFormat[strings.ToLower(filepath.Ext(filename))]
-	if ok {
+	if ok && guess != "" {
 		return guess, nil
 	}
 
@@ -371,34 +2013,383 @@ func formatForFile(file *bufio.Reader, filename, format string) (string, error)
 		// JCEKS/JKS files always start with this prefix
 		return "JCEKS", nil
 	}
-	if magic == 0x2D2D2D2D || magic == 0x434f4e4e {
-		// Starts with '----' or 'CONN' (what s_client prints...)
+	if magic == 0x2D2D2D2D || magic == 0x434f4e4e || magic == 0x64657074 {
+		// Starts with '----', 'CONN' or 'dept' (what s_client prints...)
+		return "PEM", nil
+	}
+	if magic&0xFF000000 == 0x7B000000 {
+		// Starts with '{', so possibly JSON (such as a Vault PKI bundle)
+		prefix, _ := file.Peek(peekLength)
+		if looksLikeVaultBundle(prefix) {
+			return "JSON", nil
+		}
+	}
+	if jceks.IsBKSVersion(magic) {
+		// BKS files start with the (small) version number
+		return "BKS", nil
+	}
+	if magic == 0x61706956 {
+		// Starts with 'apiV', as in the "apiVersion: v1" line kubectl
+		// puts at the top of kubeconfig files
+		return "KUBECONFIG", nil
+	}
+	if magic == 0x646E3A20 || magic == 0x646E3A3A || magic == 0x76657273 {
+		// Starts with 'dn: ', 'dn::' or 'vers' (as in "version: 1"), the
+		// first line of an LDIF record or file
+		return "LDIF", nil
+	}
+	if magic&0xFF000000 == 0x23000000 || magic == 0x42454749 {
+		// Starts with '#' or 'BEGI', possibly an NSS certdata.txt file
+		// (which starts with a license comment), or commented PEM
+		prefix, _ := file.Peek(peekLength)
+		if looksLikeCertdata(prefix) {
+			return "CERTDATA", nil
+		}
+		if bytes.Contains(prefix, pemStart) {
+			// Comments before PEM blocks, as in annotated bundles
+			return "PEM", nil
+		}
+	}
+	if magic == 0x582E3530 {
+		// Starts with 'X.50', as in the "X.509 Certificate Information:"
+		// text dump GnuTLS certtool puts before each PEM block
 		return "PEM", nil
 	}
+	if magic&0xFFFF0000 == 0x0b000000 || magic&0xFF0000FF == 0x00000030 || magic&0xFF0000FF == 0x00000000 {
+		// Might be a TLS Certificate message (handshake type 11), or a
+		// list of 3-byte length-prefixed certificates taken from one. As
+		// plenty of binary data starts like that, check that the length
+		// prefixes fit the input.
+		prefix, err := file.Peek(peekLength)
+		if looksLikeTLSCertificateList(prefix, err != nil) {
+			return "TLS", nil
+		}
+	}
+	if magic&0xFFFF0000 == 0x4D5A0000 {
+		// Starts with 'MZ', so probably a (signed) Windows PE binary
+		return "PE", nil
+	}
+	if magic&0xFF000000 == 0x30000000 {
+		// Looks like the input is DER-encoded, so it's either PKCS12 or
+		// X.509/PKCS7. Check the start of the structure to tell them apart.
+		prefix, _ := file.Peek(sniffLength)
+		if guess := sniffASN1Format(prefix); guess != "" {
+			return guess, nil
+		}
+	}
 	if magic&0xFFFF0000 == 0x30820000 {
-		// Looks like the input is DER-encoded, so it's either PKCS12 or X.509.
+		// Fall back to a guess based on the length of the input alone.
 		if magic&0x0000FF00 == 0x0300 {
 			// Probably X.509
 			return "DER", nil
 		}
 		return "PKCS12", nil
 	}
+	if magic&0xFFFF0000 == 0x30800000 {
+		// BER with indefinite length: PKCS7 (starting with an OID) or PKCS12.
+		if magic&0x0000FF00 == 0x0600 {
+			return "DER", nil
+		}
+		return "PKCS12", nil
+	}
+	if prefix, _ := file.Peek(sniffLength); looksLikeHexDump(prefix) {
+		// Hex digits only, probably a hex dump of DER data
+		return "HEX", nil
+	}
 
 	return "", fmt.Errorf("unable to guess file format")
 }
 
+// sniffLength is how much of an input we look at to guess its format.
+const sniffLength = 16
+
+// peekLength is how much of an input we may look at for formats that can't
+// be told apart by their first bytes, such as certdata.txt files.
+const peekLength = 4096
+
+// sniffASN1Format looks at the start of a DER (or BER) SEQUENCE to tell a
+// PKCS12 PFX, whose first field is the version (INTEGER 3), apart from X.509
+// certificates (SEQUENCE) and PKCS7 envelopes (OID). Returns an empty
+// string if the data doesn't match either.
+func sniffASN1Format(data []byte) string {
+	if len(data) < 2 || data[0] != 0x30 {
+		return ""
+	}
+	offset := 2
+	if data[1] > 0x80 {
+		// Long-form length
+		offset += int(data[1] & 0x7f)
+	}
+	if len(data) < offset+3 {
+		return ""
+	}
+	switch data[offset] {
+	case 0x02:
+		if data[offset+1] == 0x01 && data[offset+2] == 0x03 {
+			return "PKCS12"
+		}
+	case 0x30, 0x06:
+		return "DER"
+	}
+	return ""
+}
+
+// looksLikeTLSCertificateList checks whether the given start of the input
+// (all of it, if complete) is laid out like parseTLSCertificateList expects:
+// the length prefixes of the handshake message and the certificate list
+// must match the size of the input, and the first certificate must start
+// with a DER SEQUENCE of the size given by its own length prefix.
+func looksLikeTLSCertificateList(data []byte, complete bool) bool {
+	uint24 := func(b []byte) int {
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	}
+	// A prefix for everything that follows must cover the rest of the
+	// input, which is exactly what's left if the input is complete.
+	coversRest := func(length, rest int) bool {
+		return length == rest || (!complete && length > rest)
+	}
+
+	if len(data) >= 4 && data[0] == 0x0b {
+		if !coversRest(uint24(data[1:4]), len(data)-4) {
+			return false
+		}
+		data = data[4:]
+	}
+	if len(data) >= 4 && data[3] != 0x30 {
+		if !coversRest(uint24(data[0:3]), len(data)-3) {
+			return false
+		}
+		data = data[3:]
+	}
+	if len(data) < 6 || data[3] != 0x30 {
+		return false
+	}
+	length := uint24(data)
+	if complete && length > len(data)-3 {
+		return false
+	}
+	var der int
+	switch {
+	case data[4] < 0x80:
+		der = 2 + int(data[4])
+	case data[4] == 0x81:
+		der = 3 + int(data[5])
+	case data[4] == 0x82 && len(data) >= 7:
+		der = 4 + int(data[5])<<8 + int(data[6])
+	case data[4] == 0x83 && len(data) >= 8:
+		der = 5 + uint24(data[5:8])
+	}
+	return der == length
+}
+
+// parseTLSCertificateList splits the certificate_list of a TLS (1.2 and
+// earlier) Certificate message into DER certificates, each of which is
+// prefixed by a 3-byte length. The data may optionally include the length
+// of the whole list, and the handshake message header before that.
+func parseTLSCertificateList(data []byte) ([][]byte, error) {
+	uint24 := func(b []byte) int {
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	}
+
+	if len(data) >= 4 && data[0] == 0x0b && uint24(data[1:4]) == len(data)-4 {
+		// Handshake header: type (certificate) and length
+		data = data[4:]
+	}
+	if len(data) >= 4 && data[3] != 0x30 && uint24(data[0:3]) == len(data)-3 {
+		// Length of the whole list (rather than a single entry)
+		data = data[3:]
+	}
+
+	var certs [][]byte
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, fmt.Errorf("truncated length prefix")
+		}
+		length := uint24(data)
+		if length > len(data)-3 {
+			return nil, fmt.Errorf("truncated certificate (expected %d bytes, have %d)", length, len(data)-3)
+		}
+		certs = append(certs, data[3:3+length])
+		data = data[3+length:]
+	}
+	return certs, nil
+}
+
+// pemNormalizer is a reader that fixes up line endings in PEM data, so that
+// pem.Decode can handle it: CRLF and bare CR line endings become LF, and a
+// line break is inserted before a BEGIN marker that directly follows other
+// text (e.g. when files without a trailing newline were concatenated).
+type pemNormalizer struct {
+	reader *bufio.Reader
+	last   byte
+	insert bool
+
+	// in and out count the bytes read and returned, and shifts records
+	// where the two get out of step, to map positions back to the input.
+	in, out int64
+	shifts  []offsetShift
+}
+
+// offsetShift records that from position out on, the normalized data is
+// delta bytes behind the input.
+type offsetShift struct {
+	out, delta int64
+}
+
+func newPEMNormalizer(reader io.Reader) *pemNormalizer {
+	return &pemNormalizer{reader: bufio.NewReader(reader), last: '\n'}
+}
+
+func (n *pemNormalizer) Read(p []byte) (int, error) {
+	i := 0
+	for i < len(p) {
+		if n.insert {
+			n.insert = false
+			p[i] = '-'
+			n.last = '-'
+			// Comes from the same input byte as the inserted line break
+			n.track(n.in - 1)
+			i++
+			continue
+		}
+
+		b, err := n.reader.ReadByte()
+		if err != nil {
+			if i > 0 {
+				return i, nil
+			}
+			return 0, err
+		}
+		n.track(n.in)
+		n.in++
+
+		switch {
+		case b == '\r':
+			if next, err := n.reader.Peek(1); err == nil && next[0] == '\n' {
+				n.reader.ReadByte()
+				n.in++
+			}
+			b = '\n'
+		case b == '-' && n.last != '\n':
+			if n.beginFollows() {
+				// Emit a newline now, and the dash on the next iteration
+				b = '\n'
+				n.insert = true
+			}
+		}
+
+		p[i] = b
+		n.last = b
+		i++
+
+		if n.reader.Buffered() == 0 && i > 0 {
+			// Don't block on more input if we have something to return
+			return i, nil
+		}
+	}
+	return i, nil
+}
+
+// beginFollows checks if the rest of a BEGIN marker follows a dash. To not
+// block on a live stream, it only waits for more input if what's buffered
+// so far could be the start of a marker.
+func (n *pemNormalizer) beginFollows() bool {
+	rest := pemStart[1:]
+	buffered := n.reader.Buffered()
+	if buffered > len(rest) {
+		buffered = len(rest)
+	}
+	next, _ := n.reader.Peek(buffered)
+	if !bytes.HasPrefix(rest, next) {
+		return false
+	}
+	next, _ = n.reader.Peek(len(rest))
+	return bytes.Equal(next, rest)
+}
+
+// track records that the next byte returned comes from the given position
+// in the input.
+func (n *pemNormalizer) track(in int64) {
+	delta := int64(0)
+	if len(n.shifts) > 0 {
+		delta = n.shifts[len(n.shifts)-1].delta
+	}
+	if in-n.out != delta {
+		n.shifts = append(n.shifts, offsetShift{n.out, in - n.out})
+	}
+	n.out++
+}
+
+// sourceOffset maps a position in the normalized data back to the input.
+func (n *pemNormalizer) sourceOffset(out int64) int64 {
+	i := sort.Search(len(n.shifts), func(i int) bool { return n.shifts[i].out > out })
+	if i == 0 {
+		return out
+	}
+	return out + n.shifts[i-1].delta
+}
+
 // pemScanner will return a bufio.Scanner that splits the input
-// from the given reader into PEM blocks.
-func pemScanner(reader io.Reader) *bufio.Scanner {
+// from the given reader into PEM blocks. Any text surrounding the blocks
+// (such as the connection and session info printed by openssl s_client)
+// is skipped. If located is set, it's called with the start and end of each
+// block before the block is returned.
+func pemScanner(reader io.Reader, located func(start, end int64)) *bufio.Scanner {
 	scanner := bufio.NewScanner(reader)
+	// PKCS7 bundles (e.g. of a whole CA store) easily exceed the default
+	// limit of 64KiB, which would stop the scan
+	scanner.Buffer(nil, maxPEMBlockSize)
+	var pos int64
 
 	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		// PGP armor looks like PEM, but won't decode as such (and isn't
+		// something we could handle anyway).
+		if start := bytes.Index(data, pemStart); start >= 0 && bytes.HasPrefix(data[start:], pgpStart) {
+			return 0, nil, errPGPArmor
+		}
+
 		block, rest := pem.Decode(data)
 		if block != nil {
 			size := len(data) - len(rest)
+			if located != nil {
+				token := data[:size]
+				start := bytes.LastIndex(token, pemStart)
+				end := len(bytes.TrimRight(token, " \t\r\n"))
+				located(pos+int64(start), pos+int64(end))
+			}
+			pos += int64(size)
 			return size, data[:size], nil
 		}
 
+		if atEOF {
+			// Trailing text after the last block
+			pos += int64(len(data))
+			return len(data), nil, nil
+		}
+
+		// Skip leading text up to the next (potential) block, so it
+		// doesn't pile up in the buffer. If there's no start marker yet,
+		// keep enough bytes to match one split across reads.
+		start := bytes.Index(data, pemStart)
+		if start == 0 {
+			// Resynchronize after a broken block: skip a BEGIN marker
+			// that's followed by another one before any END marker, or
+			// whose block ended (at the end of a line) but didn't decode.
+			end := bytes.Index(data, pemEnd)
+			next := bytes.Index(data[1:], pemStart) + 1
+			if next > 0 && (end < 0 || end > next) {
+				start = next
+			} else if end >= 0 && bytes.IndexByte(data[end:], '\n') >= 0 {
+				start = len(pemStart)
+			}
+		}
+		if start < 0 {
+			start = len(data) - len(pemStart) + 1
+		}
+		if start > 0 {
+			pos += int64(start)
+			return start, nil, nil
+		}
 		return 0, nil, nil
 	})
 
@@ -0,0 +1,1906 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/dsa"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/square/certigo/pkcs7"
+	"golang.org/x/crypto/pkcs12"
+)
+
+func TestReadAsPEMPassthrough(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/mixed-bundle.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	var out bytes.Buffer
+	err = ReadAsPEM([]io.Reader{bytes.NewReader(data)}, "", nil, func(block *pem.Block, format string) error {
+		types = append(types, block.Type)
+		return pem.Encode(&out, block)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"CERTIFICATE", "EC PARAMETERS", "EC PRIVATE KEY", "DH PARAMETERS"}
+	if len(types) != len(expected) {
+		t.Fatalf("unexpected block types: %v", types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Fatalf("unexpected block types: %v", types)
+		}
+	}
+
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("round-tripped bundle differs from input:\n%s", out.String())
+	}
+}
+
+func TestReadAsX509SClientOutput(t *testing.T) {
+	cert, err := ioutil.ReadFile("../test-certs/example-leaf.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data bytes.Buffer
+	data.WriteString("CONNECTED(00000003)\n")
+	data.WriteString("depth=0 CN = example-leaf\nverify error:num=20:unable to get local issuer certificate\n")
+	data.WriteString("---\nCertificate chain\n 0 s:CN = example-leaf\n   i:CN = example-root\n")
+	data.Write(cert)
+	data.WriteString(strings.Repeat("    Session-ID-ctx: -----\n", 5000))
+	data.WriteString("---\nServer certificate\n")
+	data.Write(cert)
+	data.WriteString("---\nSSL handshake has read 1234 bytes\n")
+	data.WriteString("---\nDONE\n")
+
+	count := 0
+	input := iotest.OneByteReader(&data)
+	err = ReadAsX509([]io.Reader{input}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("unexpected number of certificates: %d != 2", count)
+	}
+}
+
+func TestReadAsX509IndefiniteLengthPKCS7(t *testing.T) {
+	data, err := ioutil.ReadFile("../pkcs7/testdata/indefinite-length.p7b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	err = ReadAsX509([]io.Reader{bytes.NewReader(data)}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("unexpected number of certificates: %d != 1", count)
+	}
+}
+
+func TestReadAsX509TLSCertificateMessage(t *testing.T) {
+	var list []byte
+	for _, name := range []string{"example-leaf", "example-root"} {
+		data, err := ioutil.ReadFile("../test-certs/" + name + ".crt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		list = append(list, byte(len(block.Bytes)>>16), byte(len(block.Bytes)>>8), byte(len(block.Bytes)))
+		list = append(list, block.Bytes...)
+	}
+	body := append([]byte{byte(len(list) >> 16), byte(len(list) >> 8), byte(len(list))}, list...)
+	message := append([]byte{0x0b, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+
+	for _, input := range [][]byte{list, body, message} {
+		var names []string
+		err := ReadAsX509([]io.Reader{bytes.NewReader(input)}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != "TLS" {
+				t.Fatalf("unexpected format: %s", format)
+			}
+			names = append(names, cert.Subject.CommonName)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 2 || names[0] != "example-leaf" || names[1] != "example-root" {
+			t.Fatalf("unexpected certificates: %v", names)
+		}
+	}
+
+	// Longer than what's looked at to guess the format
+	long := bytes.Repeat(list, 4)
+	if format, err := formatForFile(bufio.NewReaderSize(bytes.NewReader(long), peekLength), "", ""); err != nil || format != "TLS" {
+		t.Errorf("unexpected format for long list: %s (%v)", format, err)
+	}
+
+	// Binary data that merely starts with zero bytes, as UTF-16 text and
+	// padded blobs do
+	others := map[string][]byte{
+		"UTF-16":        []byte("\x00\x00\xfe\xff\x00-\x00-\x00-\x00-"),
+		"zero padding":  make([]byte, 64),
+		"wrong length":  append([]byte{0x00, 0x10, 0x00}, list[3:]...),
+		"no DER inside": append([]byte{0x00, 0x00, 0x04, 0x30, 0x05, 0x00, 0x00}, make([]byte, 32)...),
+	}
+	for name, input := range others {
+		if format, err := formatForFile(bufio.NewReaderSize(bytes.NewReader(input), peekLength), "", ""); err == nil && format == "TLS" {
+			t.Errorf("%s: unexpectedly guessed TLS", name)
+		}
+	}
+}
+
+func TestReadX509CollectErrors(t *testing.T) {
+	good, err := ioutil.ReadFile("../test-certs/example-leaf.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")})
+
+	inputs := []io.Reader{
+		bytes.NewReader(append(bad, good...)),
+		strings.NewReader("not a certificate"),
+		bytes.NewReader(good),
+	}
+
+	count := 0
+	err = ReadX509WithOptions(inputs, ReadOptions{CollectErrors: true}, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			t.Fatalf("callback got error: %s", err)
+		}
+		count++
+		return nil
+	})
+	if count != 2 {
+		t.Fatalf("unexpected number of certificates: %d != 2", count)
+	}
+	errs, ok := err.(ReadErrors)
+	if !ok {
+		t.Fatalf("expected ReadErrors, got: %v", err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("unexpected number of errors: %d != 2 (%s)", len(errs), errs)
+	}
+}
+
+func TestReadAsX509PGPArmor(t *testing.T) {
+	armor := `-----BEGIN PGP PUBLIC KEY BLOCK-----
+Version: GnuPG v2
+
+mQENBFnGxEsBCAC2bmgzPmY6sUBHhLEgSQqq6Xse7uR3bIkq0bT/7w0+1KkV
+=nU4c
+-----END PGP PUBLIC KEY BLOCK-----
+`
+	err := ReadAsX509([]io.Reader{strings.NewReader(armor)}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+		t.Fatal("unexpected callback")
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "PGP") {
+		t.Fatalf("expected PGP error, got: %v", err)
+	}
+}
+
+func TestKeyToPemDSA(t *testing.T) {
+	key := new(dsa.PrivateKey)
+	if err := dsa.GenerateParameters(&key.Parameters, rand.Reader, dsa.L1024N160); err != nil {
+		t.Fatal(err)
+	}
+	if err := dsa.GenerateKey(key, rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := keyToPem(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Type != "DSA PRIVATE KEY" {
+		t.Fatalf("unexpected block type: %s", block.Type)
+	}
+
+	var decoded dsaPrivateKey
+	if _, err := asn1.Unmarshal(block.Bytes, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Version != 0 || decoded.X.Cmp(key.X) != 0 || decoded.Y.Cmp(key.Y) != 0 || decoded.P.Cmp(key.P) != 0 {
+		t.Fatal("decoded key differs")
+	}
+}
+
+func TestReadPEMLatin1Headers(t *testing.T) {
+	file, err := os.Open("testdata/latin1-friendlyname.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var blocks []*pem.Block
+	err = ReadAsPEMFromFiles([]*os.File{file}, "", nil, func(block *pem.Block, format string) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 {
+		t.Fatalf("unexpected number of blocks: %d != 1", len(blocks))
+	}
+	if name, expected := blocks[0].Headers[nameHeader], "Zertifikat für Müller € “Test”"; name != expected {
+		t.Errorf("unexpected friendly name: %q != %q", name, expected)
+	}
+	if _, err := x509.ParseCertificate(blocks[0].Bytes); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadAsWrappers(t *testing.T) {
+	// The ReadAs* functions are ReadX509WithOptions/ReadPEMWithOptions
+	// with just the format and password set
+	password := func(string) string { return "password" }
+	opts := ReadOptions{Format: "PKCS12", Password: PasswordFromMap(nil, "password")}
+	open := func() *os.File {
+		file, err := os.Open("testdata/password.p12")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	readCerts := func(read func(func(*x509.Certificate, string, error) error) error) []string {
+		var out []string
+		err := read(func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			out = append(out, format+":"+cert.Subject.CommonName)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	readBlocks := func(read func(func(*pem.Block, string) error) error) []string {
+		var out []string
+		err := read(func(block *pem.Block, format string) error {
+			out = append(out, format+":"+block.Type)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	file := open()
+	expectedCerts := readCerts(func(callback func(*x509.Certificate, string, error) error) error {
+		return ReadX509WithOptions([]io.Reader{file}, opts, callback)
+	})
+	file.Close()
+	if len(expectedCerts) == 0 {
+		t.Fatal("no certificates read")
+	}
+	file = open()
+	expectedBlocks := readBlocks(func(callback func(*pem.Block, string) error) error {
+		return ReadPEMWithOptions([]io.Reader{file}, opts, callback)
+	})
+	file.Close()
+
+	file = open()
+	certs := readCerts(func(callback func(*x509.Certificate, string, error) error) error {
+		return ReadAsX509([]io.Reader{file}, "PKCS12", password, callback)
+	})
+	file.Close()
+	file = open()
+	certsFromFiles := readCerts(func(callback func(*x509.Certificate, string, error) error) error {
+		return ReadAsX509FromFiles([]*os.File{file}, "PKCS12", password, callback)
+	})
+	file.Close()
+	file = open()
+	blocks := readBlocks(func(callback func(*pem.Block, string) error) error {
+		return ReadAsPEM([]io.Reader{file}, "PKCS12", password, callback)
+	})
+	file.Close()
+	file = open()
+	blocksFromFiles := readBlocks(func(callback func(*pem.Block, string) error) error {
+		return ReadAsPEMFromFiles([]*os.File{file}, "PKCS12", password, callback)
+	})
+	file.Close()
+
+	if !reflect.DeepEqual(certs, expectedCerts) || !reflect.DeepEqual(certsFromFiles, expectedCerts) {
+		t.Errorf("unexpected certificates: %v and %v, expected %v", certs, certsFromFiles, expectedCerts)
+	}
+	if !reflect.DeepEqual(blocks, expectedBlocks) || !reflect.DeepEqual(blocksFromFiles, expectedBlocks) {
+		t.Errorf("unexpected blocks: %v and %v, expected %v", blocks, blocksFromFiles, expectedBlocks)
+	}
+
+	// The zero value guesses the format
+	key := newTestKey(t)
+	cert := issueTestCert(t, "guessed", key, "guessed", key)
+	guessed := readCerts(func(callback func(*x509.Certificate, string, error) error) error {
+		return ReadX509WithOptions([]io.Reader{bytes.NewReader(cert.Raw)}, ReadOptions{}, callback)
+	})
+	if !reflect.DeepEqual(guessed, []string{"DER:guessed"}) {
+		t.Errorf("unexpected certificates with zero options: %v", guessed)
+	}
+}
+
+func TestReadAsX509UTF8BOM(t *testing.T) {
+	file, err := os.Open("testdata/utf8-bom.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	count := 0
+	err = ReadAsX509FromFiles([]*os.File{file}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("unexpected number of certificates: %d != 1", count)
+	}
+
+	// Without an extension to go by, the format is guessed from the data.
+	data, err := ioutil.ReadFile("testdata/utf8-bom.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count = 0
+	err = ReadAsX509([]io.Reader{bytes.NewReader(data)}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("unexpected number of certificates: %d != 1", count)
+	}
+}
+
+func TestReadAsX509LineEndings(t *testing.T) {
+	concatenated, err := ioutil.ReadFile("testdata/concatenated-no-newline.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := map[string][]byte{
+		"concatenated": concatenated,
+		"crlf":         bytes.Replace(concatenated, []byte("\n"), []byte("\r\n"), -1),
+		"cr":           bytes.Replace(concatenated, []byte("\n"), []byte("\r"), -1),
+	}
+	for name, data := range inputs {
+		var names []string
+		err := ReadAsX509([]io.Reader{iotest.HalfReader(bytes.NewReader(data))}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			names = append(names, cert.Subject.CommonName)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(names) != 2 || names[0] != "example-leaf" || names[1] != "example-root" {
+			t.Fatalf("%s: unexpected certificates: %v", name, names)
+		}
+	}
+}
+
+func TestParseCertificatesPEM(t *testing.T) {
+	leaf := readTestFile(t, "../test-certs/example-leaf.crt")
+	root := readTestFile(t, "../test-certs/example-root.crt")
+
+	certs, err := ParseCertificatesPEM(leaf + root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || certs[0].Subject.CommonName != "example-leaf" || certs[1].Subject.CommonName != "example-root" {
+		t.Errorf("unexpected certificates: %v", certs)
+	}
+
+	cert, err := ParseCertificatePEM(root + leaf)
+	if err != nil || cert.Subject.CommonName != "example-root" {
+		t.Errorf("unexpected certificate %v: %v", cert, err)
+	}
+
+	if certs, err := ParseCertificatesPEM(""); err != nil || len(certs) != 0 {
+		t.Errorf("unexpected result for empty input: %v, %v", certs, err)
+	}
+	if _, err := ParseCertificatePEM("not a certificate"); err == nil {
+		t.Error("expected error without certificates")
+	}
+}
+
+func TestEncodeX509ToPEMWithType(t *testing.T) {
+	key := newTestKey(t)
+	cert := issueTestCert(t, "Test CA", key, "Test CA", key)
+
+	if block := EncodeX509ToPEM(cert, nil); block.Type != "CERTIFICATE" {
+		t.Errorf("unexpected default block type: %s", block.Type)
+	}
+	if block := EncodeX509ToPEMWithType(cert, "", nil); block.Type != "CERTIFICATE" {
+		t.Errorf("unexpected block type for empty type: %s", block.Type)
+	}
+
+	block := EncodeX509ToPEMWithType(cert, PEMTypeTrustedCertificate, map[string]string{"friendlyName": "test"})
+	if block.Type != "TRUSTED CERTIFICATE" {
+		t.Errorf("unexpected block type: %s", block.Type)
+	}
+	if !bytes.Equal(block.Bytes, cert.Raw) || block.Headers["friendlyName"] != "test" {
+		t.Error("block contents differ from certificate")
+	}
+}
+
+func TestReadJCEKSMetadataOnly(t *testing.T) {
+	file, err := os.Open("../jceks/testdata/private-key.jceks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Only the store password is given, so decrypting the key would fail
+	opts := ReadOptions{
+		Password:     PasswordFromMap(map[string]string{"": "private-key-store-password"}, "wrong"),
+		MetadataOnly: true,
+	}
+	var blocks []*pem.Block
+	err = ReadPEMWithOptions([]io.Reader{file}, opts, func(block *pem.Block, format string) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 {
+		t.Fatalf("unexpected number of blocks: %d != 1", len(blocks))
+	}
+	block := blocks[0]
+	if block.Type != "CERTIFICATE" || block.Headers["friendlyName"] != "private-key-some-alias" || block.Headers["entryType"] != "PrivateKeyEntry" {
+		t.Fatalf("unexpected block: %s %v", block.Type, block.Headers)
+	}
+
+	if block.Headers["entryDate"] == "" {
+		t.Errorf("missing entry date: %v", block.Headers)
+	}
+
+	// Secret keys are listed too, without the key
+	file, err = os.Open("testdata/secret-key.jceks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	opts.Password = PasswordFromMap(map[string]string{"": "secret-key-store-password"}, "wrong")
+	blocks = nil
+	err = ReadPEMWithOptions([]io.Reader{file}, opts, func(block *pem.Block, format string) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"friendlyName": "secret-key-some-alias",
+		"entryType":    "SecretKeyEntry",
+		"entryDate":    "2017-07-14T02:40:00Z",
+	}
+	if len(blocks) != 1 || blocks[0].Type != "SECRET KEY" || len(blocks[0].Bytes) != 0 {
+		t.Fatalf("unexpected blocks: %v", blocks)
+	}
+	for key, value := range expected {
+		if blocks[0].Headers[key] != value {
+			t.Errorf("unexpected %s header: %q", key, blocks[0].Headers[key])
+		}
+	}
+}
+
+func TestReadMultiSegment(t *testing.T) {
+	key := newTestKey(t)
+	certA := issueTestCert(t, "Segment A", key, "Segment A", key)
+	certB := issueTestCert(t, "Segment B", key, "Segment B", key)
+	certC := issueTestCert(t, "Segment C", key, "Segment C", key)
+	certD := issueTestCert(t, "Segment D", key, "Segment D", key)
+
+	p7b, err := pkcs7.BuildCertsOnly([]*x509.Certificate{certC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// PEM, then DER, then PKCS7, then PEM again
+	var data bytes.Buffer
+	pem.Encode(&data, EncodeX509ToPEM(certA, nil))
+	data.Write(certB.Raw)
+	data.Write(p7b)
+	data.WriteString("\n")
+	pem.Encode(&data, EncodeX509ToPEM(certD, nil))
+
+	var subjects []string
+	err = ReadX509WithOptions([]io.Reader{bytes.NewReader(data.Bytes())}, ReadOptions{MultiSegment: true}, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		subjects = append(subjects, cert.Subject.CommonName)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(subjects, ",") != "Segment A,Segment B,Segment C,Segment D" {
+		t.Errorf("unexpected certificates: %v", subjects)
+	}
+
+	// Without the option, only the PEM blocks are found
+	subjects = nil
+	err = ReadX509WithOptions([]io.Reader{bytes.NewReader(data.Bytes())}, ReadOptions{}, func(cert *x509.Certificate, format string, err error) error {
+		if err == nil {
+			subjects = append(subjects, cert.Subject.CommonName)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(subjects, ",") != "Segment A,Segment D" {
+		t.Errorf("unexpected certificates without multi-segment reading: %v", subjects)
+	}
+}
+
+func TestReadAsX509CerttoolOutput(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/certtool-output.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var subjects []string
+	input := iotest.OneByteReader(bytes.NewReader(data))
+	err = ReadAsX509([]io.Reader{input}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		subjects = append(subjects, cert.Subject.CommonName)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(subjects, ",") != "example-leaf,example-root" {
+		t.Fatalf("unexpected certificates: %v", subjects)
+	}
+}
+
+func TestKeyToPemUnsupportedCurve(t *testing.T) {
+	key := newTestKey(t)
+	params := *key.Curve.Params()
+	params.Name = "brainpoolP256r1"
+	key.Curve = &params
+
+	_, err := keyToPem(key, nil)
+	if err == nil || !strings.Contains(err.Error(), "brainpoolP256r1") {
+		t.Fatalf("expected error naming the curve, got: %v", err)
+	}
+}
+
+func TestReadX509Detailed(t *testing.T) {
+	keyStore, err := os.Open("../jceks/testdata/private-key.jceks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyStore.Close()
+	pemFile, err := os.Open("../test-certs/example-root.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pemFile.Close()
+
+	opts := ReadOptions{
+		Password: PasswordFromMap(map[string]string{
+			"":                       "private-key-store-password",
+			"private-key-some-alias": "private-key-key-password",
+		}, ""),
+	}
+	var sources []SourceInfo
+	err = ReadX509Detailed([]io.Reader{keyStore, pemFile}, opts, func(cert *x509.Certificate, source SourceInfo) error {
+		if cert == nil {
+			t.Fatal("nil certificate")
+		}
+		sources = append(sources, source)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []SourceInfo{
+		{Filename: "../jceks/testdata/private-key.jceks", Format: "JCEKS", Alias: "private-key-some-alias"},
+		{Filename: "../test-certs/example-root.crt", Format: "PEM", Offset: 0, Length: 1211},
+	}
+	if len(sources) != len(expected) || sources[0] != expected[0] || sources[1] != expected[1] {
+		t.Errorf("unexpected sources: %+v", sources)
+	}
+}
+
+func TestReadOffsets(t *testing.T) {
+	key := newTestKey(t)
+	one := pem.EncodeToMemory(EncodeX509ToPEM(issueTestCert(t, "one", key, "one", key), nil))
+	two := issueTestCert(t, "two", key, "two", key)
+	oneCRLF := bytes.Replace(one, []byte("\n"), []byte("\r\n"), -1)
+
+	// Leading text, CRLF line endings, and a block glued to the end of the
+	// previous one (which gets a line break inserted when normalized)
+	var input bytes.Buffer
+	input.WriteString("depth=0 CN = one\n")
+	input.Write(oneCRLF)
+	input.Write(bytes.TrimSuffix(one, []byte("\n")))
+	secondStart := int64(input.Len())
+	input.Write(one)
+
+	testCases := []struct {
+		opts     ReadOptions
+		input    []byte
+		expected [][2]int64
+	}{
+		{ReadOptions{Format: "PEM"}, input.Bytes(), [][2]int64{
+			{17, int64(len(oneCRLF)) - 2},
+			{17 + int64(len(oneCRLF)), int64(len(one)) - 1},
+			{secondStart, int64(len(one)) - 1},
+		}},
+		{ReadOptions{Format: "DER"}, append(append([]byte{}, two.Raw...), two.Raw...), [][2]int64{
+			{0, int64(len(two.Raw))},
+			{int64(len(two.Raw)), int64(len(two.Raw))},
+		}},
+		{ReadOptions{Format: "PEM", MultiSegment: true}, append(append([]byte{}, one...), two.Raw...), [][2]int64{
+			{0, int64(len(one)) - 1},
+			{int64(len(one)), int64(len(two.Raw))},
+		}},
+	}
+	for i, tc := range testCases {
+		var positions [][2]int64
+		err := ReadX509Detailed([]io.Reader{bytes.NewReader(tc.input)}, tc.opts, func(cert *x509.Certificate, source SourceInfo) error {
+			positions = append(positions, [2]int64{source.Offset, source.Length})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %s", i, err)
+		}
+		if !reflect.DeepEqual(positions, tc.expected) {
+			t.Errorf("case %d: expected positions %v, got %v", i, tc.expected, positions)
+		}
+	}
+
+	// Positions are only added to PEM blocks when asked for
+	err := ReadPEMWithOptions([]io.Reader{bytes.NewReader(one)}, ReadOptions{Format: "PEM"}, func(block *pem.Block, format string) error {
+		if _, ok := block.Headers[offsetHeader]; ok {
+			t.Errorf("unexpected offset header: %v", block.Headers)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadLiveStream(t *testing.T) {
+	key := newTestKey(t)
+	var certs [][]byte
+	for _, name := range []string{"one", "two", "three"} {
+		certs = append(certs, pem.EncodeToMemory(EncodeX509ToPEM(issueTestCert(t, name, key, name, key), nil)))
+	}
+
+	// Log lines (including a broken block) and certificates arrive over
+	// time; each certificate must be read before more input is written.
+	chunks := [][]byte{
+		[]byte("Oct 16 12:00:00 host app[1]: starting up\n"),
+		append([]byte("Oct 16 12:00:01 host app[1]: got certificate:\n"), certs[0]...),
+		append([]byte("Oct 16 12:00:02 host app[1]: -----BEGIN CERTIFICATE-----\ntruncated\n"), certs[1]...),
+		append(append([]byte{}, certs[2]...), "-----BEGIN CERTIFICATE-----\nnot base64\n-----END CERTIFICATE-----\nmore noise\n"...),
+	}
+	reader, writer := io.Pipe()
+	received := make(chan string, len(certs))
+	go func() {
+		for i, chunk := range chunks {
+			writer.Write(chunk)
+			if i == 0 {
+				continue
+			}
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				writer.CloseWithError(fmt.Errorf("no certificate read after chunk %d", i))
+				return
+			}
+		}
+		writer.Close()
+	}()
+
+	err := ReadX509WithOptions([]io.Reader{reader}, ReadOptions{Format: "PEM"}, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			return err
+		}
+		received <- cert.Subject.CommonName
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 0 {
+		t.Errorf("unexpected extra certificates: %d", len(received))
+	}
+}
+
+func TestReadPreservesHeaders(t *testing.T) {
+	key := newTestKey(t)
+	block := EncodeX509ToPEMWithType(issueTestCert(t, "test", key, "test", key), "", map[string]string{
+		"Version":  "2",
+		fileHeader: "elsewhere.pem",
+	})
+	input := namedReader{bytes.NewReader(pem.EncodeToMemory(block)), "input.pem"}
+
+	var headers map[string]string
+	err := ReadPEMWithOptions([]io.Reader{input}, ReadOptions{}, func(block *pem.Block, format string) error {
+		headers = block.Headers
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"Version":               "2",
+		fileHeader:              "elsewhere.pem",
+		"certigo-" + fileHeader: "input.pem",
+	}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+
+	merged := mergeHeaders(map[string]string{nameHeader: "alias"}, map[string]string{nameHeader: ""})
+	if merged[nameHeader] != "alias" || len(merged) != 1 {
+		t.Errorf("empty value replaced existing header: %v", merged)
+	}
+}
+
+func TestReadWithFormat(t *testing.T) {
+	key := newTestKey(t)
+	certA := issueTestCert(t, "Format A", key, "Format A", key)
+	certB := issueTestCert(t, "Format B", key, "Format B", key)
+
+	inputs := []io.Reader{
+		bytes.NewReader(certA.Raw),
+		WithFormat(namedReader{bytes.NewReader(pem.EncodeToMemory(EncodeX509ToPEM(certB, nil))), "cert-b.der"}, "PEM"),
+	}
+	var blocks []*pem.Block
+	var formats []string
+	err := ReadPEMWithOptions(inputs, ReadOptions{Format: "DER"}, func(block *pem.Block, format string) error {
+		blocks = append(blocks, block)
+		formats = append(formats, format)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || !bytes.Equal(blocks[0].Bytes, certA.Raw) || !bytes.Equal(blocks[1].Bytes, certB.Raw) {
+		t.Fatalf("unexpected blocks: %v", blocks)
+	}
+	if formats[0] != "DER" || formats[1] != "PEM" {
+		t.Errorf("unexpected formats: %v", formats)
+	}
+	if blocks[1].Headers[fileHeader] != "cert-b.der" {
+		t.Errorf("unexpected headers: %v", blocks[1].Headers)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	certPEM := []byte(readTestFile(t, "../test-certs/example-leaf.crt"))
+	noop := func(*x509.Certificate, string, error) error { return nil }
+
+	// A plain reader and a connection (with SetReadDeadline) that stall
+	// halfway through a certificate
+	pipeReader, pipeWriter := io.Pipe()
+	connReader, connWriter := net.Pipe()
+	defer pipeWriter.Close()
+	defer connWriter.Close()
+	for _, w := range []io.Writer{pipeWriter, connWriter} {
+		go w.Write(certPEM[:len(certPEM)/2])
+	}
+
+	for _, input := range []io.Reader{pipeReader, connReader} {
+		start := time.Now()
+		opts := ReadOptions{Deadline: start.Add(100 * time.Millisecond)}
+		err := ReadX509WithOptions([]io.Reader{input}, opts, noop)
+		if err == nil || !strings.Contains(err.Error(), "timeout") && !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("%T: expected timeout error, got: %v", input, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%T: read took too long: %s", input, elapsed)
+		}
+	}
+
+	// The deadline of the connection is cleared again afterwards
+	go connWriter.Write([]byte("more"))
+	if _, err := connReader.Read(make([]byte, 4)); err != nil {
+		t.Errorf("expected the connection to be usable after reading, got: %v", err)
+	}
+
+	opts := ReadOptions{Deadline: time.Now().Add(5 * time.Second)}
+	if err := ReadX509WithOptions([]io.Reader{bytes.NewReader(certPEM)}, opts, noop); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestReadCorruptedPKCS12(t *testing.T) {
+	file, err := os.Open("testdata/corrupted.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	err = ReadAsPEM([]io.Reader{file}, "", func(string) string { return "password" }, func(block *pem.Block, format string) error {
+		return nil
+	})
+	if err == nil {
+		t.Error("expected error for corrupted PKCS12 file")
+	}
+}
+
+func TestReadPKCS12Panic(t *testing.T) {
+	defer func(decoder func([]byte, string) ([]*pem.Block, error)) {
+		pkcs12Decoder = decoder
+	}(pkcs12Decoder)
+	pkcs12Decoder = func([]byte, string) ([]*pem.Block, error) {
+		panic("index out of range")
+	}
+
+	err := ReadAsPEM([]io.Reader{strings.NewReader("not really a keystore")}, "PKCS12", nil, func(block *pem.Block, format string) error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "malformed PKCS12 input") {
+		t.Errorf("expected malformed PKCS12 input error, got: %v", err)
+	}
+}
+
+func TestFormatForFileDERvsPKCS12(t *testing.T) {
+	key := newTestKey(t)
+	small := issueTestCert(t, "small", key, "small", key)
+	leaf, err := ioutil.ReadFile("../test-certs/example-leaf.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(leaf)
+	p7b, err := pkcs7.BuildCertsOnly([]*x509.Certificate{small})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]byte{
+		"DER (small cert)": small.Raw,
+		"DER (RSA cert)":   block.Bytes,
+		"DER (PKCS7)":      p7b,
+	}
+	for _, name := range []string{"example-leaf", "example-root", "example-elliptic-sha1"} {
+		data, err := ioutil.ReadFile("../test-certs/" + name + ".p12")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cases["PKCS12 ("+name+")"] = data
+	}
+
+	for name, data := range cases {
+		expected := strings.Fields(name)[0]
+		format, err := formatForFile(bufio.NewReaderSize(bytes.NewReader(data), sniffLength), "", "")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if format != expected {
+			t.Errorf("%s: guessed %s", name, format)
+		}
+	}
+}
+
+func TestFormatForFileExtension(t *testing.T) {
+	certPEM := []byte(readTestFile(t, "../test-certs/example-leaf.crt"))
+	certBlock, _ := pem.Decode(certPEM)
+	p7PEM := []byte(readTestFile(t, "../test-certs/example-leaf.p7b"))
+	p7Block, _ := pem.Decode(p7PEM)
+
+	testCases := []struct {
+		filename string
+		data     []byte
+		expected string
+	}{
+		// Extensions with a definite format take precedence over the data
+		{"cert.crt", certBlock.Bytes, "PEM"},
+		{"cert.der", certPEM, "DER"},
+		// Extensions that can go either way leave it to the data
+		{"cert.cer", certPEM, "PEM"},
+		{"cert.cer", certBlock.Bytes, "DER"},
+		{"cert.CERT", certPEM, "PEM"},
+		{"cert.cert", certBlock.Bytes, "DER"},
+		{"chain.p7", p7PEM, "PEM"},
+		{"chain.p7", p7Block.Bytes, "DER"},
+	}
+	for _, tc := range testCases {
+		format, err := formatForFile(bufio.NewReaderSize(bytes.NewReader(tc.data), sniffLength), tc.filename, "")
+		if err != nil {
+			t.Errorf("%s: %s", tc.filename, err)
+			continue
+		}
+		if format != tc.expected {
+			t.Errorf("%s: expected %s, guessed %s", tc.filename, tc.expected, format)
+		}
+		// All of them are recognized as certificate files, e.g. in archives
+		if !isCertMember(tc.filename) {
+			t.Errorf("%s: not recognized as a certificate file", tc.filename)
+		}
+	}
+	if isCertMember("README.txt") {
+		t.Error("README.txt recognized as a certificate file")
+	}
+}
+
+func TestReadTruncatedDER(t *testing.T) {
+	key := newTestKey(t)
+	first := issueTestCert(t, "first", key, "first", key)
+	second := issueTestCert(t, "second", key, "first", key)
+	both := append(append([]byte{}, first.Raw...), second.Raw...)
+
+	cases := []struct {
+		data     []byte
+		expected int
+	}{
+		{first.Raw[:len(first.Raw)-10], len(first.Raw)},
+		{both[:len(both)-1], len(both)},
+	}
+	for i, tc := range cases {
+		err := ReadAsX509([]io.Reader{bytes.NewReader(tc.data)}, "DER", nil, func(cert *x509.Certificate, format string, err error) error {
+			return err
+		})
+		expected := fmt.Sprintf("input appears truncated: expected %d bytes, got %d", tc.expected, len(tc.data))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("case %d: expected %q, got %v", i, expected, err)
+		}
+	}
+
+	var count int
+	err := ReadAsX509([]io.Reader{bytes.NewReader(both)}, "DER", nil, func(cert *x509.Certificate, format string, err error) error {
+		count++
+		return err
+	})
+	if err != nil || count != 2 {
+		t.Errorf("expected 2 certificates, got %d (%v)", count, err)
+	}
+}
+
+// withStdin replaces os.Stdin with a pipe carrying the given data.
+func withStdin(t *testing.T, data []byte, f func()) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	f()
+}
+
+func TestReadEmptyStdin(t *testing.T) {
+	withStdin(t, nil, func() {
+		err := ReadAsPEM([]io.Reader{os.Stdin}, "", nil, func(*pem.Block, string) error {
+			t.Error("unexpected block from empty stdin")
+			return nil
+		})
+		if err != errNoStdinData {
+			t.Errorf("expected %q, got %v", errNoStdinData, err)
+		}
+	})
+}
+
+func TestReadStdinOriginFile(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/mixed-bundle.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withStdin(t, data, func() {
+		err := ReadAsPEM([]io.Reader{os.Stdin}, "", nil, func(block *pem.Block, format string) error {
+			if block.Headers[fileHeader] != stdinName {
+				t.Errorf("expected origin file %q, got %q", stdinName, block.Headers[fileHeader])
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestReadPKCS12PasswordMismatch(t *testing.T) {
+	defer func(decoder func([]byte, string) ([]*pem.Block, error)) {
+		pkcs12Decoder = decoder
+	}(pkcs12Decoder)
+	pkcs12Decoder = func([]byte, string) ([]*pem.Block, error) {
+		return nil, pkcs12.ErrDecryption
+	}
+
+	err := ReadAsPEM([]io.Reader{strings.NewReader("not really a keystore")}, "PKCS12", nil, func(block *pem.Block, format string) error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), errPKCS12PasswordMismatch.Error()) {
+		t.Errorf("expected integrity/privacy password mismatch error, got: %v", err)
+	}
+}
+
+func TestReadPKCS12KeyOnly(t *testing.T) {
+	defer func(decoder func([]byte, string) ([]*pem.Block, error)) {
+		pkcs12Decoder = decoder
+	}(pkcs12Decoder)
+	pkcs12Decoder = func([]byte, string) ([]*pem.Block, error) {
+		return []*pem.Block{{Type: "PRIVATE KEY", Bytes: []byte("key")}}, nil
+	}
+	data := "not really a keystore"
+	password := func(string) string { return "password" }
+
+	var blocks []*pem.Block
+	err := ReadAsPEM([]io.Reader{strings.NewReader(data)}, "PKCS12", password, func(block *pem.Block, format string) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || !strings.HasSuffix(blocks[0].Type, "PRIVATE KEY") {
+		t.Fatalf("expected a single private key block, got: %v", blocks)
+	}
+
+	var errs []error
+	err = ReadAsX509([]io.Reader{strings.NewReader(data)}, "PKCS12", password, func(cert *x509.Certificate, format string, err error) error {
+		if cert != nil {
+			t.Errorf("unexpected certificate: %s", cert.Subject)
+		}
+		errs = append(errs, err)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs[0] != ErrKeyWithoutCertificate {
+		t.Errorf("expected ErrKeyWithoutCertificate, got: %v", errs)
+	}
+}
+
+// buildTrustStore builds a JKS trust store holding the given certificate,
+// protected with the given store password.
+func buildTrustStore(t *testing.T, cert *x509.Certificate, password string) []byte {
+	return buildTrustStoreWithAliases(t, map[string]*x509.Certificate{"trusted": cert}, password)
+}
+
+// buildTrustStoreWithAliases builds a JKS trust store holding the given
+// certificates as trusted-cert entries, protected with the given store
+// password.
+func buildTrustStoreWithAliases(t *testing.T, certs map[string]*x509.Certificate, password string) []byte {
+	var data bytes.Buffer
+	write := func(v interface{}) {
+		if err := binary.Write(&data, binary.BigEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeUTF := func(v string) {
+		write(uint16(len(v)))
+		data.WriteString(v)
+	}
+
+	write(uint32(0xfeedfeed))
+	write(uint32(2))
+	write(uint32(len(certs)))
+	for alias, cert := range certs {
+		write(uint32(2))
+		writeUTF(alias)
+		write(int64(0))
+		writeUTF("X.509")
+		write(uint32(len(cert.Raw)))
+		data.Write(cert.Raw)
+	}
+
+	md := sha1.New()
+	for _, c := range []byte(password) {
+		md.Write([]byte{0, c})
+	}
+	md.Write([]byte("Mighty Aphrodite"))
+	md.Write(data.Bytes())
+	data.Write(md.Sum(nil))
+	return data.Bytes()
+}
+
+func TestReadJCEKSLinkTrustedCerts(t *testing.T) {
+	rootKey, intKey, leafKey, otherKey := newTestKey(t), newTestKey(t), newTestKey(t), newTestKey(t)
+	store := buildTrustStoreWithAliases(t, map[string]*x509.Certificate{
+		"a-root":         issueTestCert(t, "root", rootKey, "root", rootKey),
+		"b-intermediate": issueTestCert(t, "intermediate", intKey, "root", rootKey),
+		"c-leaf":         issueTestCert(t, "leaf", leafKey, "intermediate", intKey),
+		"d-other":        issueTestCert(t, "other", otherKey, "other", otherKey),
+	}, "changeit")
+
+	expected := []struct {
+		alias, chain string
+	}{
+		{"c-leaf", "c-leaf"},
+		{"b-intermediate", "c-leaf"},
+		{"a-root", "c-leaf"},
+		{"d-other", ""},
+	}
+	for _, grouped := range []bool{false, true} {
+		var blocks []*pem.Block
+		opts := ReadOptions{Format: "JCEKS", LinkTrustedCerts: true, GroupByAlias: grouped}
+		err := ReadPEMWithOptions([]io.Reader{bytes.NewReader(store)}, opts, func(block *pem.Block, format string) error {
+			blocks = append(blocks, block)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) != len(expected) {
+			t.Fatalf("unexpected number of blocks: %d != %d", len(blocks), len(expected))
+		}
+		for i, block := range blocks {
+			if block.Headers[nameHeader] != expected[i].alias || block.Headers[chainHeader] != expected[i].chain {
+				t.Errorf("block %d (grouped: %v): unexpected headers %v", i, grouped, block.Headers)
+			}
+		}
+	}
+}
+
+func TestReadCertsOnly(t *testing.T) {
+	mixed := readTestFile(t, "testdata/mixed-bundle.pem")
+	jceksStore, err := ioutil.ReadFile("../jceks/testdata/private-key.jceks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12, err := ioutil.ReadFile("testdata/password.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		description string
+		input       io.Reader
+		opts        ReadOptions
+	}{
+		// No key password is given, as the JCEKS key shouldn't be decrypted
+		{"JCEKS", bytes.NewReader(jceksStore), ReadOptions{Format: "JCEKS", Password: PasswordFromMap(nil, "private-key-store-password")}},
+		{"PKCS12", bytes.NewReader(p12), ReadOptions{Format: "PKCS12", Password: PasswordFromMap(nil, "password")}},
+		{"PEM", strings.NewReader(mixed), ReadOptions{Format: "PEM"}},
+	}
+	for _, c := range cases {
+		c.opts.CertsOnly = true
+		var certs int
+		err := ReadPEMWithOptions([]io.Reader{c.input}, c.opts, func(block *pem.Block, format string) error {
+			if strings.Contains(block.Type, "PRIVATE KEY") {
+				t.Errorf("%s: unexpected %s block", c.description, block.Type)
+			}
+			if block.Type == "CERTIFICATE" {
+				certs++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: %s", c.description, err)
+			continue
+		}
+		if certs == 0 {
+			t.Errorf("%s: no certificates read", c.description)
+		}
+	}
+}
+
+func TestReadJCEKSDefaultPassword(t *testing.T) {
+	key := newTestKey(t)
+	cert := issueTestCert(t, "trusted", key, "trusted", key)
+
+	// The defaults are used without a password, or with an empty one
+	emptyPassword := func(string) (string, error) { return "", nil }
+	for _, password := range []string{"changeit", ""} {
+		store := buildTrustStore(t, cert, password)
+		for _, callback := range []func(string) (string, error){nil, emptyPassword} {
+			var certs []*x509.Certificate
+			err := ReadX509WithOptions([]io.Reader{bytes.NewReader(store)}, ReadOptions{
+				Format:   "JCEKS",
+				Password: callback,
+			}, func(cert *x509.Certificate, format string, err error) error {
+				if err != nil {
+					return err
+				}
+				certs = append(certs, cert)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("store password %q: %s", password, err)
+			}
+			if len(certs) != 1 || !bytes.Equal(certs[0].Raw, cert.Raw) {
+				t.Errorf("store password %q: unexpected certificates read", password)
+			}
+		}
+	}
+
+	// An explicit password is used as is, even if a default would work
+	store := buildTrustStore(t, cert, "changeit")
+	err := ReadX509WithOptions([]io.Reader{bytes.NewReader(store)}, ReadOptions{
+		Format:   "JCEKS",
+		Password: PasswordFromMap(nil, "wrong"),
+	}, func(cert *x509.Certificate, format string, err error) error {
+		return err
+	})
+	if err == nil {
+		t.Error("expected error for wrong explicit store password")
+	}
+
+	// A prompt only comes after the defaults, and only if they don't work
+	for _, password := range []string{"changeit", ""} {
+		store = buildTrustStore(t, cert, password)
+		err = ReadX509WithOptions([]io.Reader{bytes.NewReader(store)}, ReadOptions{
+			Format: "JCEKS",
+			Password: func(string) (string, error) {
+				t.Errorf("store password %q: unexpected prompt", password)
+				return "", errors.New("no terminal")
+			},
+			PasswordPrompts: true,
+		}, func(cert *x509.Certificate, format string, err error) error {
+			return err
+		})
+		if err != nil {
+			t.Errorf("store password %q: %s", password, err)
+		}
+	}
+
+	store = buildTrustStore(t, cert, "secret")
+	prompted := false
+	err = ReadX509WithOptions([]io.Reader{bytes.NewReader(store)}, ReadOptions{
+		Format: "JCEKS",
+		Password: func(string) (string, error) {
+			prompted = true
+			return "secret", nil
+		},
+		PasswordPrompts: true,
+	}, func(cert *x509.Certificate, format string, err error) error {
+		return err
+	})
+	if err != nil || !prompted {
+		t.Errorf("expected prompt for non-default store password (err: %v)", err)
+	}
+}
+
+func TestReadAsX509HexDump(t *testing.T) {
+	key := newTestKey(t)
+	cert := issueTestCert(t, "hex", key, "hex", key)
+
+	encoded := hex.EncodeToString(cert.Raw)
+	var colons []string
+	for i := 0; i < len(encoded); i += 2 {
+		colons = append(colons, encoded[i:i+2])
+	}
+
+	inputs := map[string]string{
+		"plain":  encoded,
+		"colons": strings.Join(colons, ":") + "\n",
+		"spaces": strings.ToUpper(strings.Join(colons, " ")),
+	}
+	for name, input := range inputs {
+		var certs []*x509.Certificate
+		err := ReadAsX509([]io.Reader{strings.NewReader(input)}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			if format != "HEX" {
+				t.Errorf("%s: unexpected format %s", name, format)
+			}
+			certs = append(certs, cert)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(certs) != 1 || !bytes.Equal(certs[0].Raw, cert.Raw) {
+			t.Errorf("%s: unexpected certificates read", name)
+		}
+	}
+}
+
+func TestReadBKS(t *testing.T) {
+	file, err := os.Open("testdata/truststore.bks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var names []string
+	err = ReadAsPEM([]io.Reader{file}, "", nil, func(block *pem.Block, format string) error {
+		if format != "BKS" || block.Type != "CERTIFICATE" {
+			t.Errorf("unexpected %s block in %s input", block.Type, format)
+		}
+		names = append(names, block.Headers[nameHeader])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "key,trusted" {
+		t.Errorf("unexpected entries: %v", names)
+	}
+}
+
+func TestReadJCEKSNonASCIIAlias(t *testing.T) {
+	file, err := os.Open("../jceks/testdata/non-ascii-alias.jceks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var names []string
+	err = ReadAsPEM([]io.Reader{file}, "", func(string) string { return "non-ascii-alias-store-password" }, func(block *pem.Block, format string) error {
+		names = append(names, block.Headers[nameHeader])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "zertifikat-ä-😀" {
+		t.Errorf("unexpected aliases: %q", names)
+	}
+}
+
+func TestReadStampReadTime(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/mixed-bundle.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ReadOptions{
+		StampReadTime: true,
+		Clock: func() time.Time {
+			return time.Date(2020, 6, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+		},
+	}
+	blocks := 0
+	err = ReadPEMWithOptions([]io.Reader{bytes.NewReader(data)}, opts, func(block *pem.Block, format string) error {
+		blocks++
+		if block.Headers[readAtHeader] != "2020-06-01T10:30:00Z" {
+			t.Errorf("unexpected readAt header: %q", block.Headers[readAtHeader])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks == 0 {
+		t.Fatal("no blocks read")
+	}
+}
+
+func TestReadSkipLimit(t *testing.T) {
+	key := newTestKey(t)
+	var certs []*x509.Certificate
+	for _, name := range []string{"one", "two", "three", "four"} {
+		certs = append(certs, issueTestCert(t, name, key, name, key))
+	}
+	p7b, err := pkcs7.BuildCertsOnly(certs[2:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := func() []io.Reader {
+		var bundle bytes.Buffer
+		for _, cert := range certs[:2] {
+			pem.Encode(&bundle, EncodeX509ToPEM(cert, nil))
+		}
+		return []io.Reader{&bundle, bytes.NewReader(p7b), bytes.NewReader(certs[3].Raw)}
+	}
+
+	testCases := []struct {
+		skip, limit int
+		expected    string
+	}{
+		{0, 0, "one,two,three,four"},
+		{1, 1, "two"},
+		{1, 2, "two,three"},
+		{3, 0, "four"},
+		{0, 10, "one,two,three,four"},
+		{5, 1, ""},
+	}
+	for _, tc := range testCases {
+		var names []string
+		opts := ReadOptions{Skip: tc.skip, Limit: tc.limit}
+		err := ReadX509WithOptions(inputs(), opts, func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			names = append(names, cert.Subject.CommonName)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("skip %d, limit %d: %s", tc.skip, tc.limit, err)
+		}
+		if strings.Join(names, ",") != tc.expected {
+			t.Errorf("skip %d, limit %d: expected %s, got %v", tc.skip, tc.limit, tc.expected, names)
+		}
+
+		blocks := 0
+		err = ReadPEMWithOptions(inputs(), opts, func(block *pem.Block, format string) error {
+			blocks++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("skip %d, limit %d: %s", tc.skip, tc.limit, err)
+		}
+		if blocks != len(names) {
+			t.Errorf("skip %d, limit %d: expected %d blocks, got %d", tc.skip, tc.limit, len(names), blocks)
+		}
+	}
+}
+
+func TestReadAsX509PKCS7Labels(t *testing.T) {
+	leaf, err := ioutil.ReadFile("../test-certs/example-leaf.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(leaf)
+
+	for _, file := range []string{"../test-certs/example-leaf.p7b", "testdata/cms-label.pem", "testdata/pkcs7-space-label.pem"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var certs []*x509.Certificate
+		err = ReadAsX509([]io.Reader{bytes.NewReader(data)}, "PEM", nil, func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+		if len(certs) != 1 || !bytes.Equal(certs[0].Raw, block.Bytes) {
+			t.Errorf("%s: unexpected certificates read", file)
+		}
+	}
+}
+
+func TestReadPKCS12GroupByAlias(t *testing.T) {
+	// A key with its leaf certificate, and the root and intermediate
+	// certificates (in that order) without friendlyName or localKeyId
+	data, err := ioutil.ReadFile("testdata/chain.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	describe := func(opts ReadOptions) []string {
+		var out []string
+		err := ReadPEMWithOptions([]io.Reader{bytes.NewReader(data)}, opts, func(block *pem.Block, format string) error {
+			description := block.Type
+			if block.Type == "CERTIFICATE" {
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return err
+				}
+				description = cert.Subject.CommonName
+			}
+			out = append(out, ownHeader(block.Headers, nameHeader)+":"+description)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	opts := ReadOptions{Format: "PKCS12", Password: PasswordFromMap(nil, "password")}
+	ungrouped := describe(opts)
+	if expected := []string{"leaf:chain-leaf", ":chain-root", ":chain-intermediate", "leaf:PRIVATE KEY"}; !reflect.DeepEqual(ungrouped, expected) {
+		t.Fatalf("unexpected blocks without grouping: %v", ungrouped)
+	}
+
+	opts.GroupByAlias = true
+	expected := []string{"leaf:PRIVATE KEY", "leaf:chain-leaf", "leaf:chain-intermediate", "leaf:chain-root"}
+	if grouped := describe(opts); !reflect.DeepEqual(grouped, expected) {
+		t.Errorf("unexpected grouped blocks:\n got: %v\nwant: %v", grouped, expected)
+	}
+}
+
+func TestReadPKCS12Passwords(t *testing.T) {
+	testCases := []struct {
+		file     string
+		password string
+		prompted bool
+		err      string
+	}{
+		{"testdata/empty-password.p12", "", false, ""},
+		{"testdata/empty-password.p12", "password", false, ""},
+		{"testdata/password.p12", "password", true, ""},
+		{"testdata/password.p12", "", true, errPKCS12NeedsPassword.Error()},
+		{"testdata/password.p12", "wrong", true, "password for keystore was incorrect"},
+		// "pässwörd", encoded as a BMPString as the spec requires, and
+		// byte by byte as by older versions of OpenSSL
+		{"testdata/non-ascii-password.p12", "pässwörd", true, ""},
+		{"testdata/non-ascii-password-legacy.p12", "pässwörd", true, ""},
+		{"testdata/non-ascii-password.p12", "passwörd", true, "password for keystore was incorrect"},
+		{"testdata/non-ascii-password.p12", "pässwörd🔑", true, "password for keystore was incorrect"},
+	}
+	for _, tc := range testCases {
+		data, err := ioutil.ReadFile(tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		prompted := false
+		password := func(string) string {
+			prompted = true
+			return tc.password
+		}
+		blocks := 0
+		err = ReadAsPEM([]io.Reader{bytes.NewReader(data)}, "PKCS12", password, func(block *pem.Block, format string) error {
+			blocks++
+			return nil
+		})
+		if prompted != tc.prompted {
+			t.Errorf("%s with password %q: expected prompted to be %v", tc.file, tc.password, tc.prompted)
+		}
+		if tc.err == "" {
+			if err != nil || blocks == 0 {
+				t.Errorf("%s with password %q: read %d blocks, error: %v", tc.file, tc.password, blocks, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s with password %q: expected error %q, got: %v", tc.file, tc.password, tc.err, err)
+		}
+	}
+}
+
+func TestReadAsX509MixedPKCS7(t *testing.T) {
+	file, err := os.Open("testdata/mixed-pkcs7.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var names []string
+	err = ReadAsX509([]io.Reader{file}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			return err
+		}
+		names = append(names, cert.Subject.CommonName)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "example-leaf,windows.example.com,Windows Test Intermediate,Windows Test Root,example-root,example-sha1"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("expected %s, got %v", expected, names)
+	}
+
+	// A PKCS7 block larger than bufio's default limit of 64KiB, followed
+	// by a plain certificate
+	block, _ := pem.Decode([]byte(readTestFile(t, "../test-certs/example-leaf.crt")))
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certs []*x509.Certificate
+	for i := 0; i < 100; i++ {
+		certs = append(certs, leaf)
+	}
+	bundle, err := pkcs7.BuildCertsOnly(certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data bytes.Buffer
+	pem.Encode(&data, &pem.Block{Type: "PKCS7", Bytes: bundle})
+	if data.Len() <= 64*1024 {
+		t.Fatalf("PKCS7 block too small: %d bytes", data.Len())
+	}
+	pem.Encode(&data, EncodeX509ToPEM(leaf, nil))
+
+	count := 0
+	err = ReadAsX509([]io.Reader{&data}, "PEM", nil, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(certs)+1 {
+		t.Errorf("unexpected number of certificates: %d != %d", count, len(certs)+1)
+	}
+}
+
+func TestReadAsX509PKCS7Chain(t *testing.T) {
+	file, err := os.Open("testdata/windows-chain.p7b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var names []string
+	err = ReadAsX509([]io.Reader{file}, "", nil, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			return err
+		}
+		names = append(names, cert.Subject.CommonName)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "windows.example.com,Windows Test Intermediate,Windows Test Root"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("expected %s, got %v", expected, names)
+	}
+}
+
+func TestReadStrictMislabeled(t *testing.T) {
+	pemBytes := func(file string) []byte {
+		block, _ := pem.Decode([]byte(readTestFile(t, file)))
+		if block == nil {
+			t.Fatalf("no PEM block in %s", file)
+		}
+		return block.Bytes
+	}
+	key := newTestKey(t)
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8Key, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name      string
+		blockType string
+		contents  []byte
+		expected  string
+	}{
+		{"CSR", "CERTIFICATE", pemBytes("../test-certs/example-leaf.csr"), "block labeled 'CERTIFICATE' contains a certificate request"},
+		{"PKCS7", "CERTIFICATE", pemBytes("../test-certs/example-leaf.p7b"), "block labeled 'CERTIFICATE' contains a PKCS7 envelope"},
+		{"public key", "CERTIFICATE", publicKey, "block labeled 'CERTIFICATE' contains a public key"},
+		{"PKCS8 key", "CERTIFICATE", pkcs8Key, "block labeled 'CERTIFICATE' contains a private key"},
+		{"PKCS1 key", "CERTIFICATE", pemBytes("../test-certs/example-custom-oid.key"), "block labeled 'CERTIFICATE' contains a private key"},
+		{"EC key", "CERTIFICATE", ecKey, "block labeled 'CERTIFICATE' contains a private key"},
+		{"garbage", "CERTIFICATE", []byte{0x30, 0x03, 0x02, 0x01, 0x01}, "unable to parse block labeled 'CERTIFICATE'"},
+		{"CSR block", "CERTIFICATE REQUEST", pemBytes("../test-certs/example-leaf.csr"), "certificate requests are not supported"},
+		{"unknown block", "SOMETHING ELSE", []byte("data"), "unsupported PEM block type 'SOMETHING ELSE'"},
+	}
+	for _, tc := range testCases {
+		input := pem.EncodeToMemory(&pem.Block{Type: tc.blockType, Bytes: tc.contents})
+		var errs []error
+		err := ReadX509WithOptions([]io.Reader{bytes.NewReader(input)}, ReadOptions{Strict: true}, func(cert *x509.Certificate, format string, err error) error {
+			if cert != nil {
+				t.Errorf("%s: unexpected certificate: %s", tc.name, cert.Subject)
+			}
+			errs = append(errs, err)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if len(errs) != 1 || errs[0] == nil || !strings.Contains(errs[0].Error(), tc.expected) {
+			t.Errorf("%s: expected error containing %q, got: %v", tc.name, tc.expected, errs)
+		}
+	}
+
+	// Keys are expected alongside certificates, so they aren't reported
+	input := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Key})
+	err = ReadX509WithOptions([]io.Reader{bytes.NewReader(input)}, ReadOptions{Strict: true}, func(cert *x509.Certificate, format string, err error) error {
+		t.Errorf("unexpected callback for private key block: %v", err)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadRejectWeak(t *testing.T) {
+	var inputs []string
+	for _, name := range []string{"example-leaf", "example-sha1", "example-root"} {
+		inputs = append(inputs, readTestFile(t, "../test-certs/"+name+".crt"))
+	}
+	readers := func() []io.Reader {
+		return []io.Reader{strings.NewReader(inputs[0]), strings.NewReader(inputs[1] + inputs[2])}
+	}
+
+	for _, collect := range []bool{false, true} {
+		var names []string
+		opts := ReadOptions{RejectWeak: true, CollectErrors: collect}
+		err := ReadX509WithOptions(readers(), opts, func(cert *x509.Certificate, format string, err error) error {
+			names = append(names, cert.Subject.CommonName)
+			return err
+		})
+		weakErr, ok := err.(*WeakSignatureError)
+		if !ok || weakErr.Algorithm != x509.SHA1WithRSA || !strings.Contains(weakErr.Error(), "CN=example-sha1") {
+			t.Errorf("collect %v: expected weak signature error, got %v", collect, err)
+		}
+		if strings.Join(names, ",") != "example-leaf" {
+			t.Errorf("collect %v: expected reading to stop at weak certificate, read %v", collect, names)
+		}
+	}
+
+	var blocks int
+	err := ReadPEMWithOptions(readers(), ReadOptions{RejectWeak: true}, func(block *pem.Block, format string) error {
+		blocks++
+		return nil
+	})
+	if _, ok := err.(*WeakSignatureError); !ok || blocks != 1 {
+		t.Errorf("expected weak signature error after 1 block, got %v after %d", err, blocks)
+	}
+
+	// Weak certificates in a PKCS7 envelope are rejected as well
+	block, _ := pem.Decode([]byte(inputs[1]))
+	sha1Cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7b, err := pkcs7.BuildCertsOnly([]*x509.Certificate{sha1Cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks = 0
+	err = ReadPEMWithOptions([]io.Reader{bytes.NewReader(p7b)}, ReadOptions{RejectWeak: true}, func(block *pem.Block, format string) error {
+		blocks++
+		return nil
+	})
+	if _, ok := err.(*WeakSignatureError); !ok || blocks != 0 {
+		t.Errorf("expected weak signature error for .p7b, got %v after %d blocks", err, blocks)
+	}
+
+	// Without the option, weak certificates are read as usual
+	err = ReadX509WithOptions(readers(), ReadOptions{}, func(cert *x509.Certificate, format string, err error) error {
+		return err
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReadCertificateSequence(t *testing.T) {
+	// A Java CertPath in PkiPath encoding: a SEQUENCE OF Certificate, root
+	// first
+	data, err := ioutil.ReadFile("testdata/chain.pkipath")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	var offsets []int64
+	err = ReadX509Detailed([]io.Reader{bytes.NewReader(data)}, ReadOptions{}, func(cert *x509.Certificate, source SourceInfo) error {
+		if source.Format != "DER" {
+			t.Errorf("unexpected format: %s", source.Format)
+		}
+		names = append(names, cert.Subject.CommonName)
+		offsets = append(offsets, source.Offset)
+		if !bytes.Equal(data[source.Offset:source.Offset+source.Length], cert.Raw) {
+			t.Errorf("%s: wrong position %d+%d", cert.Subject.CommonName, source.Offset, source.Length)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "Windows Test Root,Windows Test Intermediate,windows.example.com" || offsets[0] != 4 {
+		t.Errorf("unexpected certificates: %v at %v", names, offsets)
+	}
+
+	// Other SEQUENCEs are still rejected
+	bogus, err := asn1.Marshal([]asn1.RawValue{{FullBytes: data[4:10]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := unwrapCertificateSequence(bogus); ok {
+		t.Error("expected bogus SEQUENCE not to be unwrapped")
+	}
+}
+
+func TestReadX509FromEnv(t *testing.T) {
+	data := readTestFile(t, "../test-certs/example-leaf.crt")
+	escaped := strings.Replace(data, "\n", `\n`, -1)
+
+	const name = "CERTIGO_TEST_CA_BUNDLE"
+	defer os.Unsetenv(name)
+	for _, value := range []string{data, escaped} {
+		os.Setenv(name, value)
+		var names []string
+		err := ReadX509FromEnv(name, ReadOptions{}, func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			names = append(names, cert.Subject.CommonName)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 1 || names[0] != "example-leaf" {
+			t.Errorf("unexpected certificates: %v", names)
+		}
+	}
+
+	os.Unsetenv(name)
+	err := ReadX509FromEnv(name, ReadOptions{}, func(*x509.Certificate, string, error) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), name) {
+		t.Errorf("expected an error for an unset variable, got %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// DefaultMaxChainLength is the maximum number of certificates in a chain
+	// built by BuildChain, unless configured otherwise.
+	DefaultMaxChainLength = 10
+)
+
+// ErrChainTooLong is returned when building a chain exceeds the maximum
+// length, which most likely means the certificates form a cycle.
+var ErrChainTooLong = errors.New("chain too long / possible cycle")
+
+// BuildChain builds a chain starting at the given leaf, by repeatedly looking
+// up the issuer of the last certificate among the given candidates. Issuers
+// are matched by name (see RawDNEqual), and by key identifier where both
+// sides have one. The chain ends at a self-issued certificate or when no
+// issuer can be found. If the chain gets longer than maxLength
+// (DefaultMaxChainLength if zero or negative), ErrChainTooLong is returned.
+func BuildChain(leaf *x509.Certificate, candidates []*x509.Certificate, maxLength int) ([]*x509.Certificate, error) {
+	if maxLength <= 0 {
+		maxLength = DefaultMaxChainLength
+	}
+
+	chain := []*x509.Certificate{leaf}
+	for current := leaf; !IsSelfIssued(current); {
+		issuer := findIssuer(current, candidates)
+		if issuer == nil {
+			break
+		}
+		if len(chain) >= maxLength {
+			return chain, ErrChainTooLong
+		}
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	return chain, nil
+}
+
+// OrderChain orders the given certificates as a chain, leaf first. The leaf
+// is picked among the certificates that didn't issue any of the others, as
+// the one with the longest chain (preferring certificates that aren't
+// self-issued). Certificates that aren't part of its chain are kept at the
+// end, in their original order.
+func OrderChain(certs []*x509.Certificate) []*x509.Certificate {
+	if len(certs) < 2 {
+		return certs
+	}
+
+	var chain []*x509.Certificate
+	for _, cert := range certs {
+		if isIssuerOfAny(cert, certs) {
+			continue
+		}
+		candidate, err := BuildChain(cert, certs, len(certs))
+		if err != nil {
+			continue
+		}
+		if len(candidate) > len(chain) ||
+			(len(candidate) == len(chain) && IsSelfIssued(chain[0]) && !IsSelfIssued(cert)) {
+			chain = candidate
+		}
+	}
+	if chain == nil {
+		// The certificates form a cycle
+		return certs
+	}
+
+	for _, cert := range certs {
+		if !containsCert(chain, cert) {
+			chain = append(chain, cert)
+		}
+	}
+	return chain
+}
+
+// AssembleChain reads a leaf certificate and the certificates in one or more
+// chain files (as in a server.crt/chain.crt deployment), and returns the full
+// chain, leaf first, as it should be served (e.g. in a fullchain.pem). Each
+// certificate in the chain must be signed by the next, and the chain files
+// must contain at least an issuer of the leaf. Certificates from the chain
+// files that aren't part of the chain are returned as unused.
+func AssembleChain(leafInput io.Reader, chainInputs []io.Reader, opts ReadOptions) (chain, unused []*x509.Certificate, err error) {
+	readAll := func(inputs []io.Reader) ([]*x509.Certificate, error) {
+		var certs []*x509.Certificate
+		err := ReadX509WithOptions(inputs, opts, func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+			return nil
+		})
+		return certs, err
+	}
+
+	leafCerts, err := readAll([]io.Reader{leafInput})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read leaf certificate: %s", err)
+	}
+	if len(leafCerts) == 0 {
+		return nil, nil, errors.New("no certificate found in leaf input")
+	}
+	candidates, err := readAll(chainInputs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read chain: %s", err)
+	}
+	if len(candidates) == 0 {
+		return nil, nil, errors.New("no certificates found in chain inputs")
+	}
+
+	// Tolerate a leaf file that already has (part of) the chain
+	leafCerts = OrderChain(leafCerts)
+	leaf := leafCerts[0]
+	candidates = append(leafCerts[1:], candidates...)
+
+	chain, err = BuildChain(leaf, candidates, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(chain) == 1 {
+		return nil, nil, fmt.Errorf("chain doesn't connect to the leaf: no issuer of '%s' (issued by '%s') found", leaf.Subject, leaf.Issuer)
+	}
+	for i := 0; i+1 < len(chain); i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return nil, nil, fmt.Errorf("'%s' isn't signed by '%s' in the chain: %s", chain[i].Subject, chain[i+1].Subject, err)
+		}
+	}
+
+	for _, cert := range candidates {
+		if !containsCert(chain, cert) && !containsCert(unused, cert) {
+			unused = append(unused, cert)
+		}
+	}
+	return chain, unused, nil
+}
+
+// BuildMinimalBundle returns the smallest bundle that lets the leaf verify
+// against the roots (the system roots, if nil): the leaf followed by the
+// intermediates on the shortest valid path, in order, without the root.
+// This is the chain a server should be configured with. Extended key
+// usages aren't checked, but validity periods are (at the current time).
+func BuildMinimalBundle(leaf *x509.Certificate, intermediatePool []*x509.Certificate, roots *x509.CertPool) ([]*x509.Certificate, error) {
+	intermediates := x509.NewCertPool()
+	for _, cert := range intermediatePool {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	shortest := chains[0]
+	for _, chain := range chains[1:] {
+		if len(chain) < len(shortest) {
+			shortest = chain
+		}
+	}
+	if len(shortest) == 1 {
+		// The leaf is itself a root
+		return shortest, nil
+	}
+	return shortest[:len(shortest)-1], nil
+}
+
+// RenderChainTree writes the given certificates to w as a tree of issuers:
+// each root (a certificate whose issuer isn't among the others) on its own
+// line, with the certificates it issued nested below it, and so on down to
+// the leaves. Certificates are matched to their issuers as in BuildChain,
+// and kept in input order among siblings.
+func RenderChainTree(certs []*x509.Certificate, w io.Writer) error {
+	parents := make([]int, len(certs))
+	for i, cert := range certs {
+		parents[i] = -1
+		if issuer := findIssuer(cert, certs); issuer != nil {
+			for j, candidate := range certs {
+				if candidate == issuer {
+					parents[i] = j
+				}
+			}
+		}
+	}
+	// Break cycles (e.g. from cross-signed certificates), so that every
+	// certificate is reachable from a root
+	for i := range certs {
+		for j, steps := parents[i], 0; j >= 0 && steps < len(certs); j, steps = parents[j], steps+1 {
+			if j == i {
+				parents[i] = -1
+				break
+			}
+		}
+	}
+
+	children := make([][]int, len(certs))
+	var roots []int
+	for i, parent := range parents {
+		if parent < 0 {
+			roots = append(roots, i)
+		} else {
+			children[parent] = append(children[parent], i)
+		}
+	}
+
+	var render func(i int, prefix, childPrefix string) error
+	render = func(i int, prefix, childPrefix string) error {
+		if _, err := fmt.Fprintf(w, "%s%s\n", prefix, PrintCommonName(certs[i].Subject)); err != nil {
+			return err
+		}
+		for n, child := range children[i] {
+			branch, indent := "|-- ", "|   "
+			if n == len(children[i])-1 {
+				branch, indent = "`-- ", "    "
+			}
+			if err := render(child, childPrefix+branch, childPrefix+indent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, root := range roots {
+		if err := render(root, "", ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isIssuerOfAny checks if the given certificate issued any of the others.
+func isIssuerOfAny(cert *x509.Certificate, certs []*x509.Certificate) bool {
+	for _, other := range certs {
+		if findIssuer(other, []*x509.Certificate{cert}) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCert(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c == cert {
+			return true
+		}
+	}
+	return false
+}
+
+// findIssuer returns the first of the candidates that could have issued the
+// given certificate, or nil if there is none.
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if bytes.Equal(candidate.Raw, cert.Raw) {
+			continue
+		}
+		if !RawDNEqual(candidate.RawSubject, cert.RawIssuer) {
+			continue
+		}
+		if len(cert.AuthorityKeyId) > 0 && len(candidate.SubjectKeyId) > 0 &&
+			!bytes.Equal(cert.AuthorityKeyId, candidate.SubjectKeyId) {
+			continue
+		}
+		return candidate
+	}
+	return nil
+}
+
+// MissingIssuer describes an issuer certificate that is needed to complete
+// a chain, but was not among the presented certificates.
+type MissingIssuer struct {
+	// Child is the last certificate of the chain that could be built.
+	Child *x509.Certificate
+
+	// Subject is the distinguished name of the missing certificate.
+	Subject string
+
+	// SubjectKeyId is the key identifier of the missing certificate, as
+	// given by the authority key identifier of the child (if any).
+	SubjectKeyId []byte
+
+	// IssuingCertificateURL lists where the missing certificate may be
+	// downloaded from, according to the child's AIA extension.
+	IssuingCertificateURL []string
+}
+
+func (m *MissingIssuer) String() string {
+	child, err := FormatDN(m.Child.RawSubject)
+	if err != nil {
+		child = m.Child.Subject.String()
+	}
+	out := fmt.Sprintf("missing intermediate %s, issuer of %s", m.Subject, child)
+	if len(m.SubjectKeyId) > 0 {
+		out += fmt.Sprintf(" (key id %s)", hexify(m.SubjectKeyId))
+	}
+	return out
+}
+
+// FindMissingIssuer builds a chain from the presented certificates (as
+// sent by a server, leaf first) and returns the issuer it could not
+// resolve, or nil if the chain is complete. A chain is complete if it ends
+// at a self-issued certificate, or at a certificate issued by one of the
+// subjects in roots (which may be nil).
+func FindMissingIssuer(presented []*x509.Certificate, roots *x509.CertPool) *MissingIssuer {
+	if len(presented) == 0 {
+		return nil
+	}
+
+	chain, err := BuildChain(presented[0], presented, 0)
+	if err != nil {
+		// Cycles are a problem of their own, but nothing is missing.
+		return nil
+	}
+
+	last := chain[len(chain)-1]
+	if IsSelfIssued(last) {
+		return nil
+	}
+	if roots != nil {
+		for _, subject := range roots.Subjects() {
+			if RawDNEqual(subject, last.RawIssuer) {
+				return nil
+			}
+		}
+	}
+
+	subject, err := FormatDN(last.RawIssuer)
+	if err != nil {
+		subject = last.Issuer.String()
+	}
+	return &MissingIssuer{
+		Child:                 last,
+		Subject:               subject,
+		SubjectKeyId:          last.AuthorityKeyId,
+		IssuingCertificateURL: last.IssuingCertificateURL,
+	}
+}
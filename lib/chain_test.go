@@ -0,0 +1,287 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issueTestCert creates a CA certificate for the given subject and key,
+// issued by the given issuer name and signed by the issuer's key.
+func issueTestCert(t *testing.T, subject string, key *ecdsa.PrivateKey, issuer string, issuerKey *ecdsa.PrivateKey) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: subject},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	parent := &x509.Certificate{Subject: pkix.Name{CommonName: issuer}}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestBuildChain(t *testing.T) {
+	rootKey, intKey, leafKey := newTestKey(t), newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	intermediate := issueTestCert(t, "intermediate", intKey, "root", rootKey)
+	leaf := issueTestCert(t, "leaf", leafKey, "intermediate", intKey)
+
+	chain, err := BuildChain(leaf, []*x509.Certificate{root, leaf, intermediate}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[0] != leaf || chain[1] != intermediate || chain[2] != root {
+		t.Fatalf("unexpected chain of length %d", len(chain))
+	}
+
+	if _, err := BuildChain(leaf, []*x509.Certificate{root, intermediate}, 2); err != ErrChainTooLong {
+		t.Fatalf("expected ErrChainTooLong, got: %v", err)
+	}
+}
+
+func TestBuildChainCycle(t *testing.T) {
+	keyA, keyB := newTestKey(t), newTestKey(t)
+	certA := issueTestCert(t, "A", keyA, "B", keyB)
+	certB := issueTestCert(t, "B", keyB, "A", keyA)
+
+	chain, err := BuildChain(certA, []*x509.Certificate{certA, certB}, 0)
+	if err != ErrChainTooLong {
+		t.Fatalf("expected ErrChainTooLong, got: %v", err)
+	}
+	if len(chain) != DefaultMaxChainLength {
+		t.Fatalf("unexpected chain length: %d != %d", len(chain), DefaultMaxChainLength)
+	}
+}
+
+func TestFindMissingIssuer(t *testing.T) {
+	rootKey, intKey, leafKey := newTestKey(t), newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	intermediate := issueTestCert(t, "intermediate", intKey, "root", rootKey)
+	leaf := issueTestCert(t, "leaf", leafKey, "intermediate", intKey)
+
+	if missing := FindMissingIssuer([]*x509.Certificate{leaf, intermediate, root}, nil); missing != nil {
+		t.Errorf("unexpected missing issuer for complete chain: %s", missing)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	if missing := FindMissingIssuer([]*x509.Certificate{leaf, intermediate}, roots); missing != nil {
+		t.Errorf("unexpected missing issuer for chain ending at a root: %s", missing)
+	}
+
+	missing := FindMissingIssuer([]*x509.Certificate{leaf}, roots)
+	if missing == nil {
+		t.Fatal("expected missing intermediate")
+	}
+	if missing.Child != leaf || missing.Subject != "CN=intermediate" {
+		t.Errorf("unexpected missing issuer: %s", missing)
+	}
+	if !strings.HasPrefix(missing.String(), "missing intermediate CN=intermediate, issuer of CN=leaf") {
+		t.Errorf("unexpected description: %s", missing)
+	}
+}
+
+func TestBuildChainReencodedIssuer(t *testing.T) {
+	intKey, leafKey := newTestKey(t), newTestKey(t)
+	intermediate := issueTestCert(t, "Intermediate CA", intKey, "root", intKey)
+	leaf := issueTestCert(t, "leaf", leafKey, "INTERMEDIATE  CA", intKey)
+
+	chain, err := BuildChain(leaf, []*x509.Certificate{intermediate}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || chain[1] != intermediate {
+		t.Fatalf("expected intermediate to be linked, got chain of length %d", len(chain))
+	}
+}
+
+func TestOrderChain(t *testing.T) {
+	rootKey, intKey, leafKey := newTestKey(t), newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	intermediate := issueTestCert(t, "intermediate", intKey, "root", rootKey)
+	leaf := issueTestCert(t, "leaf", leafKey, "intermediate", intKey)
+	unrelated := issueTestCert(t, "unrelated", leafKey, "unrelated", leafKey)
+
+	names := func(certs []*x509.Certificate) string {
+		var out []string
+		for _, cert := range certs {
+			out = append(out, cert.Subject.CommonName)
+		}
+		return strings.Join(out, ",")
+	}
+
+	testCases := []struct {
+		certs    []*x509.Certificate
+		expected string
+	}{
+		{[]*x509.Certificate{root, intermediate, leaf}, "leaf,intermediate,root"},
+		{[]*x509.Certificate{intermediate, leaf, root}, "leaf,intermediate,root"},
+		{[]*x509.Certificate{leaf, intermediate, root}, "leaf,intermediate,root"},
+		{[]*x509.Certificate{root, unrelated, leaf, intermediate}, "leaf,intermediate,root,unrelated"},
+		{[]*x509.Certificate{root, leaf}, "leaf,root"},
+	}
+	for _, tc := range testCases {
+		if ordered := names(OrderChain(tc.certs)); ordered != tc.expected {
+			t.Errorf("%s: expected %s, got %s", names(tc.certs), tc.expected, ordered)
+		}
+	}
+}
+
+func TestAssembleChain(t *testing.T) {
+	rootKey, intKey, leafKey, otherKey := newTestKey(t), newTestKey(t), newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	intermediate := issueTestCert(t, "intermediate", intKey, "root", rootKey)
+	leaf := issueTestCert(t, "leaf", leafKey, "intermediate", intKey)
+	other := issueTestCert(t, "other", otherKey, "other", otherKey)
+	// Same name as the real intermediate, but a different key
+	impostor := issueTestCert(t, "intermediate", otherKey, "root", rootKey)
+
+	toPEM := func(certs ...*x509.Certificate) io.Reader {
+		var out bytes.Buffer
+		for _, cert := range certs {
+			pem.Encode(&out, EncodeX509ToPEM(cert, nil))
+		}
+		return &out
+	}
+
+	chain, unused, err := AssembleChain(toPEM(leaf), []io.Reader{toPEM(root, other), toPEM(intermediate)}, ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[0].Subject.CommonName != "leaf" || chain[1].Subject.CommonName != "intermediate" || chain[2].Subject.CommonName != "root" {
+		t.Errorf("unexpected chain: %v", chain)
+	}
+	if len(unused) != 1 || unused[0].Subject.CommonName != "other" {
+		t.Errorf("unexpected unused certificates: %v", unused)
+	}
+
+	testCases := []struct {
+		leaf     io.Reader
+		chain    []io.Reader
+		expected string
+	}{
+		{toPEM(leaf), []io.Reader{toPEM(root, other)}, "chain doesn't connect to the leaf"},
+		{toPEM(leaf), []io.Reader{toPEM(impostor)}, "isn't signed by"},
+		{toPEM(leaf), []io.Reader{toPEM()}, "no certificates found"},
+		{toPEM(), []io.Reader{toPEM(intermediate)}, "no certificate found"},
+	}
+	for i, tc := range testCases {
+		_, _, err := AssembleChain(tc.leaf, tc.chain, ReadOptions{Format: "PEM"})
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("case %d: expected error containing %q, got: %v", i, tc.expected, err)
+		}
+	}
+}
+
+func TestBuildMinimalBundle(t *testing.T) {
+	rootKey, oldKey, intKey, leafKey, otherKey := newTestKey(t), newTestKey(t), newTestKey(t), newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	old := issueTestCert(t, "old", oldKey, "root", rootKey)
+	intermediate := issueTestCert(t, "intermediate", intKey, "root", rootKey)
+	// The same intermediate, cross-signed by the old root: a longer path
+	crossSigned := issueTestCert(t, "intermediate", intKey, "old", oldKey)
+	leaf := issueTestCert(t, "leaf", leafKey, "intermediate", intKey)
+	other := issueTestCert(t, "other", otherKey, "root", rootKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	bundle, err := BuildMinimalBundle(leaf, []*x509.Certificate{crossSigned, old, other, intermediate}, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle) != 2 || bundle[0] != leaf || !bundle[1].Equal(intermediate) {
+		t.Errorf("unexpected bundle: %v", bundle)
+	}
+
+	bundle, err = BuildMinimalBundle(root, nil, roots)
+	if err != nil || len(bundle) != 1 || !bundle[0].Equal(root) {
+		t.Errorf("unexpected bundle for root: %v, %v", bundle, err)
+	}
+
+	if _, err := BuildMinimalBundle(leaf, []*x509.Certificate{other}, roots); err == nil {
+		t.Error("expected an error for a leaf without its intermediate")
+	}
+}
+
+func TestRenderChainTree(t *testing.T) {
+	rootKey, intKey, otherKey := newTestKey(t), newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	intermediate := issueTestCert(t, "intermediate", intKey, "root", rootKey)
+	leaf1 := issueTestCert(t, "leaf1", newTestKey(t), "intermediate", intKey)
+	leaf2 := issueTestCert(t, "leaf2", newTestKey(t), "intermediate", intKey)
+	direct := issueTestCert(t, "direct", newTestKey(t), "root", rootKey)
+	orphan := issueTestCert(t, "orphan", newTestKey(t), "unknown", otherKey)
+
+	var out bytes.Buffer
+	err := RenderChainTree([]*x509.Certificate{leaf1, orphan, intermediate, root, leaf2, direct}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `CN=orphan
+CN=root
+|-- CN=intermediate
+|   |-- CN=leaf1
+|   ` + "`" + `-- CN=leaf2
+` + "`" + `-- CN=direct
+`
+	if out.String() != expected {
+		t.Errorf("unexpected tree:\n%s\nwant:\n%s", out.String(), expected)
+	}
+
+	// Certificates that issued each other still appear
+	aKey, bKey := newTestKey(t), newTestKey(t)
+	out.Reset()
+	err = RenderChainTree([]*x509.Certificate{
+		issueTestCert(t, "a", aKey, "b", bKey),
+		issueTestCert(t, "b", bKey, "a", aKey),
+	}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "CN=a\n`-- CN=b\n"; out.String() != expected {
+		t.Errorf("unexpected tree:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,256 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+var (
+	oidExtensionCRLNumber                = asn1.ObjectIdentifier{2, 5, 29, 20}
+	oidExtensionReasonCode               = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidExtensionDeltaCRLIndicator        = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidExtensionIssuingDistributionPoint = asn1.ObjectIdentifier{2, 5, 29, 28}
+	oidExtensionCertificateIssuer        = asn1.ObjectIdentifier{2, 5, 29, 29}
+)
+
+// CRL holds the contents of a certificate revocation list.
+type CRL struct {
+	Issuer     pkix.Name
+	ThisUpdate time.Time
+	NextUpdate time.Time
+
+	// Number is the CRL number, if present.
+	Number *big.Int
+
+	// IsDelta is set for delta CRLs, which only list changes since the
+	// (complete) CRL numbered BaseCRLNumber.
+	IsDelta       bool
+	BaseCRLNumber *big.Int
+
+	// IsIndirect is set for indirect CRLs, which may list certificates
+	// issued by CAs other than the CRL issuer (see CRLEntry.Issuer).
+	IsIndirect bool
+
+	Entries []CRLEntry
+
+	// Raw is the parsed CRL, for access to anything not covered above.
+	Raw *pkix.CertificateList
+}
+
+// CRLEntry is a revoked certificate in a CRL.
+type CRLEntry struct {
+	SerialNumber   *big.Int
+	RevocationTime time.Time
+
+	// Reason is the CRL reason code, or -1 if not given.
+	Reason int
+
+	// Issuer holds the names of the issuer of the revoked certificate in an
+	// indirect CRL (from the certificate issuer entry extension, which
+	// also applies to subsequent entries). Empty means the CRL issuer.
+	Issuer []string
+}
+
+// issuingDistributionPoint is the issuing distribution point CRL extension,
+// see RFC 5280, section 5.2.5.
+type issuingDistributionPoint struct {
+	DistributionPoint          asn1.RawValue  `asn1:"optional,tag:0"`
+	OnlyContainsUserCerts      bool           `asn1:"optional,tag:1"`
+	OnlyContainsCACerts        bool           `asn1:"optional,tag:2"`
+	OnlySomeReasons            asn1.BitString `asn1:"optional,tag:3"`
+	IndirectCRL                bool           `asn1:"optional,tag:4"`
+	OnlyContainsAttributeCerts bool           `asn1:"optional,tag:5"`
+}
+
+// ParseCRL parses a CRL, in DER or PEM form. Besides the basic fields, it
+// reports delta CRLs (with their base CRL number) and indirect CRLs (with
+// the certificate issuer of each entry).
+func ParseCRL(data []byte) (*CRL, error) {
+	list, err := x509.ParseCRL(data)
+	if err != nil {
+		return nil, err
+	}
+
+	crl := &CRL{
+		ThisUpdate: list.TBSCertList.ThisUpdate,
+		NextUpdate: list.TBSCertList.NextUpdate,
+		Raw:        list,
+	}
+	crl.Issuer.FillFromRDNSequence(&list.TBSCertList.Issuer)
+
+	for _, ext := range list.TBSCertList.Extensions {
+		switch {
+		case ext.Id.Equal(oidExtensionCRLNumber):
+			crl.Number = new(big.Int)
+			if _, err := asn1.Unmarshal(ext.Value, &crl.Number); err != nil {
+				return nil, fmt.Errorf("invalid CRL number extension: %s", err)
+			}
+		case ext.Id.Equal(oidExtensionDeltaCRLIndicator):
+			crl.IsDelta = true
+			crl.BaseCRLNumber = new(big.Int)
+			if _, err := asn1.Unmarshal(ext.Value, &crl.BaseCRLNumber); err != nil {
+				return nil, fmt.Errorf("invalid delta CRL indicator extension: %s", err)
+			}
+		case ext.Id.Equal(oidExtensionIssuingDistributionPoint):
+			var idp issuingDistributionPoint
+			if _, err := asn1.Unmarshal(ext.Value, &idp); err != nil {
+				return nil, fmt.Errorf("invalid issuing distribution point extension: %s", err)
+			}
+			crl.IsIndirect = idp.IndirectCRL
+		}
+	}
+
+	var issuer []string
+	for _, revoked := range list.TBSCertList.RevokedCertificates {
+		entry := CRLEntry{
+			SerialNumber:   revoked.SerialNumber,
+			RevocationTime: revoked.RevocationTime,
+			Reason:         -1,
+		}
+		for _, ext := range revoked.Extensions {
+			switch {
+			case ext.Id.Equal(oidExtensionReasonCode):
+				var reason asn1.Enumerated
+				if _, err := asn1.Unmarshal(ext.Value, &reason); err != nil {
+					return nil, fmt.Errorf("invalid reason code in CRL entry: %s", err)
+				}
+				entry.Reason = int(reason)
+			case ext.Id.Equal(oidExtensionCertificateIssuer):
+				issuer, err = parseGeneralNames(ext.Value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid certificate issuer in CRL entry: %s", err)
+				}
+			}
+		}
+		if crl.IsIndirect {
+			entry.Issuer = issuer
+		}
+		crl.Entries = append(crl.Entries, entry)
+	}
+
+	return crl, nil
+}
+
+// VerifyCRLSignature checks that the given CRL was signed by the given
+// issuer: the names must match, the issuer must be allowed to sign CRLs (if
+// it has a key usage extension), and the signature must be valid.
+func VerifyCRLSignature(crl *CRL, issuer *x509.Certificate) error {
+	if !stringsEqual(canonicalRDNs(crl.Raw.TBSCertList.Issuer), canonicalRawDN(issuer.RawSubject, issuer.Subject)) {
+		return fmt.Errorf("CRL issuer (%s) does not match certificate subject (%s)", crl.Issuer, issuer.Subject)
+	}
+	if issuer.KeyUsage != 0 && issuer.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return errors.New("issuer certificate is not allowed to sign CRLs (no cRLSign key usage)")
+	}
+
+	sigAlgorithm := crl.Raw.SignatureAlgorithm.Algorithm
+	keyAlgorithm, _ := decodeKey(issuer.PublicKey)
+	if _, ok := issuer.PublicKey.(ed25519.PublicKey); ok {
+		keyAlgorithm = "Ed25519"
+	}
+	if expected := crlSignatureKeyAlgorithm(sigAlgorithm); expected != "" && keyAlgorithm != "" && expected != keyAlgorithm {
+		return fmt.Errorf("CRL signature algorithm (%s) does not match issuer key algorithm (%s)", sigAlgorithm, keyAlgorithm)
+	}
+
+	if err := issuer.CheckCRLSignature(crl.Raw); err != nil {
+		return fmt.Errorf("invalid CRL signature: %s", err)
+	}
+	return nil
+}
+
+// crlSignatureKeyAlgorithm returns the key algorithm (as named by decodeKey)
+// for a signature algorithm OID, or an empty string if it's unknown.
+func crlSignatureKeyAlgorithm(oid asn1.ObjectIdentifier) string {
+	switch {
+	case len(oid) == 7 && oid[:6].Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1}):
+		// PKCS #1, including RSA-PSS
+		return "RSA"
+	case len(oid) >= 6 && oid[:5].Equal(asn1.ObjectIdentifier{1, 2, 840, 10045, 4}):
+		return "ECDSA"
+	case oid.Equal(asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 3}),
+		oid.Equal(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 1}),
+		oid.Equal(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 2}):
+		return "DSA"
+	case oid.Equal(asn1.ObjectIdentifier{1, 3, 101, 112}):
+		return "Ed25519"
+	}
+	return ""
+}
+
+// parseGeneralNames renders a DER-encoded GeneralNames sequence as strings,
+// prefixed with the type of name in the style of OpenSSL (e.g. "DNS:").
+func parseGeneralNames(der []byte) ([]string, error) {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &seq); err != nil {
+		return nil, err
+	}
+	if !seq.IsCompound || seq.Tag != asn1.TagSequence || seq.Class != asn1.ClassUniversal {
+		return nil, asn1.StructuralError{Msg: "bad GeneralNames sequence"}
+	}
+
+	var names []string
+	rest := seq.Bytes
+	for len(rest) > 0 {
+		var name asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &name)
+		if err != nil {
+			return nil, err
+		}
+		if name.Class != asn1.ClassContextSpecific {
+			return nil, asn1.StructuralError{Msg: "bad GeneralName tag"}
+		}
+
+		switch name.Tag {
+		case 0:
+			other, err := parseOtherName(name.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			typ := other.TypeID.String()
+			if other.TypeID.Equal(oidOtherNameUPN) {
+				typ = "UPN"
+			}
+			names = append(names, "othername:"+typ+":"+other.String())
+		case 1:
+			names = append(names, "email:"+string(name.Bytes))
+		case 2:
+			names = append(names, "DNS:"+string(name.Bytes))
+		case 4:
+			dn, err := FormatDN(name.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, "DirName:"+dn)
+		case 6:
+			names = append(names, "URI:"+string(name.Bytes))
+		case 7:
+			names = append(names, "IP:"+net.IP(name.Bytes).String())
+		default:
+			names = append(names, fmt.Sprintf("<unsupported name type %d>", name.Tag))
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,256 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/certigo/pkcs7"
+)
+
+var oidSignatureECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+
+// createTestCRL builds a CRL with the given extensions, signed with key.
+// The x509 package can't create CRLs with custom extensions.
+func createTestCRL(t *testing.T, key *ecdsa.PrivateKey, issuer string, revoked []pkix.RevokedCertificate, extensions []pkix.Extension) []byte {
+	now := time.Now().UTC().Truncate(time.Second)
+	tbs := pkix.TBSCertificateList{
+		Version:             1,
+		Signature:           pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSAWithSHA256},
+		Issuer:              pkix.Name{CommonName: issuer}.ToRDNSequence(),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(24 * time.Hour),
+		RevokedCertificates: revoked,
+		Extensions:          extensions,
+	}
+	tbsRaw, err := asn1.Marshal(tbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbsRaw)
+	signature, err := key.Sign(rand.Reader, digest[:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := asn1.Marshal(pkix.CertificateList{
+		TBSCertList:        pkix.TBSCertificateList{Raw: tbsRaw},
+		SignatureAlgorithm: tbs.Signature,
+		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func marshalExtension(t *testing.T, oid asn1.ObjectIdentifier, value interface{}) pkix.Extension {
+	raw, err := asn1.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oid, Value: raw}
+}
+
+func TestParseCRL(t *testing.T) {
+	key := newTestKey(t)
+	revocationTime := time.Now().UTC().Truncate(time.Second)
+
+	der := createTestCRL(t, key, "Test CA", []pkix.RevokedCertificate{
+		{SerialNumber: big.NewInt(1), RevocationTime: revocationTime},
+	}, []pkix.Extension{marshalExtension(t, oidExtensionCRLNumber, 7)})
+
+	crl, err := ParseCRL(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crl.Issuer.CommonName != "Test CA" || crl.Number.Int64() != 7 || crl.IsDelta || crl.IsIndirect {
+		t.Errorf("unexpected CRL: %+v", crl)
+	}
+	if len(crl.Entries) != 1 || crl.Entries[0].SerialNumber.Int64() != 1 || crl.Entries[0].Reason != -1 || crl.Entries[0].Issuer != nil {
+		t.Errorf("unexpected CRL entries: %+v", crl.Entries)
+	}
+}
+
+func TestParseDeltaCRL(t *testing.T) {
+	key := newTestKey(t)
+	der := createTestCRL(t, key, "Test CA", nil, []pkix.Extension{
+		marshalExtension(t, oidExtensionCRLNumber, 8),
+		marshalExtension(t, oidExtensionDeltaCRLIndicator, 5),
+	})
+
+	crl, err := ParseCRL(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !crl.IsDelta || crl.BaseCRLNumber.Int64() != 5 || crl.Number.Int64() != 8 {
+		t.Errorf("unexpected delta CRL: %+v", crl)
+	}
+}
+
+func TestParseIndirectCRL(t *testing.T) {
+	key := newTestKey(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	otherCA, err := asn1.Marshal(pkix.Name{CommonName: "Other CA"}.ToRDNSequence())
+	if err != nil {
+		t.Fatal(err)
+	}
+	certIssuer := marshalExtension(t, oidExtensionCertificateIssuer, []asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: otherCA},
+	})
+	reason := marshalExtension(t, oidExtensionReasonCode, asn1.Enumerated(1))
+
+	idp := marshalExtension(t, oidExtensionIssuingDistributionPoint, issuingDistributionPoint{IndirectCRL: true})
+	idp.Critical = true
+
+	der := createTestCRL(t, key, "CRL Issuer", []pkix.RevokedCertificate{
+		{SerialNumber: big.NewInt(1), RevocationTime: now},
+		{SerialNumber: big.NewInt(2), RevocationTime: now, Extensions: []pkix.Extension{certIssuer, reason}},
+		{SerialNumber: big.NewInt(3), RevocationTime: now},
+	}, []pkix.Extension{idp})
+
+	crl, err := ParseCRL(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !crl.IsIndirect {
+		t.Fatal("expected indirect CRL")
+	}
+	var issuers [][]string
+	for _, entry := range crl.Entries {
+		issuers = append(issuers, entry.Issuer)
+	}
+	// The certificate issuer extension applies to subsequent entries, too
+	expected := [][]string{nil, {"DirName:CN=Other CA"}, {"DirName:CN=Other CA"}}
+	if !reflect.DeepEqual(issuers, expected) {
+		t.Errorf("unexpected entry issuers: %q", issuers)
+	}
+	if crl.Entries[1].Reason != 1 {
+		t.Errorf("unexpected reason: %d", crl.Entries[1].Reason)
+	}
+}
+
+func TestVerifyCRLSignature(t *testing.T) {
+	key := newTestKey(t)
+	crl, err := ParseCRL(createTestCRL(t, key, "Test CA", nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyCRLSignature(crl, issueTestCert(t, "Test CA", key, "Test CA", key)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		issuer   *x509.Certificate
+		expected string
+	}{
+		{issueTestCert(t, "Other CA", key, "Other CA", key), "does not match certificate subject"},
+		{issueTestCert(t, "Test CA", newTestKey(t), "Test CA", key), "invalid CRL signature"},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}, PublicKey: &rsaKey.PublicKey}, "does not match issuer key algorithm"},
+		{selfSignTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}, KeyUsage: x509.KeyUsageDigitalSignature}), "no cRLSign key usage"},
+	}
+	for i, tc := range testCases {
+		err := VerifyCRLSignature(crl, tc.issuer)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("case %d: expected error containing %q, got: %v", i, tc.expected, err)
+		}
+	}
+}
+
+func TestReadX509WithCRLs(t *testing.T) {
+	keyA, keyB := newTestKey(t), newTestKey(t)
+	certA := issueTestCert(t, "CA A", keyA, "CA A", keyA)
+	certB := issueTestCert(t, "CA B", keyB, "CA B", keyB)
+	crlA := createTestCRL(t, keyA, "CA A", nil, nil)
+	crlB := createTestCRL(t, keyB, "CA B", nil, nil)
+
+	contentInfo, err := asn1.Marshal(struct{ Type asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := asn1.Marshal(pkcs7.SignedDataEnvelope{
+		Type: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2},
+		SignedData: pkcs7.SignedData{
+			Version:          1,
+			DigestAlgorithms: []asn1.RawValue{},
+			ContentInfo:      asn1.RawValue{FullBytes: contentInfo},
+			Certificates:     []asn1.RawValue{{FullBytes: certB.Raw}},
+			RevocationLists:  []asn1.RawValue{{FullBytes: crlB}},
+			SignerInfos:      []asn1.RawValue{},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bundle bytes.Buffer
+	pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: certA.Raw})
+	pem.Encode(&bundle, &pem.Block{Type: PEMTypeCRL, Bytes: crlA})
+	pem.Encode(&bundle, &pem.Block{Type: "PKCS7", Bytes: envelope})
+	pem.Encode(&bundle, &pem.Block{Type: PEMTypeCRL, Bytes: []byte("garbage")})
+	data := bundle.Bytes()
+
+	var read []string
+	opts := ReadOptions{
+		Strict:        true,
+		CollectErrors: true,
+		CRLs: func(crl *CRL, format string, err error) error {
+			read = append(read, "crl:"+crl.Issuer.CommonName)
+			return nil
+		},
+	}
+	err = ReadX509WithOptions([]io.Reader{bytes.NewReader(data)}, opts, func(cert *x509.Certificate, format string, err error) error {
+		read = append(read, "cert:"+cert.Subject.CommonName)
+		return nil
+	})
+	if errs, ok := err.(ReadErrors); !ok || len(errs) != 1 {
+		t.Errorf("expected one error for the bad CRL, got %v", err)
+	}
+	expected := []string{"cert:CA A", "crl:CA A", "cert:CA B", "crl:CA B"}
+	if !reflect.DeepEqual(read, expected) {
+		t.Errorf("unexpected read order %v, expected %v", read, expected)
+	}
+
+	// Without a CRL callback, CRLs are skipped as before
+	read = nil
+	err = ReadX509WithOptions([]io.Reader{bytes.NewReader(data)}, ReadOptions{}, func(cert *x509.Certificate, format string, err error) error {
+		read = append(read, "cert:"+cert.Subject.CommonName)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(read, []string{"cert:CA A", "cert:CA B"}) {
+		t.Errorf("unexpected certificates without CRL callback: %v, %v", read, err)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"reflect"
 	"text/template"
 	"time"
 
@@ -111,19 +112,19 @@ Extended Key Usage:
 {{- end}}
 {{- if .AltDNSNames}}
 DNS Names:
-	{{wrapWith .Width "\n\t" (join ", " .AltDNSNames)}}
+	{{wrapWith .Width "\n\t" (join ", " (capNames .MaxSANs .AltDNSNames))}}
 {{- end}}
 {{- if .AltIPAddresses}}
 IP Addresses:
-	{{wrapWith .Width "\n\t" (join ", " .AltIPAddresses)}}
+	{{wrapWith .Width "\n\t" (join ", " (capNames .MaxSANs .AltIPAddresses))}}
 {{- end}}
 {{- if .URINames}}
 URI Names:
-	{{wrapWith .Width "\n\t" (join ", " .URINames)}}
+	{{wrapWith .Width "\n\t" (join ", " (capNames .MaxSANs .URINames))}}
 {{- end}}
 {{- if .EmailAddresses}}
 Email Addresses:
-	{{wrapWith .Width "\n\t" (join ", " .EmailAddresses)}}
+	{{wrapWith .Width "\n\t" (join ", " (capNames .MaxSANs .EmailAddresses))}}
 {{- end}}
 {{- if .Warnings}}
 Warnings:
@@ -142,16 +143,16 @@ Issuer:
 	{{wrapWith .Width "\n\t" (.Issuer.Name | printShortName)}}
 {{- if .AltDNSNames}}
 DNS Names:
-	{{wrapWith .Width "\n\t" (join ", " .AltDNSNames)}}{{end}}
+	{{wrapWith .Width "\n\t" (join ", " (capNames .MaxSANs .AltDNSNames))}}{{end}}
 {{- if .AltIPAddresses}}
 IP Addresses:
-	{{wrapWith .Width "\n\t" (join ", " .AltIPAddresses)}}{{end}}
+	{{wrapWith .Width "\n\t" (join ", " (capNames .MaxSANs .AltIPAddresses))}}{{end}}
 {{- if .URINames}}
 URI Names:
-	{{wrapWith .Width "\n\t" (join ", " .URINames)}}{{end}}
+	{{wrapWith .Width "\n\t" (join ", " (capNames .MaxSANs .URINames))}}{{end}}
 {{- if .EmailAddresses}}
 Email Addresses:
-	{{wrapWith .Width "\n\t" (join ", " .EmailAddresses)}}{{end}}
+	{{wrapWith .Width "\n\t" (join ", " (capNames .MaxSANs .EmailAddresses))}}{{end}}
 {{- if .Warnings}}
 Warnings:{{range .Warnings}}
 	{{. | redify}}{{end}}{{end}}`
@@ -173,12 +174,10 @@ func createSimpleCertificateFromX509(block *pem.Block) (simpleCertificate, error
 		return simpleCertificate{}, fmt.Errorf("error reading cert: %s", err)
 	}
 
-	cert := certWithName{cert: raw}
-	if val, ok := block.Headers[nameHeader]; ok {
-		cert.name = val
-	}
-	if val, ok := block.Headers[fileHeader]; ok {
-		cert.file = val
+	cert := certWithName{
+		cert: raw,
+		name: ownHeader(block.Headers, nameHeader),
+		file: ownHeader(block.Headers, fileHeader),
 	}
 
 	return createSimpleCertificate(cert.name, cert.cert), nil
@@ -201,10 +200,31 @@ func EncodeX509ToObject(cert *x509.Certificate) interface{} {
 
 // EncodeX509ToText encodes an X.509 certificate into human-readable text.
 func EncodeX509ToText(cert *x509.Certificate, terminalWidth int, verbose bool) []byte {
+	return EncodeX509ToTextWithOptions(cert, TextOptions{TerminalWidth: terminalWidth, Verbose: verbose})
+}
+
+// TextOptions holds settings for rendering certificates as text.
+type TextOptions struct {
+	// TerminalWidth is used to wrap long lists of names.
+	TerminalWidth int
+
+	// Verbose includes all details (serial, key usage, etc.).
+	Verbose bool
+
+	// MaxSANs caps the number of names shown for each kind of subject
+	// alternative name (DNS, IP, URI, email), followed by a count of the
+	// ones left out. Zero means no limit.
+	MaxSANs int
+}
+
+// EncodeX509ToTextWithOptions encodes an X.509 certificate into
+// human-readable text, as configured by the given options.
+func EncodeX509ToTextWithOptions(cert *x509.Certificate, opts TextOptions) []byte {
 	c := createSimpleCertificate("", cert)
-	c.Width = terminalWidth - 8 /* Need some margin for tab */
+	c.Width = opts.TerminalWidth - 8 /* Need some margin for tab */
+	c.MaxSANs = opts.MaxSANs
 
-	return displayCert(c, verbose)
+	return displayCert(c, opts.Verbose)
 }
 
 // displayCert takes in a parsed certificate object
@@ -227,6 +247,7 @@ func displayCert(cert simpleCertificate, verbose bool) []byte {
 		"oidShort":           oidShort,
 		"printShortName":     PrintShortName,
 		"printCommonName":    PrintCommonName,
+		"capNames":           capNames,
 	}
 	for k, v := range extras {
 		funcMap[k] = v
@@ -254,6 +275,21 @@ func displayCert(cert simpleCertificate, verbose bool) []byte {
 	return buffer.Bytes()
 }
 
+// capNames returns the given list of names (of any type) as strings, cut
+// off after max entries with a note of how many were left out.
+func capNames(max int, names interface{}) []string {
+	v := reflect.ValueOf(names)
+	out := []string{}
+	for i := 0; i < v.Len(); i++ {
+		if max > 0 && i == max {
+			out = append(out, fmt.Sprintf("... and %d more (%d total)", v.Len()-max, v.Len()))
+			break
+		}
+		out = append(out, fmt.Sprint(v.Index(i).Interface()))
+	}
+	return out
+}
+
 var (
 	green  = color.New(color.Bold, color.FgGreen)
 	yellow = color.New(color.Bold, color.FgYellow)
@@ -0,0 +1,45 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestCapNames(t *testing.T) {
+	names := []string{"a.example.com", "b.example.com", "c.example.com"}
+
+	if out := capNames(0, names); !reflect.DeepEqual(out, names) {
+		t.Errorf("unexpected uncapped names: %v", out)
+	}
+	if out := capNames(3, names); !reflect.DeepEqual(out, names) {
+		t.Errorf("unexpected names at cap: %v", out)
+	}
+
+	expected := []string{"a.example.com", "... and 2 more (3 total)"}
+	if out := capNames(1, names); !reflect.DeepEqual(out, expected) {
+		t.Errorf("unexpected capped names: %v", out)
+	}
+
+	ips := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}
+	expected = []string{"10.0.0.1", "... and 1 more (2 total)"}
+	if out := capNames(1, ips); !reflect.DeepEqual(out, expected) {
+		t.Errorf("unexpected capped IPs: %v", out)
+	}
+}
@@ -0,0 +1,88 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+)
+
+// DistrustList is a set of distrusted root certificates, mapping the
+// hex-encoded SHA-256 fingerprint of each root to a description of it.
+type DistrustList map[string]string
+
+// defaultDistrustedRoots are roots that browsers have stopped trusting, but
+// that may still linger in older trust stores (mostly the legacy Symantec
+// PKI, distrusted by Chrome and Firefox in 2018).
+var defaultDistrustedRoots = DistrustList{
+	"9acfab7e43c8d880d06b262a94deeee4b4659989c3d0caf19baf6405e41ab7df": "VeriSign Class 3 Public Primary Certification Authority - G5 (Symantec)",
+	"2399561127a57125de8cefea610ddf2fa078b5c8067f4e828290bfb860e84b3c": "VeriSign Universal Root Certification Authority (Symantec)",
+	"ff856a2d251dcd88d36656f450126798cfabaade40799c722de4d2b5db36a73a": "GeoTrust Global CA (Symantec)",
+	"37d51006c512eaab626421f1ec8c92013fc5f82ae98ee533eb4619b8deb4d06c": "GeoTrust Primary Certification Authority (Symantec)",
+	"b478b812250df878635c2aa7ec7d155eaa625ee82916e2cd294361886cd1fbd4": "GeoTrust Primary Certification Authority - G3 (Symantec)",
+	"8d722f81a9c113c0791df136a2966db26c950a971db46b4199f4ea54b78bfb9f": "thawte Primary Root CA (Symantec)",
+	"4b03f45807ad70f21bfc2cae71c9fde4604c064cf5ffb686bae5dbaad7fdd34c": "thawte Primary Root CA - G3 (Symantec)",
+}
+
+// DefaultDistrustList returns a copy of the built-in list of distrusted
+// roots, which callers may extend.
+func DefaultDistrustList() DistrustList {
+	list := DistrustList{}
+	for fingerprint, description := range defaultDistrustedRoots {
+		list[fingerprint] = description
+	}
+	return list
+}
+
+// Add adds a root certificate to the list.
+func (l DistrustList) Add(root *x509.Certificate, description string) {
+	l[distrustFingerprint(root)] = description
+}
+
+// AddFingerprint adds a root to the list by its SHA-256 fingerprint, given
+// in hex (optionally with colons, as printed by OpenSSL).
+func (l DistrustList) AddFingerprint(fingerprint, description string) {
+	l[strings.ToLower(strings.Replace(fingerprint, ":", "", -1))] = description
+}
+
+// Lookup returns the description of the given certificate if it's in the
+// list.
+func (l DistrustList) Lookup(cert *x509.Certificate) (string, bool) {
+	description, ok := l[distrustFingerprint(cert)]
+	return description, ok
+}
+
+func distrustFingerprint(cert *x509.Certificate) string {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// CheckDistrustedRoot builds the chain of the given leaf from the candidates
+// (see BuildChain), which should include the roots it may chain to, and
+// checks it against the list. If a certificate in the chain is distrusted,
+// it is returned along with its description.
+func CheckDistrustedRoot(leaf *x509.Certificate, candidates []*x509.Certificate, list DistrustList) (*x509.Certificate, string) {
+	chain, _ := BuildChain(leaf, candidates, 0)
+	for i := len(chain) - 1; i >= 0; i-- {
+		if description, ok := list.Lookup(chain[i]); ok {
+			return chain[i], description
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,59 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestCheckDistrustedRoot(t *testing.T) {
+	rootKey, intKey, leafKey := newTestKey(t), newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	intermediate := issueTestCert(t, "intermediate", intKey, "root", rootKey)
+	leaf := issueTestCert(t, "leaf", leafKey, "intermediate", intKey)
+	candidates := []*x509.Certificate{intermediate, root}
+
+	list := DefaultDistrustList()
+	if len(list) != len(defaultDistrustedRoots) {
+		t.Fatal("default list not copied")
+	}
+	if cert, _ := CheckDistrustedRoot(leaf, candidates, list); cert != nil {
+		t.Errorf("unexpected distrusted root: %s", cert.Subject)
+	}
+
+	list.Add(root, "test root")
+	cert, description := CheckDistrustedRoot(leaf, candidates, list)
+	if cert != root || description != "test root" {
+		t.Errorf("expected distrusted root, got %v (%s)", cert, description)
+	}
+	if _, ok := defaultDistrustedRoots[distrustFingerprint(root)]; ok {
+		t.Error("extending a list modified the built-in list")
+	}
+
+	list = DistrustList{}
+	fingerprint := strings.ToUpper(distrustFingerprint(intermediate))
+	var colons []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		colons = append(colons, fingerprint[i:i+2])
+	}
+	list.AddFingerprint(strings.Join(colons, ":"), "test intermediate")
+	if cert, _ := CheckDistrustedRoot(leaf, candidates, list); cert != intermediate {
+		t.Errorf("expected distrusted intermediate, got %v", cert)
+	}
+}
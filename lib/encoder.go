@@ -18,15 +18,19 @@ package lib
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/dsa"
 	"crypto/ecdsa"
+	_ "crypto/md5" // for TBSHash
 	"crypto/rsa"
+	_ "crypto/sha512" // for TBSHash
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -134,18 +138,20 @@ var keyUsageStrings = map[x509.KeyUsage]string{
 }
 
 var extKeyUsageStrings = map[x509.ExtKeyUsage]string{
-	x509.ExtKeyUsageAny:                        "Any",
-	x509.ExtKeyUsageServerAuth:                 "Server Auth",
-	x509.ExtKeyUsageClientAuth:                 "Client Auth",
-	x509.ExtKeyUsageCodeSigning:                "Code Signing",
-	x509.ExtKeyUsageEmailProtection:            "Email Protection",
-	x509.ExtKeyUsageIPSECEndSystem:             "IPSEC End System",
-	x509.ExtKeyUsageIPSECTunnel:                "IPSEC Tunnel",
-	x509.ExtKeyUsageIPSECUser:                  "IPSEC User",
-	x509.ExtKeyUsageTimeStamping:               "Time Stamping",
-	x509.ExtKeyUsageOCSPSigning:                "OCSP Signing",
-	x509.ExtKeyUsageMicrosoftServerGatedCrypto: "Microsoft ServerGatedCrypto",
-	x509.ExtKeyUsageNetscapeServerGatedCrypto:  "Netscape ServerGatedCrypto",
+	x509.ExtKeyUsageAny:                            "Any",
+	x509.ExtKeyUsageServerAuth:                     "Server Auth",
+	x509.ExtKeyUsageClientAuth:                     "Client Auth",
+	x509.ExtKeyUsageCodeSigning:                    "Code Signing",
+	x509.ExtKeyUsageEmailProtection:                "Email Protection",
+	x509.ExtKeyUsageIPSECEndSystem:                 "IPSEC End System",
+	x509.ExtKeyUsageIPSECTunnel:                    "IPSEC Tunnel",
+	x509.ExtKeyUsageIPSECUser:                      "IPSEC User",
+	x509.ExtKeyUsageTimeStamping:                   "Time Stamping",
+	x509.ExtKeyUsageOCSPSigning:                    "OCSP Signing",
+	x509.ExtKeyUsageMicrosoftServerGatedCrypto:     "Microsoft ServerGatedCrypto",
+	x509.ExtKeyUsageNetscapeServerGatedCrypto:      "Netscape ServerGatedCrypto",
+	x509.ExtKeyUsageMicrosoftCommercialCodeSigning: "Microsoft Commercial Code Signing",
+	x509.ExtKeyUsageMicrosoftKernelCodeSigning:     "Microsoft Kernel Code Signing",
 }
 
 var algoName = [...]string{
@@ -204,7 +210,8 @@ type simpleCertificate struct {
 	PEM                   string              `json:"pem,omitempty"`
 
 	// Internal fields for text display. Set - to skip serialize.
-	Width int `json:"-"`
+	Width   int `json:"-"`
+	MaxSANs int `json:"-"`
 }
 
 type simplePKIXName struct {
@@ -249,11 +256,12 @@ func createSimpleCertificate(name string, cert *x509.Certificate) simpleCertific
 	out.Warnings = certWarnings(cert, out.URINames)
 
 	if cert.BasicConstraintsValid {
+		isCA, maxPathLen, hasMaxPathLen := BasicConstraintsOf(cert)
 		out.BasicConstraints = &basicConstraints{
-			IsCA: cert.IsCA,
+			IsCA: isCA,
 		}
-		if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
-			out.BasicConstraints.MaxPathLen = &cert.MaxPathLen
+		if hasMaxPathLen {
+			out.BasicConstraints.MaxPathLen = &maxPathLen
 		}
 	}
 
@@ -353,6 +361,20 @@ func extKeyUsage(sEku simpleExtKeyUsage) string {
 	return fmt.Sprintf("unknown:%d", eku)
 }
 
+// ExtKeyUsageStrings returns the extended key usages of the given
+// certificate as human-readable strings. Usages that aren't known to the
+// x509 package are rendered as dotted OIDs.
+func ExtKeyUsageStrings(cert *x509.Certificate) []string {
+	out := []string{}
+	for _, eku := range cert.ExtKeyUsage {
+		out = append(out, extKeyUsage(simpleExtKeyUsage(eku)))
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		out = append(out, oid.String())
+	}
+	return out
+}
+
 func algString(algo x509.SignatureAlgorithm) string {
 	if 0 < algo && int(algo) < len(algoName) {
 		return algoName[algo]
@@ -360,6 +382,85 @@ func algString(algo x509.SignatureAlgorithm) string {
 	return strconv.Itoa(int(algo))
 }
 
+// signatureAlgorithmParts maps signature algorithms to their key algorithm
+// and hash algorithm.
+var signatureAlgorithmParts = map[x509.SignatureAlgorithm][2]string{
+	x509.MD2WithRSA:       {"RSA", "MD2"},
+	x509.MD5WithRSA:       {"RSA", "MD5"},
+	x509.SHA1WithRSA:      {"RSA", "SHA-1"},
+	x509.SHA256WithRSA:    {"RSA", "SHA-256"},
+	x509.SHA384WithRSA:    {"RSA", "SHA-384"},
+	x509.SHA512WithRSA:    {"RSA", "SHA-512"},
+	x509.DSAWithSHA1:      {"DSA", "SHA-1"},
+	x509.DSAWithSHA256:    {"DSA", "SHA-256"},
+	x509.ECDSAWithSHA1:    {"ECDSA", "SHA-1"},
+	x509.ECDSAWithSHA256:  {"ECDSA", "SHA-256"},
+	x509.ECDSAWithSHA384:  {"ECDSA", "SHA-384"},
+	x509.ECDSAWithSHA512:  {"ECDSA", "SHA-512"},
+	x509.SHA256WithRSAPSS: {"RSA-PSS", "SHA-256"},
+	x509.SHA384WithRSAPSS: {"RSA-PSS", "SHA-384"},
+	x509.SHA512WithRSAPSS: {"RSA-PSS", "SHA-512"},
+	x509.PureEd25519:      {"Ed25519", ""},
+}
+
+// SignatureAlgorithmDetails splits the signature algorithm of the given
+// certificate into its key algorithm (e.g. "RSA") and hash algorithm (e.g.
+// "SHA-256"). The hash is empty for Ed25519, which has no separate hash, and
+// both are empty if the signature algorithm is unknown.
+func SignatureAlgorithmDetails(cert *x509.Certificate) (keyAlgo string, hashAlgo string) {
+	parts := signatureAlgorithmParts[cert.SignatureAlgorithm]
+	return parts[0], parts[1]
+}
+
+// signatureHashes maps the hash algorithm names in signatureAlgorithmParts
+// to hash functions.
+var signatureHashes = map[string]crypto.Hash{
+	"MD5":     crypto.MD5,
+	"SHA-1":   crypto.SHA1,
+	"SHA-256": crypto.SHA256,
+	"SHA-384": crypto.SHA384,
+	"SHA-512": crypto.SHA512,
+}
+
+// TBSCertificate returns the raw DER-encoded TBSCertificate of the given
+// certificate, i.e. the part that is covered by the signature.
+func TBSCertificate(cert *x509.Certificate) []byte {
+	return cert.RawTBSCertificate
+}
+
+// TBSHash returns the hash of the TBSCertificate of the given certificate,
+// as signed by the issuer. If hash is zero, the hash algorithm of the
+// certificate's signature algorithm is used.
+func TBSHash(cert *x509.Certificate, hash crypto.Hash) ([]byte, error) {
+	if hash == 0 {
+		_, name := SignatureAlgorithmDetails(cert)
+		var ok bool
+		if hash, ok = signatureHashes[name]; !ok {
+			if name == "" {
+				return nil, fmt.Errorf("signature algorithm %s has no separate hash algorithm", algString(cert.SignatureAlgorithm))
+			}
+			return nil, fmt.Errorf("unsupported hash algorithm %s", name)
+		}
+	}
+	if !hash.Available() {
+		return nil, errors.New("hash algorithm is not available")
+	}
+	h := hash.New()
+	h.Write(cert.RawTBSCertificate)
+	return h.Sum(nil), nil
+}
+
+// IsWeakSignature returns true if the given certificate is signed with an
+// outdated signature algorithm (MD2, MD5 or SHA-1 based).
+func IsWeakSignature(cert *x509.Certificate) bool {
+	for _, alg := range badSignatureAlgorithms {
+		if cert.SignatureAlgorithm == alg {
+			return true
+		}
+	}
+	return false
+}
+
 // decodeKey returns the algorithm and key size for a public key.
 func decodeKey(publicKey interface{}) (string, int) {
 	switch publicKey.(type) {
@@ -376,12 +477,8 @@ func decodeKey(publicKey interface{}) (string, int) {
 
 // certWarnings prints a list of warnings to show common mistakes in certs.
 func certWarnings(cert *x509.Certificate, uriNames []string) (warnings []string) {
-	if cert.SerialNumber.Sign() != 1 {
-		warnings = append(warnings, "Serial number in cert appears to be zero/negative")
-	}
-
-	if cert.SerialNumber.BitLen() > 160 {
-		warnings = append(warnings, "Serial number too long; should be 20 bytes or less")
+	if err := ValidateSerial(cert); err != nil {
+		warnings = append(warnings, err.Error())
 	}
 
 	if cert.KeyUsage&x509.KeyUsageCertSign != 0 && !cert.IsCA {
@@ -442,3 +539,39 @@ func algWarnings(cert *x509.Certificate) (warnings []string) {
 func IsSelfSigned(cert *x509.Certificate) bool {
 	return cert.CheckSignatureFrom(cert) == nil
 }
+
+// BasicConstraintsOf returns the basic constraints of the given certificate:
+// whether it's a CA, and its path length constraint if it has one. Note that
+// a maxPathLen of zero with hasMaxPathLen set ("pathlen:0") means that no
+// intermediate CAs may follow, while hasMaxPathLen unset means no limit.
+func BasicConstraintsOf(cert *x509.Certificate) (isCA bool, maxPathLen int, hasMaxPathLen bool) {
+	if !cert.BasicConstraintsValid {
+		return false, 0, false
+	}
+	// The x509 package uses -1 or zero (without MaxPathLenZero) for unset.
+	if cert.MaxPathLen > 0 || (cert.MaxPathLen == 0 && cert.MaxPathLenZero) {
+		return cert.IsCA, cert.MaxPathLen, true
+	}
+	return cert.IsCA, 0, false
+}
+
+// IsSelfIssued returns true iff the subject and issuer of the given
+// certificate are the same. Unlike IsSelfSigned, the signature isn't checked:
+// self-issued certificates (e.g. for CA key rollover) may be signed by a
+// different key than their own.
+func IsSelfIssued(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer)
+}
+
+// SubjectKeyIDHex returns the subject key identifier of the given certificate
+// as colon separated hex, or an empty string if the certificate has none.
+func SubjectKeyIDHex(cert *x509.Certificate) string {
+	return hexify(cert.SubjectKeyId)
+}
+
+// AuthorityKeyIDHex returns the authority key identifier of the given
+// certificate as colon separated hex, or an empty string if the certificate
+// has none. For a valid chain, it matches SubjectKeyIDHex of the issuer.
+func AuthorityKeyIDHex(cert *x509.Certificate) string {
+	return hexify(cert.AuthorityKeyId)
+}
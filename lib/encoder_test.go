@@ -0,0 +1,160 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"reflect"
+	"testing"
+)
+
+func TestSignatureAlgorithmDetails(t *testing.T) {
+	cases := []struct {
+		alg        x509.SignatureAlgorithm
+		key, hash  string
+		isWeakHash bool
+	}{
+		{x509.SHA256WithRSA, "RSA", "SHA-256", false},
+		{x509.SHA1WithRSA, "RSA", "SHA-1", true},
+		{x509.ECDSAWithSHA1, "ECDSA", "SHA-1", true},
+		{x509.ECDSAWithSHA384, "ECDSA", "SHA-384", false},
+		{x509.SHA512WithRSAPSS, "RSA-PSS", "SHA-512", false},
+		{x509.PureEd25519, "Ed25519", "", false},
+		{x509.UnknownSignatureAlgorithm, "", "", false},
+	}
+	for _, c := range cases {
+		cert := &x509.Certificate{SignatureAlgorithm: c.alg}
+		key, hash := SignatureAlgorithmDetails(cert)
+		if key != c.key || hash != c.hash {
+			t.Errorf("%v: got (%q, %q), expected (%q, %q)", c.alg, key, hash, c.key, c.hash)
+		}
+		if IsWeakSignature(cert) != c.isWeakHash {
+			t.Errorf("%v: unexpected IsWeakSignature result", c.alg)
+		}
+	}
+}
+
+func TestTBSHash(t *testing.T) {
+	cert := issueTestCert(t, "test", newTestKey(t), "test", newTestKey(t))
+	if !bytes.Equal(TBSCertificate(cert), cert.RawTBSCertificate) {
+		t.Error("unexpected TBSCertificate")
+	}
+
+	// Test certificates are signed with ECDSA and SHA-256
+	expected := sha256.Sum256(cert.RawTBSCertificate)
+	hash, err := TBSHash(cert, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hash, expected[:]) {
+		t.Errorf("unexpected hash: %x", hash)
+	}
+
+	expected384 := sha512.Sum384(cert.RawTBSCertificate)
+	hash, err = TBSHash(cert, crypto.SHA384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hash, expected384[:]) {
+		t.Errorf("unexpected SHA-384 hash: %x", hash)
+	}
+
+	if _, err := TBSHash(&x509.Certificate{SignatureAlgorithm: x509.PureEd25519}, 0); err == nil {
+		t.Error("expected error for Ed25519 signature without explicit hash")
+	}
+	if _, err := TBSHash(cert, crypto.BLAKE2b_256); err == nil {
+		t.Error("expected error for unavailable hash")
+	}
+}
+
+func TestKeyIDHex(t *testing.T) {
+	cert := &x509.Certificate{
+		SubjectKeyId:   []byte{0x01, 0xab, 0xff},
+		AuthorityKeyId: []byte{0x0a},
+	}
+	if got := SubjectKeyIDHex(cert); got != "01:AB:FF" {
+		t.Errorf("unexpected subject key ID: %q", got)
+	}
+	if got := AuthorityKeyIDHex(cert); got != "0A" {
+		t.Errorf("unexpected authority key ID: %q", got)
+	}
+	if got := SubjectKeyIDHex(&x509.Certificate{}); got != "" {
+		t.Errorf("unexpected subject key ID without extension: %q", got)
+	}
+	if got := AuthorityKeyIDHex(&x509.Certificate{}); got != "" {
+		t.Errorf("unexpected authority key ID without extension: %q", got)
+	}
+}
+
+func TestIsSelfIssued(t *testing.T) {
+	rootKey, otherKey := newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	// Same name, signed by a different key, as for a key rollover
+	rollover := issueTestCert(t, "root", rootKey, "root", otherKey)
+	leaf := issueTestCert(t, "leaf", newTestKey(t), "root", rootKey)
+
+	if !IsSelfIssued(root) || !IsSelfSigned(root) {
+		t.Error("expected root to be self-issued and self-signed")
+	}
+	if !IsSelfIssued(rollover) || IsSelfSigned(rollover) {
+		t.Error("expected rollover certificate to be self-issued, but not self-signed")
+	}
+	if IsSelfIssued(leaf) {
+		t.Error("expected leaf not to be self-issued")
+	}
+}
+
+func TestExtKeyUsageStrings(t *testing.T) {
+	cert := &x509.Certificate{
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageMicrosoftKernelCodeSigning},
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{{1, 3, 6, 1, 4, 1, 99999, 1}},
+	}
+	expected := []string{"Server Auth", "Microsoft Kernel Code Signing", "1.3.6.1.4.1.99999.1"}
+	if got := ExtKeyUsageStrings(cert); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected usages: %q", got)
+	}
+	if got := ExtKeyUsageStrings(&x509.Certificate{}); got == nil || len(got) != 0 {
+		t.Errorf("expected empty list, got %q", got)
+	}
+}
+
+func TestBasicConstraintsOf(t *testing.T) {
+	cases := []struct {
+		template      *x509.Certificate
+		isCA          bool
+		maxPathLen    int
+		hasMaxPathLen bool
+	}{
+		{&x509.Certificate{}, false, 0, false},
+		{&x509.Certificate{BasicConstraintsValid: true}, false, 0, false},
+		{&x509.Certificate{BasicConstraintsValid: true, IsCA: true, MaxPathLen: -1}, true, 0, false},
+		{&x509.Certificate{BasicConstraintsValid: true, IsCA: true, MaxPathLen: 0, MaxPathLenZero: true}, true, 0, true},
+		{&x509.Certificate{BasicConstraintsValid: true, IsCA: true, MaxPathLen: 2}, true, 2, true},
+	}
+	for i, c := range cases {
+		cert := selfSignTestCert(t, c.template)
+		isCA, maxPathLen, hasMaxPathLen := BasicConstraintsOf(cert)
+		if isCA != c.isCA || maxPathLen != c.maxPathLen || hasMaxPathLen != c.hasMaxPathLen {
+			t.Errorf("case %d: got (%v, %d, %v), expected (%v, %d, %v)", i, isCA, maxPathLen, hasMaxPathLen, c.isCA, c.maxPathLen, c.hasMaxPathLen)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	oidExtensionSubjectAltName      = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidExtensionCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+
+	// Policy qualifier types, see RFC 5280, section 4.2.1.4.
+	oidPolicyQualifierCPS        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+	oidPolicyQualifierUserNotice = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 2}
+
+	// Microsoft user principal name, used in smartcard logon certificates.
+	oidOtherNameUPN = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+
+	// Microsoft AD CS extensions: the certificate template name (v1, as
+	// used by Windows 2000), the template OID and version (v2), and the
+	// version of the CA (the index of its certificate and key).
+	oidExtensionTemplateName = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2}
+	oidExtensionTemplate     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 7}
+	oidExtensionCAVersion    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 1}
+)
+
+// ExtensionByOID looks up the extension with the given OID in the
+// certificate, and returns its raw (DER) value and whether it's critical.
+// This is useful for extensions that the x509 package doesn't parse.
+func ExtensionByOID(cert *x509.Certificate, oid asn1.ObjectIdentifier) (value []byte, critical bool, found bool) {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return ext.Value, ext.Critical, true
+		}
+	}
+	return nil, false, false
+}
+
+// ExtensionOIDs lists the OIDs of all extensions present in the
+// certificate, in the order they appear.
+func ExtensionOIDs(cert *x509.Certificate) []asn1.ObjectIdentifier {
+	oids := make([]asn1.ObjectIdentifier, len(cert.Extensions))
+	for i, ext := range cert.Extensions {
+		oids[i] = ext.Id
+	}
+	return oids
+}
+
+// OtherName is an otherName entry in a subject alternative name extension,
+// which the x509 package drops.
+type OtherName struct {
+	TypeID asn1.ObjectIdentifier
+
+	// Value is the DER encoding of the value, whose type depends on TypeID.
+	Value []byte
+}
+
+// otherName is the (implicitly tagged) OtherName from RFC 5280. The value
+// is explicitly tagged [0], which encoding/asn1 doesn't handle for
+// RawValues, so it's unwrapped by hand.
+type otherName struct {
+	TypeID asn1.ObjectIdentifier
+	Value  asn1.RawValue
+}
+
+// String renders the value if it's a string, as is the case for UPNs, and
+// otherwise as '#' followed by the hex encoding of the DER value.
+func (n OtherName) String() string {
+	var value asn1.RawValue
+	if _, err := asn1.Unmarshal(n.Value, &value); err == nil {
+		if s, ok := dnAttributeString(value); ok {
+			return s
+		}
+	}
+	return "#" + hex.EncodeToString(n.Value)
+}
+
+// OtherNameSANs extracts the otherName entries from the subject alternative
+// name extension of the given certificate.
+func OtherNameSANs(cert *x509.Certificate) ([]OtherName, error) {
+	value, _, found := ExtensionByOID(cert, oidExtensionSubjectAltName)
+	if !found {
+		return nil, nil
+	}
+
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(value, &seq); err != nil {
+		return nil, err
+	}
+	if !seq.IsCompound || seq.Tag != asn1.TagSequence || seq.Class != asn1.ClassUniversal {
+		return nil, errors.New("bad subject alternative name extension")
+	}
+
+	var names []OtherName
+	rest := seq.Bytes
+	for len(rest) > 0 {
+		var name asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &name)
+		if err != nil {
+			return nil, err
+		}
+		if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+			continue
+		}
+		parsed, err := parseOtherName(name.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, parsed)
+	}
+	return names, nil
+}
+
+// UPNs returns the Microsoft user principal names (e.g. user@example.com)
+// from otherName entries in the subject alternative name extension of the
+// given certificate.
+func UPNs(cert *x509.Certificate) ([]string, error) {
+	names, err := OtherNameSANs(cert)
+	if err != nil {
+		return nil, err
+	}
+	var upns []string
+	for _, name := range names {
+		if name.TypeID.Equal(oidOtherNameUPN) {
+			upns = append(upns, name.String())
+		}
+	}
+	return upns, nil
+}
+
+// openSSLStringTypes are the names OpenSSL's ASN1_generate_nconf uses for
+// the string types an otherName value may have.
+var openSSLStringTypes = map[int]string{
+	asn1.TagUTF8String:      "UTF8",
+	asn1.TagPrintableString: "PRINTABLESTRING",
+	asn1.TagT61String:       "T61STRING",
+	asn1.TagIA5String:       "IA5STRING",
+	26:                      "VISIBLESTRING",
+	28:                      "UNIVERSALSTRING",
+	30:                      "BMPSTRING",
+}
+
+// EncodeOpenSSLAltNames renders the subject alternative names of the given
+// certificate as an [alt_names] section for an OpenSSL config file (to be
+// referenced as "subjectAltName = @alt_names"), for requesting a renewal
+// with the same names. The otherName entries dropped by the x509 package
+// are included if their value is a string; others can't be expressed this
+// way, so are given as comments.
+func EncodeOpenSSLAltNames(cert *x509.Certificate) (string, error) {
+	others, err := OtherNameSANs(cert)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	out.WriteString("[alt_names]\n")
+	for i, name := range cert.DNSNames {
+		fmt.Fprintf(&out, "DNS.%d = %s\n", i+1, name)
+	}
+	for i, ip := range cert.IPAddresses {
+		fmt.Fprintf(&out, "IP.%d = %s\n", i+1, ip)
+	}
+	for i, email := range cert.EmailAddresses {
+		fmt.Fprintf(&out, "email.%d = %s\n", i+1, email)
+	}
+	for i, uri := range cert.URIs {
+		fmt.Fprintf(&out, "URI.%d = %s\n", i+1, uri)
+	}
+	count := 0
+	for _, name := range others {
+		var value asn1.RawValue
+		if _, err := asn1.Unmarshal(name.Value, &value); err == nil && value.Class == asn1.ClassUniversal {
+			if typ, ok := openSSLStringTypes[value.Tag]; ok {
+				if s, ok := dnAttributeString(value); ok {
+					count++
+					fmt.Fprintf(&out, "otherName.%d = %s;%s:%s\n", count, name.TypeID, typ, s)
+					continue
+				}
+			}
+		}
+		fmt.Fprintf(&out, "# otherName with type %s and non-string value %s\n", name.TypeID, name)
+	}
+	return out.String(), nil
+}
+
+// parseOtherName parses the contents of an implicitly tagged OtherName.
+func parseOtherName(content []byte) (OtherName, error) {
+	der, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: content})
+	if err != nil {
+		return OtherName{}, err
+	}
+	var parsed otherName
+	if _, err := asn1.Unmarshal(der, &parsed); err != nil {
+		return OtherName{}, err
+	}
+	if parsed.Value.Class != asn1.ClassContextSpecific || parsed.Value.Tag != 0 || !parsed.Value.IsCompound {
+		return OtherName{}, errors.New("bad otherName value")
+	}
+	return OtherName{TypeID: parsed.TypeID, Value: parsed.Value.Bytes}, nil
+}
+
+// PolicyInfo describes a policy in the certificate policies extension,
+// with its qualifiers.
+type PolicyInfo struct {
+	OID asn1.ObjectIdentifier
+
+	// CPSURIs are pointers to the certification practice statement.
+	CPSURIs []string
+
+	// UserNotices hold the text of user notice qualifiers. A notice that
+	// only references a notice in some other document is given as the name
+	// of the organization and the notice numbers.
+	UserNotices []string
+}
+
+type policyInformation struct {
+	Policy     asn1.ObjectIdentifier
+	Qualifiers []policyQualifierInfo `asn1:"optional"`
+}
+
+type policyQualifierInfo struct {
+	ID        asn1.ObjectIdentifier
+	Qualifier asn1.RawValue
+}
+
+type noticeReference struct {
+	Organization  asn1.RawValue
+	NoticeNumbers []*big.Int
+}
+
+// PolicyQualifiers parses the certificate policies extension of the given
+// certificate, which the x509 package only reduces to a list of OIDs, and
+// returns each policy with any CPS URIs and user notices.
+func PolicyQualifiers(cert *x509.Certificate) ([]PolicyInfo, error) {
+	value, _, found := ExtensionByOID(cert, oidExtensionCertificatePolicies)
+	if !found {
+		return nil, nil
+	}
+
+	var policies []policyInformation
+	if _, err := asn1.Unmarshal(value, &policies); err != nil {
+		return nil, fmt.Errorf("invalid certificate policies extension: %s", err)
+	}
+
+	out := make([]PolicyInfo, 0, len(policies))
+	for _, policy := range policies {
+		info := PolicyInfo{OID: policy.Policy}
+		for _, qualifier := range policy.Qualifiers {
+			switch {
+			case qualifier.ID.Equal(oidPolicyQualifierCPS):
+				uri, ok := dnAttributeString(qualifier.Qualifier)
+				if !ok {
+					return nil, errors.New("invalid CPS qualifier in certificate policies")
+				}
+				info.CPSURIs = append(info.CPSURIs, uri)
+			case qualifier.ID.Equal(oidPolicyQualifierUserNotice):
+				notice, err := parseUserNotice(qualifier.Qualifier)
+				if err != nil {
+					return nil, fmt.Errorf("invalid user notice in certificate policies: %s", err)
+				}
+				info.UserNotices = append(info.UserNotices, notice)
+			}
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+// parseUserNotice parses a UserNotice qualifier:
+//
+//	UserNotice ::= SEQUENCE {
+//	  noticeRef        NoticeReference OPTIONAL,
+//	  explicitText     DisplayText OPTIONAL }
+func parseUserNotice(qualifier asn1.RawValue) (string, error) {
+	if qualifier.Tag != asn1.TagSequence || qualifier.Class != asn1.ClassUniversal {
+		return "", errors.New("not a sequence")
+	}
+
+	var reference, text string
+	rest := qualifier.Bytes
+	for len(rest) > 0 {
+		var field asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &field)
+		if err != nil {
+			return "", err
+		}
+		if field.Tag == asn1.TagSequence {
+			var ref noticeReference
+			if _, err := asn1.Unmarshal(field.FullBytes, &ref); err != nil {
+				return "", err
+			}
+			organization, _ := dnAttributeString(ref.Organization)
+			numbers := make([]string, len(ref.NoticeNumbers))
+			for i, n := range ref.NoticeNumbers {
+				numbers[i] = n.String()
+			}
+			reference = fmt.Sprintf("%s, notice %s", organization, strings.Join(numbers, ", "))
+			continue
+		}
+		var ok bool
+		if text, ok = dnAttributeString(field); !ok {
+			return "", errors.New("invalid explicit text")
+		}
+	}
+
+	if text != "" {
+		return text, nil
+	}
+	return reference, nil
+}
+
+// CertificateTemplate identifies the Microsoft AD CS certificate template
+// a certificate was issued from.
+type CertificateTemplate struct {
+	// Name is set for (v1) templates identified by name, and OID and the
+	// versions for (v2) templates identified by OID.
+	Name         string
+	OID          asn1.ObjectIdentifier
+	MajorVersion int
+	MinorVersion int
+}
+
+type certificateTemplate struct {
+	ID           asn1.ObjectIdentifier
+	MajorVersion int
+	MinorVersion int `asn1:"optional"`
+}
+
+// MicrosoftTemplate returns the AD CS certificate template of the given
+// certificate, from the certificate template (v2) and/or template name
+// (v1) extensions. Returns nil if there are neither.
+func MicrosoftTemplate(cert *x509.Certificate) (*CertificateTemplate, error) {
+	var template *CertificateTemplate
+	if value, _, found := ExtensionByOID(cert, oidExtensionTemplate); found {
+		var parsed certificateTemplate
+		if _, err := asn1.Unmarshal(value, &parsed); err != nil {
+			return nil, fmt.Errorf("invalid certificate template extension: %s", err)
+		}
+		template = &CertificateTemplate{
+			OID:          parsed.ID,
+			MajorVersion: parsed.MajorVersion,
+			MinorVersion: parsed.MinorVersion,
+		}
+	}
+	if value, _, found := ExtensionByOID(cert, oidExtensionTemplateName); found {
+		var raw asn1.RawValue
+		if _, err := asn1.Unmarshal(value, &raw); err != nil {
+			return nil, fmt.Errorf("invalid certificate template name extension: %s", err)
+		}
+		name, ok := dnAttributeString(raw)
+		if !ok {
+			return nil, errors.New("invalid certificate template name extension")
+		}
+		if template == nil {
+			template = &CertificateTemplate{}
+		}
+		template.Name = name
+	}
+	return template, nil
+}
+
+// String returns the template name, or its OID and version.
+func (t CertificateTemplate) String() string {
+	if t.OID == nil {
+		return t.Name
+	}
+	version := fmt.Sprintf("%s (version %d.%d)", t.OID, t.MajorVersion, t.MinorVersion)
+	if t.Name != "" {
+		return t.Name + ", " + version
+	}
+	return version
+}
+
+// CAVersion is the version of a Microsoft CA, which counts how often its
+// certificate and its key were renewed.
+type CAVersion struct {
+	CertIndex int
+	KeyIndex  int
+}
+
+// MicrosoftCAVersion returns the version of the CA from the CA version
+// extension in CA certificates issued by Microsoft AD CS. Returns nil if
+// the extension isn't present.
+func MicrosoftCAVersion(cert *x509.Certificate) (*CAVersion, error) {
+	value, _, found := ExtensionByOID(cert, oidExtensionCAVersion)
+	if !found {
+		return nil, nil
+	}
+	var version int
+	if _, err := asn1.Unmarshal(value, &version); err != nil {
+		return nil, fmt.Errorf("invalid CA version extension: %s", err)
+	}
+	// The key index is in the high 16 bits, the certificate index in the low
+	return &CAVersion{CertIndex: version & 0xffff, KeyIndex: version >> 16 & 0xffff}, nil
+}
+
+// String formats the version like Windows does, e.g. "V1.0".
+func (v CAVersion) String() string {
+	return fmt.Sprintf("V%d.%d", v.CertIndex, v.KeyIndex)
+}
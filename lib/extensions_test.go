@@ -0,0 +1,298 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// selfSignTestCert creates a self-signed certificate from the given template,
+// filling in the serial number and validity if unset.
+func selfSignTestCert(t *testing.T, template *x509.Certificate) *x509.Certificate {
+	key := newTestKey(t)
+	if template.SerialNumber == nil {
+		template.SerialNumber = big.NewInt(1)
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestExtensionByOID(t *testing.T) {
+	custom := pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}, Critical: true, Value: []byte{0x05, 0x00}}
+	cert := selfSignTestCert(t, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "example.com"},
+		DNSNames:        []string{"example.com"},
+		ExtraExtensions: []pkix.Extension{custom},
+	})
+
+	value, critical, found := ExtensionByOID(cert, custom.Id)
+	if !found || !critical || !reflect.DeepEqual(value, custom.Value) {
+		t.Errorf("unexpected custom extension: %x, %v, %v", value, critical, found)
+	}
+	if _, critical, found := ExtensionByOID(cert, oidExtensionSubjectAltName); !found || critical {
+		t.Errorf("unexpected SAN extension: %v, %v", critical, found)
+	}
+	if _, _, found := ExtensionByOID(cert, asn1.ObjectIdentifier{1, 2, 3}); found {
+		t.Error("unexpected extension found")
+	}
+
+	oids := ExtensionOIDs(cert)
+	if len(oids) != len(cert.Extensions) || !oids[len(oids)-1].Equal(custom.Id) {
+		t.Errorf("unexpected extension OIDs: %v", oids)
+	}
+	if oids := ExtensionOIDs(&x509.Certificate{}); len(oids) != 0 {
+		t.Errorf("unexpected extension OIDs: %v", oids)
+	}
+}
+
+func TestOtherNameSANs(t *testing.T) {
+	value, err := asn1.MarshalWithParams("jdoe@example.com", "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upn, err := asn1.Marshal(otherName{
+		TypeID: oidOtherNameUPN,
+		Value:  asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upnName asn1.RawValue
+	if _, err := asn1.Unmarshal(upn, &upnName); err != nil {
+		t.Fatal(err)
+	}
+	san, err := asn1.Marshal([]asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("example.com")},
+		{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: upnName.Bytes},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := selfSignTestCert(t, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "smartcard"},
+		ExtraExtensions: []pkix.Extension{{Id: oidExtensionSubjectAltName, Value: san}},
+	})
+	if !reflect.DeepEqual(cert.DNSNames, []string{"example.com"}) {
+		t.Fatalf("unexpected DNS names: %v", cert.DNSNames)
+	}
+
+	names, err := OtherNameSANs(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || !names[0].TypeID.Equal(oidOtherNameUPN) || names[0].String() != "jdoe@example.com" {
+		t.Fatalf("unexpected other names: %v", names)
+	}
+
+	upns, err := UPNs(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(upns, []string{"jdoe@example.com"}) {
+		t.Errorf("unexpected UPNs: %v", upns)
+	}
+
+	rendered, err := parseGeneralNames(san)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rendered, []string{"DNS:example.com", "othername:UPN:jdoe@example.com"}) {
+		t.Errorf("unexpected general names: %v", rendered)
+	}
+}
+
+func TestEncodeOpenSSLAltNames(t *testing.T) {
+	upnValue, err := asn1.MarshalWithParams("jdoe@example.com", "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	intValue, err := asn1.Marshal(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var otherNames []asn1.RawValue
+	for _, other := range []otherName{
+		{TypeID: oidOtherNameUPN, Value: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: upnValue}},
+		{TypeID: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: intValue}},
+	} {
+		der, err := asn1.Marshal(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var name asn1.RawValue
+		if _, err := asn1.Unmarshal(der, &name); err != nil {
+			t.Fatal(err)
+		}
+		otherNames = append(otherNames, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: name.Bytes})
+	}
+	san, err := asn1.Marshal([]asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("example.com")},
+		{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("www.example.com")},
+		{Class: asn1.ClassContextSpecific, Tag: 7, Bytes: []byte{192, 0, 2, 1}},
+		{Class: asn1.ClassContextSpecific, Tag: 1, Bytes: []byte("jdoe@example.com")},
+		{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte("https://example.com/")},
+		otherNames[0],
+		otherNames[1],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := selfSignTestCert(t, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "example.com"},
+		ExtraExtensions: []pkix.Extension{{Id: oidExtensionSubjectAltName, Value: san}},
+	})
+
+	encoded, err := EncodeOpenSSLAltNames(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[alt_names]
+DNS.1 = example.com
+DNS.2 = www.example.com
+IP.1 = 192.0.2.1
+email.1 = jdoe@example.com
+URI.1 = https://example.com/
+otherName.1 = 1.3.6.1.4.1.311.20.2.3;UTF8:jdoe@example.com
+# otherName with type 1.2.3.4 and non-string value #02012a
+`
+	if encoded != expected {
+		t.Errorf("unexpected section:\n%s\nwant:\n%s", encoded, expected)
+	}
+}
+
+func TestPolicyQualifiers(t *testing.T) {
+	cps, err := asn1.MarshalWithParams("https://example.com/cps", "ia5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notice, err := asn1.Marshal(struct {
+		Text string `asn1:"utf8"`
+	}{"For testing only"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	referenceOnly, err := asn1.Marshal(struct {
+		Ref noticeReference
+	}{noticeReference{
+		Organization:  asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte("Example CA")},
+		NoticeNumbers: []*big.Int{big.NewInt(1), big.NewInt(2)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies := []policyInformation{
+		{
+			Policy: asn1.ObjectIdentifier{2, 23, 140, 1, 2, 1},
+		},
+		{
+			Policy: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1},
+			Qualifiers: []policyQualifierInfo{
+				{ID: oidPolicyQualifierCPS, Qualifier: asn1.RawValue{FullBytes: cps}},
+				{ID: oidPolicyQualifierUserNotice, Qualifier: asn1.RawValue{FullBytes: notice}},
+				{ID: oidPolicyQualifierUserNotice, Qualifier: asn1.RawValue{FullBytes: referenceOnly}},
+			},
+		},
+	}
+	value, err := asn1.Marshal(policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := selfSignTestCert(t, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "policies"},
+		ExtraExtensions: []pkix.Extension{{Id: oidExtensionCertificatePolicies, Value: value}},
+	})
+
+	infos, err := PolicyQualifiers(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PolicyInfo{
+		{OID: asn1.ObjectIdentifier{2, 23, 140, 1, 2, 1}},
+		{
+			OID:         asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1},
+			CPSURIs:     []string{"https://example.com/cps"},
+			UserNotices: []string{"For testing only", "Example CA, notice 1, 2"},
+		},
+	}
+	if !reflect.DeepEqual(infos, expected) {
+		t.Errorf("unexpected policies: %+v", infos)
+	}
+}
+
+func TestMicrosoftExtensions(t *testing.T) {
+	var name []byte
+	for _, c := range "WebServer" {
+		name = append(name, 0, byte(c))
+	}
+	templateOID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 8, 1, 2, 3}
+	cert := selfSignTestCert(t, &x509.Certificate{
+		ExtraExtensions: []pkix.Extension{
+			marshalExtension(t, oidExtensionTemplateName, asn1.RawValue{Tag: 30, Bytes: name}),
+			marshalExtension(t, oidExtensionTemplate, certificateTemplate{ID: templateOID, MajorVersion: 100, MinorVersion: 4}),
+			marshalExtension(t, oidExtensionCAVersion, 1<<16|2),
+		},
+	})
+
+	template, err := MicrosoftTemplate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &CertificateTemplate{Name: "WebServer", OID: templateOID, MajorVersion: 100, MinorVersion: 4}
+	if !reflect.DeepEqual(template, expected) {
+		t.Errorf("unexpected template: %+v", template)
+	}
+	if template.String() != "WebServer, 1.3.6.1.4.1.311.21.8.1.2.3 (version 100.4)" {
+		t.Errorf("unexpected template string: %s", template)
+	}
+
+	version, err := MicrosoftCAVersion(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version == nil || version.String() != "V2.1" {
+		t.Errorf("unexpected CA version: %v", version)
+	}
+
+	plain := selfSignTestCert(t, &x509.Certificate{})
+	if template, err := MicrosoftTemplate(plain); template != nil || err != nil {
+		t.Errorf("unexpected template for plain certificate: %v, %v", template, err)
+	}
+	if version, err := MicrosoftCAVersion(plain); version != nil || err != nil {
+		t.Errorf("unexpected CA version for plain certificate: %v, %v", version, err)
+	}
+}
@@ -0,0 +1,48 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// FilterExpired returns a filter (see ReadOptions.Filter) that selects
+// certificates that have expired at the given time.
+func FilterExpired(at time.Time) func(*x509.Certificate) bool {
+	return func(cert *x509.Certificate) bool {
+		return at.After(cert.NotAfter)
+	}
+}
+
+// FilterValid returns a filter (see ReadOptions.Filter) that selects
+// certificates that are within their validity period at the given time.
+func FilterValid(at time.Time) func(*x509.Certificate) bool {
+	return func(cert *x509.Certificate) bool {
+		return !at.Before(cert.NotBefore) && !at.After(cert.NotAfter)
+	}
+}
+
+// FilterExpiringWithin returns a filter (see ReadOptions.Filter) that
+// selects certificates that are valid at the given time, but expire within
+// the given duration after it.
+func FilterExpiringWithin(at time.Time, d time.Duration) func(*x509.Certificate) bool {
+	valid := FilterValid(at)
+	return func(cert *x509.Certificate) bool {
+		return valid(cert) && cert.NotAfter.Before(at.Add(d))
+	}
+}
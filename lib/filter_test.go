@@ -0,0 +1,80 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadFilter(t *testing.T) {
+	key := newTestKey(t)
+	var bundle bytes.Buffer
+	for _, name := range []string{"one", "two"} {
+		pem.Encode(&bundle, EncodeX509ToPEM(issueTestCert(t, name, key, name, key), nil))
+	}
+	keyBlock, err := keyToPem(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem.Encode(&bundle, keyBlock)
+
+	// Test certificates are valid from an hour ago until an hour from now
+	now := time.Now()
+	testCases := []struct {
+		filter   func(*x509.Certificate) bool
+		expected int
+	}{
+		{FilterValid(now), 2},
+		{FilterExpired(now), 0},
+		{FilterExpired(now.Add(2 * time.Hour)), 2},
+		{FilterValid(now.Add(-2 * time.Hour)), 0},
+		{FilterExpiringWithin(now, 30*time.Minute), 0},
+		{FilterExpiringWithin(now, 2*time.Hour), 2},
+		{func(cert *x509.Certificate) bool { return cert.Subject.CommonName == "two" }, 1},
+	}
+	for i, tc := range testCases {
+		certs := 0
+		err := ReadX509WithOptions([]io.Reader{bytes.NewReader(bundle.Bytes())}, ReadOptions{Filter: tc.filter}, func(cert *x509.Certificate, format string, err error) error {
+			certs++
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if certs != tc.expected {
+			t.Errorf("case %d: expected %d certificates, got %d", i, tc.expected, certs)
+		}
+
+		var types []string
+		err = ReadPEMWithOptions([]io.Reader{bytes.NewReader(bundle.Bytes())}, ReadOptions{Filter: tc.filter}, func(block *pem.Block, format string) error {
+			types = append(types, block.Type)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(types) != tc.expected+1 || !strings.HasSuffix(types[len(types)-1], "PRIVATE KEY") {
+			t.Errorf("case %d: unexpected blocks: %v", i, types)
+		}
+	}
+}
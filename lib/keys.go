@@ -0,0 +1,105 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+)
+
+// KeyMatchesCert checks whether the given private key belongs to the given
+// certificate, by comparing the public keys. Supports RSA, ECDSA, Ed25519
+// and DSA keys.
+func KeyMatchesCert(key crypto.PrivateKey, cert *x509.Certificate) (bool, error) {
+	var public crypto.PublicKey
+	switch k := key.(type) {
+	case *dsa.PrivateKey:
+		// Doesn't implement crypto.Signer
+		public = &k.PublicKey
+	case crypto.Signer:
+		public = k.Public()
+	default:
+		return false, fmt.Errorf("unknown key type: %s", reflect.TypeOf(key))
+	}
+	return publicKeysEqual(public, cert.PublicKey)
+}
+
+// PublicKeyEqual checks whether two public keys are the same key, comparing
+// the key material by type rather than the in-memory representation. Keys
+// of different or unknown types are never equal. Supports RSA, ECDSA,
+// Ed25519 and DSA keys.
+func PublicKeyEqual(a, b crypto.PublicKey) bool {
+	equal, err := publicKeysEqual(a, b)
+	return err == nil && equal
+}
+
+// publicKeysEqual compares two public keys. Keys of different types are
+// never equal, and an error is returned for unsupported key types.
+func publicKeysEqual(a, b crypto.PublicKey) (bool, error) {
+	switch a := a.(type) {
+	case *rsa.PublicKey:
+		b, ok := b.(*rsa.PublicKey)
+		return ok && a.E == b.E && a.N.Cmp(b.N) == 0, nil
+	case *ecdsa.PublicKey:
+		b, ok := b.(*ecdsa.PublicKey)
+		return ok && a.Curve.Params().Name == b.Curve.Params().Name &&
+			a.X.Cmp(b.X) == 0 && a.Y.Cmp(b.Y) == 0, nil
+	case ed25519.PublicKey:
+		b, ok := b.(ed25519.PublicKey)
+		return ok && bytes.Equal(a, b), nil
+	case *dsa.PublicKey:
+		b, ok := b.(*dsa.PublicKey)
+		return ok && a.Y.Cmp(b.Y) == 0 && a.P.Cmp(b.P) == 0 &&
+			a.Q.Cmp(b.Q) == 0 && a.G.Cmp(b.G) == 0, nil
+	}
+	return false, fmt.Errorf("unknown key type: %s", reflect.TypeOf(a))
+}
+
+// EncodePublicKeyToPEM encodes a public key as a "PUBLIC KEY" PEM block
+// (a PKIX SubjectPublicKeyInfo, as "openssl pkey -pubout" writes). Supports
+// RSA, ECDSA and Ed25519 keys.
+func EncodePublicKeyToPEM(pub crypto.PublicKey) (*pem.Block, error) {
+	raw, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal public key: %s", err)
+	}
+	return &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: raw,
+	}, nil
+}
+
+// EncodeCertPublicKeyToPEM encodes the public key of the given certificate
+// as a "PUBLIC KEY" PEM block. The encoding from the certificate is used
+// as-is, so this works for any type of key.
+func EncodeCertPublicKeyToPEM(cert *x509.Certificate) (*pem.Block, error) {
+	if len(cert.RawSubjectPublicKeyInfo) == 0 {
+		return nil, fmt.Errorf("certificate has no subject public key info")
+	}
+	return &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: cert.RawSubjectPublicKeyInfo,
+	}, nil
+}
@@ -0,0 +1,141 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+)
+
+func TestKeyMatchesCert(t *testing.T) {
+	key, otherKey := newTestKey(t), newTestKey(t)
+	cert := issueTestCert(t, "test", key, "test", key)
+
+	match, err := KeyMatchesCert(key, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match {
+		t.Error("key should match its certificate")
+	}
+
+	match, err = KeyMatchesCert(otherKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match {
+		t.Error("other key should not match certificate")
+	}
+
+	if _, err := KeyMatchesCert("not a key", cert); err == nil {
+		t.Error("expected error for unknown key type")
+	}
+}
+
+func TestEncodePublicKeyToPEM(t *testing.T) {
+	ecKey := newTestKey(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pub := range []interface{}{&ecKey.PublicKey, &rsaKey.PublicKey, edPublic} {
+		block, err := EncodePublicKeyToPEM(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Type != "PUBLIC KEY" {
+			t.Errorf("unexpected block type: %s", block.Type)
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if equal, err := publicKeysEqual(parsed, pub); err != nil || !equal {
+			t.Errorf("public key differs after round trip (%T)", pub)
+		}
+	}
+
+	if _, err := EncodePublicKeyToPEM("not a key"); err == nil {
+		t.Error("expected error for unknown key type")
+	}
+
+	cert := issueTestCert(t, "test", ecKey, "test", ecKey)
+	block, err := EncodeCertPublicKeyToPEM(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := EncodePublicKeyToPEM(&ecKey.PublicKey)
+	if !bytes.Equal(block.Bytes, expected.Bytes) {
+		t.Error("certificate public key differs from key")
+	}
+}
+
+func TestPublicKeyEqual(t *testing.T) {
+	ecKey, otherECKey := newTestKey(t), newTestKey(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same keys, parsed from other encodings
+	pkcs1, err := x509.ParsePKCS1PublicKey(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := issueTestCert(t, "test", ecKey, "test", ecKey)
+	ecBlock, err := EncodePublicKeyToPEM(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkixKey, err := x509.ParsePKIXPublicKey(ecBlock.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edCopy := ed25519.PublicKey(append([]byte{}, edPublic...))
+
+	testCases := []struct {
+		a, b     interface{}
+		expected bool
+	}{
+		{&rsaKey.PublicKey, pkcs1, true},
+		{&ecKey.PublicKey, cert.PublicKey, true},
+		{pkixKey, cert.PublicKey, true},
+		{edPublic, edCopy, true},
+		{&ecKey.PublicKey, &otherECKey.PublicKey, false},
+		{&ecKey.PublicKey, &rsaKey.PublicKey, false},
+		{edPublic, &rsaKey.PublicKey, false},
+		{"not a key", "not a key", false},
+	}
+	for i, tc := range testCases {
+		if PublicKeyEqual(tc.a, tc.b) != tc.expected {
+			t.Errorf("case %d: expected %t for %T and %T", i, tc.expected, tc.a, tc.b)
+		}
+	}
+}
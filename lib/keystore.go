@@ -0,0 +1,172 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"io"
+
+	"github.com/square/certigo/jceks"
+)
+
+// KeyStore gives access to the entries of a JCEKS/JKS or BKS key store by
+// alias, see OpenKeyStore.
+type KeyStore struct {
+	format string
+	store  *jceks.KeyStore
+	opts   ReadOptions
+}
+
+// OpenKeyStore loads a JCEKS/JKS or BKS key store, so that entries can be
+// looked up by alias when needed, rather than all being read through a
+// callback. The format is detected unless set in the options. The Password
+// option is called for the store password (the usual defaults are tried if
+// it gives none, or first with PasswordPrompts) and for the passwords of
+// keys as they are looked up. The input is read from the start again for
+// each store password tried, rather than being buffered. PKCS12 files are
+// not supported, since they can only be decrypted as a whole; see
+// ReadPKCS12CertsDER instead.
+func OpenKeyStore(input io.ReaderAt, size int64, opts ReadOptions) (*KeyStore, error) {
+	format, err := formatForFile(bufio.NewReaderSize(io.NewSectionReader(input, 0, size), sniffLength), "", opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	var load func(io.Reader, []byte) (*jceks.KeyStore, error)
+	switch format {
+	case "JCEKS":
+		load = jceks.LoadFromReader
+	case "BKS":
+		load = jceks.LoadBKSFromReader
+	default:
+		return nil, fmt.Errorf("unsupported key store format '%s'", format)
+	}
+	store, err := loadKeyStoreAt(input, size, opts, load)
+	if pwErr, ok := err.(passwordError); ok {
+		return nil, pwErr.err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse keystore: %s", err)
+	}
+	return &KeyStore{format: format, store: store, opts: opts}, nil
+}
+
+// Format returns the format of the key store (JCEKS or BKS).
+func (ks *KeyStore) Format() string {
+	return ks.format
+}
+
+// Entries lists the entries of the key store, ordered by alias.
+func (ks *KeyStore) Entries() []jceks.EntryInfo {
+	return ks.store.ListEntries()
+}
+
+// GetCert returns the certificate of the entry with the given alias: the
+// certificate of a trusted cert entry, or the first certificate of the
+// chain of a private key entry.
+func (ks *KeyStore) GetCert(alias string) (*x509.Certificate, error) {
+	for _, entry := range ks.store.ListEntries() {
+		if entry.Alias != alias {
+			continue
+		}
+		if len(entry.Certs) == 0 {
+			return nil, fmt.Errorf("entry '%s' has no certificate", alias)
+		}
+		return entry.Certs[0], nil
+	}
+	return nil, fmt.Errorf("no entry '%s' in key store", alias)
+}
+
+// GetPrivateKeyAndCerts decrypts the private key entry with the given
+// alias, using the password given for it by the Password option, and
+// returns the key along with its certificate chain.
+func (ks *KeyStore) GetPrivateKeyAndCerts(alias string) (crypto.PrivateKey, []*x509.Certificate, error) {
+	password, err := ks.opts.password(alias)
+	if err != nil {
+		return nil, nil, err.(passwordError).err
+	}
+	key, certs, err := ks.store.GetPrivateKeyAndCerts(alias, []byte(password))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read key '%s': %s", alias, err)
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("no private key entry '%s' in key store", alias)
+	}
+	return key, certs, nil
+}
+
+// GetCertDER returns the certificate of the entry with the given alias (see
+// GetCert), DER-encoded exactly as it is stored in the key store.
+func (ks *KeyStore) GetCertDER(alias string) ([]byte, error) {
+	cert, err := ks.GetCert(alias)
+	if err != nil {
+		return nil, err
+	}
+	return cert.Raw, nil
+}
+
+// GetPrivateKeyDER decrypts the private key entry with the given alias,
+// like GetPrivateKeyAndCerts, and returns the key as it is stored in the
+// key store (a DER-encoded PKCS#8 PrivateKeyInfo), rather than parsing it.
+// This allows keys to be imported elsewhere verbatim, which re-encoding
+// them (e.g. as PKCS#1, see ReadAsPEM) doesn't.
+func (ks *KeyStore) GetPrivateKeyDER(alias string) ([]byte, error) {
+	password, err := ks.opts.password(alias)
+	if err != nil {
+		return nil, err.(passwordError).err
+	}
+	der, err := ks.store.GetPrivateKeyDER(alias, []byte(password))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key '%s': %s", alias, err)
+	}
+	if der == nil {
+		return nil, fmt.Errorf("no private key entry '%s' in key store", alias)
+	}
+	return der, nil
+}
+
+// ReadPKCS12CertsDER returns the certificates of the entry with the given
+// alias (friendlyName) in a PKCS12 file, DER-encoded exactly as they are
+// stored: the certificate of the entry first, followed by the rest of its
+// chain, grouped as with the GroupByAlias option. The Password option is
+// called for the password, if an empty one doesn't work. Keys can't be
+// exported verbatim: the pkcs12 package only gives out keys it has already
+// re-encoded (as PKCS#1 or SEC 1), so their stored PKCS#8 encoding is lost.
+// Use ReadPEMWithOptions to read them re-encoded.
+func ReadPKCS12CertsDER(data []byte, alias string, opts ReadOptions) ([][]byte, error) {
+	blocks, err := decodePKCS12(data, opts)
+	if pwErr, ok := err.(passwordError); ok {
+		return nil, pwErr.err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var certs [][]byte
+	for _, block := range groupBlocksByAlias(blocks) {
+		if block.Type == "CERTIFICATE" && ownHeader(block.Headers, nameHeader) == alias {
+			certs = append(certs, block.Bytes)
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates for entry '%s' in key store", alias)
+	}
+	return certs, nil
+}
@@ -0,0 +1,164 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenKeyStore(t *testing.T) {
+	file, err := os.Open("../jceks/testdata/private-key.jceks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ReadOptions{
+		Password: PasswordFromMap(map[string]string{
+			"":                       "private-key-store-password",
+			"private-key-some-alias": "private-key-key-password",
+		}, ""),
+	}
+	ks, err := OpenKeyStore(file, info.Size(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks.Format() != "JCEKS" {
+		t.Errorf("unexpected format: %s", ks.Format())
+	}
+	entries := ks.Entries()
+	if len(entries) != 1 || entries[0].Alias != "private-key-some-alias" {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	key, certs, err := ks.GetPrivateKeyAndCerts("private-key-some-alias")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) == 0 {
+		t.Fatal("no certificates for key")
+	}
+	if ok, err := KeyMatchesCert(key, certs[0]); err != nil || !ok {
+		t.Errorf("key doesn't match certificate: %v", err)
+	}
+	cert, err := ks.GetCert("private-key-some-alias")
+	if err != nil || !cert.Equal(certs[0]) {
+		t.Errorf("unexpected certificate: %v", err)
+	}
+
+	certDER, err := ks.GetCertDER("private-key-some-alias")
+	if err != nil || !bytes.Equal(certDER, certs[0].Raw) {
+		t.Errorf("unexpected certificate DER: %v", err)
+	}
+	keyDER, err := ks.GetPrivateKeyDER("private-key-some-alias")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := x509.ParsePKCS8PrivateKey(keyDER); err != nil || !reflect.DeepEqual(parsed, key) {
+		t.Errorf("unexpected key DER: %v", err)
+	}
+
+	if _, _, err := ks.GetPrivateKeyAndCerts("missing"); err == nil || !strings.Contains(err.Error(), "no private key entry") {
+		t.Errorf("expected error for missing alias, got: %v", err)
+	}
+	if _, err := ks.GetCert("missing"); err == nil {
+		t.Error("expected error for missing alias")
+	}
+	if _, err := ks.GetPrivateKeyDER("missing"); err == nil || !strings.Contains(err.Error(), "no private key entry") {
+		t.Errorf("expected error for missing alias, got: %v", err)
+	}
+}
+
+func TestOpenKeyStoreTrustStore(t *testing.T) {
+	key := newTestKey(t)
+	root := issueTestCert(t, "root", key, "root", key)
+	store := buildTrustStoreWithAliases(t, map[string]*x509.Certificate{"root": root}, "changeit")
+
+	ks, err := OpenKeyStore(bytes.NewReader(store), int64(len(store)), ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ks.GetCert("root")
+	if err != nil || !cert.Equal(root) {
+		t.Errorf("unexpected certificate: %v", err)
+	}
+	if _, _, err := ks.GetPrivateKeyAndCerts("root"); err == nil {
+		t.Error("expected error for a trusted cert entry")
+	}
+
+	// An explicit password is used instead of the defaults
+	store = buildTrustStoreWithAliases(t, map[string]*x509.Certificate{"root": root}, "secret")
+	opts := ReadOptions{Password: PasswordFromMap(map[string]string{"": "secret"}, "")}
+	if _, err := OpenKeyStore(bytes.NewReader(store), int64(len(store)), opts); err != nil {
+		t.Errorf("unexpected error with explicit password: %v", err)
+	}
+	store = buildTrustStoreWithAliases(t, map[string]*x509.Certificate{"root": root}, "changeit")
+	opts = ReadOptions{Password: PasswordFromMap(map[string]string{"": "wrong"}, "")}
+	if _, err := OpenKeyStore(bytes.NewReader(store), int64(len(store)), opts); err == nil {
+		t.Error("expected error for wrong explicit password, even though the default works")
+	}
+
+	pemData := []byte(readTestFile(t, "../test-certs/example-leaf.crt"))
+	if _, err := OpenKeyStore(bytes.NewReader(pemData), int64(len(pemData)), ReadOptions{}); err == nil || !strings.Contains(err.Error(), "unsupported key store format") {
+		t.Errorf("expected error for PEM input, got: %v", err)
+	}
+}
+
+func TestReadPKCS12CertsDER(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/password.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := ReadOptions{Password: PasswordFromMap(nil, "password")}
+
+	var stored [][]byte
+	err = ReadX509WithOptions([]io.Reader{bytes.NewReader(data)}, opts, func(cert *x509.Certificate, format string, err error) error {
+		if err == nil {
+			stored = append(stored, cert.Raw)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := ReadPKCS12CertsDER(data, "key", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(certs, stored) {
+		t.Errorf("certificates differ from those stored: %d vs %d", len(certs), len(stored))
+	}
+
+	if _, err := ReadPKCS12CertsDER(data, "missing", opts); err == nil || !strings.Contains(err.Error(), "no certificates for entry") {
+		t.Errorf("expected error for missing alias, got: %v", err)
+	}
+	if _, err := ReadPKCS12CertsDER(data, "key", ReadOptions{}); err == nil {
+		t.Error("expected error without a password")
+	}
+}
@@ -0,0 +1,156 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// kubeconfigDataKeys are the kubeconfig fields holding base64-encoded
+// certificates and keys.
+var kubeconfigDataKeys = map[string]bool{
+	"certificate-authority-data": true,
+	"client-certificate-data":    true,
+	"client-key-data":            true,
+}
+
+var errNotKubeconfig = errors.New("input doesn't look like a kubeconfig file with embedded certificates")
+
+// kubeconfigEntry is an entry in the clusters or users list of a kubeconfig.
+type kubeconfigEntry struct {
+	section string
+	name    string
+	fields  []kubeconfigField
+}
+
+type kubeconfigField struct {
+	key   string
+	value string
+}
+
+// readKubeconfig reads the certificates (and client keys) embedded in a
+// kubeconfig file, passing them to the callback with the cluster or user
+// they belong to in the friendlyName header (e.g. "cluster:prod").
+//
+// Kubeconfig files are YAML, but written by kubectl in a regular enough way
+// that a line-based reader suffices to find the *-data fields and the name
+// of the list entry they are in.
+func readKubeconfig(reader io.Reader, opts ReadOptions, callback func(*pem.Block, string) error) error {
+	entries, err := parseKubeconfig(reader)
+	if err != nil {
+		return fmt.Errorf("unable to read kubeconfig: %s", err)
+	}
+	if len(entries) == 0 {
+		return errNotKubeconfig
+	}
+
+	for _, entry := range entries {
+		headers := map[string]string{nameHeader: entry.section + ":" + entry.name}
+		for _, field := range entry.fields {
+			data, err := base64.StdEncoding.DecodeString(field.value)
+			if err != nil {
+				return fmt.Errorf("invalid base64 in %s of %s %s: %s", field.key, entry.section, entry.name, err)
+			}
+			format := "DER"
+			if bytes.Contains(data, pemStart) {
+				format = "PEM"
+			}
+			err = readCertsFromStream(bytes.NewReader(data), "", format, opts, func(block *pem.Block, _ string) error {
+				block.Headers = mergeHeaders(block.Headers, headers)
+				return callback(block, "KUBECONFIG")
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseKubeconfig finds the entries of the clusters and users lists that
+// have certificate or key data.
+func parseKubeconfig(reader io.Reader) ([]kubeconfigEntry, error) {
+	var entries []kubeconfigEntry
+	var section string
+	var current *kubeconfigEntry
+	itemIndent := -1
+
+	flush := func() {
+		if current != nil && len(current.fields) > 0 {
+			entries = append(entries, *current)
+		}
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || strings.HasPrefix(content, "#") {
+			continue
+		}
+		indent := len(line) - len(content)
+
+		if indent == 0 && !strings.HasPrefix(content, "-") {
+			// Top-level key, such as "clusters:" or "users:"
+			flush()
+			section = strings.TrimSuffix(content, ":")
+			itemIndent = -1
+			continue
+		}
+		if section != "clusters" && section != "users" {
+			continue
+		}
+
+		if strings.HasPrefix(content, "- ") && (itemIndent < 0 || indent <= itemIndent) {
+			flush()
+			current = &kubeconfigEntry{section: strings.TrimSuffix(section, "s")}
+			itemIndent = indent
+			content = content[2:]
+			indent += 2
+		}
+		if current == nil {
+			continue
+		}
+
+		colon := strings.Index(content, ":")
+		if colon < 0 {
+			continue
+		}
+		key := content[:colon]
+		value := strings.Trim(strings.TrimSpace(content[colon+1:]), `"'`)
+		switch {
+		case key == "name" && indent == itemIndent+2:
+			current.name = value
+		case kubeconfigDataKeys[key]:
+			current.fields = append(current.fields, kubeconfigField{key, value})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return entries, nil
+}
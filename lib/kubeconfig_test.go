@@ -0,0 +1,106 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadKubeconfig(t *testing.T) {
+	key := newTestKey(t)
+	ca := issueTestCert(t, "Kubernetes CA", key, "Kubernetes CA", key)
+	client := issueTestCert(t, "admin", key, "Kubernetes CA", key)
+	keyBlock, err := keyToPem(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodePEM := func(block *pem.Block) string {
+		return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(block))
+	}
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: %s
+    server: https://127.0.0.1:6443
+  name: prod
+- cluster:
+    insecure-skip-tls-verify: true
+    server: https://127.0.0.1:7443
+  name: dev
+contexts:
+- context:
+    cluster: prod
+    user: admin
+  name: admin@prod
+current-context: admin@prod
+kind: Config
+preferences: {}
+users:
+- name: admin
+  user:
+    client-certificate-data: "%s"
+    client-key-data: %s
+`, encodePEM(EncodeX509ToPEM(ca, nil)), base64.StdEncoding.EncodeToString(client.Raw), encodePEM(keyBlock))
+
+	var names, types []string
+	var certs []*x509.Certificate
+	err = ReadAsPEM([]io.Reader{strings.NewReader(kubeconfig)}, "", nil, func(block *pem.Block, format string) error {
+		if format != "KUBECONFIG" {
+			t.Errorf("unexpected format: %s", format)
+		}
+		names = append(names, block.Headers[nameHeader])
+		types = append(types, block.Type)
+		if block.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedNames := []string{"cluster:prod", "user:admin", "user:admin"}
+	expectedTypes := []string{"CERTIFICATE", "CERTIFICATE", keyBlock.Type}
+	if strings.Join(names, ",") != strings.Join(expectedNames, ",") || strings.Join(types, ",") != strings.Join(expectedTypes, ",") {
+		t.Fatalf("unexpected blocks: %v %v", names, types)
+	}
+	if !bytes.Equal(certs[0].Raw, ca.Raw) || !bytes.Equal(certs[1].Raw, client.Raw) {
+		t.Error("unexpected certificates read from kubeconfig")
+	}
+}
+
+func TestReadKubeconfigWithoutCerts(t *testing.T) {
+	kubeconfig := "apiVersion: v1\nclusters:\n- cluster:\n    server: https://127.0.0.1:6443\n  name: dev\n"
+	err := ReadAsPEM([]io.Reader{strings.NewReader(kubeconfig)}, "", nil, func(block *pem.Block, format string) error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), errNotKubeconfig.Error()) {
+		t.Errorf("expected error for kubeconfig without certificates, got: %v", err)
+	}
+}
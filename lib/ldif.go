@@ -0,0 +1,166 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ldifCertAttributes are the LDAP attributes (lowercased, without options
+// such as ";binary") holding DER certificates or PKCS7 envelopes.
+var ldifCertAttributes = map[string]bool{
+	"usercertificate":      true,
+	"cacertificate":        true,
+	"usersmimecertificate": true,
+}
+
+var errNotLDIF = errors.New("input doesn't look like an LDIF file with certificates")
+
+// ldifRecord is an entry in an LDIF file, with its certificate values.
+type ldifRecord struct {
+	dn     string
+	values []ldifValue
+}
+
+type ldifValue struct {
+	attribute string
+	data      []byte
+}
+
+// readLDIF reads the certificates in the userCertificate, cACertificate and
+// userSMIMECertificate attributes of the entries in an LDIF file (as
+// exported by ldapsearch or from Active Directory), passing them to the
+// callback with the DN of their entry in the friendlyName header.
+func readLDIF(reader io.Reader, opts ReadOptions, callback func(*pem.Block, string) error) error {
+	records, err := parseLDIF(reader)
+	if err != nil {
+		return fmt.Errorf("unable to read LDIF: %s", err)
+	}
+	if len(records) == 0 {
+		return errNotLDIF
+	}
+
+	// Positions in the decoded values would be meaningless
+	opts.RecordOffsets = false
+	for _, record := range records {
+		headers := map[string]string{nameHeader: record.dn}
+		for _, value := range record.values {
+			if err := readDER(value.data, headers, "LDIF", opts, callback); err != nil {
+				return fmt.Errorf("invalid %s of %s: %s", value.attribute, record.dn, strings.TrimSuffix(err.Error(), "\n"))
+			}
+		}
+	}
+	return nil
+}
+
+// parseLDIF finds the entries of an LDIF file (RFC 2849) that have
+// certificate values. Folded lines are joined, and values given by URL
+// (with ":<") are skipped.
+func parseLDIF(reader io.Reader) ([]ldifRecord, error) {
+	var records []ldifRecord
+	var current ldifRecord
+	var lines []string
+
+	flushLine := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		line := strings.Join(lines, "")
+		lines = nil
+
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return fmt.Errorf("malformed line: %q", line)
+		}
+		attribute, value := line[:colon], line[colon+1:]
+		if strings.HasPrefix(value, "<") {
+			// A URL reference to the value
+			return nil
+		}
+		encoded := strings.HasPrefix(value, ":")
+		value = strings.TrimLeft(strings.TrimPrefix(value, ":"), " ")
+		data := []byte(value)
+		if encoded {
+			var err error
+			data, err = base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return fmt.Errorf("invalid base64 in %s: %s", attribute, err)
+			}
+		}
+
+		name := strings.ToLower(attribute)
+		if semicolon := strings.IndexByte(name, ';'); semicolon >= 0 {
+			name = name[:semicolon]
+		}
+		switch {
+		case name == "dn":
+			current.dn = string(data)
+		case ldifCertAttributes[name]:
+			current.values = append(current.values, ldifValue{attribute, data})
+		}
+		return nil
+	}
+	flushRecord := func() {
+		if len(current.values) > 0 {
+			records = append(records, current)
+		}
+		current = ldifRecord{}
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, " "):
+			// Continuation of a folded line
+			if len(lines) > 0 {
+				lines = append(lines, line[1:])
+			}
+			continue
+		case strings.HasPrefix(line, "#"):
+			// Comments may be folded too, so drop what follows
+			if err := flushLine(); err != nil {
+				return nil, err
+			}
+			lines = nil
+			continue
+		}
+		if err := flushLine(); err != nil {
+			return nil, err
+		}
+		if line == "" {
+			flushRecord()
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flushLine(); err != nil {
+		return nil, err
+	}
+	flushRecord()
+	return records, nil
+}
@@ -0,0 +1,99 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// foldLDIF folds a line as LDIF writers do, at 76 characters.
+func foldLDIF(line string) string {
+	var out strings.Builder
+	for len(line) > 76 {
+		out.WriteString(line[:76] + "\n ")
+		line = line[76:]
+	}
+	out.WriteString(line)
+	return out.String()
+}
+
+func TestReadLDIF(t *testing.T) {
+	key := newTestKey(t)
+	ca := issueTestCert(t, "Example CA", key, "Example CA", key)
+	user := issueTestCert(t, "jdoe", key, "Example CA", key)
+
+	encode := func(cert *x509.Certificate) string {
+		return base64.StdEncoding.EncodeToString(cert.Raw)
+	}
+	ldif := fmt.Sprintf(`version: 1
+
+# Example CA, example.com
+# (exported with ldapsearch)
+dn: cn=Example CA,dc=example,dc=com
+objectClass: certificationAuthority
+%s
+
+dn: ou=people,dc=example,dc=com
+objectClass: organizationalUnit
+
+dn:: %s
+cn: jdoe
+%s
+`, foldLDIF("cACertificate;binary:: "+encode(ca)),
+		base64.StdEncoding.EncodeToString([]byte("cn=jdoe,ou=people,dc=example,dc=com")),
+		foldLDIF("userCertificate;binary:: "+encode(user)))
+
+	var names []string
+	var certs []*x509.Certificate
+	err := ReadAsPEM([]io.Reader{strings.NewReader(ldif)}, "", nil, func(block *pem.Block, format string) error {
+		if format != "LDIF" {
+			t.Errorf("unexpected format: %s", format)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		names = append(names, block.Headers[nameHeader])
+		certs = append(certs, cert)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || !certs[0].Equal(ca) || !certs[1].Equal(user) {
+		t.Fatalf("unexpected certificates: %v", certs)
+	}
+	if names[0] != "cn=Example CA,dc=example,dc=com" || names[1] != "cn=jdoe,ou=people,dc=example,dc=com" {
+		t.Errorf("unexpected names: %v", names)
+	}
+}
+
+func TestReadLDIFWithoutCerts(t *testing.T) {
+	ldif := "dn: ou=people,dc=example,dc=com\nobjectClass: organizationalUnit\n"
+	err := ReadAsPEM([]io.Reader{strings.NewReader(ldif)}, "", nil, func(block *pem.Block, format string) error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), errNotLDIF.Error()) {
+		t.Errorf("expected error for LDIF without certificates, got: %v", err)
+	}
+}
@@ -0,0 +1,594 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	// RFC 5280, Section 4.1.2.2: serial numbers must be positive and no
+	// longer than 20 octets (as encoded, i.e. including any leading zero).
+	maxSerialNumberOctets = 20
+
+	// CA/Browser Forum Baseline Requirements, Section 6.1.5 and 6.1.6:
+	// RSA moduli must be at least 2048 bits (and a multiple of 8), and
+	// public exponents should be at least 2^16+1.
+	minRSAKeyBits  = 2048
+	minRSAExponent = 65537
+
+	// CA/Browser Forum Baseline Requirements, Section 6.3.2: the maximum
+	// validity period of TLS server certificates, in days, for those
+	// issued on or after the given dates.
+	maxServerCertDays     = 398
+	maxServerCertDays2018 = 825
+)
+
+var (
+	maxServerCertDaysSince     = time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	maxServerCertDays2018Since = time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// ValidateSerial checks that the serial number of the given certificate
+// conforms to RFC 5280, i.e. it must be a positive integer of at most
+// 20 octets. The limit applies to the DER encoding (Section 4.1.2.2), in
+// which a positive integer with the high bit set takes a leading zero
+// octet, so serials of 160 bits are too long, as they take 21 octets.
+// (Certigo used to only flag serials over 160 bits.) CAs generating 20
+// random octets have to clear the high bit, as the CA/Browser Forum
+// Baseline Requirements point out.
+func ValidateSerial(cert *x509.Certificate) error {
+	serial := cert.SerialNumber
+	if serial == nil || serial.Sign() != 1 {
+		return errors.New("Serial number in cert appears to be zero/negative")
+	}
+
+	// A positive INTEGER is DER-encoded with a leading zero octet if
+	// the high bit is set, so a 160-bit serial would need 21 octets.
+	if serial.BitLen()/8+1 > maxSerialNumberOctets {
+		return errors.New("Serial number too long; should be 20 bytes or less")
+	}
+
+	return nil
+}
+
+// LintSANs checks the subject alternative names of the given certificate,
+// returning a warning for each problem found: a subject common name that
+// isn't also in the SAN list (browsers ignore the common name), duplicate
+// SAN entries, and malformed DNS names or email addresses.
+func LintSANs(cert *x509.Certificate) []string {
+	var warnings []string
+
+	if cn := cert.Subject.CommonName; cn != "" && !sanListContains(cert, cn) {
+		warnings = append(warnings, fmt.Sprintf("Common name '%s' is not in the SAN list", cn))
+	}
+
+	seen := map[string]bool{}
+	duplicate := func(kind, value string) {
+		key := kind + ":" + value
+		if seen[key] {
+			warnings = append(warnings, fmt.Sprintf("Duplicate %s SAN '%s'", kind, value))
+		}
+		seen[key] = true
+	}
+
+	for _, name := range cert.DNSNames {
+		duplicate("DNS", strings.ToLower(name))
+		if !validDNSName(name) {
+			warnings = append(warnings, fmt.Sprintf("Malformed DNS SAN '%s'", name))
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		duplicate("IP", ip.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		duplicate("email", strings.ToLower(email))
+		if at := strings.LastIndex(email, "@"); at <= 0 || at == len(email)-1 {
+			warnings = append(warnings, fmt.Sprintf("Malformed email SAN '%s'", email))
+		}
+	}
+	for _, uri := range cert.URIs {
+		duplicate("URI", uri.String())
+	}
+
+	return warnings
+}
+
+// sanListContains checks if the given name (e.g. a common name) appears in
+// the DNS or IP SANs of the certificate.
+func sanListContains(cert *x509.Certificate, name string) bool {
+	if ip := net.ParseIP(name); ip != nil {
+		for _, san := range cert.IPAddresses {
+			if san.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, san := range cert.DNSNames {
+		if strings.EqualFold(san, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validDNSName checks the syntax of a DNS name in a SAN: letters, digits and
+// hyphens in labels of at most 63 characters, with a wildcard allowed only
+// as the entire left-most label.
+func validDNSName(name string) bool {
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+	for i, label := range strings.Split(name, ".") {
+		if i == 0 && label == "*" {
+			continue
+		}
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// LintWildcards checks the DNS SANs of the given certificate for names that
+// match more hosts than intended, returning a warning for each: a bare
+// wildcard, a name that is itself a public suffix (e.g. a TLD), a wildcard
+// directly under a public suffix (e.g. "*.co.uk"), which covers every
+// registrable domain under it, and any other wildcard. Public suffixes are
+// taken from the Public Suffix List, including its private domains (such as
+// github.io), since these are also operated by unrelated parties. Unlike the
+// other lints this isn't part of ReportFor, as wildcards are often intended.
+func LintWildcards(cert *x509.Certificate) []string {
+	var warnings []string
+
+	for _, name := range cert.DNSNames {
+		host := strings.TrimSuffix(strings.ToLower(name), ".")
+		if host == "*" {
+			warnings = append(warnings, "Bare wildcard SAN '*' matches every host name")
+			continue
+		}
+
+		base := strings.TrimPrefix(host, "*.")
+		if suffix, _ := publicsuffix.PublicSuffix(base); suffix == base {
+			if base == host {
+				warnings = append(warnings, fmt.Sprintf("SAN '%s' is a public suffix", name))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("Wildcard SAN '%s' matches every registrable domain under the public suffix '%s'", name, suffix))
+			}
+		} else if base != host {
+			warnings = append(warnings, fmt.Sprintf("Wildcard SAN '%s' matches every host directly under '%s'", name, base))
+		}
+	}
+
+	return warnings
+}
+
+// reservedIPRanges are the IP ranges that aren't publicly routable, so
+// public CAs may not issue certificates for them, with a description of each.
+var reservedIPRanges = []struct {
+	cidr, name string
+}{
+	{"0.0.0.0/8", "\"this network\""},
+	{"10.0.0.0/8", "private (RFC 1918)"},
+	{"100.64.0.0/10", "shared address space (RFC 6598)"},
+	{"127.0.0.0/8", "loopback"},
+	{"169.254.0.0/16", "link-local"},
+	{"172.16.0.0/12", "private (RFC 1918)"},
+	{"192.0.0.0/24", "IETF protocol assignments"},
+	{"192.0.2.0/24", "documentation"},
+	{"192.168.0.0/16", "private (RFC 1918)"},
+	{"198.18.0.0/15", "benchmarking"},
+	{"198.51.100.0/24", "documentation"},
+	{"203.0.113.0/24", "documentation"},
+	{"224.0.0.0/4", "multicast"},
+	{"240.0.0.0/4", "reserved"},
+	{"::/128", "unspecified"},
+	{"::1/128", "loopback"},
+	{"fc00::/7", "unique local"},
+	{"fe80::/10", "link-local"},
+	{"ff00::/8", "multicast"},
+	{"2001:db8::/32", "documentation"},
+}
+
+// internalTLDs are top-level domains that are reserved (RFC 2606, 6761,
+// 6762) or commonly used on internal networks.
+var internalTLDs = map[string]bool{
+	"corp":        true,
+	"example":     true,
+	"home":        true,
+	"internal":    true,
+	"intranet":    true,
+	"invalid":     true,
+	"lan":         true,
+	"local":       true,
+	"localdomain": true,
+	"localhost":   true,
+	"private":     true,
+	"test":        true,
+}
+
+// LintInternalNames checks the SANs of the given certificate for names
+// that only make sense on an internal network, returning a warning for
+// each: single-label host names, names under reserved or internal
+// top-level domains (e.g. ".local" or ".corp") or under no known public
+// one, and IP addresses in private or reserved ranges. Public CAs no longer
+// issue certificates for such names, so they are out of place in a
+// certificate meant for public use.
+func LintInternalNames(cert *x509.Certificate) []string {
+	var warnings []string
+
+	for _, name := range cert.DNSNames {
+		host := strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(name), "."), "*.")
+		labels := strings.Split(host, ".")
+		tld := labels[len(labels)-1]
+		switch {
+		case len(labels) == 1:
+			warnings = append(warnings, fmt.Sprintf("SAN '%s' is a single-label host name", name))
+		case host == "home.arpa" || strings.HasSuffix(host, ".home.arpa"):
+			warnings = append(warnings, fmt.Sprintf("SAN '%s' is under the reserved or internal domain '.home.arpa'", name))
+		case internalTLDs[tld]:
+			warnings = append(warnings, fmt.Sprintf("SAN '%s' is under the reserved or internal domain '.%s'", name, tld))
+		default:
+			// Names under unlisted top-level domains fall back to an
+			// implicit rule, which isn't ICANN-managed
+			if suffix, icann := publicsuffix.PublicSuffix(host); !icann && !strings.Contains(suffix, ".") {
+				warnings = append(warnings, fmt.Sprintf("SAN '%s' is not under a public top-level domain", name))
+			}
+		}
+	}
+
+	for _, ip := range cert.IPAddresses {
+		for _, reserved := range reservedIPRanges {
+			_, network, _ := net.ParseCIDR(reserved.cidr)
+			if network.Contains(ip) {
+				warnings = append(warnings, fmt.Sprintf("IP SAN %s is in the %s range %s", ip, reserved.name, reserved.cidr))
+				break
+			}
+		}
+	}
+
+	return warnings
+}
+
+// signatureStrength returns the nominal security level in bits of the hash
+// used by a signature algorithm, or zero if unknown.
+func signatureStrength(alg x509.SignatureAlgorithm) int {
+	switch alg {
+	case x509.MD2WithRSA, x509.MD5WithRSA:
+		return 64
+	case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return 80
+	case x509.SHA256WithRSA, x509.SHA256WithRSAPSS, x509.DSAWithSHA256, x509.ECDSAWithSHA256, x509.PureEd25519:
+		return 128
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		return 192
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		return 256
+	}
+	return 0
+}
+
+// LintChainSignatures checks the signature algorithms along a chain (leaf
+// first), returning the weakest one used and a warning for each link signed
+// with an outdated algorithm (see IsWeakSignature). A chain is only as strong
+// as its weakest signature, so a strong leaf under a SHA-1 intermediate is
+// still open to forgery, which checking the leaf alone misses. The signature
+// of a self-issued root at the end is skipped, as roots are trusted as is.
+func LintChainSignatures(chain []*x509.Certificate) (weakest x509.SignatureAlgorithm, warnings []string) {
+	for i, cert := range chain {
+		if i > 0 && i == len(chain)-1 && bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+			break
+		}
+		if weakest == x509.UnknownSignatureAlgorithm || signatureStrength(cert.SignatureAlgorithm) < signatureStrength(weakest) {
+			weakest = cert.SignatureAlgorithm
+		}
+		if IsWeakSignature(cert) {
+			warnings = append(warnings, fmt.Sprintf("Certificate %d in chain (%s) is signed with %s, which is an outdated signature algorithm",
+				i, PrintCommonName(cert.Subject), algString(cert.SignatureAlgorithm)))
+		}
+	}
+	if len(chain) > 0 && len(warnings) > 0 && !IsWeakSignature(chain[0]) {
+		warnings = append(warnings, fmt.Sprintf("Leaf is signed with %s, but the chain is only as strong as its weakest link, %s",
+			algString(chain[0].SignatureAlgorithm), algString(weakest)))
+	}
+	return weakest, warnings
+}
+
+// signatureKeyAlgorithm returns the type of key that makes signatures with
+// the given algorithm, or UnknownPublicKeyAlgorithm if unknown.
+func signatureKeyAlgorithm(alg x509.SignatureAlgorithm) x509.PublicKeyAlgorithm {
+	switch alg {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+		return x509.RSA
+	case x509.DSAWithSHA1, x509.DSAWithSHA256:
+		return x509.DSA
+	case x509.ECDSAWithSHA1, x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+		return x509.ECDSA
+	case x509.PureEd25519:
+		return x509.Ed25519
+	}
+	return x509.UnknownPublicKeyAlgorithm
+}
+
+// LintSignatureKeyType checks that the signature algorithm of the given
+// certificate fits the type of its issuer's key, returning a warning if not,
+// such as for an RSA signature algorithm on a certificate issued under an
+// ECDSA key. Such a certificate can never verify, which points to a CA
+// misconfiguration (or to the wrong issuer). Unknown algorithms and key
+// types are skipped.
+func LintSignatureKeyType(cert, issuer *x509.Certificate) []string {
+	signatureKey := signatureKeyAlgorithm(cert.SignatureAlgorithm)
+	if signatureKey == x509.UnknownPublicKeyAlgorithm || issuer.PublicKeyAlgorithm == x509.UnknownPublicKeyAlgorithm {
+		return nil
+	}
+	if signatureKey != issuer.PublicKeyAlgorithm {
+		return []string{fmt.Sprintf("Certificate is signed with %s, but its issuer (%s) has a %s key",
+			algString(cert.SignatureAlgorithm), PrintCommonName(issuer.Subject), issuer.PublicKeyAlgorithm)}
+	}
+	return nil
+}
+
+// deprecatedExtensions are legacy extensions that modern certificates
+// shouldn't carry, with a description of each.
+var deprecatedExtensions = []struct {
+	oid  asn1.ObjectIdentifier
+	name string
+}{
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 1}, "Netscape certificate type"},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 2}, "Netscape base URL"},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 3}, "Netscape revocation URL"},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 4}, "Netscape CA revocation URL"},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 7}, "Netscape renewal URL"},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 8}, "Netscape CA policy URL"},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 12}, "Netscape SSL server name"},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 13}, "Netscape comment"},
+	{asn1.ObjectIdentifier{2, 5, 29, 1}, "old authority key identifier"},
+	{asn1.ObjectIdentifier{2, 5, 29, 3}, "old certificate policies"},
+	{asn1.ObjectIdentifier{2, 5, 29, 4}, "key usage restriction"},
+	{asn1.ObjectIdentifier{2, 5, 29, 7}, "old subject alternative name"},
+	{asn1.ObjectIdentifier{2, 5, 29, 8}, "old issuer alternative name"},
+	{asn1.ObjectIdentifier{2, 5, 29, 10}, "old basic constraints"},
+	{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2}, "Microsoft certificate template name (v1)"},
+}
+
+// LintExtensions checks the extensions of the given certificate, returning
+// a warning for each deprecated extension it carries (such as the Netscape
+// certificate type) and for missing extensions that modern clients expect,
+// such as serverAuth in the extended key usage of a TLS server certificate.
+func LintExtensions(cert *x509.Certificate) []string {
+	var warnings []string
+
+	for _, ext := range deprecatedExtensions {
+		if _, _, found := ExtensionByOID(cert, ext.oid); found {
+			warnings = append(warnings, fmt.Sprintf("Deprecated extension: %s (%s)", ext.name, ext.oid))
+		}
+	}
+
+	if cert.IsCA {
+		if cert.KeyUsage == 0 {
+			warnings = append(warnings, "CA certificate has no key usage extension")
+		}
+		return warnings
+	}
+
+	if len(cert.DNSNames) > 0 || len(cert.IPAddresses) > 0 {
+		// Looks like a TLS server certificate
+		if !hasExtKeyUsage(cert, x509.ExtKeyUsageServerAuth) {
+			warnings = append(warnings, "Certificate has DNS/IP names, but no serverAuth extended key usage")
+		}
+	}
+
+	return warnings
+}
+
+// LintPublicKey checks the public key of the given certificate against the
+// CA/Browser Forum Baseline Requirements, returning a warning for each
+// problem found: undersized RSA keys, weak RSA public exponents (such as
+// e=3, or even ones), and ECDSA keys on curves other than P-256, P-384 and
+// P-521.
+func LintPublicKey(cert *x509.Certificate) []string {
+	var warnings []string
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		bits := key.N.BitLen()
+		if bits < minRSAKeyBits {
+			warnings = append(warnings, fmt.Sprintf("RSA key is %d bits, should be at least %d bits", bits, minRSAKeyBits))
+		}
+		if bits%8 != 0 {
+			warnings = append(warnings, fmt.Sprintf("RSA modulus size (%d bits) is not a multiple of 8", bits))
+		}
+		switch {
+		case key.E%2 == 0:
+			warnings = append(warnings, fmt.Sprintf("RSA public exponent %d is even", key.E))
+		case key.E < minRSAExponent:
+			warnings = append(warnings, fmt.Sprintf("RSA public exponent %d is weak, should be at least %d", key.E, minRSAExponent))
+		}
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			warnings = append(warnings, fmt.Sprintf("ECDSA key uses curve %s, should be P-256, P-384 or P-521", key.Curve.Params().Name))
+		}
+	case *dsa.PublicKey:
+		warnings = append(warnings, "DSA keys are not allowed by the CA/Browser Forum baseline requirements")
+	}
+
+	return warnings
+}
+
+// LintCAConsistency checks that the basic constraints and key usage of the
+// given certificate agree on whether it's a CA (RFC 5280, Section 4.2.1.3
+// and 4.2.1.9), returning a warning for each inconsistency: keyCertSign
+// without CA:TRUE or the other way around, a path length constraint on a
+// certificate that isn't a CA, and CA certificates that look like leaves.
+// Chain building tends to fail in confusing ways on such certificates.
+func LintCAConsistency(cert *x509.Certificate) []string {
+	var warnings []string
+
+	if cert.KeyUsage&x509.KeyUsageCertSign != 0 && !cert.IsCA {
+		warnings = append(warnings, "Key usage includes keyCertSign, but basic constraints don't mark it as a CA")
+	}
+	if !cert.IsCA && cert.BasicConstraintsValid && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+		warnings = append(warnings, "Basic constraints have a path length constraint, but don't mark it as a CA")
+	}
+	if !cert.IsCA {
+		return warnings
+	}
+
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		warnings = append(warnings, "Basic constraints mark it as a CA, but key usage doesn't include keyCertSign")
+	}
+	if len(cert.DNSNames) > 0 || len(cert.IPAddresses) > 0 || len(cert.EmailAddresses) > 0 {
+		warnings = append(warnings, "CA certificate has DNS/IP/email names, like a leaf certificate")
+	}
+	if hasLeafOnlyExtKeyUsage(cert) {
+		warnings = append(warnings, "CA certificate has a leaf-only extended key usage (serverAuth or clientAuth only)")
+	}
+
+	return warnings
+}
+
+// hasLeafOnlyExtKeyUsage checks if the extended key usage of the given
+// certificate is limited to serverAuth and/or clientAuth, as is typical of
+// TLS leaf certificates.
+func hasLeafOnlyExtKeyUsage(cert *x509.Certificate) bool {
+	if len(cert.ExtKeyUsage) == 0 || len(cert.UnknownExtKeyUsage) > 0 {
+		return false
+	}
+	for _, eku := range cert.ExtKeyUsage {
+		if eku != x509.ExtKeyUsageServerAuth && eku != x509.ExtKeyUsageClientAuth {
+			return false
+		}
+	}
+	return true
+}
+
+// LintValidity checks the validity period of the given certificate,
+// returning a warning for each problem found: a notBefore after the
+// notAfter, a period longer than the CA/Browser Forum allows for TLS server
+// certificates (398 days for those issued since September 2020, see
+// LintMaxValidity), and dates that aren't encoded as RFC 5280 requires
+// (UTCTime through 2049 and GeneralizedTime after, in UTC and with
+// seconds), which parsers may interpret differently.
+func LintValidity(cert *x509.Certificate) []string {
+	return lintValidity(cert, defaultMaxServerCertDays(cert))
+}
+
+// lintValidity is LintValidity with the given maximum validity period of
+// TLS server certificates.
+func lintValidity(cert *x509.Certificate, maxDays int) []string {
+	var warnings []string
+
+	if cert.NotBefore.After(cert.NotAfter) {
+		warnings = append(warnings, fmt.Sprintf("Validity period is negative: notBefore (%s) is after notAfter (%s)",
+			cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339)))
+	} else {
+		warnings = append(warnings, LintMaxValidity(cert, maxDays)...)
+	}
+
+	var tbs rawTBSCertificatePrefix
+	if _, err := asn1.Unmarshal(cert.RawTBSCertificate, &tbs); err == nil {
+		warnings = append(warnings, lintValidityTime("notBefore", tbs.Validity.NotBefore, cert.NotBefore)...)
+		warnings = append(warnings, lintValidityTime("notAfter", tbs.Validity.NotAfter, cert.NotAfter)...)
+	}
+
+	return warnings
+}
+
+// LintMaxValidity checks that the validity period of the given certificate
+// is at most maxDays days if it's a TLS server certificate (not a CA, and
+// with the serverAuth extended key usage or DNS/IP SANs), returning a
+// warning with the actual period if it's longer. Browsers reject server
+// certificates valid for longer than the CA/Browser Forum allows, which
+// has changed over time, so the limit is up to the caller. Other
+// certificates aren't checked, and neither are any if maxDays isn't
+// positive.
+func LintMaxValidity(cert *x509.Certificate, maxDays int) []string {
+	if maxDays <= 0 || cert.IsCA || !(hasExtKeyUsage(cert, x509.ExtKeyUsageServerAuth) || len(cert.DNSNames) > 0 || len(cert.IPAddresses) > 0) {
+		return nil
+	}
+	// The validity period includes the notAfter second itself
+	period := cert.NotAfter.Sub(cert.NotBefore) + time.Second
+	if period <= time.Duration(maxDays)*24*time.Hour {
+		return nil
+	}
+	return []string{fmt.Sprintf("Validity period of %d days exceeds the maximum of %d days for TLS server certificates",
+		int((period+24*time.Hour-1)/(24*time.Hour)), maxDays)}
+}
+
+// defaultMaxServerCertDays returns the maximum validity period of TLS
+// server certificates under the CA/Browser Forum Baseline Requirements at
+// the time the certificate was issued, or zero if there was none.
+func defaultMaxServerCertDays(cert *x509.Certificate) int {
+	switch {
+	case !cert.NotBefore.Before(maxServerCertDaysSince):
+		return maxServerCertDays
+	case !cert.NotBefore.Before(maxServerCertDays2018Since):
+		return maxServerCertDays2018
+	}
+	return 0
+}
+
+// lintValidityTime checks that a time in the validity period is encoded as
+// RFC 5280, Section 4.1.2.5 requires, and as it was parsed.
+func lintValidityTime(field string, raw asn1.RawValue, parsed time.Time) []string {
+	parsed = parsed.UTC()
+	tag, encoded := asn1.TagGeneralizedTime, parsed.Format("20060102150405Z")
+	if year := parsed.Year(); year >= 1950 && year < 2050 {
+		tag, encoded = asn1.TagUTCTime, parsed.Format("060102150405Z")
+	}
+
+	switch {
+	case raw.Tag == asn1.TagGeneralizedTime && tag == asn1.TagUTCTime:
+		return []string{fmt.Sprintf("%s is encoded as GeneralizedTime, but must be a UTCTime before 2050", field)}
+	case raw.Tag == asn1.TagUTCTime && tag == asn1.TagGeneralizedTime:
+		return []string{fmt.Sprintf("%s is encoded as UTCTime, but must be a GeneralizedTime after 2049", field)}
+	case string(raw.Bytes) != encoded:
+		return []string{fmt.Sprintf("%s is encoded as '%s', which doesn't round-trip (parsed as %s)", field, raw.Bytes, parsed.Format(time.RFC3339))}
+	}
+	return nil
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, eku := range cert.ExtKeyUsage {
+		if eku == usage || eku == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,394 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidateSerial(t *testing.T) {
+	bits := func(n uint) *big.Int {
+		return new(big.Int).Lsh(big.NewInt(1), n-1)
+	}
+	cases := []struct {
+		serial *big.Int
+		valid  bool
+	}{
+		{nil, false},
+		{big.NewInt(0), false},
+		{big.NewInt(-1), false},
+		{big.NewInt(1), true},
+		// 159 bits encode to 20 octets, but 160 bits need a leading zero
+		// octet, so take 21
+		{bits(159), true},
+		{new(big.Int).Sub(bits(161), big.NewInt(1)), false},
+		{bits(160), false},
+		{bits(161), false},
+	}
+	for _, c := range cases {
+		err := ValidateSerial(&x509.Certificate{SerialNumber: c.serial})
+		if (err == nil) != c.valid {
+			t.Errorf("serial %v (%d bits): unexpected result %v", c.serial, c.serial.BitLen(), err)
+		}
+		if c.serial == nil || c.serial.Sign() != 1 {
+			continue
+		}
+		encoded, _ := asn1.Marshal(c.serial)
+		if octets := len(encoded) - 2; (octets <= maxSerialNumberOctets) != c.valid {
+			t.Errorf("serial %v: encodes to %d octets", c.serial, octets)
+		}
+	}
+}
+
+func TestLintSANs(t *testing.T) {
+	clean := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "Example.com"},
+		DNSNames:    []string{"example.com", "*.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	if warnings := LintSANs(clean); len(warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+
+	ipCN := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "10.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	if warnings := LintSANs(ipCN); len(warnings) != 0 {
+		t.Errorf("unexpected warnings for IP common name: %v", warnings)
+	}
+
+	bad := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "www.example.com"},
+		DNSNames:       []string{"example.com", "EXAMPLE.com", "foo.*.example.com", "bad_name.example.com"},
+		EmailAddresses: []string{"nobody"},
+	}
+	expected := []string{
+		"Common name 'www.example.com' is not in the SAN list",
+		"Duplicate DNS SAN 'example.com'",
+		"Malformed DNS SAN 'foo.*.example.com'",
+		"Malformed DNS SAN 'bad_name.example.com'",
+		"Malformed email SAN 'nobody'",
+	}
+	if warnings := LintSANs(bad); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+}
+
+func TestLintExtensions(t *testing.T) {
+	modern := &x509.Certificate{
+		DNSNames:    []string{"example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if warnings := LintExtensions(modern); len(warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+
+	legacy := &x509.Certificate{
+		DNSNames:    []string{"example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		Extensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 1}, Value: []byte{3, 2, 6, 64}},
+		},
+	}
+	expected := []string{
+		"Deprecated extension: Netscape certificate type (2.16.840.1.113730.1.1)",
+		"Certificate has DNS/IP names, but no serverAuth extended key usage",
+	}
+	if warnings := LintExtensions(legacy); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+
+	ca := &x509.Certificate{IsCA: true}
+	if warnings := LintExtensions(ca); !reflect.DeepEqual(warnings, []string{"CA certificate has no key usage extension"}) {
+		t.Errorf("unexpected warnings for CA: %q", warnings)
+	}
+}
+
+func TestLintPublicKey(t *testing.T) {
+	modulus := func(bits uint) *big.Int {
+		return new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bits), big.NewInt(1))
+	}
+	testCases := []struct {
+		key      interface{}
+		expected []string
+	}{
+		{&rsa.PublicKey{N: modulus(2048), E: 65537}, nil},
+		{&rsa.PublicKey{N: modulus(1024), E: 65537}, []string{"RSA key is 1024 bits, should be at least 2048 bits"}},
+		{&rsa.PublicKey{N: modulus(2049), E: 3}, []string{
+			"RSA modulus size (2049 bits) is not a multiple of 8",
+			"RSA public exponent 3 is weak, should be at least 65537",
+		}},
+		{&rsa.PublicKey{N: modulus(4096), E: 65536}, []string{"RSA public exponent 65536 is even"}},
+		{&ecdsa.PublicKey{Curve: elliptic.P256()}, nil},
+		{&ecdsa.PublicKey{Curve: elliptic.P224()}, []string{"ECDSA key uses curve P-224, should be P-256, P-384 or P-521"}},
+		{&dsa.PublicKey{}, []string{"DSA keys are not allowed by the CA/Browser Forum baseline requirements"}},
+	}
+	for i, tc := range testCases {
+		warnings := LintPublicKey(&x509.Certificate{PublicKey: tc.key})
+		if !reflect.DeepEqual(warnings, tc.expected) {
+			t.Errorf("case %d: unexpected warnings: %q", i, warnings)
+		}
+	}
+}
+
+func TestLintCAConsistency(t *testing.T) {
+	testCases := []struct {
+		cert     *x509.Certificate
+		expected []string
+	}{
+		{&x509.Certificate{BasicConstraintsValid: true, IsCA: true, MaxPathLen: -1, KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign}, nil},
+		{&x509.Certificate{BasicConstraintsValid: true, MaxPathLen: -1, KeyUsage: x509.KeyUsageDigitalSignature, DNSNames: []string{"example.com"}}, nil},
+		{&x509.Certificate{BasicConstraintsValid: true, MaxPathLen: -1, KeyUsage: x509.KeyUsageCertSign}, []string{
+			"Key usage includes keyCertSign, but basic constraints don't mark it as a CA",
+		}},
+		{&x509.Certificate{KeyUsage: x509.KeyUsageCertSign}, []string{
+			"Key usage includes keyCertSign, but basic constraints don't mark it as a CA",
+		}},
+		{&x509.Certificate{BasicConstraintsValid: true, MaxPathLen: 0, MaxPathLenZero: true}, []string{
+			"Basic constraints have a path length constraint, but don't mark it as a CA",
+		}},
+		{&x509.Certificate{BasicConstraintsValid: true, IsCA: true, MaxPathLen: -1, KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment}, []string{
+			"Basic constraints mark it as a CA, but key usage doesn't include keyCertSign",
+		}},
+		{&x509.Certificate{
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			MaxPathLen:            -1,
+			DNSNames:              []string{"www.example.com"},
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, []string{
+			"CA certificate has DNS/IP/email names, like a leaf certificate",
+			"CA certificate has a leaf-only extended key usage (serverAuth or clientAuth only)",
+		}},
+		{&x509.Certificate{BasicConstraintsValid: true, IsCA: true, MaxPathLen: -1, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning}}, nil},
+	}
+	for i, tc := range testCases {
+		warnings := LintCAConsistency(tc.cert)
+		if !reflect.DeepEqual(warnings, tc.expected) {
+			t.Errorf("case %d: unexpected warnings: %q", i, warnings)
+		}
+	}
+}
+
+func TestLintWildcards(t *testing.T) {
+	testCases := []struct {
+		names    []string
+		expected []string
+	}{
+		{[]string{"www.example.com", "example.com"}, nil},
+		{[]string{"*.example.com"}, []string{
+			"Wildcard SAN '*.example.com' matches every host directly under 'example.com'",
+		}},
+		{[]string{"*"}, []string{
+			"Bare wildcard SAN '*' matches every host name",
+		}},
+		{[]string{"*.com", "*.CO.UK."}, []string{
+			"Wildcard SAN '*.com' matches every registrable domain under the public suffix 'com'",
+			"Wildcard SAN '*.CO.UK.' matches every registrable domain under the public suffix 'co.uk'",
+		}},
+		{[]string{"com", "github.io"}, []string{
+			"SAN 'com' is a public suffix",
+			"SAN 'github.io' is a public suffix",
+		}},
+	}
+	for i, tc := range testCases {
+		warnings := LintWildcards(&x509.Certificate{DNSNames: tc.names})
+		if !reflect.DeepEqual(warnings, tc.expected) {
+			t.Errorf("case %d: unexpected warnings: %q", i, warnings)
+		}
+	}
+}
+
+func TestLintInternalNames(t *testing.T) {
+	testCases := []struct {
+		cert     *x509.Certificate
+		expected []string
+	}{
+		{&x509.Certificate{
+			DNSNames:    []string{"www.example.com", "*.example.co.uk"},
+			IPAddresses: []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("2606:4700::1111")},
+		}, nil},
+		{&x509.Certificate{DNSNames: []string{"intranet", "printer.local", "*.CORP.", "router.home.arpa", "db.notarealtld"}}, []string{
+			"SAN 'intranet' is a single-label host name",
+			"SAN 'printer.local' is under the reserved or internal domain '.local'",
+			"SAN '*.CORP.' is a single-label host name",
+			"SAN 'router.home.arpa' is under the reserved or internal domain '.home.arpa'",
+			"SAN 'db.notarealtld' is not under a public top-level domain",
+		}},
+		{&x509.Certificate{DNSNames: []string{"*.example.corp"}}, []string{
+			"SAN '*.example.corp' is under the reserved or internal domain '.corp'",
+		}},
+		{&x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.1.2.3"), net.ParseIP("127.0.0.1"), net.ParseIP("fd00::1"), net.ParseIP("192.0.2.1")}}, []string{
+			"IP SAN 10.1.2.3 is in the private (RFC 1918) range 10.0.0.0/8",
+			"IP SAN 127.0.0.1 is in the loopback range 127.0.0.0/8",
+			"IP SAN fd00::1 is in the unique local range fc00::/7",
+			"IP SAN 192.0.2.1 is in the documentation range 192.0.2.0/24",
+		}},
+	}
+	for i, tc := range testCases {
+		warnings := LintInternalNames(tc.cert)
+		if !reflect.DeepEqual(warnings, tc.expected) {
+			t.Errorf("case %d: unexpected warnings: %q", i, warnings)
+		}
+	}
+}
+
+func TestLintChainSignatures(t *testing.T) {
+	cert := func(cn, issuer string, alg x509.SignatureAlgorithm) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:            pkix.Name{CommonName: cn},
+			RawSubject:         []byte(cn),
+			RawIssuer:          []byte(issuer),
+			SignatureAlgorithm: alg,
+		}
+	}
+	leaf := cert("leaf", "intermediate", x509.ECDSAWithSHA384)
+	strong := cert("intermediate", "root", x509.SHA256WithRSA)
+	weak := cert("intermediate", "root", x509.SHA1WithRSA)
+	root := cert("root", "root", x509.MD5WithRSA)
+
+	weakest, warnings := LintChainSignatures([]*x509.Certificate{leaf, strong, root})
+	if weakest != x509.SHA256WithRSA || warnings != nil {
+		t.Errorf("unexpected result: %s, %q", weakest, warnings)
+	}
+
+	weakest, warnings = LintChainSignatures([]*x509.Certificate{leaf, weak, root})
+	expected := []string{
+		"Certificate 1 in chain (CN=intermediate) is signed with SHA1-RSA, which is an outdated signature algorithm",
+		"Leaf is signed with ECDSA-SHA384, but the chain is only as strong as its weakest link, SHA1-RSA",
+	}
+	if weakest != x509.SHA1WithRSA || !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("unexpected result: %s, %q", weakest, warnings)
+	}
+
+	// A self-issued certificate on its own is still checked
+	weakest, warnings = LintChainSignatures([]*x509.Certificate{root})
+	if weakest != x509.MD5WithRSA || len(warnings) != 1 {
+		t.Errorf("unexpected result: %s, %q", weakest, warnings)
+	}
+}
+
+func TestLintValidity(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		cert     *x509.Certificate
+		expected []string
+	}{
+		{&x509.Certificate{NotBefore: start, NotAfter: start.Add(397 * 24 * time.Hour), DNSNames: []string{"example.com"}}, nil},
+		{&x509.Certificate{NotBefore: start, NotAfter: start.Add(-time.Hour)}, []string{
+			"Validity period is negative: notBefore (2021-01-01T00:00:00Z) is after notAfter (2020-12-31T23:00:00Z)",
+		}},
+		{&x509.Certificate{NotBefore: start, NotAfter: start.Add(2 * 365 * 24 * time.Hour), DNSNames: []string{"example.com"}}, []string{
+			"Validity period of 731 days exceeds the maximum of 398 days for TLS server certificates",
+		}},
+		{&x509.Certificate{NotBefore: start.AddDate(-2, 0, 0), NotAfter: start, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, nil},
+		{&x509.Certificate{NotBefore: start, NotAfter: start.AddDate(10, 0, 0), IsCA: true, DNSNames: []string{"example.com"}}, nil},
+	}
+	for i, tc := range testCases {
+		warnings := LintValidity(tc.cert)
+		if !reflect.DeepEqual(warnings, tc.expected) {
+			t.Errorf("case %d: unexpected warnings: %q", i, warnings)
+		}
+	}
+
+	// Certificates from the x509 package are encoded properly
+	key := newTestKey(t)
+	if warnings := LintValidity(issueTestCert(t, "root", key, "root", key)); len(warnings) > 0 {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+
+	timeCases := []struct {
+		raw      asn1.RawValue
+		parsed   time.Time
+		expected string
+	}{
+		{asn1.RawValue{Tag: asn1.TagUTCTime, Bytes: []byte("210101000000Z")}, start, ""},
+		{asn1.RawValue{Tag: asn1.TagGeneralizedTime, Bytes: []byte("20500101000000Z")}, time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), ""},
+		{asn1.RawValue{Tag: asn1.TagGeneralizedTime, Bytes: []byte("99991231235959Z")}, time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC), ""},
+		{asn1.RawValue{Tag: asn1.TagGeneralizedTime, Bytes: []byte("20210101000000Z")}, start, "notBefore is encoded as GeneralizedTime, but must be a UTCTime before 2050"},
+		{asn1.RawValue{Tag: asn1.TagUTCTime, Bytes: []byte("500101000000Z")}, time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), "notBefore is encoded as UTCTime, but must be a GeneralizedTime after 2049"},
+		{asn1.RawValue{Tag: asn1.TagUTCTime, Bytes: []byte("210101010000+0100")}, start, "notBefore is encoded as '210101010000+0100', which doesn't round-trip (parsed as 2021-01-01T00:00:00Z)"},
+	}
+	for i, tc := range timeCases {
+		warnings := lintValidityTime("notBefore", tc.raw, tc.parsed)
+		if (tc.expected == "" && len(warnings) > 0) || (tc.expected != "" && (len(warnings) != 1 || warnings[0] != tc.expected)) {
+			t.Errorf("time case %d: unexpected warnings: %q", i, warnings)
+		}
+	}
+}
+
+func TestLintMaxValidity(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	server := &x509.Certificate{NotBefore: start, NotAfter: start.Add(100 * 24 * time.Hour), DNSNames: []string{"example.com"}}
+
+	expected := []string{"Validity period of 101 days exceeds the maximum of 90 days for TLS server certificates"}
+	if warnings := LintMaxValidity(server, 90); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+	if warnings := LintMaxValidity(server, 398); warnings != nil {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+	if warnings := LintMaxValidity(server, 0); warnings != nil {
+		t.Errorf("unexpected warnings with check disabled: %q", warnings)
+	}
+
+	client := &x509.Certificate{NotBefore: start, NotAfter: start.AddDate(5, 0, 0), ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if warnings := LintMaxValidity(client, 90); warnings != nil {
+		t.Errorf("unexpected warnings for client certificate: %q", warnings)
+	}
+}
+
+func TestLintSignatureKeyType(t *testing.T) {
+	rsaCert, err := ParseCertificatePEM(readTestFile(t, "../test-certs/example-leaf.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := newTestKey(t)
+	ecdsaRoot := issueTestCert(t, "root", key, "root", key)
+	ecdsaLeaf := issueTestCert(t, "leaf", newTestKey(t), "root", key)
+
+	if warnings := LintSignatureKeyType(rsaCert, rsaCert); len(warnings) != 0 {
+		t.Errorf("unexpected warnings for RSA certificate: %v", warnings)
+	}
+	if warnings := LintSignatureKeyType(ecdsaLeaf, ecdsaRoot); len(warnings) != 0 {
+		t.Errorf("unexpected warnings for ECDSA certificate: %v", warnings)
+	}
+	if warnings := LintSignatureKeyType(rsaCert, ecdsaRoot); len(warnings) != 1 {
+		t.Errorf("expected a warning for RSA signature under ECDSA key, got %v", warnings)
+	}
+	if warnings := LintSignatureKeyType(ecdsaLeaf, rsaCert); len(warnings) != 1 {
+		t.Errorf("expected a warning for ECDSA signature under RSA key, got %v", warnings)
+	}
+
+	unknown := *ecdsaLeaf
+	unknown.SignatureAlgorithm = x509.UnknownSignatureAlgorithm
+	if warnings := LintSignatureKeyType(&unknown, rsaCert); len(warnings) != 0 {
+		t.Errorf("unexpected warnings for unknown signature algorithm: %v", warnings)
+	}
+}
@@ -0,0 +1,374 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/net/idna"
+)
+
+// DNComponent is a single attribute (type=value pair) of a distinguished name.
+type DNComponent struct {
+	// Type is the short name of the attribute type (e.g. "CN"), or the
+	// dotted OID if it has none.
+	Type  string
+	OID   asn1.ObjectIdentifier
+	Value string
+}
+
+// dnAttribute is like pkix.AttributeTypeAndValue, but keeps the raw value
+// so that string types Go doesn't decode (BMPString etc.) aren't lost.
+type dnAttribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// The SET suffix makes encoding/asn1 treat this as a SET OF.
+type dnAttributeSET []dnAttribute
+
+// DNComponents parses a DER-encoded distinguished name (such as
+// cert.RawSubject or cert.RawIssuer) into its relative distinguished names,
+// in the order they are encoded. Each RDN is a list of components, since an
+// RDN may be multi-valued. Unlike pkix.Name, this keeps the original order
+// and attributes of unknown types. Values that aren't strings are given as
+// '#' followed by the hex encoding of the DER value.
+func DNComponents(raw []byte) ([][]DNComponent, error) {
+	rdns, err := parseDN(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]DNComponent, 0, len(rdns))
+	for _, rdn := range rdns {
+		components := make([]DNComponent, 0, len(rdn))
+		for _, attr := range rdn {
+			value, ok := dnAttributeString(attr.Value)
+			if !ok {
+				value = "#" + hex.EncodeToString(attr.Value.FullBytes)
+			}
+			components = append(components, DNComponent{
+				Type:  dnAttributeType(attr.Type),
+				OID:   attr.Type,
+				Value: value,
+			})
+		}
+		out = append(out, components)
+	}
+	return out, nil
+}
+
+// FormatDN renders a DER-encoded distinguished name as type=value pairs in
+// encoded order, separated by ", " (or "+" within a multi-valued RDN), with
+// values escaped as described in RFC 4514.
+func FormatDN(raw []byte) (string, error) {
+	rdns, err := parseDN(raw)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, 0, len(rdns))
+	for _, rdn := range rdns {
+		values := make([]string, 0, len(rdn))
+		for _, attr := range rdn {
+			value, ok := dnAttributeString(attr.Value)
+			if ok {
+				value = escapeDNValue(value)
+			} else {
+				value = "#" + hex.EncodeToString(attr.Value.FullBytes)
+			}
+			values = append(values, dnAttributeType(attr.Type)+"="+value)
+		}
+		parts = append(parts, strings.Join(values, "+"))
+	}
+	return strings.Join(parts, ", "), nil
+}
+
+// jsonName is the JSON form of a distinguished name produced by MarshalName.
+type jsonName struct {
+	DN   string                `json:"dn"`
+	RDNs [][]jsonNameAttribute `json:"rdns"`
+}
+
+type jsonNameAttribute struct {
+	Type  string `json:"type"`
+	OID   string `json:"oid"`
+	Value string `json:"value"`
+}
+
+// MarshalName encodes a distinguished name as a JSON object holding the
+// formatted name (as FormatDN) and its RDNs as lists of type, OID and value
+// objects, in encoded order. Unlike marshalling a pkix.Name, this keeps the
+// order of the attributes and those of unknown types, so the output is
+// stable and suitable for comparing names. The name is read from raw (such
+// as cert.RawSubject or cert.RawIssuer) if given, and otherwise from the
+// fields of name.
+func MarshalName(name pkix.Name, raw []byte) ([]byte, error) {
+	if len(raw) == 0 {
+		var err error
+		raw, err = asn1.Marshal(name.ToRDNSequence())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dn, err := FormatDN(raw)
+	if err != nil {
+		return nil, err
+	}
+	rdns, err := DNComponents(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	out := jsonName{DN: dn, RDNs: make([][]jsonNameAttribute, 0, len(rdns))}
+	for _, rdn := range rdns {
+		attributes := make([]jsonNameAttribute, 0, len(rdn))
+		for _, component := range rdn {
+			attributes = append(attributes, jsonNameAttribute{
+				Type:  component.Type,
+				OID:   component.OID.String(),
+				Value: component.Value,
+			})
+		}
+		out.RDNs = append(out.RDNs, attributes)
+	}
+	return json.Marshal(out)
+}
+
+func parseDN(raw []byte) ([]dnAttributeSET, error) {
+	var rdns []dnAttributeSET
+	rest, err := asn1.Unmarshal(raw, &rdns)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after distinguished name")
+	}
+	return rdns, nil
+}
+
+func dnAttributeType(oid asn1.ObjectIdentifier) string {
+	if short := oidShort(oid); short != "" {
+		return short
+	}
+	return oid.String()
+}
+
+// dnAttributeString decodes the string types used in names.
+func dnAttributeString(value asn1.RawValue) (string, bool) {
+	if value.Class != asn1.ClassUniversal {
+		return "", false
+	}
+	switch value.Tag {
+	case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String, asn1.TagT61String, 26 /* VisibleString */ :
+		return string(value.Bytes), true
+	case 30: // BMPString (UCS-2)
+		if len(value.Bytes)%2 == 0 {
+			chars := make([]uint16, len(value.Bytes)/2)
+			for i := range chars {
+				chars[i] = binary.BigEndian.Uint16(value.Bytes[2*i:])
+			}
+			return string(utf16.Decode(chars)), true
+		}
+	case 28: // UniversalString (UCS-4)
+		if len(value.Bytes)%4 == 0 {
+			chars := make([]rune, len(value.Bytes)/4)
+			for i := range chars {
+				chars[i] = rune(binary.BigEndian.Uint32(value.Bytes[4*i:]))
+			}
+			return string(chars), true
+		}
+	}
+	return "", false
+}
+
+// escapeDNValue escapes special characters in an attribute value, as
+// described in RFC 4514, section 2.4.
+func escapeDNValue(value string) string {
+	var out strings.Builder
+	for i, c := range value {
+		switch {
+		case c == ',' || c == '+' || c == '"' || c == '\\' || c == '<' || c == '>' || c == ';',
+			i == 0 && (c == '#' || c == ' '),
+			i == len(value)-1 && c == ' ':
+			out.WriteByte('\\')
+			out.WriteRune(c)
+		case c == 0:
+			out.WriteString("\\00")
+		default:
+			out.WriteRune(c)
+		}
+	}
+	return out.String()
+}
+
+// DNEqual compares two distinguished names after canonicalizing them, so
+// that names which differ only in string type, case, or surrounding or
+// repeated whitespace are considered equal. This is a simplified version of
+// the RFC 4518 string preparation (it doesn't do Unicode normalization),
+// which all attribute types commonly seen in certificates compare with. The
+// RDNs must be in the same order. Since pkix.Name doesn't keep track of
+// which attributes share a (multi-valued) RDN, each attribute is compared
+// as an RDN of its own here; RawDNEqual compares multi-valued RDNs
+// regardless of the order of their attributes.
+func DNEqual(a, b pkix.Name) bool {
+	return stringsEqual(canonicalDN(a), canonicalDN(b))
+}
+
+// RawDNEqual is like DNEqual, but for DER-encoded names (such as
+// cert.RawIssuer and cert.RawSubject), which keep the grouping of
+// attributes into RDNs. The attributes within a multi-valued RDN may be in
+// any order, as they form a set. Names that can't be parsed are only equal
+// if their encodings are.
+func RawDNEqual(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var rdnsA, rdnsB pkix.RDNSequence
+	if rest, err := asn1.Unmarshal(a, &rdnsA); err != nil || len(rest) > 0 {
+		return false
+	}
+	if rest, err := asn1.Unmarshal(b, &rdnsB); err != nil || len(rest) > 0 {
+		return false
+	}
+	return stringsEqual(canonicalRDNs(rdnsA), canonicalRDNs(rdnsB))
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalDN returns the canonicalized RDNs of a name, in order, with each
+// attribute as an RDN of its own (see DNEqual).
+func canonicalDN(name pkix.Name) []string {
+	if len(name.Names) == 0 {
+		// Not parsed from a certificate, so use the fields instead
+		return canonicalRDNs(name.ToRDNSequence())
+	}
+	var rdns pkix.RDNSequence
+	for _, attribute := range name.Names {
+		rdns = append(rdns, pkix.RelativeDistinguishedNameSET{attribute})
+	}
+	return canonicalRDNs(rdns)
+}
+
+// canonicalRawDN is canonicalDN for a DER-encoded name, keeping multi-valued
+// RDNs together. The parsed name is used if the encoding can't be parsed.
+func canonicalRawDN(raw []byte, name pkix.Name) []string {
+	var rdns pkix.RDNSequence
+	if rest, err := asn1.Unmarshal(raw, &rdns); err != nil || len(rest) > 0 {
+		return canonicalDN(name)
+	}
+	return canonicalRDNs(rdns)
+}
+
+// canonicalRDNs returns the canonicalized RDNs of a name, in order. The
+// attributes of a multi-valued RDN are sorted, and joined with "+".
+func canonicalRDNs(rdns pkix.RDNSequence) []string {
+	out := make([]string, len(rdns))
+	for i, rdn := range rdns {
+		attributes := make([]string, len(rdn))
+		for j, attribute := range rdn {
+			var value string
+			if s, ok := attribute.Value.(string); ok {
+				value = strings.ToLower(strings.Join(strings.Fields(s), " "))
+			} else {
+				value = fmt.Sprintf("#%v", attribute.Value)
+			}
+			attributes[j] = attribute.Type.String() + "=" + value
+		}
+		sort.Strings(attributes)
+		out[i] = strings.Join(attributes, "+")
+	}
+	return out
+}
+
+// HostnamesOf returns the hostnames the given certificate covers: its
+// subject common name, if it's a hostname, followed by its DNS SANs,
+// lowercased and without duplicates. IP addresses aren't included, see
+// IPsOf. Internationalized names are returned as is, in punycode (see
+// HostnamesWithUnicode).
+func HostnamesOf(cert *x509.Certificate) []string {
+	var names []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		name = strings.ToLower(name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	if cn := cert.Subject.CommonName; validDNSName(cn) && net.ParseIP(cn) == nil {
+		add(cn)
+	}
+	for _, name := range cert.DNSNames {
+		add(name)
+	}
+	return names
+}
+
+// HostnamesWithUnicode is like HostnamesOf, but each internationalized name
+// (with "xn--" labels) is followed by its Unicode form.
+func HostnamesWithUnicode(cert *x509.Certificate) []string {
+	var names []string
+	for _, name := range HostnamesOf(cert) {
+		names = append(names, name)
+		if !strings.Contains(name, "xn--") {
+			continue
+		}
+		if unicode, err := idna.ToUnicode(name); err == nil && unicode != name {
+			names = append(names, unicode)
+		}
+	}
+	return names
+}
+
+// IPsOf returns the IP address SANs of the given certificate, without
+// duplicates.
+func IPsOf(cert *x509.Certificate) []net.IP {
+	var ips []net.IP
+	seen := map[string]bool{}
+	for _, ip := range cert.IPAddresses {
+		if key := ip.String(); !seen[key] {
+			seen[key] = true
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,208 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestFormatDN(t *testing.T) {
+	name := pkix.RDNSequence{
+		{{Type: asn1.ObjectIdentifier{2, 5, 4, 6}, Value: "US"}},
+		{{Type: asn1.ObjectIdentifier{2, 5, 4, 10}, Value: "Example, Inc."}},
+		{
+			{Type: asn1.ObjectIdentifier{2, 5, 4, 11}, Value: "Dev"},
+			{Type: asn1.ObjectIdentifier{2, 5, 4, 11}, Value: "Ops"},
+		},
+		{{Type: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: "custom"}},
+		{{Type: asn1.ObjectIdentifier{1, 2, 3, 5}, Value: 42}},
+		{{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "example.com"}},
+	}
+	raw, err := asn1.Marshal(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formatted, err := FormatDN(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `C=US, O=Example\, Inc., OU=Dev+OU=Ops, 1.2.3.4=custom, 1.2.3.5=#02012a, CN=example.com`
+	if formatted != expected {
+		t.Errorf("unexpected DN:\n got: %s\nwant: %s", formatted, expected)
+	}
+
+	rdns, err := DNComponents(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rdns) != 6 || len(rdns[2]) != 2 {
+		t.Fatalf("unexpected RDNs: %v", rdns)
+	}
+	if c := rdns[3][0]; c.Type != "1.2.3.4" || !c.OID.Equal(asn1.ObjectIdentifier{1, 2, 3, 4}) || c.Value != "custom" {
+		t.Errorf("unexpected component: %+v", c)
+	}
+	if c := rdns[1][0]; c.Type != "O" || c.Value != "Example, Inc." {
+		t.Errorf("unexpected component: %+v", c)
+	}
+}
+
+func TestMarshalName(t *testing.T) {
+	raw, err := asn1.Marshal(pkix.RDNSequence{
+		{{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "example.com"}},
+		{{Type: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: "custom"}},
+		{{Type: asn1.ObjectIdentifier{2, 5, 4, 6}, Value: "US"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := MarshalName(pkix.Name{}, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"dn":"CN=example.com, 1.2.3.4=custom, C=US","rdns":[` +
+		`[{"type":"CN","oid":"2.5.4.3","value":"example.com"}],` +
+		`[{"type":"1.2.3.4","oid":"1.2.3.4","value":"custom"}],` +
+		`[{"type":"C","oid":"2.5.4.6","value":"US"}]]}`
+	if string(encoded) != expected {
+		t.Errorf("unexpected JSON:\n got: %s\nwant: %s", encoded, expected)
+	}
+
+	// Without the raw name, the fields are used
+	encoded, err = MarshalName(pkix.Name{CommonName: "example.com", Country: []string{"US"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"dn":"C=US, CN=example.com","rdns":[` +
+		`[{"type":"C","oid":"2.5.4.6","value":"US"}],` +
+		`[{"type":"CN","oid":"2.5.4.3","value":"example.com"}]]}`
+	if string(encoded) != expected {
+		t.Errorf("unexpected JSON:\n got: %s\nwant: %s", encoded, expected)
+	}
+}
+
+func TestDNEqual(t *testing.T) {
+	parsed := func(attributes ...pkix.AttributeTypeAndValue) pkix.Name {
+		var name pkix.Name
+		name.FillFromRDNSequence(&pkix.RDNSequence{attributes})
+		return name
+	}
+	cn := asn1.ObjectIdentifier{2, 5, 4, 3}
+	o := asn1.ObjectIdentifier{2, 5, 4, 10}
+
+	testCases := []struct {
+		a, b  pkix.Name
+		equal bool
+	}{
+		{pkix.Name{CommonName: "Example CA"}, pkix.Name{CommonName: "Example CA"}, true},
+		{pkix.Name{CommonName: "Example CA"}, pkix.Name{CommonName: "  example   ca "}, true},
+		{pkix.Name{CommonName: "Example CA"}, pkix.Name{CommonName: "Example CA 2"}, false},
+		{pkix.Name{CommonName: "Example CA"}, pkix.Name{CommonName: "Example CA", Organization: []string{"Example"}}, false},
+		{
+			parsed(pkix.AttributeTypeAndValue{Type: cn, Value: "Example CA"}, pkix.AttributeTypeAndValue{Type: o, Value: "Example"}),
+			parsed(pkix.AttributeTypeAndValue{Type: cn, Value: "example ca"}, pkix.AttributeTypeAndValue{Type: o, Value: "EXAMPLE"}),
+			true,
+		},
+		{
+			// RDN order matters
+			parsed(pkix.AttributeTypeAndValue{Type: cn, Value: "Example CA"}, pkix.AttributeTypeAndValue{Type: o, Value: "Example"}),
+			parsed(pkix.AttributeTypeAndValue{Type: o, Value: "Example"}, pkix.AttributeTypeAndValue{Type: cn, Value: "Example CA"}),
+			false,
+		},
+		{
+			parsed(pkix.AttributeTypeAndValue{Type: cn, Value: "Example CA"}),
+			parsed(pkix.AttributeTypeAndValue{Type: o, Value: "Example CA"}),
+			false,
+		},
+	}
+	for i, tc := range testCases {
+		if DNEqual(tc.a, tc.b) != tc.equal {
+			t.Errorf("case %d: expected DNEqual(%s, %s) to be %v", i, tc.a, tc.b, tc.equal)
+		}
+	}
+}
+
+func TestRawDNEqual(t *testing.T) {
+	encode := func(rdns ...[]pkix.AttributeTypeAndValue) []byte {
+		var sequence pkix.RDNSequence
+		for _, rdn := range rdns {
+			sequence = append(sequence, rdn)
+		}
+		raw, err := asn1.Marshal(sequence)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	cn := pkix.AttributeTypeAndValue{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "Example CA"}
+	cnLower := pkix.AttributeTypeAndValue{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "example  ca"}
+	o := pkix.AttributeTypeAndValue{Type: asn1.ObjectIdentifier{2, 5, 4, 10}, Value: "Example"}
+
+	testCases := []struct {
+		a, b  []byte
+		equal bool
+	}{
+		{encode([]pkix.AttributeTypeAndValue{cn}), encode([]pkix.AttributeTypeAndValue{cnLower}), true},
+		// Attributes within a multi-valued RDN form a set
+		{encode([]pkix.AttributeTypeAndValue{cn, o}), encode([]pkix.AttributeTypeAndValue{o, cnLower}), true},
+		// but the RDNs themselves are ordered
+		{encode([]pkix.AttributeTypeAndValue{cn}, []pkix.AttributeTypeAndValue{o}), encode([]pkix.AttributeTypeAndValue{o}, []pkix.AttributeTypeAndValue{cn}), false},
+		// and a multi-valued RDN isn't the same as separate RDNs
+		{encode([]pkix.AttributeTypeAndValue{cn, o}), encode([]pkix.AttributeTypeAndValue{cn}, []pkix.AttributeTypeAndValue{o}), false},
+		{[]byte("garbage"), encode([]pkix.AttributeTypeAndValue{cn}), false},
+		{[]byte("garbage"), []byte("garbage"), true},
+	}
+	for i, tc := range testCases {
+		if RawDNEqual(tc.a, tc.b) != tc.equal {
+			t.Errorf("case %d: expected RawDNEqual to be %v", i, tc.equal)
+		}
+	}
+}
+
+func TestHostnamesOf(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "WWW.Example.com"},
+		DNSNames:    []string{"www.example.com", "*.Example.com", "xn--bcher-kva.example", "example.com"},
+		IPAddresses: []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")},
+	}
+
+	expected := []string{"www.example.com", "*.example.com", "xn--bcher-kva.example", "example.com"}
+	if names := HostnamesOf(cert); !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected hostnames: %q", names)
+	}
+	expected = []string{"www.example.com", "*.example.com", "xn--bcher-kva.example", "bücher.example", "example.com"}
+	if names := HostnamesWithUnicode(cert); !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected hostnames with Unicode: %q", names)
+	}
+	if ips := IPsOf(cert); len(ips) != 2 || !ips[0].Equal(net.ParseIP("192.0.2.1")) || !ips[1].Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("unexpected IPs: %v", ips)
+	}
+
+	// Common names that aren't hostnames are left out
+	for _, cn := range []string{"Example CA", "192.0.2.1", ""} {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}, DNSNames: []string{"example.com"}}
+		if names := HostnamesOf(cert); !reflect.DeepEqual(names, []string{"example.com"}) {
+			t.Errorf("CN %q: unexpected hostnames: %q", cn, names)
+		}
+	}
+}
@@ -18,10 +18,14 @@ package lib
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -82,17 +86,19 @@ func checkOCSP(chain []*x509.Certificate, ocspStaple []byte) (status *ocsp.Respo
 		retries = 1
 	}
 
-	var issuer *x509.Certificate
 	for i := 0; i < retries; i++ {
 		encoded := ocspStaple
+		issuer := chain[1]
 		if len(encoded) == 0 {
-			encoded, _, err = fetchOCSP(chain)
+			encoded, issuer, err = fetchOCSP(chain)
 			if err != nil {
 				return nil, err
 			}
 		}
 
-		status, err = ocsp.ParseResponse(encoded, issuer)
+		// Check that the response is for this certificate, and signed by
+		// its issuer (or a responder delegated by the issuer).
+		status, err = ocsp.ParseResponseForCert(encoded, chain[0], issuer)
 		if err == nil {
 			break
 		}
@@ -101,6 +107,30 @@ func checkOCSP(chain []*x509.Certificate, ocspStaple []byte) (status *ocsp.Respo
 	return status, err
 }
 
+// CheckOCSPStaple checks the OCSP response stapled by a server (as found in
+// tls.ConnectionState.OCSPResponse) against the given chain, which must
+// start with the leaf followed by its issuer. Returns an error if there is
+// no staple, or if it isn't a valid and current response for the leaf. The
+// revocation status itself is in the returned response. VerifyChain uses
+// this for stapled responses, as passed by the connect command.
+func CheckOCSPStaple(chain []*x509.Certificate, staple []byte) (*ocsp.Response, error) {
+	if len(staple) == 0 {
+		return nil, errors.New("server did not staple an OCSP response")
+	}
+	if len(chain) < 2 {
+		return nil, errors.New("need the issuing certificate to check the OCSP staple")
+	}
+
+	resp, err := ocsp.ParseResponseForCert(staple, chain[0], chain[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCSP staple: %s", err)
+	}
+	if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(time.Now()) {
+		return resp, fmt.Errorf("stale OCSP staple (next update was due %s)", resp.NextUpdate.Format(time.RFC822))
+	}
+	return resp, nil
+}
+
 func fetchOCSP(chain []*x509.Certificate) ([]byte, *x509.Certificate, error) {
 	var lastError error
 	for _, issuer := range chain[1:] {
@@ -162,14 +192,13 @@ func fetchOCSP(chain []*x509.Certificate) ([]byte, *x509.Certificate, error) {
 }
 
 func buildOCSPwithPOST(server string, encoded []byte) (*http.Request, error) {
-	req, err := http.NewRequest("POST", server, nil)
+	req, err := http.NewRequest("POST", server, bytes.NewReader(encoded))
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Add("Content-Type", "application/ocsp-request")
 	req.Header.Add("Accept", "application/ocsp-response")
-	req.Write(bytes.NewBuffer(encoded))
 
 	return req, nil
 }
@@ -188,3 +217,130 @@ func buildOCSPwithGET(server string, encoded []byte) (*http.Request, error) {
 
 	return req, nil
 }
+
+// BuildOCSPRequest builds a DER-encoded OCSP request for the status of the
+// given certificate, as issued by the given issuer. The issuerNameHash and
+// issuerKeyHash identifying the issuer are computed with the given hash
+// (SHA-1, which nearly all responders expect, if zero).
+func BuildOCSPRequest(cert, issuer *x509.Certificate, hash crypto.Hash) ([]byte, error) {
+	return ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: hash})
+}
+
+// ParseOCSPRequest parses a DER-encoded OCSP request, as sent to a
+// responder.
+func ParseOCSPRequest(der []byte) (*ocsp.Request, error) {
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse OCSP request: %s", err)
+	}
+	return req, nil
+}
+
+// CheckOCSPRequest checks that an OCSP request asks for the status of the
+// given certificate: that the serial number matches, and the issuerNameHash
+// and issuerKeyHash match the issuer.
+func CheckOCSPRequest(req *ocsp.Request, cert, issuer *x509.Certificate) error {
+	if req.SerialNumber == nil || req.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return fmt.Errorf("OCSP request is for serial number %s, not %s", req.SerialNumber, cert.SerialNumber)
+	}
+	if !req.HashAlgorithm.Available() {
+		return errors.New("OCSP request uses an unsupported hash algorithm")
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return fmt.Errorf("unable to parse issuer's public key: %s", err)
+	}
+	h := req.HashAlgorithm.New()
+	h.Write(issuer.RawSubject)
+	if !bytes.Equal(h.Sum(nil), req.IssuerNameHash) {
+		return errors.New("OCSP request issuerNameHash doesn't match the issuer's name")
+	}
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	if !bytes.Equal(h.Sum(nil), req.IssuerKeyHash) {
+		return errors.New("OCSP request issuerKeyHash doesn't match the issuer's key")
+	}
+	return nil
+}
+
+// PostOCSPRequest sends a DER-encoded OCSP request (see BuildOCSPRequest)
+// to the given responder URL with an HTTP POST, and returns the raw
+// response, which can be parsed with ocsp.ParseResponseForCert.
+func PostOCSPRequest(server string, request []byte) ([]byte, error) {
+	req, err := buildOCSPwithPOST(server, request)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ocspHttpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error querying OCSP responder %s: %s", server, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error querying OCSP responder %s: unexpected status code, got: %s", server, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxURLBodySize))
+}
+
+// CheckOCSP checks the revocation status of the given certificate with the
+// OCSP responders listed in its authority information access extension:
+// it builds a request (see BuildOCSPRequest), posts it to each responder in
+// turn until one gives a valid response (signed by the issuer, or a
+// responder it delegated to), and returns that response. Requests time out
+// after a few seconds. As with CheckOCSPStaple, a response that is no
+// longer current is returned along with an error.
+func CheckOCSP(leaf, issuer *x509.Certificate) (*ocsp.Response, error) {
+	servers, _ := RevocationEndpoints(leaf)
+	if len(servers) == 0 {
+		return nil, errors.New("certificate doesn't list an OCSP responder")
+	}
+	request, err := BuildOCSPRequest(leaf, issuer, crypto.SHA1)
+	if err != nil {
+		return nil, fmt.Errorf("failure building request: %s", err)
+	}
+
+	var lastError error
+	for _, server := range servers {
+		body, err := PostOCSPRequest(server, request)
+		if err != nil {
+			lastError = err
+			continue
+		}
+		resp, err := ocsp.ParseResponseForCert(body, leaf, issuer)
+		if err != nil {
+			lastError = fmt.Errorf("invalid response from OCSP responder %s: %s", server, err)
+			continue
+		}
+		if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(time.Now()) {
+			return resp, fmt.Errorf("stale OCSP response from %s (next update was due %s)", server, resp.NextUpdate.Format(time.RFC822))
+		}
+		return resp, nil
+	}
+	return nil, lastError
+}
+
+// RevocationEndpoints returns the URLs the given certificate lists for
+// fetching revocation info: OCSP responders from the authority information
+// access extension, and CRLs from the CRL distribution points extension.
+// Blank and duplicate entries are dropped.
+func RevocationEndpoints(cert *x509.Certificate) (ocspServers, crlURLs []string) {
+	return cleanURLs(cert.OCSPServer), cleanURLs(cert.CRLDistributionPoints)
+}
+
+func cleanURLs(urls []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, url := range urls {
+		url = strings.TrimSpace(url)
+		if url == "" || seen[url] {
+			continue
+		}
+		seen[url] = true
+		out = append(out, url)
+	}
+	return out
+}
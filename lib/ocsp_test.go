@@ -0,0 +1,213 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPRequest(t *testing.T) {
+	rootKey, leafKey, otherKey := newTestKey(t), newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	other := issueTestCert(t, "root", otherKey, "root", otherKey)
+	leaf := issueTestCert(t, "leaf", leafKey, "root", rootKey)
+
+	for _, hash := range []crypto.Hash{0, crypto.SHA256} {
+		der, err := BuildOCSPRequest(leaf, root, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := ParseOCSPRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := hash
+		if expected == 0 {
+			expected = crypto.SHA1
+		}
+		if req.HashAlgorithm != expected {
+			t.Errorf("unexpected hash algorithm: %v", req.HashAlgorithm)
+		}
+		if err := CheckOCSPRequest(req, leaf, root); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		// Same name, different key
+		if err := CheckOCSPRequest(req, leaf, other); err == nil || !strings.Contains(err.Error(), "issuerKeyHash") {
+			t.Errorf("expected key hash mismatch, got: %v", err)
+		}
+		if err := CheckOCSPRequest(req, leaf, leaf); err == nil || !strings.Contains(err.Error(), "issuerNameHash") {
+			t.Errorf("expected name hash mismatch, got: %v", err)
+		}
+		req.SerialNumber = big.NewInt(2)
+		if err := CheckOCSPRequest(req, leaf, root); err == nil || !strings.Contains(err.Error(), "serial number") {
+			t.Errorf("expected serial number mismatch, got: %v", err)
+		}
+	}
+
+	if _, err := ParseOCSPRequest([]byte("not a request")); err == nil {
+		t.Error("expected error for malformed request")
+	}
+}
+
+func TestPostOCSPRequest(t *testing.T) {
+	key := newTestKey(t)
+	root := issueTestCert(t, "root", key, "root", key)
+	der, err := BuildOCSPRequest(root, root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/ocsp-request" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if _, err := ParseOCSPRequest(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("response"))
+	}))
+	defer server.Close()
+
+	resp, err := PostOCSPRequest(server.URL, der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "response" {
+		t.Errorf("unexpected response: %q", resp)
+	}
+	if _, err := PostOCSPRequest(server.URL, []byte("garbage")); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("expected error status, got: %v", err)
+	}
+}
+
+func TestCheckOCSP(t *testing.T) {
+	rootKey, leafKey := newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+
+	status := ocsp.Good
+	nextUpdate := time.Now().Add(time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := ocsp.CreateResponse(root, root, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Hour),
+			NextUpdate:   nextUpdate,
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, rootKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(resp)
+	}))
+	defer server.Close()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{"http://127.0.0.1:1/unreachable", server.URL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root, &leafKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := CheckOCSP(leaf, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != ocsp.Good || resp.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	status = ocsp.Revoked
+	if resp, err := CheckOCSP(leaf, root); err != nil || resp.Status != ocsp.Revoked {
+		t.Errorf("expected revoked status, got: %v (%v)", resp, err)
+	}
+
+	nextUpdate = time.Now().Add(-time.Minute)
+	if resp, err := CheckOCSP(leaf, root); resp == nil || err == nil || !strings.Contains(err.Error(), "stale") {
+		t.Errorf("expected stale response error, got: %v", err)
+	}
+
+	if _, err := CheckOCSP(root, root); err == nil {
+		t.Error("expected error for certificate without OCSP responder")
+	}
+}
+
+func TestRevocationEndpoints(t *testing.T) {
+	rootKey := newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(42),
+		Subject:               pkix.Name{CommonName: "leaf"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		OCSPServer:            []string{"http://ocsp.example.com", " http://ocsp.example.com ", ""},
+		CRLDistributionPoints: []string{"http://crl.example.com/a.crl", "http://crl.example.com/b.crl"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root, &newTestKey(t).PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ocspServers, crlURLs := RevocationEndpoints(leaf)
+	if !reflect.DeepEqual(ocspServers, []string{"http://ocsp.example.com"}) {
+		t.Errorf("unexpected OCSP servers: %q", ocspServers)
+	}
+	if !reflect.DeepEqual(crlURLs, template.CRLDistributionPoints) {
+		t.Errorf("unexpected CRL URLs: %q", crlURLs)
+	}
+
+	// Without AIA or CDP extensions
+	if ocspServers, crlURLs := RevocationEndpoints(root); ocspServers != nil || crlURLs != nil {
+		t.Errorf("unexpected endpoints: %q, %q", ocspServers, crlURLs)
+	}
+}
@@ -0,0 +1,75 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// PasswordFunc adapts a password callback that can't fail (as taken by
+// ReadAsPEM and the like) for the Password option. Returns nil for a nil
+// callback.
+func PasswordFunc(password func(string) string) func(string) (string, error) {
+	if password == nil {
+		return nil
+	}
+	return func(alias string) (string, error) {
+		return password(alias), nil
+	}
+}
+
+// PasswordFromMap returns a password callback, suitable for the Read
+// functions, that looks up passwords by alias in the given map. The empty
+// alias is used for the store-wide password (PKCS12 files, and the integrity
+// check on JCEKS key stores). Aliases missing from the map fall back to the
+// given default password.
+func PasswordFromMap(passwords map[string]string, defaultPassword string) func(string) (string, error) {
+	return func(alias string) (string, error) {
+		if password, ok := passwords[alias]; ok {
+			return password, nil
+		}
+		return defaultPassword, nil
+	}
+}
+
+// PasswordFromReader returns a password callback, suitable for the Read
+// functions, that returns the contents of the given reader (e.g. a password
+// file or secret mount) for any alias. The reader is read once, up front,
+// and a single trailing newline is removed. An empty input gives an empty
+// password, while an unreadable one makes the callback fail, aborting the
+// read.
+func PasswordFromReader(r io.Reader) func(string) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		err = fmt.Errorf("unable to read password: %s", err)
+	}
+	password := string(data)
+	if strings.HasSuffix(password, "\r\n") {
+		password = password[:len(password)-2]
+	} else {
+		password = strings.TrimSuffix(password, "\n")
+	}
+	return func(string) (string, error) {
+		if err != nil {
+			return "", err
+		}
+		return password, nil
+	}
+}
@@ -0,0 +1,107 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestPasswordFromMap(t *testing.T) {
+	password := PasswordFromMap(map[string]string{
+		"":          "store-password",
+		"key-alias": "key-password",
+		"no-pass":   "",
+	}, "default-password")
+	cases := map[string]string{
+		"":          "store-password",
+		"key-alias": "key-password",
+		"no-pass":   "",
+		"other":     "default-password",
+	}
+	for alias, expected := range cases {
+		if got, err := password(alias); got != expected || err != nil {
+			t.Errorf("for alias %q: got %q (%v), expected %q", alias, got, err, expected)
+		}
+	}
+
+	// Without a map, every alias gets the default
+	if got, err := PasswordFromMap(nil, "default-password")(""); got != "default-password" || err != nil {
+		t.Errorf("got %q (%v) from nil map, expected default", got, err)
+	}
+}
+
+func TestPasswordFromReader(t *testing.T) {
+	cases := map[string]string{
+		"":             "",
+		"secret":       "secret",
+		"secret\n":     "secret",
+		"secret\r\n":   "secret",
+		"secret\n\n":   "secret\n",
+		" spaced out ": " spaced out ",
+	}
+	for input, expected := range cases {
+		password := PasswordFromReader(strings.NewReader(input))
+		if got, err := password(""); got != expected || err != nil {
+			t.Errorf("for %q: got %q (%v), expected %q", input, got, err, expected)
+		}
+		if got, err := password("some-alias"); got != expected || err != nil {
+			t.Errorf("for %q with alias: got %q (%v), expected %q", input, got, err, expected)
+		}
+	}
+
+	password := PasswordFromReader(iotest.TimeoutReader(iotest.OneByteReader(strings.NewReader("secret"))))
+	if _, err := password(""); err == nil {
+		t.Error("expected error for unreadable input")
+	}
+}
+
+func TestPasswordError(t *testing.T) {
+	cancelled := errors.New("cancelled")
+	prompts := 0
+	opts := ReadOptions{
+		Password: func(string) (string, error) {
+			prompts++
+			return "", cancelled
+		},
+	}
+	for _, path := range []string{"testdata/password.p12", "../jceks/testdata/private-key.jceks"} {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		// The error aborts reading, rather than being collected with the
+		// errors of other inputs
+		prompts = 0
+		other := strings.NewReader(readTestFile(t, "../test-certs/example-leaf.crt"))
+		opts := opts
+		opts.CollectErrors = true
+		err = ReadPEMWithOptions([]io.Reader{file, other}, opts, func(block *pem.Block, format string) error {
+			return nil
+		})
+		if err != cancelled || prompts != 1 {
+			t.Errorf("%s: unexpected error %v after %d prompts", path, err, prompts)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// SPKIPin returns the pin of the given certificate's public key, as used by
+// HPKP and most pinning configs: the base64-encoded SHA-256 hash of the
+// SubjectPublicKeyInfo, prefixed with "sha256//". Unlike a fingerprint, it
+// stays the same when a certificate is renewed with the same key.
+func SPKIPin(cert *x509.Certificate) (string, error) {
+	if len(cert.RawSubjectPublicKeyInfo) == 0 {
+		return "", errors.New("certificate has no subject public key info")
+	}
+	return spkiPin(cert.RawSubjectPublicKeyInfo), nil
+}
+
+// SPKIPinFromPublicKey is like SPKIPin, but for a parsed public key.
+func SPKIPinFromPublicKey(key crypto.PublicKey) (string, error) {
+	spki, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal public key: %s", err)
+	}
+	return spkiPin(spki), nil
+}
+
+func spkiPin(spki []byte) string {
+	digest := sha256.Sum256(spki)
+	return "sha256//" + base64.StdEncoding.EncodeToString(digest[:])
+}
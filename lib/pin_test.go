@@ -0,0 +1,48 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"testing"
+)
+
+func TestSPKIPin(t *testing.T) {
+	cert, err := ParseCertificatePEM(readTestFile(t, "../test-certs/example-leaf.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// openssl x509 -pubkey -noout | openssl pkey -pubin -outform DER |
+	//   openssl dgst -sha256 -binary | base64
+	expected := "sha256//7z5sTlYl26Igphfbzg0oCWrvmzSurCK/rI1k2mOr6rE="
+
+	pin, err := SPKIPin(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin != expected {
+		t.Errorf("unexpected pin: %s", pin)
+	}
+
+	pin, err = SPKIPinFromPublicKey(cert.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin != expected {
+		t.Errorf("unexpected pin from public key: %s", pin)
+	}
+}
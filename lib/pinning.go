@@ -0,0 +1,256 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/tls"
+	"encoding/asn1"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// oidExtensionSCTList is the extension holding the signed certificate
+// timestamps embedded in a certificate (RFC 6962).
+var oidExtensionSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// PinningPolicy describes the key pinning (HPKP, RFC 7469) and Expect-CT
+// policies an HTTPS server announces in its response headers, cross-checked
+// against the chain it presented.
+type PinningPolicy struct {
+	PublicKeyPins *PublicKeyPins `json:"public_key_pins,omitempty"`
+	ExpectCT      *ExpectCT      `json:"expect_ct,omitempty"`
+}
+
+// PublicKeyPins is a parsed Public-Key-Pins (or Public-Key-Pins-Report-Only)
+// header.
+type PublicKeyPins struct {
+	ReportOnly        bool     `json:"report_only"`
+	Pins              []string `json:"pins"`
+	MaxAge            int64    `json:"max_age"`
+	IncludeSubdomains bool     `json:"include_subdomains"`
+	ReportURI         string   `json:"report_uri,omitempty"`
+
+	// MatchingPins are the pins that match a key in the presented chain
+	// (see SPKIPin), and BackupPins the ones that don't.
+	MatchingPins []string `json:"matching_pins"`
+	BackupPins   []string `json:"backup_pins"`
+}
+
+// Consistent checks if the pins are consistent with the presented chain:
+// at least one of them must match a key in the chain, and at least one must
+// be a backup pin that doesn't, as RFC 7469 requires.
+func (p *PublicKeyPins) Consistent() bool {
+	return len(p.MatchingPins) > 0 && len(p.BackupPins) > 0
+}
+
+// ExpectCT is a parsed Expect-CT header.
+type ExpectCT struct {
+	MaxAge    int64  `json:"max_age"`
+	Enforce   bool   `json:"enforce"`
+	ReportURI string `json:"report_uri,omitempty"`
+
+	// HasSCTs is whether the server provided signed certificate timestamps,
+	// either in the TLS handshake or embedded in the leaf certificate. SCTs
+	// in stapled OCSP responses aren't checked.
+	HasSCTs bool `json:"has_scts"`
+}
+
+// ParsePinningPolicy parses the HPKP and Expect-CT headers of an HTTPS
+// response, and checks them against the connection the response came over.
+// It returns nil if the response has neither.
+func ParsePinningPolicy(header http.Header, state *tls.ConnectionState) (*PinningPolicy, error) {
+	policy := &PinningPolicy{}
+
+	value, reportOnly := header.Get("Public-Key-Pins"), false
+	if value == "" {
+		value, reportOnly = header.Get("Public-Key-Pins-Report-Only"), true
+	}
+	if value != "" {
+		pins := &PublicKeyPins{ReportOnly: reportOnly}
+		for _, directive := range parseDirectives(value, ';') {
+			switch directive.name {
+			case "pin-sha256":
+				pins.Pins = append(pins.Pins, "sha256//"+directive.value)
+			case "max-age":
+				maxAge, err := strconv.ParseInt(directive.value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid max-age in Public-Key-Pins header: %q", directive.value)
+				}
+				pins.MaxAge = maxAge
+			case "includesubdomains":
+				pins.IncludeSubdomains = true
+			case "report-uri":
+				pins.ReportURI = directive.value
+			}
+		}
+		presented := map[string]bool{}
+		for _, cert := range state.PeerCertificates {
+			if pin, err := SPKIPin(cert); err == nil {
+				presented[pin] = true
+			}
+		}
+		for _, pin := range pins.Pins {
+			if presented[pin] {
+				pins.MatchingPins = append(pins.MatchingPins, pin)
+			} else {
+				pins.BackupPins = append(pins.BackupPins, pin)
+			}
+		}
+		policy.PublicKeyPins = pins
+	}
+
+	if value := header.Get("Expect-CT"); value != "" {
+		expectCT := &ExpectCT{HasSCTs: len(state.SignedCertificateTimestamps) > 0}
+		for _, directive := range parseDirectives(value, ',') {
+			switch directive.name {
+			case "max-age":
+				maxAge, err := strconv.ParseInt(directive.value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid max-age in Expect-CT header: %q", directive.value)
+				}
+				expectCT.MaxAge = maxAge
+			case "enforce":
+				expectCT.Enforce = true
+			case "report-uri":
+				expectCT.ReportURI = directive.value
+			}
+		}
+		if len(state.PeerCertificates) > 0 {
+			for _, ext := range state.PeerCertificates[0].Extensions {
+				if ext.Id.Equal(oidExtensionSCTList) {
+					expectCT.HasSCTs = true
+				}
+			}
+		}
+		policy.ExpectCT = expectCT
+	}
+
+	if policy.PublicKeyPins == nil && policy.ExpectCT == nil {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// FetchPinningPolicy makes an HTTPS request to the given server (host with
+// optional port) and returns the pinning policy from the response headers,
+// as in ParsePinningPolicy. The server's certificate isn't verified, and
+// redirects aren't followed, since the policy of the server itself is
+// wanted. The name overrides the one used for SNI, if set. If a proxy is
+// given, the request is made through it (with CONNECT).
+func FetchPinningPolicy(connectTo, name string, proxy *url.URL, timeout time.Duration) (*PinningPolicy, error) {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(proxy),
+			TLSClientConfig: &tls.Config{
+				ServerName:         name,
+				InsecureSkipVerify: true,
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	url := "https://" + connectTo + "/"
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %s", url, err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil {
+		return nil, fmt.Errorf("error fetching %s: no TLS connection state", url)
+	}
+	return ParsePinningPolicy(resp.Header, resp.TLS)
+}
+
+// EncodePinningPolicyToText describes a pinning policy for humans.
+func EncodePinningPolicyToText(policy *PinningPolicy) string {
+	if policy == nil {
+		return "No key pinning or Expect-CT headers.\n"
+	}
+
+	var out strings.Builder
+	if pins := policy.PublicKeyPins; pins != nil {
+		header := "Public-Key-Pins"
+		if pins.ReportOnly {
+			header += "-Report-Only"
+		}
+		fmt.Fprintf(&out, "%s: max-age %d", header, pins.MaxAge)
+		if pins.IncludeSubdomains {
+			out.WriteString(", includes subdomains")
+		}
+		out.WriteString("\n")
+		for _, pin := range pins.MatchingPins {
+			fmt.Fprintf(&out, "  %s (matches chain)\n", pin)
+		}
+		for _, pin := range pins.BackupPins {
+			fmt.Fprintf(&out, "  %s (backup)\n", pin)
+		}
+		switch {
+		case len(pins.MatchingPins) == 0:
+			out.WriteString("  Warning: no pin matches a key in the presented chain\n")
+		case len(pins.BackupPins) == 0:
+			out.WriteString("  Warning: no backup pin\n")
+		}
+	}
+	if expectCT := policy.ExpectCT; expectCT != nil {
+		fmt.Fprintf(&out, "Expect-CT: max-age %d", expectCT.MaxAge)
+		if expectCT.Enforce {
+			out.WriteString(", enforced")
+		}
+		out.WriteString("\n")
+		if !expectCT.HasSCTs {
+			out.WriteString("  Warning: no signed certificate timestamps presented\n")
+		}
+	}
+	return out.String()
+}
+
+// directive is a name (lowercased) and optional value in an HTTP header
+// such as Public-Key-Pins.
+type directive struct {
+	name, value string
+}
+
+// parseDirectives splits a header value into directives at the given
+// separator, removing quotes around values.
+func parseDirectives(value string, sep byte) []directive {
+	var directives []directive
+	for _, part := range strings.Split(value, string(sep)) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var d directive
+		if eq := strings.IndexByte(part, '='); eq >= 0 {
+			d.name, d.value = part[:eq], strings.TrimSpace(part[eq+1:])
+			if unquoted, err := strconv.Unquote(d.value); err == nil {
+				d.value = unquoted
+			}
+		} else {
+			d.name = part
+		}
+		d.name = strings.ToLower(strings.TrimSpace(d.name))
+		directives = append(directives, d)
+	}
+	return directives
+}
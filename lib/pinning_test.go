@@ -0,0 +1,127 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchPinningPolicy(t *testing.T) {
+	var header http.Header
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+	}))
+	defer server.Close()
+
+	pin, err := SPKIPin(server.Certificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := "sha256//AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	connectTo := strings.TrimPrefix(server.URL, "https://")
+
+	header = http.Header{
+		"Public-Key-Pins": {`pin-sha256="` + strings.TrimPrefix(pin, "sha256//") + `"; pin-sha256="` + strings.TrimPrefix(backup, "sha256//") + `"; max-age=5184000; includeSubDomains`},
+		"Expect-Ct":       {`max-age=86400, enforce, report-uri="https://example.com/report"`},
+	}
+	policy, err := FetchPinningPolicy(connectTo, "example.com", nil, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins := policy.PublicKeyPins
+	if pins == nil || pins.ReportOnly || pins.MaxAge != 5184000 || !pins.IncludeSubdomains || !pins.Consistent() {
+		t.Fatalf("unexpected pins: %+v", pins)
+	}
+	if len(pins.MatchingPins) != 1 || pins.MatchingPins[0] != pin || len(pins.BackupPins) != 1 || pins.BackupPins[0] != backup {
+		t.Errorf("unexpected pin matches: %+v", pins)
+	}
+	expectCT := policy.ExpectCT
+	if expectCT == nil || expectCT.MaxAge != 86400 || !expectCT.Enforce || expectCT.ReportURI != "https://example.com/report" || expectCT.HasSCTs {
+		t.Errorf("unexpected Expect-CT: %+v", expectCT)
+	}
+	if text := EncodePinningPolicyToText(policy); !strings.Contains(text, "no signed certificate timestamps") {
+		t.Errorf("missing SCT warning in:\n%s", text)
+	}
+
+	header = http.Header{"Public-Key-Pins-Report-Only": {`pin-sha256="` + strings.TrimPrefix(backup, "sha256//") + `"; max-age=60`}}
+	policy, err = FetchPinningPolicy(connectTo, "", nil, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pins := policy.PublicKeyPins; pins == nil || !pins.ReportOnly || pins.Consistent() || policy.ExpectCT != nil {
+		t.Errorf("unexpected policy: %+v", policy)
+	}
+
+	header = nil
+	policy, err = FetchPinningPolicy(connectTo, "", nil, 5*time.Second)
+	if err != nil || policy != nil {
+		t.Errorf("expected no policy, got: %+v, %v", policy, err)
+	}
+
+	// Through a CONNECT proxy
+	var tunneled []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		tunneled = append(tunneled, r.Host)
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header = http.Header{"Expect-Ct": {`max-age=60`}}
+	policy, err = FetchPinningPolicy(connectTo, "", proxyURL, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy == nil || policy.ExpectCT == nil || policy.ExpectCT.MaxAge != 60 {
+		t.Errorf("unexpected policy: %+v", policy)
+	}
+	if len(tunneled) != 1 || tunneled[0] != connectTo {
+		t.Errorf("expected request through proxy, got tunnels: %v", tunneled)
+	}
+}
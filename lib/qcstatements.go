@@ -0,0 +1,254 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	// Qualified certificate statements, see RFC 3739 and ETSI EN 319 412-5.
+	oidExtensionQCStatements = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 3}
+	oidQCSyntaxV2            = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 11, 2}
+	oidQCCompliance          = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 1}
+	oidQCLimitValue          = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 2}
+	oidQCRetentionPeriod     = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 3}
+	oidQCSSCD                = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 4}
+	oidQCPDS                 = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 5}
+	oidQCType                = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 6}
+	oidQCLegislation         = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 7}
+
+	// PSD2 statement, see ETSI TS 119 495.
+	oidQCPSD2 = asn1.ObjectIdentifier{0, 4, 0, 19495, 2}
+)
+
+// qcStatementNames are the names of the known QC statements.
+var qcStatementNames = []struct {
+	oid  asn1.ObjectIdentifier
+	name string
+}{
+	{oidQCSyntaxV2, "QcSyntax-v2"},
+	{oidQCCompliance, "QcCompliance"},
+	{oidQCLimitValue, "QcLimitValue"},
+	{oidQCRetentionPeriod, "QcRetentionPeriod"},
+	{oidQCSSCD, "QcSSCD"},
+	{oidQCPDS, "QcPDS"},
+	{oidQCType, "QcType"},
+	{oidQCLegislation, "QcCClegislation"},
+	{oidQCPSD2, "PSD2"},
+}
+
+// qcTypeNames are the names of the QcType values: certificates for
+// electronic signatures, electronic seals and website authentication (QWAC).
+var qcTypeNames = map[string]string{
+	"0.4.0.1862.1.6.1": "esign",
+	"0.4.0.1862.1.6.2": "eseal",
+	"0.4.0.1862.1.6.3": "web",
+}
+
+// QCStatement is a single statement in the qcStatements extension.
+type QCStatement struct {
+	ID asn1.ObjectIdentifier
+
+	// Name is the name of the statement (e.g. "QcCompliance"), or empty if
+	// it isn't known.
+	Name string
+
+	// Info is the DER encoding of the statement info, if any.
+	Info []byte
+}
+
+// QCStatements describes the qcStatements extension of a (European)
+// qualified certificate, as used for eIDAS and PSD2. Statements holds all
+// the statements, in order, and the other fields the decoded values of
+// those that are known.
+type QCStatements struct {
+	Statements []QCStatement
+
+	// Compliance is set for EU qualified certificates, and SSCD if the
+	// private key is on a qualified signature creation device.
+	Compliance bool
+	SSCD       bool
+
+	// Types are the kinds of qualified certificate ("esign", "eseal" or
+	// "web"), or dotted OIDs for unknown kinds.
+	Types []string
+
+	// Legislation are the countries (ISO 3166 codes) under whose law the
+	// certificate is qualified, if not the EU.
+	Legislation []string
+
+	// RetentionPeriod is how many years after expiry the registration
+	// information is kept, if given.
+	RetentionPeriod int
+
+	// LimitValue is the limit on transactions the certificate may be
+	// used for, if any.
+	LimitValue *QCLimitValue
+
+	PDSLocations []PDSLocation
+	PSD2         *PSD2Statement
+}
+
+// QCLimitValue is a monetary limit, Amount * 10^Exponent in Currency (an
+// ISO 4217 code, alphabetic or numeric).
+type QCLimitValue struct {
+	Currency string
+	Amount   int
+	Exponent int
+}
+
+// PDSLocation is where to find the PKI disclosure statement, in a language.
+type PDSLocation struct {
+	URL      string
+	Language string
+}
+
+// PSD2Statement gives the roles of a payment service provider, and the
+// national competent authority (NCA) that authorized it.
+type PSD2Statement struct {
+	Roles   []PSD2Role
+	NCAName string
+	NCAID   string
+}
+
+// PSD2Role is a role of a payment service provider, e.g. PSP_AS (account
+// servicing) or PSP_PI (payment initiation).
+type PSD2Role struct {
+	OID  asn1.ObjectIdentifier
+	Name string
+}
+
+type qcStatement struct {
+	ID   asn1.ObjectIdentifier
+	Info asn1.RawValue `asn1:"optional"`
+}
+
+type monetaryValue struct {
+	Currency asn1.RawValue
+	Amount   int
+	Exponent int
+}
+
+type pdsLocation struct {
+	URL      string `asn1:"ia5"`
+	Language string `asn1:"printable"`
+}
+
+type psd2QcType struct {
+	Roles   []psd2Role
+	NCAName string `asn1:"utf8"`
+	NCAID   string `asn1:"utf8"`
+}
+
+type psd2Role struct {
+	OID  asn1.ObjectIdentifier
+	Name string `asn1:"utf8"`
+}
+
+// QCStatementsOf decodes the qcStatements extension (RFC 3739) of the given
+// certificate, including the ETSI EN 319 412-5 statements of EU qualified
+// certificates and the PSD2 statement of ETSI TS 119 495. Returns nil if the
+// extension isn't present.
+func QCStatementsOf(cert *x509.Certificate) (*QCStatements, error) {
+	value, _, found := ExtensionByOID(cert, oidExtensionQCStatements)
+	if !found {
+		return nil, nil
+	}
+
+	var statements []qcStatement
+	if rest, err := asn1.Unmarshal(value, &statements); err != nil || len(rest) > 0 {
+		return nil, errors.New("invalid qcStatements extension")
+	}
+
+	out := &QCStatements{Statements: []QCStatement{}}
+	for _, statement := range statements {
+		name := ""
+		for _, known := range qcStatementNames {
+			if statement.ID.Equal(known.oid) {
+				name = known.name
+			}
+		}
+		out.Statements = append(out.Statements, QCStatement{ID: statement.ID, Name: name, Info: statement.Info.FullBytes})
+		if err := out.decode(statement); err != nil {
+			return nil, fmt.Errorf("invalid %s statement: %s", name, err)
+		}
+	}
+	return out, nil
+}
+
+// decode fills in the fields for a known statement.
+func (s *QCStatements) decode(statement qcStatement) error {
+	info := statement.Info.FullBytes
+	var err error
+	switch {
+	case statement.ID.Equal(oidQCCompliance):
+		s.Compliance = true
+	case statement.ID.Equal(oidQCSSCD):
+		s.SSCD = true
+	case statement.ID.Equal(oidQCType):
+		var types []asn1.ObjectIdentifier
+		if _, err = asn1.Unmarshal(info, &types); err == nil {
+			for _, typ := range types {
+				if name, ok := qcTypeNames[typ.String()]; ok {
+					s.Types = append(s.Types, name)
+				} else {
+					s.Types = append(s.Types, typ.String())
+				}
+			}
+		}
+	case statement.ID.Equal(oidQCLegislation):
+		_, err = asn1.Unmarshal(info, &s.Legislation)
+	case statement.ID.Equal(oidQCRetentionPeriod):
+		_, err = asn1.Unmarshal(info, &s.RetentionPeriod)
+	case statement.ID.Equal(oidQCLimitValue):
+		var limit monetaryValue
+		if _, err = asn1.Unmarshal(info, &limit); err == nil {
+			s.LimitValue = &QCLimitValue{Amount: limit.Amount, Exponent: limit.Exponent}
+			switch limit.Currency.Tag {
+			case asn1.TagPrintableString:
+				s.LimitValue.Currency = string(limit.Currency.Bytes)
+			case asn1.TagInteger:
+				var code int
+				_, err = asn1.Unmarshal(limit.Currency.FullBytes, &code)
+				s.LimitValue.Currency = strconv.Itoa(code)
+			default:
+				err = errors.New("bad currency code")
+			}
+		}
+	case statement.ID.Equal(oidQCPDS):
+		var locations []pdsLocation
+		if _, err = asn1.Unmarshal(info, &locations); err == nil {
+			for _, location := range locations {
+				s.PDSLocations = append(s.PDSLocations, PDSLocation{URL: location.URL, Language: location.Language})
+			}
+		}
+	case statement.ID.Equal(oidQCPSD2):
+		var psd2 psd2QcType
+		if _, err = asn1.Unmarshal(info, &psd2); err == nil {
+			s.PSD2 = &PSD2Statement{NCAName: psd2.NCAName, NCAID: psd2.NCAID}
+			for _, role := range psd2.Roles {
+				s.PSD2.Roles = append(s.PSD2.Roles, PSD2Role{OID: role.OID, Name: role.Name})
+			}
+		}
+	}
+	return err
+}
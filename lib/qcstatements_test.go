@@ -0,0 +1,88 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"reflect"
+	"testing"
+)
+
+func TestQCStatementsOf(t *testing.T) {
+	info := func(value interface{}) asn1.RawValue {
+		der, err := asn1.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var raw asn1.RawValue
+		if _, err := asn1.Unmarshal(der, &raw); err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	unknown := asn1.ObjectIdentifier{1, 2, 3, 4}
+	pspAS := asn1.ObjectIdentifier{0, 4, 0, 19495, 1, 1}
+	cert := selfSignTestCert(t, &x509.Certificate{
+		ExtraExtensions: []pkix.Extension{
+			marshalExtension(t, oidExtensionQCStatements, []qcStatement{
+				{ID: oidQCCompliance},
+				{ID: oidQCType, Info: info([]asn1.ObjectIdentifier{{0, 4, 0, 1862, 1, 6, 3}})},
+				{ID: oidQCRetentionPeriod, Info: info(15)},
+				{ID: oidQCPDS, Info: info([]pdsLocation{{URL: "https://example.com/pds", Language: "en"}})},
+				{ID: oidQCLimitValue, Info: info(monetaryValue{Currency: info(asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte("EUR")}), Amount: 5, Exponent: 3})},
+				{ID: oidQCPSD2, Info: info(psd2QcType{
+					Roles:   []psd2Role{{OID: pspAS, Name: "PSP_AS"}},
+					NCAName: "Financial Conduct Authority",
+					NCAID:   "GB-FCA",
+				})},
+				{ID: unknown, Info: info(true)},
+			}),
+		},
+	})
+
+	statements, err := QCStatementsOf(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statements.Statements) != 7 || statements.Statements[0].Name != "QcCompliance" || statements.Statements[6].Name != "" || !statements.Statements[6].ID.Equal(unknown) {
+		t.Errorf("unexpected statements: %+v", statements.Statements)
+	}
+	if !statements.Compliance || statements.SSCD || !reflect.DeepEqual(statements.Types, []string{"web"}) || statements.RetentionPeriod != 15 {
+		t.Errorf("unexpected statements: %+v", statements)
+	}
+	if !reflect.DeepEqual(statements.PDSLocations, []PDSLocation{{URL: "https://example.com/pds", Language: "en"}}) {
+		t.Errorf("unexpected PDS locations: %+v", statements.PDSLocations)
+	}
+	if !reflect.DeepEqual(statements.LimitValue, &QCLimitValue{Currency: "EUR", Amount: 5, Exponent: 3}) {
+		t.Errorf("unexpected limit value: %+v", statements.LimitValue)
+	}
+	expected := &PSD2Statement{
+		Roles:   []PSD2Role{{OID: pspAS, Name: "PSP_AS"}},
+		NCAName: "Financial Conduct Authority",
+		NCAID:   "GB-FCA",
+	}
+	if !reflect.DeepEqual(statements.PSD2, expected) {
+		t.Errorf("unexpected PSD2 statement: %+v", statements.PSD2)
+	}
+
+	plain := selfSignTestCert(t, &x509.Certificate{})
+	if statements, err := QCStatementsOf(plain); statements != nil || err != nil {
+		t.Errorf("unexpected statements for plain certificate: %v, %v", statements, err)
+	}
+}
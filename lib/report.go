@@ -0,0 +1,297 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Validity statuses of a certificate in a CertReport.
+const (
+	StatusValid       = "valid"
+	StatusExpired     = "expired"
+	StatusNotYetValid = "not yet valid"
+)
+
+// Validity describes where a point in time lies relative to the validity
+// period of a certificate, see ValidityStatus.
+type Validity struct {
+	// Status is StatusValid, StatusExpired or StatusNotYetValid.
+	Status string
+
+	// SinceNotBefore is how long ago the certificate became valid
+	// (negative if that's in the future), and UntilNotAfter how long until
+	// it expires (negative if it has expired).
+	SinceNotBefore time.Duration
+	UntilNotAfter  time.Duration
+
+	// WithinSkew is set if the certificate is only considered valid
+	// thanks to the allowed clock skew.
+	WithinSkew bool
+}
+
+// ValidityStatus checks the validity of the given certificate at the given
+// time, allowing for the given clock skew at either end of the validity
+// period. This helps to explain errors caused by clocks that are off.
+func ValidityStatus(cert *x509.Certificate, now time.Time, skew time.Duration) Validity {
+	validity := Validity{
+		Status:         StatusValid,
+		SinceNotBefore: now.Sub(cert.NotBefore),
+		UntilNotAfter:  cert.NotAfter.Sub(now),
+	}
+	switch {
+	case now.Add(skew).Before(cert.NotBefore):
+		validity.Status = StatusNotYetValid
+	case now.Add(-skew).After(cert.NotAfter):
+		validity.Status = StatusExpired
+	default:
+		validity.WithinSkew = now.Before(cert.NotBefore) || now.After(cert.NotAfter)
+	}
+	return validity
+}
+
+func (v Validity) String() string {
+	var out string
+	switch {
+	case v.SinceNotBefore < 0:
+		out = fmt.Sprintf("%s, becomes valid in %s", v.Status, -v.SinceNotBefore)
+	case v.UntilNotAfter < 0 && v.Status == StatusExpired:
+		out = fmt.Sprintf("expired %s ago", -v.UntilNotAfter)
+	case v.UntilNotAfter < 0:
+		out = fmt.Sprintf("%s, expired %s ago", v.Status, -v.UntilNotAfter)
+	default:
+		out = fmt.Sprintf("%s, expires in %s", v.Status, v.UntilNotAfter)
+	}
+	if v.WithinSkew {
+		out += " (within allowed clock skew)"
+	}
+	return out
+}
+
+// ExpiryEntry is how long a certificate remains valid, see ExpiryReport.
+type ExpiryEntry struct {
+	Certificate       *x509.Certificate `json:"-"`
+	Subject           string            `json:"subject"`
+	SHA256Fingerprint string            `json:"sha256_fingerprint"`
+	NotAfter          time.Time         `json:"not_after"`
+
+	// Remaining is the time until the certificate expires, negative if
+	// it has expired.
+	Remaining time.Duration `json:"remaining"`
+}
+
+// Days returns the remaining time in whole days, rounded down (so a
+// certificate that expired an hour ago has -1 days left).
+func (e ExpiryEntry) Days() int {
+	return int(math.Floor(e.Remaining.Hours() / 24))
+}
+
+// ExpiryReport lists how long each of the given certificates remains valid
+// at the given time, soonest to expire first. Certificates expiring at the
+// same time keep their order.
+func ExpiryReport(certs []*x509.Certificate, now time.Time) []ExpiryEntry {
+	entries := make([]ExpiryEntry, len(certs))
+	for i, cert := range certs {
+		fingerprint := sha256.Sum256(cert.Raw)
+		entries[i] = ExpiryEntry{
+			Certificate:       cert,
+			Subject:           cert.Subject.String(),
+			SHA256Fingerprint: hexify(fingerprint[:]),
+			NotAfter:          cert.NotAfter,
+			Remaining:         ValidityStatus(cert, now, 0).UntilNotAfter,
+		}
+		if subject, err := FormatDN(cert.RawSubject); err == nil {
+			entries[i].Subject = subject
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Remaining < entries[j].Remaining
+	})
+	return entries
+}
+
+// ReportOptions configures the verification done by ReportFor.
+type ReportOptions struct {
+	// Intermediates are used to build the chain of the certificate.
+	Intermediates []*x509.Certificate
+
+	// Roots are the trust anchors to verify against (the system roots,
+	// if nil).
+	Roots *x509.CertPool
+
+	// Host is the name to verify the certificate against, if not empty.
+	Host string
+
+	// Usage is the extended key usage the certificate must be valid for.
+	// The zero value, x509.ExtKeyUsageAny, accepts any usage.
+	Usage x509.ExtKeyUsage
+
+	// At is the time to check validity at (now, if zero).
+	At time.Time
+
+	// MaxValidityDays is the longest validity period, in days, allowed for
+	// TLS server certificates (see LintMaxValidity). If zero, the
+	// CA/Browser Forum limit at the time the certificate was issued is
+	// used; if negative, the period isn't checked.
+	MaxValidityDays int
+}
+
+// CertReport bundles what certigo can tell about a certificate: its
+// identity, key, validity, warnings and whether it verifies.
+type CertReport struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	SHA1Fingerprint    string    `json:"sha1_fingerprint"`
+	SHA256Fingerprint  string    `json:"sha256_fingerprint"`
+	SPKIPin            string    `json:"spki_pin,omitempty"`
+	KeyAlgorithm       string    `json:"key_algorithm,omitempty"`
+	KeySize            int       `json:"key_size,omitempty"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+	WeakSignature      bool      `json:"weak_signature"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	Status             string    `json:"status"`
+	Warnings           []string  `json:"warnings,omitempty"`
+	Valid              bool      `json:"valid"`
+	VerifyError        string    `json:"verify_error,omitempty"`
+}
+
+// ReportFor builds a report for the given certificate, verifying it as
+// configured by the options (see IsValidForWithRoots).
+func ReportFor(cert *x509.Certificate, opts ReportOptions) *CertReport {
+	at := opts.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	sha1Fingerprint := sha1.Sum(cert.Raw)
+	sha256Fingerprint := sha256.Sum256(cert.Raw)
+	report := &CertReport{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       cert.SerialNumber.String(),
+		SHA1Fingerprint:    hexify(sha1Fingerprint[:]),
+		SHA256Fingerprint:  hexify(sha256Fingerprint[:]),
+		SignatureAlgorithm: algString(cert.SignatureAlgorithm),
+		WeakSignature:      IsWeakSignature(cert),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+	}
+	if subject, err := FormatDN(cert.RawSubject); err == nil {
+		report.Subject = subject
+	}
+	if issuer, err := FormatDN(cert.RawIssuer); err == nil {
+		report.Issuer = issuer
+	}
+	if pin, err := SPKIPin(cert); err == nil {
+		report.SPKIPin = pin
+	}
+	report.KeyAlgorithm, report.KeySize = decodeKey(cert.PublicKey)
+
+	report.Status = ValidityStatus(cert, at, 0).Status
+
+	var uriNames []string
+	for _, uri := range cert.URIs {
+		uriNames = append(uriNames, uri.String())
+	}
+	report.Warnings = append(report.Warnings, certWarnings(cert, uriNames)...)
+	report.Warnings = append(report.Warnings, LintSANs(cert)...)
+	report.Warnings = append(report.Warnings, LintExtensions(cert)...)
+	report.Warnings = append(report.Warnings, LintCAConsistency(cert)...)
+	maxDays := opts.MaxValidityDays
+	if maxDays == 0 {
+		maxDays = defaultMaxServerCertDays(cert)
+	}
+	report.Warnings = append(report.Warnings, lintValidity(cert, maxDays)...)
+
+	report.Valid, report.VerifyError = IsValidForWithRoots(cert, opts.Intermediates, opts.Host, opts.Usage, at, opts.Roots)
+	return report
+}
+
+// ChainReport bundles the reports for a certificate chain (leaf first), and
+// for chains from a TLS connection, the negotiated parameters.
+type ChainReport struct {
+	TLS          *TLSDescription `json:"tls,omitempty"`
+	ServerName   string          `json:"server_name,omitempty"`
+	Certificates []*CertReport   `json:"certificates"`
+
+	// Valid and VerifyError tell whether the leaf verifies, using the
+	// rest of the chain as intermediates.
+	Valid       bool   `json:"valid"`
+	VerifyError string `json:"verify_error,omitempty"`
+
+	// Warnings are problems with the chain as a whole, such as a weak
+	// signature somewhere up the chain (see LintChainSignatures).
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ReportChain builds reports for the given chain, leaf first. The rest of
+// the chain is used as intermediates (in addition to those in the options),
+// and the host name (if any) only applies to the leaf.
+func ReportChain(chain []*x509.Certificate, opts ReportOptions) *ChainReport {
+	report := &ChainReport{Certificates: []*CertReport{}}
+	if len(chain) == 0 {
+		report.VerifyError = "no certificates"
+		return report
+	}
+
+	opts.Intermediates = append(append([]*x509.Certificate{}, opts.Intermediates...), chain[1:]...)
+	for i, cert := range chain {
+		certOpts := opts
+		if i > 0 {
+			certOpts.Host = ""
+		}
+		report.Certificates = append(report.Certificates, ReportFor(cert, certOpts))
+	}
+	report.Valid = report.Certificates[0].Valid
+	report.VerifyError = report.Certificates[0].VerifyError
+	_, report.Warnings = LintChainSignatures(chain)
+
+	// Check signature algorithms against the issuing keys, where available
+	candidates := append(append([]*x509.Certificate{}, chain...), opts.Intermediates...)
+	for i, cert := range chain {
+		issuer := findIssuer(cert, candidates)
+		if issuer == nil && IsSelfIssued(cert) {
+			issuer = cert
+		}
+		if issuer == nil {
+			continue
+		}
+		for _, warning := range LintSignatureKeyType(cert, issuer) {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("Certificate %d in chain (%s): %s", i, PrintCommonName(cert.Subject), warning))
+		}
+	}
+	return report
+}
+
+// AnalyzeConnectionState builds a report for an established TLS connection:
+// the negotiated version and cipher suite, and the chain presented by the
+// peer, verified against the system roots and the server name.
+func AnalyzeConnectionState(cs tls.ConnectionState) *ChainReport {
+	report := ReportChain(cs.PeerCertificates, ReportOptions{Host: cs.ServerName})
+	report.TLS = EncodeTLSToObject(&cs).(*TLSDescription)
+	report.ServerName = cs.ServerName
+	return report
+}
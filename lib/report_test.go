@@ -0,0 +1,203 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportFor(t *testing.T) {
+	rootKey, leafKey := newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	leaf := issueTestCert(t, "leaf", leafKey, "root", rootKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	report := ReportFor(leaf, ReportOptions{Roots: roots})
+	if !report.Valid || report.VerifyError != "" {
+		t.Errorf("expected valid certificate, got error: %s", report.VerifyError)
+	}
+	if report.Subject != "CN=leaf" || report.Issuer != "CN=root" {
+		t.Errorf("unexpected names: %s, %s", report.Subject, report.Issuer)
+	}
+	if report.KeyAlgorithm != "ECDSA" || report.KeySize != 256 {
+		t.Errorf("unexpected key: %s %d", report.KeyAlgorithm, report.KeySize)
+	}
+	if report.Status != StatusValid || report.WeakSignature {
+		t.Errorf("unexpected status: %s (weak signature: %v)", report.Status, report.WeakSignature)
+	}
+	if len(report.SHA256Fingerprint) != 32*3-1 || !strings.HasPrefix(report.SPKIPin, "sha256//") {
+		t.Errorf("unexpected fingerprint/pin: %s, %s", report.SHA256Fingerprint, report.SPKIPin)
+	}
+
+	expired := ReportFor(leaf, ReportOptions{Roots: roots, At: time.Now().Add(2 * time.Hour)})
+	if expired.Valid || expired.Status != StatusExpired || expired.VerifyError == "" {
+		t.Errorf("expected expired certificate, got %s (%s)", expired.Status, expired.VerifyError)
+	}
+
+	untrusted := ReportFor(leaf, ReportOptions{Roots: x509.NewCertPool()})
+	if untrusted.Valid || untrusted.Status != StatusValid {
+		t.Errorf("expected verification failure only, got %s (valid: %v)", untrusted.Status, untrusted.Valid)
+	}
+
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"subject", "sha256_fingerprint", "signature_algorithm", "not_after", "status", "valid"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("missing key %s in JSON report: %s", key, encoded)
+		}
+	}
+}
+
+func TestValidityStatus(t *testing.T) {
+	notBefore := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(24 * time.Hour)}
+
+	testCases := []struct {
+		now        time.Time
+		skew       time.Duration
+		status     string
+		withinSkew bool
+		text       string
+	}{
+		{notBefore.Add(time.Hour), 0, StatusValid, false, "valid, expires in 23h0m0s"},
+		{notBefore.Add(-time.Minute), 0, StatusNotYetValid, false, "not yet valid, becomes valid in 1m0s"},
+		{notBefore.Add(-time.Minute), 5 * time.Minute, StatusValid, true, "valid, becomes valid in 1m0s (within allowed clock skew)"},
+		{notBefore.Add(-10 * time.Minute), 5 * time.Minute, StatusNotYetValid, false, "not yet valid, becomes valid in 10m0s"},
+		{notBefore.Add(25 * time.Hour), 0, StatusExpired, false, "expired 1h0m0s ago"},
+		{notBefore.Add(25 * time.Hour), 2 * time.Hour, StatusValid, true, "valid, expired 1h0m0s ago (within allowed clock skew)"},
+	}
+	for i, tc := range testCases {
+		validity := ValidityStatus(cert, tc.now, tc.skew)
+		if validity.Status != tc.status || validity.WithinSkew != tc.withinSkew {
+			t.Errorf("case %d: unexpected validity: %+v", i, validity)
+		}
+		if validity.SinceNotBefore != tc.now.Sub(cert.NotBefore) || validity.UntilNotAfter != cert.NotAfter.Sub(tc.now) {
+			t.Errorf("case %d: unexpected distances: %+v", i, validity)
+		}
+		if validity.String() != tc.text {
+			t.Errorf("case %d: expected %q, got %q", i, tc.text, validity.String())
+		}
+	}
+}
+
+func TestExpiryReport(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	newCert := func(cn string, notAfter time.Time) *x509.Certificate {
+		return &x509.Certificate{
+			Raw:      []byte(cn),
+			Subject:  pkix.Name{CommonName: cn},
+			NotAfter: notAfter,
+		}
+	}
+	certs := []*x509.Certificate{
+		newCert("later", now.Add(90*24*time.Hour)),
+		newCert("expired", now.Add(-time.Hour)),
+		newCert("soon", now.Add(36*time.Hour)),
+		newCert("soon too", now.Add(36*time.Hour)),
+	}
+
+	entries := ExpiryReport(certs, now)
+	expected := []struct {
+		subject string
+		days    int
+	}{
+		{"CN=expired", -1},
+		{"CN=soon", 1},
+		{"CN=soon too", 1},
+		{"CN=later", 90},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("unexpected number of entries: %d", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Subject != expected[i].subject || entry.Days() != expected[i].days {
+			t.Errorf("entry %d: expected %s with %d days, got %s with %d days", i, expected[i].subject, expected[i].days, entry.Subject, entry.Days())
+		}
+		if entry.Remaining != entry.NotAfter.Sub(now) || len(entry.SHA256Fingerprint) != 95 {
+			t.Errorf("entry %d: unexpected entry: %+v", i, entry)
+		}
+	}
+}
+
+func TestAnalyzeConnectionState(t *testing.T) {
+	rootKey, leafKey := newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, root, &leafKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	server := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der, root.Raw}, PrivateKey: leafKey}},
+	})
+	defer server.Close()
+	go server.Handshake()
+
+	client := tls.Client(clientConn, &tls.Config{ServerName: "example.com", InsecureSkipVerify: true})
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	cs := client.ConnectionState()
+
+	// Not trusted by the system roots
+	report := AnalyzeConnectionState(cs)
+	if report.Valid || report.VerifyError == "" {
+		t.Error("expected verification error")
+	}
+	if report.TLS == nil || report.TLS.Version == "" || report.TLS.Cipher == "" || report.ServerName != "example.com" {
+		t.Errorf("unexpected connection info: %+v", report)
+	}
+	if len(report.Certificates) != 2 || report.Certificates[0].Subject != "CN=example.com" || report.Certificates[1].Subject != "CN=root" {
+		t.Fatalf("unexpected certificates: %+v", report.Certificates)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	report = ReportChain(cs.PeerCertificates, ReportOptions{Roots: roots, Host: "example.com"})
+	if !report.Valid || !report.Certificates[1].Valid {
+		t.Errorf("expected valid chain, got: %s", report.VerifyError)
+	}
+	if report := ReportChain(nil, ReportOptions{}); report.Valid || len(report.Certificates) != 0 {
+		t.Errorf("unexpected report for empty chain: %+v", report)
+	}
+}
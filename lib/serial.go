@@ -0,0 +1,101 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Bases for SerialString.
+const (
+	// SerialDecimal is the decimal serial number, e.g. "12105469093004400624".
+	SerialDecimal = "decimal"
+
+	// SerialHex is colon-separated hex, as shown by "openssl x509 -text",
+	// e.g. "a8:3e:2d:09:62:ad:f7:f0".
+	SerialHex = "hex"
+
+	// SerialHexPlain is hex without separators, as shown by "openssl x509
+	// -serial", e.g. "A83E2D0962ADF7F0".
+	SerialHexPlain = "hex-plain"
+)
+
+// SerialNumberFormats holds the serial number of a certificate in all supported
+// representations, see SerialString.
+type SerialNumberFormats struct {
+	Decimal  string `json:"decimal"`
+	Hex      string `json:"hex"`
+	HexPlain string `json:"hex_plain"`
+
+	// Bytes is the big-endian magnitude of the serial number.
+	Bytes []byte `json:"bytes"`
+}
+
+// SerialString formats the serial number of the given certificate in the
+// given base (SerialDecimal, SerialHex or SerialHexPlain). Hex serials
+// always have an even number of digits, like OpenSSL prints them, and
+// negative (invalid) ones are prefixed with "-".
+func SerialString(cert *x509.Certificate, base string) (string, error) {
+	serial := cert.SerialNumber
+	if serial == nil {
+		return "", fmt.Errorf("certificate has no serial number")
+	}
+	sign := ""
+	if serial.Sign() < 0 {
+		sign = "-"
+	}
+	digits := hex.EncodeToString(new(big.Int).Abs(serial).Bytes())
+	if digits == "" {
+		digits = "00"
+	}
+
+	switch base {
+	case SerialDecimal:
+		return serial.String(), nil
+	case SerialHex:
+		pairs := make([]string, 0, len(digits)/2)
+		for i := 0; i < len(digits); i += 2 {
+			pairs = append(pairs, digits[i:i+2])
+		}
+		return sign + strings.Join(pairs, ":"), nil
+	case SerialHexPlain:
+		return sign + strings.ToUpper(digits), nil
+	}
+	return "", fmt.Errorf("unknown serial number base '%s' (expected %s, %s or %s)", base, SerialDecimal, SerialHex, SerialHexPlain)
+}
+
+// SerialFormats returns the serial number of the given certificate in all
+// supported representations.
+func SerialFormats(cert *x509.Certificate) (*SerialNumberFormats, error) {
+	formats := &SerialNumberFormats{}
+	for base, out := range map[string]*string{
+		SerialDecimal:  &formats.Decimal,
+		SerialHex:      &formats.Hex,
+		SerialHexPlain: &formats.HexPlain,
+	} {
+		var err error
+		if *out, err = SerialString(cert, base); err != nil {
+			return nil, err
+		}
+	}
+	formats.Bytes = new(big.Int).Abs(cert.SerialNumber).Bytes()
+	return formats, nil
+}
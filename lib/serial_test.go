@@ -0,0 +1,68 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestSerialFormats(t *testing.T) {
+	cert, err := ParseCertificatePEM(readTestFile(t, "../test-certs/example-root.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	formats, err := SerialFormats(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As printed by openssl x509 -text and -serial
+	expected := &SerialNumberFormats{
+		Decimal:  "12123176765261477872",
+		Hex:      "a8:3e:2d:09:62:ad:f7:f0",
+		HexPlain: "A83E2D0962ADF7F0",
+		Bytes:    []byte{0xa8, 0x3e, 0x2d, 0x09, 0x62, 0xad, 0xf7, 0xf0},
+	}
+	if !reflect.DeepEqual(formats, expected) {
+		t.Errorf("unexpected serial formats: %+v", formats)
+	}
+
+	// Leading zero digits are kept to fill the first byte
+	small := &x509.Certificate{SerialNumber: big.NewInt(0x10203)}
+	testCases := []struct {
+		cert     *x509.Certificate
+		base     string
+		expected string
+	}{
+		{small, SerialHex, "01:02:03"},
+		{small, SerialHexPlain, "010203"},
+		{small, SerialDecimal, "66051"},
+		{&x509.Certificate{SerialNumber: big.NewInt(-5)}, SerialHex, "-05"},
+		{&x509.Certificate{SerialNumber: big.NewInt(0)}, SerialHexPlain, "00"},
+	}
+	for i, tc := range testCases {
+		if serial, err := SerialString(tc.cert, tc.base); err != nil || serial != tc.expected {
+			t.Errorf("case %d: expected %s, got %s (%v)", i, tc.expected, serial, err)
+		}
+	}
+
+	if _, err := SerialString(small, "octal"); err == nil {
+		t.Error("expected error for unknown base")
+	}
+}
@@ -0,0 +1,139 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/square/certigo/starttls"
+)
+
+// ServedChainComparison is the result of comparing a local chain (such as
+// the certificates deployed on disk) with the chain a server presents.
+// Certificates are compared by SHA-256 fingerprint.
+type ServedChainComparison struct {
+	// LocalLeaf and ServedLeaf are the first certificates of each chain.
+	LocalLeaf  *x509.Certificate
+	ServedLeaf *x509.Certificate
+
+	// LeafMatches is true if the server presents the local leaf.
+	LeafMatches bool
+
+	// Missing are the local certificates the server doesn't send, apart
+	// from a self-signed root, which servers don't need to send. Extra are
+	// the served certificates that aren't in the local chain.
+	Missing []*x509.Certificate
+	Extra   []*x509.Certificate
+
+	// OrderDiffers is true if the certificates present in both chains are
+	// served in a different order.
+	OrderDiffers bool
+}
+
+// Matches returns true if the server presents the local leaf and chain.
+func (c *ServedChainComparison) Matches() bool {
+	return c.LeafMatches && len(c.Missing) == 0 && len(c.Extra) == 0 && !c.OrderDiffers
+}
+
+// Differences describes how the served chain differs from the local one,
+// one line per difference.
+func (c *ServedChainComparison) Differences() []string {
+	var out []string
+	if !c.LeafMatches {
+		out = append(out, fmt.Sprintf("served leaf %s differs from local leaf %s",
+			describeServedCert(c.ServedLeaf), describeServedCert(c.LocalLeaf)))
+	}
+	for _, cert := range c.Missing {
+		out = append(out, fmt.Sprintf("not served: %s", describeServedCert(cert)))
+	}
+	for _, cert := range c.Extra {
+		out = append(out, fmt.Sprintf("not in local chain: %s", describeServedCert(cert)))
+	}
+	if c.OrderDiffers {
+		out = append(out, "chain is served in a different order")
+	}
+	return out
+}
+
+func describeServedCert(cert *x509.Certificate) string {
+	if cert == nil {
+		return "(none)"
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	return fmt.Sprintf("%s (SHA-256 %s)", PrintCommonName(cert.Subject), hexify(fingerprint[:]))
+}
+
+// CompareServedChain compares a local chain, starting with the leaf, with
+// the chain served by a server, as in the peer certificates of a TLS
+// connection.
+func CompareServedChain(local, served []*x509.Certificate) *ServedChainComparison {
+	comparison := &ServedChainComparison{}
+	if len(local) > 0 {
+		comparison.LocalLeaf = local[0]
+	}
+	if len(served) > 0 {
+		comparison.ServedLeaf = served[0]
+	}
+	comparison.LeafMatches = comparison.LocalLeaf != nil && comparison.ServedLeaf != nil &&
+		comparison.LocalLeaf.Equal(comparison.ServedLeaf)
+
+	servedIndex := map[[sha256.Size]byte]int{}
+	for i, cert := range served {
+		servedIndex[sha256.Sum256(cert.Raw)] = i
+	}
+	localIndex := map[[sha256.Size]byte]bool{}
+	last := -1
+	for i, cert := range local {
+		fingerprint := sha256.Sum256(cert.Raw)
+		localIndex[fingerprint] = true
+		index, ok := servedIndex[fingerprint]
+		if !ok {
+			if i == 0 || i < len(local)-1 || !IsSelfSigned(cert) {
+				comparison.Missing = append(comparison.Missing, cert)
+			}
+			continue
+		}
+		if index < last {
+			comparison.OrderDiffers = true
+		}
+		last = index
+	}
+	for _, cert := range served {
+		if !localIndex[sha256.Sum256(cert.Raw)] {
+			comparison.Extra = append(comparison.Extra, cert)
+		}
+	}
+	return comparison
+}
+
+// CompareWithServer connects to a server, as in starttls.GetConnectionState,
+// and compares the chain it serves with the given local chain (see
+// CompareServedChain). The server's chain isn't verified.
+func CompareWithServer(local []*x509.Certificate, startTLSType, connectName, connectTo string, timeout time.Duration) (*ServedChainComparison, error) {
+	state, _, err := starttls.GetConnectionState(startTLSType, connectName, connectTo, "", "", "", nil, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %s", connectTo, err)
+	}
+	if len(state.PeerCertificates) == 0 {
+		return nil, errors.New("server didn't present any certificates")
+	}
+	return CompareServedChain(local, state.PeerCertificates), nil
+}
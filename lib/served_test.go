@@ -0,0 +1,84 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareServedChain(t *testing.T) {
+	rootKey, intKey, leafKey, oldKey := newTestKey(t), newTestKey(t), newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	intermediate := issueTestCert(t, "intermediate", intKey, "root", rootKey)
+	leaf := issueTestCert(t, "leaf", leafKey, "intermediate", intKey)
+	old := issueTestCert(t, "leaf", oldKey, "intermediate", intKey)
+	local := []*x509.Certificate{leaf, intermediate, root}
+
+	comparison := CompareServedChain(local, []*x509.Certificate{leaf, intermediate})
+	if !comparison.Matches() {
+		t.Errorf("expected chains to match, got %v", comparison.Differences())
+	}
+
+	comparison = CompareServedChain(local, []*x509.Certificate{old})
+	if comparison.LeafMatches || comparison.Matches() {
+		t.Error("expected old leaf not to match")
+	}
+	if len(comparison.Missing) != 2 || comparison.Missing[0] != leaf || comparison.Missing[1] != intermediate {
+		t.Errorf("unexpected missing certificates: %v", comparison.Missing)
+	}
+	if len(comparison.Extra) != 1 || comparison.Extra[0] != old {
+		t.Errorf("unexpected extra certificates: %v", comparison.Extra)
+	}
+	differences := comparison.Differences()
+	if len(differences) != 4 || !strings.HasPrefix(differences[0], "served leaf CN=leaf (SHA-256 ") {
+		t.Errorf("unexpected differences: %q", differences)
+	}
+
+	comparison = CompareServedChain(local, []*x509.Certificate{leaf, root, intermediate})
+	if !comparison.LeafMatches || !comparison.OrderDiffers || len(comparison.Missing) != 0 || len(comparison.Extra) != 0 {
+		t.Errorf("expected only a different order, got %v", comparison.Differences())
+	}
+}
+
+func TestCompareWithServer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	local := []*x509.Certificate{server.Certificate()}
+	comparison, err := CompareWithServer(local, "", "example.com", server.Listener.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !comparison.Matches() {
+		t.Errorf("expected served chain to match, got %v", comparison.Differences())
+	}
+
+	key := newTestKey(t)
+	other := []*x509.Certificate{issueTestCert(t, "other", key, "other", key)}
+	comparison, err = CompareWithServer(other, "", "example.com", server.Listener.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comparison.LeafMatches {
+		t.Error("expected served leaf not to match")
+	}
+}
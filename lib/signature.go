@@ -0,0 +1,122 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// rawCertificate is the outer structure of a certificate (RFC 5280,
+// Section 4.1), for checking its encoding.
+type rawCertificate struct {
+	TBSCertificate     asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	SignatureValue     asn1.BitString
+}
+
+// rawTBSCertificatePrefix is the start of a TBSCertificate, up to the
+// validity period.
+type rawTBSCertificatePrefix struct {
+	Version            int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber       asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Issuer             asn1.RawValue
+	Validity           struct {
+		NotBefore, NotAfter asn1.RawValue
+	}
+}
+
+// ecdsaSignature is an ECDSA signature, as encoded in certificates.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// VerifySignatureStrict checks that the given certificate was signed by the
+// issuer, like x509.Certificate.CheckSignatureFrom, and additionally checks
+// the encoding of the signature, catching mistakes that verification
+// tolerates:
+//
+//   - the signature algorithm outside the TBSCertificate must be the same
+//     as the one inside it (RFC 5280, Section 4.1.1.2)
+//   - the signature BIT STRING must have no unused bits
+//   - ECDSA signature algorithms must have absent parameters (RFC 5758)
+//   - ECDSA signatures must be DER-encoded, with r and s in [1, n-1], and
+//     the issuer's key must be a point on its curve
+func VerifySignatureStrict(cert, issuer *x509.Certificate) error {
+	if err := cert.CheckSignatureFrom(issuer); err != nil {
+		return err
+	}
+	return checkSignatureEncoding(cert, issuer.PublicKey)
+}
+
+// checkSignatureEncoding does the encoding checks of VerifySignatureStrict,
+// given the issuer's public key.
+func checkSignatureEncoding(cert *x509.Certificate, issuerKey interface{}) error {
+	var outer rawCertificate
+	if rest, err := asn1.Unmarshal(cert.Raw, &outer); err != nil {
+		return fmt.Errorf("unable to parse certificate: %s", err)
+	} else if len(rest) > 0 {
+		return errors.New("trailing data after certificate")
+	}
+	var tbs rawTBSCertificatePrefix
+	if _, err := asn1.Unmarshal(cert.RawTBSCertificate, &tbs); err != nil {
+		return fmt.Errorf("unable to parse TBSCertificate: %s", err)
+	}
+	if !tbs.SignatureAlgorithm.Algorithm.Equal(outer.SignatureAlgorithm.Algorithm) ||
+		!bytes.Equal(tbs.SignatureAlgorithm.Parameters.FullBytes, outer.SignatureAlgorithm.Parameters.FullBytes) {
+		return fmt.Errorf("signature algorithm %s doesn't match the one in the TBSCertificate (%s)", outer.SignatureAlgorithm.Algorithm, tbs.SignatureAlgorithm.Algorithm)
+	}
+	if outer.SignatureValue.BitLength%8 != 0 {
+		return fmt.Errorf("signature has %d unused bits", 8-outer.SignatureValue.BitLength%8)
+	}
+
+	key, ok := issuerKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil
+	}
+	if len(outer.SignatureAlgorithm.Parameters.FullBytes) > 0 {
+		return errors.New("ECDSA signature algorithm has parameters, which must be absent")
+	}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return errors.New("issuer's ECDSA public key is not on its curve")
+	}
+
+	var signature ecdsaSignature
+	if rest, err := asn1.Unmarshal(cert.Signature, &signature); err != nil {
+		return fmt.Errorf("malformed ECDSA signature: %s", err)
+	} else if len(rest) > 0 {
+		return errors.New("trailing data after ECDSA signature")
+	}
+	if encoded, err := asn1.Marshal(signature); err != nil || !bytes.Equal(encoded, cert.Signature) {
+		return errors.New("ECDSA signature is not DER-encoded")
+	}
+	n := key.Curve.Params().N
+	if signature.R.Sign() <= 0 || signature.R.Cmp(n) >= 0 {
+		return errors.New("ECDSA signature value r is out of range")
+	}
+	if signature.S.Sign() <= 0 || signature.S.Cmp(n) >= 0 {
+		return errors.New("ECDSA signature value s is out of range")
+	}
+	return nil
+}
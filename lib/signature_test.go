@@ -0,0 +1,99 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestVerifySignatureStrict(t *testing.T) {
+	rootKey, leafKey := newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	leaf := issueTestCert(t, "leaf", leafKey, "root", rootKey)
+
+	if err := VerifySignatureStrict(leaf, root); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := VerifySignatureStrict(root, leaf); err == nil {
+		t.Error("expected an error for the wrong issuer")
+	}
+
+	var outer rawCertificate
+	if _, err := asn1.Unmarshal(leaf.Raw, &outer); err != nil {
+		t.Fatal(err)
+	}
+	// withOuter returns a copy of the leaf with a modified outer structure
+	withOuter := func(modify func(*rawCertificate)) *x509.Certificate {
+		modified := outer
+		modify(&modified)
+		raw, err := asn1.Marshal(modified)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert := *leaf
+		cert.Raw = raw
+		cert.Signature = modified.SignatureValue.RightAlign()
+		return &cert
+	}
+	withSignature := func(r, s *big.Int, modify func([]byte) []byte) *x509.Certificate {
+		signature, err := asn1.Marshal(ecdsaSignature{r, s})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if modify != nil {
+			signature = modify(signature)
+		}
+		return withOuter(func(c *rawCertificate) {
+			c.SignatureValue = asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)}
+		})
+	}
+	n := rootKey.Curve.Params().N
+
+	testCases := []struct {
+		cert     *x509.Certificate
+		expected string
+	}{
+		{withOuter(func(c *rawCertificate) {
+			c.SignatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}}
+		}), "doesn't match the one in the TBSCertificate"},
+		{withOuter(func(c *rawCertificate) {
+			bytes := append([]byte{}, c.SignatureValue.Bytes...)
+			bytes[len(bytes)-1] &^= 7
+			c.SignatureValue = asn1.BitString{Bytes: bytes, BitLength: 8*len(bytes) - 3}
+		}), "signature has 3 unused bits"},
+		{withSignature(big.NewInt(0), big.NewInt(1), nil), "r is out of range"},
+		{withSignature(big.NewInt(1), n, nil), "s is out of range"},
+		{withSignature(big.NewInt(1), big.NewInt(1), func(signature []byte) []byte {
+			// Long-form length, which DER doesn't allow here
+			return append([]byte{0x30, 0x81, signature[1]}, signature[2:]...)
+		}), "malformed ECDSA signature"},
+		{withSignature(big.NewInt(1), big.NewInt(1), func(signature []byte) []byte {
+			return append(signature, 0)
+		}), "trailing data after ECDSA signature"},
+	}
+	for i, tc := range testCases {
+		err := checkSignatureEncoding(tc.cert, root.PublicKey)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("case %d: expected error containing %q, got: %v", i, tc.expected, err)
+		}
+	}
+}
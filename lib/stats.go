@@ -0,0 +1,150 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StatsExpiryWindow is how close to expiry a certificate must be to count
+// as expiring soon in BundleStats.
+const StatsExpiryWindow = 30 * 24 * time.Hour
+
+// BundleStats summarizes the contents of a set of inputs, see ReadStats.
+type BundleStats struct {
+	Certificates   int            `json:"certificates"`
+	PrivateKeys    int            `json:"private_keys"`
+	KeyAlgorithms  map[string]int `json:"key_algorithms"`
+	Expired        int            `json:"expired"`
+	ExpiringSoon   int            `json:"expiring_soon"`
+	WeakSignatures int            `json:"weak_signatures"`
+	SelfSigned     int            `json:"self_signed"`
+}
+
+// add counts a certificate, checking expiry against the given time.
+func (stats *BundleStats) add(cert *x509.Certificate, now time.Time) {
+	stats.Certificates++
+	algorithm, _ := decodeKey(cert.PublicKey)
+	if algorithm == "" {
+		algorithm = "unknown"
+	}
+	stats.KeyAlgorithms[algorithm]++
+	if now.After(cert.NotAfter) {
+		stats.Expired++
+	} else if FilterExpiringWithin(now, StatsExpiryWindow)(cert) {
+		stats.ExpiringSoon++
+	}
+	if IsWeakSignature(cert) {
+		stats.WeakSignatures++
+	}
+	if IsSelfSigned(cert) {
+		stats.SelfSigned++
+	}
+}
+
+// ReadStatsFromFiles is like ReadStats, for a set of files.
+func ReadStatsFromFiles(files []*os.File, format string, password func(string) string) (*BundleStats, error) {
+	return ReadStats(FilesToReaders(files), ReadOptions{Format: format, Password: PasswordFunc(password)})
+}
+
+// ReadStats reads the given inputs like ReadPEMWithOptions, but instead of
+// passing on what was read, it returns counts of the certificates and
+// private keys found. Expiry is checked against opts.Clock (if set), and
+// certificates that fail to parse are skipped.
+func ReadStats(inputs []io.Reader, opts ReadOptions) (*BundleStats, error) {
+	stats := &BundleStats{KeyAlgorithms: map[string]int{}}
+	now := opts.now()
+	countCert := pemToX509(func(cert *x509.Certificate, format string, err error) error {
+		if err == nil && cert != nil {
+			stats.add(cert, now)
+		}
+		return nil
+	}, false)
+	err := ReadPEMWithOptions(inputs, opts, func(block *pem.Block, format string) error {
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			stats.PrivateKeys++
+			return nil
+		}
+		return countCert(block, format)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// EncodeStatsToText returns a human-readable summary of the given stats.
+func EncodeStatsToText(stats *BundleStats) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "Certificates: %d\n", stats.Certificates)
+	algorithms := make([]string, 0, len(stats.KeyAlgorithms))
+	for algorithm := range stats.KeyAlgorithms {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	for _, algorithm := range algorithms {
+		fmt.Fprintf(&out, "  %s keys: %d\n", algorithm, stats.KeyAlgorithms[algorithm])
+	}
+	fmt.Fprintf(&out, "  Expired: %d\n", stats.Expired)
+	fmt.Fprintf(&out, "  Expiring within %d days: %d\n", int(StatsExpiryWindow.Hours()/24), stats.ExpiringSoon)
+	fmt.Fprintf(&out, "  Weak signatures: %d\n", stats.WeakSignatures)
+	fmt.Fprintf(&out, "  Self-signed: %d\n", stats.SelfSigned)
+	fmt.Fprintf(&out, "Private keys: %d\n", stats.PrivateKeys)
+	return out.String()
+}
+
+// IssuerCount is an issuer of certificates in a bundle, see UniqueIssuers.
+type IssuerCount struct {
+	Issuer pkix.Name `json:"-"`
+	DN     string    `json:"dn"`
+	Count  int       `json:"count"`
+}
+
+// UniqueIssuers returns the distinct issuers of the given certificates, with
+// the number of certificates each issued, most first (and in order of first
+// appearance for equal counts). Issuers are compared as RawDNEqual does, so
+// names that differ only in encoding, case or the order of attributes within
+// an RDN are counted together, under the first form seen.
+func UniqueIssuers(certs []*x509.Certificate) []IssuerCount {
+	var issuers []IssuerCount
+	index := map[string]int{}
+	for _, cert := range certs {
+		key := strings.Join(canonicalRawDN(cert.RawIssuer, cert.Issuer), "\n")
+		if i, ok := index[key]; ok {
+			issuers[i].Count++
+			continue
+		}
+		dn, err := FormatDN(cert.RawIssuer)
+		if err != nil || len(cert.RawIssuer) == 0 {
+			dn = cert.Issuer.String()
+		}
+		index[key] = len(issuers)
+		issuers = append(issuers, IssuerCount{Issuer: cert.Issuer, DN: dn, Count: 1})
+	}
+	sort.SliceStable(issuers, func(i, j int) bool {
+		return issuers[i].Count > issuers[j].Count
+	})
+	return issuers
+}
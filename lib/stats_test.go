@@ -0,0 +1,86 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadStats(t *testing.T) {
+	root := newTestKey(t)
+	leaf := newTestKey(t)
+	var bundle bytes.Buffer
+	pem.Encode(&bundle, EncodeX509ToPEM(issueTestCert(t, "leaf", leaf, "root", root), nil))
+	pem.Encode(&bundle, EncodeX509ToPEM(issueTestCert(t, "root", root, "root", root), nil))
+	keyBlock, err := keyToPem(leaf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem.Encode(&bundle, keyBlock)
+
+	// Test certificates are valid from an hour ago until an hour from now
+	now := time.Now()
+	testCases := []struct {
+		at       time.Time
+		expected BundleStats
+	}{
+		{now, BundleStats{Certificates: 2, PrivateKeys: 1, ExpiringSoon: 2, SelfSigned: 1}},
+		{now.Add(2 * time.Hour), BundleStats{Certificates: 2, PrivateKeys: 1, Expired: 2, SelfSigned: 1}},
+	}
+	for i, tc := range testCases {
+		at := tc.at
+		stats, err := ReadStats([]io.Reader{bytes.NewReader(bundle.Bytes())}, ReadOptions{Clock: func() time.Time { return at }})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %s", i, err)
+		}
+		if stats.KeyAlgorithms["ECDSA"] != 2 || len(stats.KeyAlgorithms) != 1 {
+			t.Errorf("case %d: unexpected key algorithms: %v", i, stats.KeyAlgorithms)
+		}
+		stats.KeyAlgorithms = nil
+		if !reflect.DeepEqual(*stats, tc.expected) {
+			t.Errorf("case %d: expected %+v, got %+v", i, tc.expected, *stats)
+		}
+	}
+}
+
+func TestUniqueIssuers(t *testing.T) {
+	rootKey, otherKey, leafKey := newTestKey(t), newTestKey(t), newTestKey(t)
+	certs := []*x509.Certificate{
+		issueTestCert(t, "root", rootKey, "root", rootKey),
+		issueTestCert(t, "leaf", leafKey, "other", otherKey),
+		issueTestCert(t, "leaf", leafKey, "root", rootKey),
+		// Differs only in case, so should be counted with "root"
+		issueTestCert(t, "leaf", leafKey, "ROOT", rootKey),
+	}
+
+	issuers := UniqueIssuers(certs)
+	if len(issuers) != 2 {
+		t.Fatalf("unexpected number of issuers: %d != 2", len(issuers))
+	}
+	if issuers[0].DN != "CN=root" || issuers[0].Count != 3 || issuers[0].Issuer.CommonName != "root" {
+		t.Errorf("unexpected issuer: %+v", issuers[0])
+	}
+	if issuers[1].DN != "CN=other" || issuers[1].Count != 1 {
+		t.Errorf("unexpected issuer: %+v", issuers[1])
+	}
+}
@@ -0,0 +1,74 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// EncodeCertSummary describes a certificate on a single line, for grepping
+// and sorting through large bundles. The fields are separated by tabs, and
+// always come in this order:
+//
+//  1. SHA-256 fingerprint, in lowercase hex without separators
+//  2. subject common name
+//  3. expiry (notAfter), in RFC 3339 format (UTC)
+//  4. issuer common name
+//
+// Common names that are empty are given as "-", and whitespace in them is
+// replaced with spaces, so that the number of fields is always the same.
+func EncodeCertSummary(cert *x509.Certificate) string {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return strings.Join([]string{
+		hex.EncodeToString(fingerprint[:]),
+		summaryField(cert.Subject.CommonName),
+		cert.NotAfter.UTC().Format(time.RFC3339),
+		summaryField(cert.Issuer.CommonName),
+	}, "\t")
+}
+
+// summaryField makes a value safe for a tab-separated line.
+func summaryField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		return r
+	}, value)
+}
+
+// SummaryWriter returns a callback for ReadX509WithOptions (and the like)
+// that writes a summary line (see EncodeCertSummary) for each certificate
+// to the given writer as it is read. Errors reading certificates abort.
+func SummaryWriter(w io.Writer) func(*x509.Certificate, string, error) error {
+	return func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, EncodeCertSummary(cert))
+		return err
+	}
+}
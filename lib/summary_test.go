@@ -0,0 +1,63 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeCertSummary(t *testing.T) {
+	cert := &x509.Certificate{
+		Raw:      []byte("certificate"),
+		Subject:  pkix.Name{CommonName: "www.example.com"},
+		Issuer:   pkix.Name{CommonName: "Example\tCA"},
+		NotAfter: time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+	}
+	expected := "03d66dd08835c1ca3f128cceacd1f31ac94163096b20f445ae84285bc0832d72" +
+		"\twww.example.com\t2030-01-02T02:04:05Z\tExample CA"
+	if summary := EncodeCertSummary(cert); summary != expected {
+		t.Errorf("unexpected summary:\n%q\n%q", summary, expected)
+	}
+
+	cert.Subject = pkix.Name{}
+	if fields := strings.Split(EncodeCertSummary(cert), "\t"); len(fields) != 4 || fields[1] != "-" {
+		t.Errorf("unexpected fields: %q", fields)
+	}
+}
+
+func TestSummaryWriter(t *testing.T) {
+	var out bytes.Buffer
+	err := ReadAsX509([]io.Reader{strings.NewReader(readTestFile(t, "testdata/mixed-bundle.pem"))}, "", nil, SummaryWriter(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) == 0 {
+		t.Fatal("no summary lines written")
+	}
+	for _, line := range lines {
+		if fields := strings.Split(line, "\t"); len(fields) != 4 || len(fields[0]) != 64 {
+			t.Errorf("malformed summary line: %q", line)
+		}
+	}
+}
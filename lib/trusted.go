@@ -0,0 +1,97 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// TrustSettings are the auxiliary trust settings OpenSSL appends to
+// certificates in "TRUSTED CERTIFICATE" blocks (see "openssl x509
+// -addtrust"). Purposes are extended key usage OIDs, such as that of
+// serverAuth, or anyExtendedKeyUsage for all purposes.
+type TrustSettings struct {
+	Trusted  []asn1.ObjectIdentifier
+	Rejected []asn1.ObjectIdentifier
+
+	// Alias is a friendly name for the certificate (see -setalias).
+	Alias string
+}
+
+// certAux is OpenSSL's X509_CERT_AUX structure.
+type certAux struct {
+	Trust  []asn1.ObjectIdentifier `asn1:"optional,omitempty"`
+	Reject []asn1.ObjectIdentifier `asn1:"optional,omitempty,tag:0"`
+	Alias  string                  `asn1:"optional,omitempty,utf8"`
+	KeyID  []byte                  `asn1:"optional,omitempty"`
+	Other  asn1.RawValue           `asn1:"optional,tag:1"`
+}
+
+// IsEmpty returns true if no trust settings are set.
+func (trust TrustSettings) IsEmpty() bool {
+	return len(trust.Trusted) == 0 && len(trust.Rejected) == 0 && trust.Alias == ""
+}
+
+// EncodeX509ToTrustedPEM encodes a certificate with the given trust settings
+// as a "TRUSTED CERTIFICATE" PEM block, as read by OpenSSL. If there are no
+// trust settings, it's encoded as a plain "CERTIFICATE" block instead.
+func EncodeX509ToTrustedPEM(cert *x509.Certificate, trust TrustSettings, headers map[string]string) (*pem.Block, error) {
+	if trust.IsEmpty() {
+		return EncodeX509ToPEM(cert, headers), nil
+	}
+	aux, err := asn1.Marshal(certAux{
+		Trust:  trust.Trusted,
+		Reject: trust.Rejected,
+		Alias:  trust.Alias,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal trust settings: %s", err)
+	}
+	block := EncodeX509ToPEMWithType(cert, PEMTypeTrustedCertificate, headers)
+	block.Bytes = append(append([]byte{}, cert.Raw...), aux...)
+	return block, nil
+}
+
+// ParseTrustedCertificate parses the contents of a "TRUSTED CERTIFICATE"
+// block: a certificate followed by (optional) trust settings.
+func ParseTrustedCertificate(der []byte) (*x509.Certificate, TrustSettings, error) {
+	var raw asn1.RawValue
+	rest, err := asn1.Unmarshal(der, &raw)
+	if err != nil {
+		return nil, TrustSettings{}, err
+	}
+	cert, err := x509.ParseCertificate(raw.FullBytes)
+	if err != nil {
+		return nil, TrustSettings{}, err
+	}
+	if len(rest) == 0 {
+		return cert, TrustSettings{}, nil
+	}
+
+	var aux certAux
+	if rest, err = asn1.Unmarshal(rest, &aux); err != nil {
+		return nil, TrustSettings{}, fmt.Errorf("invalid trust settings: %s", err)
+	}
+	if len(rest) > 0 {
+		return nil, TrustSettings{}, errors.New("trailing data after trust settings")
+	}
+	return cert, TrustSettings{Trusted: aux.Trust, Rejected: aux.Reject, Alias: aux.Alias}, nil
+}
@@ -0,0 +1,117 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var (
+	oidServerAuth      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+	oidEmailProtection = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}
+)
+
+func TestEncodeX509ToTrustedPEM(t *testing.T) {
+	key := newTestKey(t)
+	cert := issueTestCert(t, "Test CA", key, "Test CA", key)
+
+	block, err := EncodeX509ToTrustedPEM(cert, TrustSettings{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Type != "CERTIFICATE" || !bytes.Equal(block.Bytes, cert.Raw) {
+		t.Errorf("expected plain certificate without trust settings, got %s", block.Type)
+	}
+
+	trust := TrustSettings{
+		Trusted:  []asn1.ObjectIdentifier{oidServerAuth},
+		Rejected: []asn1.ObjectIdentifier{oidEmailProtection},
+		Alias:    "Test CA",
+	}
+	block, err = EncodeX509ToTrustedPEM(cert, trust, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Type != "TRUSTED CERTIFICATE" {
+		t.Errorf("unexpected block type: %s", block.Type)
+	}
+	parsed, parsedTrust, err := ParseTrustedCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Equal(cert) || !reflect.DeepEqual(parsedTrust, trust) {
+		t.Errorf("unexpected round trip result: %+v", parsedTrust)
+	}
+}
+
+func TestReadTrustedCertificate(t *testing.T) {
+	// Made with "openssl x509 -addtrust serverAuth -addreject
+	// emailProtection -setalias 'Example Root' -trustout"
+	file, err := os.Open("testdata/trusted-root.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var certs []*x509.Certificate
+	var blocks []*pem.Block
+	err = ReadPEMWithOptions([]io.Reader{file}, ReadOptions{}, func(block *pem.Block, format string) error {
+		blocks = append(blocks, block)
+		return pemToX509(func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+			return nil
+		}, true)(block, format)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || certs[0].Subject.CommonName != "example-root" {
+		t.Fatalf("unexpected certificates: %v", certs)
+	}
+
+	_, trust, err := ParseTrustedCertificate(blocks[0].Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := TrustSettings{
+		Trusted:  []asn1.ObjectIdentifier{oidServerAuth},
+		Rejected: []asn1.ObjectIdentifier{oidEmailProtection},
+		Alias:    "Example Root",
+	}
+	if !reflect.DeepEqual(trust, expected) {
+		t.Errorf("unexpected trust settings: %+v", trust)
+	}
+
+	// Re-encoding gives the same data as OpenSSL
+	block, err := EncodeX509ToTrustedPEM(certs[0], trust, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(block.Bytes, blocks[0].Bytes) {
+		t.Error("encoded trusted certificate differs from OpenSSL's")
+	}
+}
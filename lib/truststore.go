@@ -0,0 +1,124 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ReadSystemTrustStore returns the root certificates the system trusts, as
+// found in the platform's trust store: the standard PEM bundles and
+// directories on Linux and other Unix systems (or those named by the
+// SSL_CERT_FILE and SSL_CERT_DIR environment variables), or the system
+// roots keychain on macOS. Duplicates are only returned once.
+func ReadSystemTrustStore() ([]*x509.Certificate, error) {
+	return readSystemTrustStore()
+}
+
+// ContainsCert returns whether the given pool (such as a trust store or a CA
+// bundle) contains exactly the target certificate. Certificates are compared
+// by their SHA-256 fingerprint, so a re-issued certificate with the same
+// subject and key doesn't count.
+func ContainsCert(pool []*x509.Certificate, target *x509.Certificate) bool {
+	fingerprint := sha256.Sum256(target.Raw)
+	for _, cert := range pool {
+		if sha256.Sum256(cert.Raw) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// SystemTrustStoreContains returns whether the target certificate is present
+// in the system trust store, as read by ReadSystemTrustStore.
+func SystemTrustStoreContains(target *x509.Certificate) (bool, error) {
+	certs, err := ReadSystemTrustStore()
+	if err != nil {
+		return false, err
+	}
+	return ContainsCert(certs, target), nil
+}
+
+// readTrustStoreFiles reads the certificates from the first of the given
+// bundle files that exists, and from the files in each of the given
+// directories. Files in directories that can't be read as certificates
+// (such as a README) are skipped.
+func readTrustStoreFiles(files, dirs []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	seen := map[string]bool{}
+	add := func(path string) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		var found []*x509.Certificate
+		err = ReadX509WithOptions([]io.Reader{file}, ReadOptions{Format: "PEM"}, func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			found = append(found, cert)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", path, err)
+		}
+		for _, cert := range found {
+			if !seen[string(cert.Raw)] {
+				seen[string(cert.Raw)] = true
+				certs = append(certs, cert)
+			}
+		}
+		return nil
+	}
+
+	for _, path := range files {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := add(path); err != nil {
+			return nil, err
+		}
+		break
+	}
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			// Hash links (e.g. 1a2b3c4d.0) point to regular files
+			path := filepath.Join(dir, entry.Name())
+			if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			add(path)
+		}
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no trusted certificates found in %v or %v", files, dirs)
+	}
+	return certs, nil
+}
@@ -0,0 +1,51 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// systemRootsKeychain is the keychain holding the roots trusted by macOS.
+const systemRootsKeychain = "/System/Library/Keychains/SystemRootCertificates.keychain"
+
+func readSystemTrustStore() ([]*x509.Certificate, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("/usr/bin/security", "find-certificate", "-a", "-p", systemRootsKeychain)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %s (%s)", systemRootsKeychain, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var certs []*x509.Certificate
+	err = ReadX509WithOptions([]io.Reader{bytes.NewReader(out)}, ReadOptions{Format: "PEM"}, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
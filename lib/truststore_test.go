@@ -0,0 +1,96 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadTrustStoreFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certigo-truststore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyA, keyB, keyC := newTestKey(t), newTestKey(t), newTestKey(t)
+	rootA := issueTestCert(t, "root A", keyA, "root A", keyA)
+	rootB := issueTestCert(t, "root B", keyB, "root B", keyB)
+	rootC := issueTestCert(t, "root C", keyC, "root C", keyC)
+	write := func(name string, certs ...*x509.Certificate) {
+		var data []byte
+		for _, cert := range certs {
+			data = append(data, pem.EncodeToMemory(EncodeX509ToPEM(cert, nil))...)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "certs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write("bundle.crt", rootA, rootB)
+	write("other-bundle.crt", rootC)
+	write("certs/root-b.pem", rootB)
+	write("certs/root-c.pem", rootC)
+	if err := ioutil.WriteFile(filepath.Join(dir, "certs", "README"), []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{filepath.Join(dir, "missing.crt"), filepath.Join(dir, "bundle.crt"), filepath.Join(dir, "other-bundle.crt")}
+	dirs := []string{filepath.Join(dir, "missing"), filepath.Join(dir, "certs")}
+	certs, err := readTrustStoreFiles(files, dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 || !certs[0].Equal(rootA) || !certs[1].Equal(rootB) || !certs[2].Equal(rootC) {
+		t.Errorf("unexpected certificates: %v", certs)
+	}
+
+	if _, err := readTrustStoreFiles(files[:1], dirs[:1]); err == nil {
+		t.Error("expected an error without any certificates")
+	}
+}
+
+func TestContainsCert(t *testing.T) {
+	key := newTestKey(t)
+	root := issueTestCert(t, "root", key, "root", key)
+	reissued := issueTestCert(t, "root", key, "root", key)
+	other := issueTestCert(t, "other", newTestKey(t), "root", key)
+
+	// A parsed copy of the same certificate is found
+	copied, err := x509.ParseCertificate(root.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := []*x509.Certificate{other, copied}
+	if !ContainsCert(pool, root) {
+		t.Error("expected root to be found")
+	}
+	if ContainsCert(pool, reissued) {
+		t.Error("expected re-issued root not to be found")
+	}
+	if ContainsCert(nil, root) {
+		t.Error("expected nothing to be found in an empty pool")
+	}
+}
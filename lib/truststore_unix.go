@@ -0,0 +1,57 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+)
+
+// trustStoreFiles are the locations of the system's CA bundle on various
+// Linux distributions and other Unix systems, as in the x509 package.
+var trustStoreFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian/Ubuntu/Gentoo etc.
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // Fedora/RHEL 6
+	"/etc/ssl/ca-bundle.pem",                            // OpenSUSE
+	"/etc/pki/tls/cacert.pem",                           // OpenELEC
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // CentOS/RHEL 7
+	"/etc/ssl/cert.pem",                                 // Alpine Linux, BSDs
+	"/usr/local/etc/ssl/cert.pem",                       // FreeBSD
+}
+
+// trustStoreDirs are the directories holding the system's trusted
+// certificates as individual files.
+var trustStoreDirs = []string{
+	"/etc/ssl/certs",               // SLES10/SLES11
+	"/etc/pki/tls/certs",           // Fedora/RHEL
+	"/system/etc/security/cacerts", // Android
+}
+
+func readSystemTrustStore() ([]*x509.Certificate, error) {
+	files, dirs := trustStoreFiles, trustStoreDirs
+	if file := os.Getenv("SSL_CERT_FILE"); file != "" {
+		files = []string{file}
+	}
+	if dir := os.Getenv("SSL_CERT_DIR"); dir != "" {
+		dirs = filepath.SplitList(dir)
+	}
+	return readTrustStoreFiles(files, dirs)
+}
@@ -0,0 +1,187 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Limits for fetching certificates over HTTP(S).
+	maxURLRedirects = 5
+	maxURLBodySize  = 16 << 20
+
+	// dataURIPrefix starts an RFC 2397 data: URI, as used to embed
+	// certificates in HTML and config files.
+	dataURIPrefix = "data:"
+)
+
+var urlHttpClient = &http.Client{
+	// Set a timeout so we don't block forever on broken servers.
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxURLRedirects {
+			return errors.New("stopped after too many redirects")
+		}
+		return nil
+	},
+}
+
+// namedReader gives a reader a name, like *os.File has, so that it's used
+// for format guessing and error messages.
+type namedReader struct {
+	io.Reader
+	name string
+}
+
+func (r namedReader) Name() string {
+	return r.name
+}
+
+// ReadX509FromURL fetches the given HTTP(S) URL and reads X.509 certificates
+// from the response body, like ReadX509WithOptions. The format is guessed
+// from the URL's file extension or the data, unless set in the options.
+func ReadX509FromURL(url string, opts ReadOptions, callback func(*x509.Certificate, string, error) error) error {
+	body, err := fetchURL(url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return ReadX509WithOptions([]io.Reader{namedReader{io.LimitReader(body, maxURLBodySize), url}}, opts, callback)
+}
+
+// FetchIssuer downloads the issuer of the given certificate from the CA
+// Issuers URLs in its AIA extension (cert.IssuingCertificateURL), as
+// browsers do to complete chains that are missing an intermediate. The
+// responses may hold a DER certificate, a PKCS7 envelope (DER or PEM) or
+// PEM certificates; the first certificate that issued the given one (by
+// name and signature) is returned. Requests time out after 10 seconds.
+func FetchIssuer(cert *x509.Certificate) (*x509.Certificate, error) {
+	if len(cert.IssuingCertificateURL) == 0 {
+		return nil, errors.New("certificate has no CA Issuers URL")
+	}
+
+	var errs []error
+	for _, url := range cert.IssuingCertificateURL {
+		issuer, err := fetchIssuerFrom(url, cert)
+		if err == nil {
+			return issuer, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errorFromErrors(errs)
+}
+
+// fetchIssuerFrom fetches the certificates at the given URL and returns the
+// one that issued the given certificate.
+func fetchIssuerFrom(url string, cert *x509.Certificate) (*x509.Certificate, error) {
+	body, err := fetchURL(url)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxURLBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %s", url, err)
+	}
+
+	// CA Issuers URLs usually end in .crt, but serve DER
+	format := "DER"
+	if bytes.Contains(data, pemStart) {
+		format = "PEM"
+	}
+	var candidates []*x509.Certificate
+	input := WithFormat(namedReader{bytes.NewReader(data), url}, format)
+	err = ReadX509WithOptions([]io.Reader{input}, ReadOptions{}, func(candidate *x509.Certificate, format string, err error) error {
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, candidate)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", url, strings.TrimSuffix(err.Error(), "\n"))
+	}
+
+	for _, candidate := range candidates {
+		if findIssuer(cert, []*x509.Certificate{candidate}) != nil && cert.CheckSignatureFrom(candidate) == nil {
+			return candidate, nil
+		}
+	}
+	return nil, fmt.Errorf("no issuer of the certificate found at %s", url)
+}
+
+// fetchURL performs a GET request, and returns the body of the response if
+// it was successful.
+func fetchURL(url string) (io.ReadCloser, error) {
+	resp, err := urlHttpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %s", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error fetching %s: unexpected status code, got: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// decodeDataURI reads an RFC 2397 data: URI (such as
+// "data:application/x-x509-ca-cert;base64,MII...") and returns its payload,
+// base64-decoded if the URI says it's base64.
+func decodeDataURI(reader io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxURLBodySize))
+	if err != nil {
+		return nil, err
+	}
+	uri := strings.TrimSpace(string(data))
+	if len(uri) < len(dataURIPrefix) || !strings.EqualFold(uri[:len(dataURIPrefix)], dataURIPrefix) {
+		return nil, errors.New("not a data URI")
+	}
+	comma := strings.IndexByte(uri, ',')
+	if comma < 0 {
+		return nil, errors.New("malformed data URI, missing ','")
+	}
+	params, payload := uri[len(dataURIPrefix):comma], uri[comma+1:]
+	payload, err = url.PathUnescape(payload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed data URI: %s", err)
+	}
+	if !strings.HasSuffix(strings.ToLower(params), ";base64") {
+		return []byte(payload), nil
+	}
+	payload = strings.Join(strings.Fields(payload), "")
+	decoded, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		// Some encoders leave out the padding
+		decoded, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed base64 in data URI: %s", err)
+	}
+	return decoded, nil
+}
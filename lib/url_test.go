@@ -0,0 +1,151 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/square/certigo/pkcs7"
+)
+
+func TestReadX509FromURL(t *testing.T) {
+	cert := readTestFile(t, "../test-certs/example-leaf.crt")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/leaf.crt":
+			w.Write([]byte(cert))
+		case "/redirect":
+			http.Redirect(w, r, "/redirect", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	count := 0
+	err := ReadX509FromURL(server.URL+"/leaf.crt", ReadOptions{}, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("unexpected number of certificates: %d != 1", count)
+	}
+
+	noop := func(*x509.Certificate, string, error) error { return nil }
+	if err := ReadX509FromURL(server.URL+"/missing", ReadOptions{}, noop); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected not found error, got: %v", err)
+	}
+	if err := ReadX509FromURL(server.URL+"/redirect", ReadOptions{}, noop); err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("expected redirect error, got: %v", err)
+	}
+}
+
+func TestFetchIssuer(t *testing.T) {
+	rootKey, intKey, leafKey, otherKey := newTestKey(t), newTestKey(t), newTestKey(t), newTestKey(t)
+	intermediate := issueTestCert(t, "intermediate", intKey, "root", rootKey)
+	other := issueTestCert(t, "other", otherKey, "root", rootKey)
+	impostor := issueTestCert(t, "intermediate", otherKey, "root", rootKey)
+	envelope, err := pkcs7.BuildCertsOnly([]*x509.Certificate{other, intermediate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/intermediate.crt":
+			w.Write(intermediate.Raw)
+		case "/intermediate.p7c":
+			w.Write(envelope)
+		case "/intermediate.pem":
+			pem.Encode(w, EncodeX509ToPEM(intermediate, nil))
+		case "/impostor.crt":
+			w.Write(impostor.Raw)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	leaf := *issueTestCert(t, "leaf", leafKey, "intermediate", intKey)
+	for _, path := range []string{"/intermediate.crt", "/intermediate.p7c", "/intermediate.pem"} {
+		leaf.IssuingCertificateURL = []string{server.URL + "/missing", server.URL + path}
+		issuer, err := FetchIssuer(&leaf)
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+		} else if !issuer.Equal(intermediate) {
+			t.Errorf("%s: unexpected issuer: %s", path, issuer.Subject)
+		}
+	}
+
+	leaf.IssuingCertificateURL = []string{server.URL + "/impostor.crt"}
+	if _, err := FetchIssuer(&leaf); err == nil || !strings.Contains(err.Error(), "no issuer") {
+		t.Errorf("expected error for a certificate with the wrong key, got: %v", err)
+	}
+	leaf.IssuingCertificateURL = nil
+	if _, err := FetchIssuer(&leaf); err == nil {
+		t.Error("expected error for a certificate without CA Issuers URL")
+	}
+}
+
+func TestReadDataURI(t *testing.T) {
+	block, _ := pem.Decode([]byte(readTestFile(t, "../test-certs/example-leaf.crt")))
+	if block == nil {
+		t.Fatal("unable to decode test certificate")
+	}
+
+	inputs := []string{
+		"data:application/x-x509-ca-cert;base64," + base64.StdEncoding.EncodeToString(block.Bytes) + "\n",
+		"DATA:application/pkix-cert;BASE64," + base64.RawStdEncoding.EncodeToString(block.Bytes),
+		"data:," + url.PathEscape(string(pem.EncodeToMemory(block))),
+	}
+	for i, input := range inputs {
+		count := 0
+		err := ReadX509WithOptions([]io.Reader{strings.NewReader(input)}, ReadOptions{}, func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			count++
+			return nil
+		})
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+		} else if count != 1 {
+			t.Errorf("case %d: unexpected number of certificates: %d != 1", i, count)
+		}
+	}
+
+	noop := func(*x509.Certificate, string, error) error { return nil }
+	for _, input := range []string{"data:;base64", "data:;base64,!!!"} {
+		if err := ReadX509WithOptions([]io.Reader{strings.NewReader(input)}, ReadOptions{}, noop); err == nil {
+			t.Errorf("expected error for malformed data URI %q", input)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// vaultBundle is the certificate bundle returned by the HashiCorp Vault PKI
+// secrets engine (e.g. from pki/issue). The fields are either at the top
+// level, or in "data" if it's the full API response.
+type vaultBundle struct {
+	Data        *vaultBundle `json:"data"`
+	Certificate string       `json:"certificate"`
+	IssuingCA   string       `json:"issuing_ca"`
+	CAChain     []string     `json:"ca_chain"`
+	PrivateKey  string       `json:"private_key"`
+}
+
+var errNotVaultBundle = errors.New("JSON input doesn't look like a Vault PKI certificate bundle")
+
+// vaultBundleKeys are the keys of a Vault PKI bundle that hold PEM data.
+var vaultBundleKeys = [][]byte{[]byte(`"certificate"`), []byte(`"issuing_ca"`), []byte(`"ca_chain"`), []byte(`"private_key"`)}
+
+// looksLikeVaultBundle checks whether the given start of some JSON input
+// has any of the keys of a Vault PKI bundle, for guessing the format.
+func looksLikeVaultBundle(prefix []byte) bool {
+	for _, key := range vaultBundleKeys {
+		if bytes.Contains(prefix, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// readVaultBundle reads the PEM values from a Vault PKI JSON bundle, passing
+// them to the callback with the name of the field they came from in the
+// originFile header (e.g. "vault:certificate").
+func readVaultBundle(reader io.Reader, opts ReadOptions, callback func(*pem.Block, string) error) error {
+	var bundle vaultBundle
+	if err := json.NewDecoder(reader).Decode(&bundle); err != nil {
+		return fmt.Errorf("unable to parse JSON: %s", err)
+	}
+	if bundle.Data != nil {
+		bundle = *bundle.Data
+	}
+
+	type field struct {
+		name  string
+		value string
+	}
+	var fields []field
+	if bundle.Certificate != "" {
+		fields = append(fields, field{"certificate", bundle.Certificate})
+	}
+	if bundle.IssuingCA != "" && !containsString(bundle.CAChain, bundle.IssuingCA) {
+		// The issuing CA is usually the first entry in the chain as well
+		fields = append(fields, field{"issuing_ca", bundle.IssuingCA})
+	}
+	for _, value := range bundle.CAChain {
+		fields = append(fields, field{"ca_chain", value})
+	}
+	if bundle.PrivateKey != "" {
+		fields = append(fields, field{"private_key", bundle.PrivateKey})
+	}
+	if len(fields) == 0 {
+		return errNotVaultBundle
+	}
+
+	for _, f := range fields {
+		headers := map[string]string{fileHeader: "vault:" + f.name}
+		err := readCertsFromStream(strings.NewReader(f.value), "", "PEM", opts, func(block *pem.Block, format string) error {
+			block.Headers = mergeHeaders(block.Headers, headers)
+			return callback(block, "JSON")
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if strings.TrimSpace(item) == strings.TrimSpace(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadVaultBundle(t *testing.T) {
+	key := newTestKey(t)
+	root := issueTestCert(t, "Vault Root", key, "Vault Root", key)
+	intermediate := issueTestCert(t, "Vault Intermediate", key, "Vault Root", key)
+	leaf := issueTestCert(t, "Vault Leaf", key, "Vault Intermediate", key)
+	keyBlock, err := keyToPem(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(cert *x509.Certificate) string {
+		return string(pem.EncodeToMemory(EncodeX509ToPEM(cert, nil)))
+	}
+	response := map[string]interface{}{
+		"lease_id": "",
+		"data": map[string]interface{}{
+			"certificate":      encode(leaf),
+			"issuing_ca":       encode(intermediate),
+			"ca_chain":         []string{encode(intermediate), encode(root)},
+			"private_key":      string(pem.EncodeToMemory(keyBlock)),
+			"private_key_type": "ec",
+			"serial_number":    "01:02:03",
+		},
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var blocks []string
+	err = ReadAsPEM([]io.Reader{bytes.NewReader(data)}, "", nil, func(block *pem.Block, format string) error {
+		if format != "JSON" {
+			t.Errorf("unexpected format: %s", format)
+		}
+		blocks = append(blocks, block.Type+"@"+block.Headers["originFile"])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "CERTIFICATE@vault:certificate,CERTIFICATE@vault:ca_chain,CERTIFICATE@vault:ca_chain,EC PRIVATE KEY@vault:private_key"
+	if strings.Join(blocks, ",") != expected {
+		t.Errorf("unexpected blocks: %v", blocks)
+	}
+
+	err = ReadAsPEM([]io.Reader{strings.NewReader(`{"foo": "bar"}`)}, "JSON", nil, func(block *pem.Block, format string) error {
+		t.Error("unexpected block from non-Vault JSON")
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "Vault") {
+		t.Errorf("expected error for non-Vault JSON, got: %v", err)
+	}
+
+	// Other JSON, or text starting with a brace, isn't taken for a bundle
+	for _, input := range []string{`{"foo": "bar"}`, "{not json at all}"} {
+		err = ReadAsPEM([]io.Reader{strings.NewReader(input)}, "", nil, func(block *pem.Block, format string) error {
+			t.Errorf("unexpected block from %q", input)
+			return nil
+		})
+		if err == nil || !strings.Contains(err.Error(), "unable to guess") {
+			t.Errorf("expected format guessing to fail for %q, got: %v", input, err)
+		}
+	}
+}
@@ -43,6 +43,9 @@ type SimpleVerification struct {
 	OCSPWasStapled bool                 `json:"ocsp_was_stapled,omitempty"`
 	OCSPError      string               `json:"ocsp_error,omitempty"`
 	Chains         [][]simpleVerifyCert `json:"chains"`
+	// CustomRootsOnly is set if the chain verified against a custom pool
+	// of trust anchors, but not against the system roots.
+	CustomRootsOnly bool `json:"custom_roots_only,omitempty"`
 }
 
 type SimpleResult struct {
@@ -51,6 +54,7 @@ type SimpleResult struct {
 	VerifyResult           *SimpleVerification `json:"verify_result,omitempty"`
 	TLSConnectionState     *tls.ConnectionState
 	CertificateRequestInfo *tls.CertificateRequestInfo
+	PinningPolicy          *PinningPolicy
 }
 
 func (s SimpleResult) MarshalJSON() ([]byte, error) {
@@ -74,6 +78,9 @@ func (s SimpleResult) MarshalJSON() ([]byte, error) {
 		}
 		out["certificate_request_info"] = encoded
 	}
+	if s.PinningPolicy != nil {
+		out["pinning_policy"] = s.PinningPolicy
+	}
 	return json.Marshal(out)
 }
 
@@ -86,30 +93,56 @@ func caBundle(caPath string) (*x509.CertPool, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error opening CA bundle %s: %s\n", caPath, err)
 	}
+	defer caFile.Close()
+
+	bundle, err := LoadCertPool([]io.Reader{caFile})
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA bundle: %s\n", err)
+	}
+	return bundle, nil
+}
 
+// LoadCertPool reads certificates from the given inputs into a new cert
+// pool, for use as custom trust anchors (e.g. an internal CA bundle) when
+// verifying. Inputs may be in any format the Read functions accept.
+func LoadCertPool(inputs []io.Reader) (*x509.CertPool, error) {
 	bundle := x509.NewCertPool()
-	err = ReadAsX509FromFiles(
-		[]*os.File{caFile},
-		"",
-		func(prompt string) string {
+	opts := ReadOptions{
+		Password: func(prompt string) (string, error) {
 			// TODO: The JDK trust store ships with this password.
-			return "changeit"
+			return "changeit", nil
 		},
-		func(cert *x509.Certificate, format string, err error) error {
-			if err != nil {
-				return fmt.Errorf("error parsing CA bundle: %s\n", err)
-			} else {
-				bundle.AddCert(cert)
-			}
-			return nil
-		})
+	}
+	err := ReadX509WithOptions(inputs, opts, func(cert *x509.Certificate, format string, err error) error {
+		if err != nil {
+			return fmt.Errorf("error parsing CA bundle: %s\n", err)
+		}
+		bundle.AddCert(cert)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error parsing CA bundle: %s\n", err)
+		return nil, err
 	}
 	return bundle, nil
 }
 
 func VerifyChain(certs []*x509.Certificate, ocspStaple []byte, dnsName, caPath string) SimpleVerification {
+	roots, err := caBundle(caPath)
+	if err != nil {
+		return SimpleVerification{
+			Error:          fmt.Sprintf("%s", err),
+			Chains:         [][]simpleVerifyCert{},
+			OCSPWasStapled: ocspStaple != nil,
+		}
+	}
+	return VerifyChainWithRoots(certs, ocspStaple, dnsName, roots)
+}
+
+// VerifyChainWithRoots verifies the given chain like VerifyChain, but against
+// the given pool of trust anchors (or the system roots, if nil). If a custom
+// pool is given and the chain doesn't also verify against the system roots,
+// CustomRootsOnly is set in the result.
+func VerifyChainWithRoots(certs []*x509.Certificate, ocspStaple []byte, dnsName string, roots *x509.CertPool) SimpleVerification {
 	result := SimpleVerification{
 		Chains:         [][]simpleVerifyCert{},
 		OCSPWasStapled: ocspStaple != nil,
@@ -125,11 +158,6 @@ func VerifyChain(certs []*x509.Certificate, ocspStaple []byte, dnsName, caPath s
 		intermediates.AddCert(certs[i])
 	}
 
-	roots, err := caBundle(caPath)
-	if err != nil {
-		result.Error = fmt.Sprintf("%s", err)
-		return result
-	}
 	opts := x509.VerifyOptions{
 		DNSName:       dnsName,
 		Roots:         roots,
@@ -142,8 +170,21 @@ func VerifyChain(certs []*x509.Certificate, ocspStaple []byte, dnsName, caPath s
 		return result
 	}
 
+	if roots != nil {
+		opts.Roots = nil
+		if _, err := certs[0].Verify(opts); err != nil {
+			result.CustomRootsOnly = true
+		}
+	}
+
 	for _, chain := range chains {
-		status, err := checkOCSP(chain, ocspStaple)
+		var status *ocsp.Response
+		if len(ocspStaple) > 0 && len(chain) > 1 {
+			// A staple (as from connect) must also be current
+			status, err = CheckOCSPStaple(chain, ocspStaple)
+		} else {
+			status, err = checkOCSP(chain, nil)
+		}
 		if err == nil {
 			result.OCSPStatus = status
 		}
@@ -194,6 +235,9 @@ func PrintVerifyResult(out io.Writer, result SimpleVerification) {
 
 func printCertificateChains(out io.Writer, result SimpleVerification) {
 	fmt.Fprintf(out, green.SprintfFunc()("Found %d valid certificate chain(s):\n", len(result.Chains)))
+	if result.CustomRootsOnly {
+		fmt.Fprintf(out, yellow.SprintfFunc()("Note: chain(s) only valid against the custom CA bundle, not the system roots\n"))
+	}
 	for i, chain := range result.Chains {
 		fmt.Fprintf(out, "[%d] %s\n", i, fmtCert(chain[0]))
 		for j, cert := range chain {
@@ -243,3 +287,52 @@ func printOCSPStatus(out io.Writer, result SimpleVerification) {
 		fmt.Fprintf(out, "\n\n")
 	}
 }
+
+// IsValidFor checks if the given certificate is valid for the given purpose
+// at the given time, against the system roots. See IsValidForWithRoots.
+func IsValidFor(cert *x509.Certificate, chain []*x509.Certificate, host string, usage x509.ExtKeyUsage, at time.Time) (bool, string) {
+	return IsValidForWithRoots(cert, chain, host, usage, at, nil)
+}
+
+// IsValidForWithRoots checks if the given certificate is valid for the given
+// purpose at the given time: that it is within its validity period, allows
+// the extended key usage, matches the host name (unless empty) and chains
+// up to one of the roots (the system roots, if nil) via the given chain of
+// intermediates. If not, the reason is returned along with false.
+func IsValidForWithRoots(cert *x509.Certificate, chain []*x509.Certificate, host string, usage x509.ExtKeyUsage, at time.Time, roots *x509.CertPool) (bool, string) {
+	if at.Before(cert.NotBefore) {
+		return false, fmt.Sprintf("certificate is not valid before %s", cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if at.After(cert.NotAfter) {
+		return false, fmt.Sprintf("certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	if usage != x509.ExtKeyUsageAny && len(cert.ExtKeyUsage) > 0 && !hasExtKeyUsage(cert, usage) {
+		name, ok := extKeyUsageStrings[usage]
+		if !ok {
+			name = fmt.Sprintf("extended key usage %d", usage)
+		}
+		return false, fmt.Sprintf("certificate is not valid for %s", name)
+	}
+
+	if host != "" {
+		if err := cert.VerifyHostname(host); err != nil {
+			return false, err.Error()
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain {
+		intermediates.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	if err != nil {
+		return false, err.Error()
+	}
+	return true, ""
+}
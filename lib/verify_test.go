@@ -0,0 +1,170 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestIsValidFor(t *testing.T) {
+	rootKey, leafKey := newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"example.com"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root, &leafKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	now := time.Now()
+	testCases := []struct {
+		host   string
+		usage  x509.ExtKeyUsage
+		at     time.Time
+		roots  *x509.CertPool
+		reason string
+	}{
+		{"example.com", x509.ExtKeyUsageServerAuth, now, roots, ""},
+		{"", x509.ExtKeyUsageServerAuth, now, roots, ""},
+		{"example.com", x509.ExtKeyUsageServerAuth, now.Add(2 * time.Hour), roots, "certificate expired"},
+		{"example.com", x509.ExtKeyUsageServerAuth, now.Add(-2 * time.Hour), roots, "certificate is not valid before"},
+		{"example.com", x509.ExtKeyUsageClientAuth, now, roots, "certificate is not valid for Client Auth"},
+		{"example.org", x509.ExtKeyUsageServerAuth, now, roots, "example.org"},
+		{"example.com", x509.ExtKeyUsageServerAuth, now, x509.NewCertPool(), "unknown authority"},
+	}
+	for i, tc := range testCases {
+		ok, reason := IsValidForWithRoots(leaf, nil, tc.host, tc.usage, tc.at, tc.roots)
+		if ok != (tc.reason == "") {
+			t.Errorf("case %d: unexpected result %v (%s)", i, ok, reason)
+		}
+		if !strings.Contains(reason, tc.reason) {
+			t.Errorf("case %d: expected reason containing %q, got %q", i, tc.reason, reason)
+		}
+	}
+}
+
+func TestVerifyChainWithRoots(t *testing.T) {
+	rootKey, leafKey := newTestKey(t), newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root, &leafKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots, err := LoadCertPool([]io.Reader{bytes.NewReader(pem.EncodeToMemory(EncodeX509ToPEM(root, nil)))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := VerifyChainWithRoots([]*x509.Certificate{leaf}, nil, "example.com", roots)
+	if result.Error != "" {
+		t.Fatal(result.Error)
+	}
+	if !result.CustomRootsOnly {
+		t.Error("expected chain to only verify against the custom roots")
+	}
+	if len(result.Chains) != 1 || len(result.Chains[0]) != 2 || !result.Chains[0][1].IsSelfSigned {
+		t.Errorf("unexpected chains: %+v", result.Chains)
+	}
+
+	if result := VerifyChainWithRoots([]*x509.Certificate{leaf}, nil, "example.org", roots); result.Error == "" {
+		t.Error("expected error for wrong host name")
+	}
+	if result := VerifyChainWithRoots([]*x509.Certificate{leaf}, nil, "", x509.NewCertPool()); result.Error == "" {
+		t.Error("expected error for unknown root")
+	}
+
+	if _, err := LoadCertPool([]io.Reader{strings.NewReader("-----BEGIN CERTIFICATE-----\nbm90IGEgY2VydGlmaWNhdGU=\n-----END CERTIFICATE-----\n")}); err == nil {
+		t.Error("expected error for malformed bundle")
+	}
+}
+
+func TestVerifyChainOCSPStaple(t *testing.T) {
+	rootKey := newTestKey(t)
+	root := issueTestCert(t, "root", rootKey, "root", rootKey)
+	leaf := issueTestCert(t, "leaf", newTestKey(t), "root", rootKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	staple := func(status int, nextUpdate time.Time) []byte {
+		resp, err := ocsp.CreateResponse(root, root, ocsp.Response{
+			Status:       status,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Hour),
+			NextUpdate:   nextUpdate,
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, rootKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	result := VerifyChainWithRoots([]*x509.Certificate{leaf}, staple(ocsp.Good, time.Now().Add(time.Hour)), "", roots)
+	if result.Error != "" || result.OCSPError != "" {
+		t.Fatalf("unexpected errors: %s, %s", result.Error, result.OCSPError)
+	}
+	if !result.OCSPWasStapled || result.OCSPStatus == nil || result.OCSPStatus.Status != ocsp.Good {
+		t.Errorf("unexpected OCSP result: %+v", result)
+	}
+
+	result = VerifyChainWithRoots([]*x509.Certificate{leaf}, staple(ocsp.Revoked, time.Now().Add(time.Hour)), "", roots)
+	if result.OCSPStatus == nil || result.OCSPStatus.Status != ocsp.Revoked {
+		t.Errorf("expected revoked status, got: %+v", result)
+	}
+
+	result = VerifyChainWithRoots([]*x509.Certificate{leaf}, staple(ocsp.Good, time.Now().Add(-time.Minute)), "", roots)
+	if result.OCSPStatus != nil || !strings.Contains(result.OCSPError, "stale") {
+		t.Errorf("expected stale staple error, got: %+v", result)
+	}
+}
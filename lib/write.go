@@ -0,0 +1,203 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteCertsToDir writes each of the given certificates to its own PEM file
+// in the given directory, named by the namer function (or by common name if
+// nil, falling back to the SHA-256 fingerprint). Existing files are never
+// overwritten: name collisions get a numeric suffix instead. Returns the
+// paths of the files written.
+func WriteCertsToDir(dir string, namer func(*x509.Certificate) string, certs ...*x509.Certificate) ([]string, error) {
+	if namer == nil {
+		namer = defaultCertFileName
+	}
+
+	paths := []string{}
+	for _, cert := range certs {
+		name := sanitizeFileName(namer(cert))
+		if name == "" {
+			name = defaultCertFileName(cert)
+		}
+
+		file, path, err := createUniqueFile(dir, name, ".pem")
+		if err != nil {
+			return paths, err
+		}
+		err = pem.Encode(file, EncodeX509ToPEM(cert, nil))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return paths, fmt.Errorf("error writing %s: %s", path, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func defaultCertFileName(cert *x509.Certificate) string {
+	if name := sanitizeFileName(cert.Subject.CommonName); name != "" {
+		return name
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// sanitizeFileName replaces characters that are problematic in file names.
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == ':' || r == '*' || r == '?' ||
+			r == '"' || r == '<' || r == '>' || r == '|' || r < ' ':
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	return strings.TrimLeft(name, ".")
+}
+
+// createUniqueFile creates a new file named base+ext in dir, or base-N+ext
+// for the first N that doesn't exist yet.
+func createUniqueFile(dir, base, ext string) (*os.File, string, error) {
+	for i := 0; ; i++ {
+		name := base + ext
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		path := filepath.Join(dir, name)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("error creating %s: %s", path, err)
+		}
+		return file, path, nil
+	}
+}
+
+// Transcode reads the given input in the given format (guessed if empty),
+// like ReadPEMWithOptions, and writes what was read to w in the given output
+// format: PEM writes all blocks (certificates, keys, etc.), while DER writes
+// the certificates, concatenated. Key stores (PKCS12, JCEKS) can't be
+// written, as they require a key and a password.
+func Transcode(in io.Reader, inFormat, outFormat string, w io.Writer, password func(string) (string, error)) error {
+	var write func(*pem.Block, string) error
+	switch strings.ToUpper(outFormat) {
+	case "PEM":
+		write = func(block *pem.Block, format string) error {
+			return pem.Encode(w, &pem.Block{
+				Type:    block.Type,
+				Headers: encryptionHeaders(block.Headers),
+				Bytes:   block.Bytes,
+			})
+		}
+	case "DER":
+		writeCert := pemToX509(func(cert *x509.Certificate, format string, err error) error {
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(cert.Raw)
+			return err
+		}, true)
+		write = func(block *pem.Block, format string) error {
+			if strings.HasSuffix(block.Type, "PRIVATE KEY") || block.Type == "SECRET KEY" {
+				return fmt.Errorf("unable to write %s as DER, only certificates are supported (try PEM)", strings.ToLower(block.Type))
+			}
+			return writeCert(block, format)
+		}
+	case "PKCS12", "JCEKS", "BKS":
+		return fmt.Errorf("unable to write %s: key stores require a key and password, which conversion doesn't support", outFormat)
+	default:
+		return fmt.Errorf("unsupported output format: %s (expected PEM or DER)", outFormat)
+	}
+
+	return ReadPEMWithOptions([]io.Reader{in}, ReadOptions{Format: inFormat, Password: password}, write)
+}
+
+// CompressedWriter wraps w so that output written to it (e.g. PEM blocks
+// from Transcode) is gzip-compressed at the given level, from
+// gzip.BestSpeed to gzip.BestCompression, or gzip.DefaultCompression. The
+// returned writer must be closed to flush the compressed data, which
+// doesn't close w.
+func CompressedWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	gz, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip compression level %d (expected %d to %d)", level, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	return gz, nil
+}
+
+// EncodeAnnotatedPEM writes a PEM block to w, preceded by comment lines
+// describing the certificate in it (subject, issuer, validity and SHA-256
+// fingerprint), for bundles that are meant to be read by people as well.
+// The comments are outside the PEM armor, so the output is still a valid
+// PEM bundle. Blocks that aren't certificates are written as is.
+func EncodeAnnotatedPEM(w io.Writer, block *pem.Block) error {
+	var cert *x509.Certificate
+	var err error
+	switch block.Type {
+	case PEMTypeCertificate, PEMTypeX509Certificate:
+		cert, err = x509.ParseCertificate(block.Bytes)
+	case PEMTypeTrustedCertificate:
+		cert, _, err = ParseTrustedCertificate(block.Bytes)
+	}
+	if cert != nil && err == nil {
+		subject, issuer := cert.Subject.String(), cert.Issuer.String()
+		if formatted, err := FormatDN(cert.RawSubject); err == nil {
+			subject = formatted
+		}
+		if formatted, err := FormatDN(cert.RawIssuer); err == nil {
+			issuer = formatted
+		}
+		fingerprint := sha256.Sum256(cert.Raw)
+		_, err = fmt.Fprintf(w, "# Subject: %s\n# Issuer: %s\n# Valid: %s to %s\n# SHA-256: %s\n",
+			subject, issuer,
+			cert.NotBefore.UTC().Format("2006-01-02 15:04 MST"),
+			cert.NotAfter.UTC().Format("2006-01-02 15:04 MST"),
+			hexify(fingerprint[:]))
+		if err != nil {
+			return err
+		}
+	}
+	return pem.Encode(w, block)
+}
+
+// encryptionHeaders returns the RFC 1421 encryption headers (needed to
+// decrypt legacy encrypted keys), leaving out certigo's own headers.
+func encryptionHeaders(headers map[string]string) map[string]string {
+	out := map[string]string{}
+	for _, key := range []string{"Proc-Type", "DEK-Info"} {
+		if value, ok := headers[key]; ok {
+			out[key] = value
+		}
+	}
+	return out
+}
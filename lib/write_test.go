@@ -0,0 +1,217 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWriteCertsToDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certigo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, err := ParseCertificatePEM(readTestFile(t, "../test-certs/example-leaf.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := WriteCertsToDir(dir, nil, cert, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dir, "example-leaf.pem"),
+		filepath.Join(dir, "example-leaf-1.pem"),
+	}
+	if len(paths) != 2 || paths[0] != expected[0] || paths[1] != expected[1] {
+		t.Fatalf("unexpected paths: %v", paths)
+	}
+
+	for _, path := range paths {
+		written, err := ParseCertificatePEM(readTestFile(t, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !written.Equal(cert) {
+			t.Fatalf("%s: certificate differs", path)
+		}
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTranscode(t *testing.T) {
+	certPEM := readTestFile(t, "../test-certs/example-leaf.crt")
+	block, _ := pem.Decode([]byte(certPEM))
+
+	// PEM to DER and back
+	var der bytes.Buffer
+	if err := Transcode(strings.NewReader(certPEM), "", "der", &der, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(der.Bytes(), block.Bytes) {
+		t.Error("unexpected DER output")
+	}
+	var out bytes.Buffer
+	if err := Transcode(bytes.NewReader(der.Bytes()), "DER", "PEM", &out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != string(pem.EncodeToMemory(block)) {
+		t.Errorf("unexpected PEM output: %s", out.String())
+	}
+
+	// Key stores to PEM, with the key and certificate
+	keyStores := []struct {
+		file, format string
+		password     func(string) (string, error)
+	}{
+		{"testdata/password.p12", "PKCS12", PasswordFromMap(nil, "password")},
+		{"../jceks/testdata/private-key.jceks", "JCEKS", PasswordFromMap(map[string]string{
+			"":                       "private-key-store-password",
+			"private-key-some-alias": "private-key-key-password",
+		}, "")},
+	}
+	for _, keyStore := range keyStores {
+		file, err := os.Open(keyStore.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Reset()
+		err = Transcode(file, keyStore.format, "PEM", &out, keyStore.password)
+		file.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", keyStore.format, err)
+		}
+		var types []string
+		for rest := out.Bytes(); ; {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			if len(block.Headers) != 0 {
+				t.Errorf("%s: unexpected headers: %v", keyStore.format, block.Headers)
+			}
+			types = append(types, block.Type)
+		}
+		sort.Strings(types)
+		if len(types) != 2 || types[0] != "CERTIFICATE" || !strings.HasSuffix(types[1], "PRIVATE KEY") {
+			t.Errorf("%s: unexpected blocks: %v", keyStore.format, types)
+		}
+	}
+
+	for _, format := range []string{"PKCS12", "XML"} {
+		if err := Transcode(strings.NewReader(certPEM), "", format, &out, nil); err == nil {
+			t.Errorf("expected error for output format %s", format)
+		}
+	}
+}
+
+func TestCompressedWriter(t *testing.T) {
+	pemData := readTestFile(t, "../test-certs/example-leaf.crt")
+
+	for _, level := range []int{gzip.DefaultCompression, gzip.BestSpeed, gzip.BestCompression} {
+		var out bytes.Buffer
+		w, err := CompressedWriter(&out, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Transcode(strings.NewReader(pemData), "PEM", "PEM", w, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		gz, err := gzip.NewReader(&out)
+		if err != nil {
+			t.Fatalf("level %d: %s", level, err)
+		}
+		decompressed, err := ioutil.ReadAll(gz)
+		if err != nil {
+			t.Fatalf("level %d: %s", level, err)
+		}
+		if block, _ := pem.Decode(decompressed); block == nil || block.Type != "CERTIFICATE" {
+			t.Errorf("level %d: unexpected output: %q", level, decompressed)
+		}
+	}
+
+	if _, err := CompressedWriter(ioutil.Discard, 42); err == nil {
+		t.Error("expected error for invalid compression level")
+	}
+}
+
+func TestEncodeAnnotatedPEM(t *testing.T) {
+	pemData := readTestFile(t, "../test-certs/example-leaf.crt")
+	var blocks []*pem.Block
+	err := ReadAsPEM([]io.Reader{strings.NewReader(pemData)}, "PEM", nil, func(block *pem.Block, format string) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil || len(blocks) != 1 {
+		t.Fatalf("unable to read test certificate: %v", err)
+	}
+
+	var out bytes.Buffer
+	blocks[0].Headers = nil
+	if err := EncodeAnnotatedPEM(&out, blocks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeAnnotatedPEM(&out, &pem.Block{Type: "PUBLIC KEY", Bytes: []byte{1, 2, 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "# Subject: C=US, ST=CA, O=certigo, OU=example, CN=example-leaf\n" +
+		"# Issuer: C=US, ST=CA, O=certigo, OU=example, CN=example-leaf\n" +
+		"# Valid: 2016-06-10 22:14 UTC to 2023-04-15 22:14 UTC\n" +
+		"# SHA-256: 20:32:4A:4B:92:F1:22:68:EF:9A:E0:25:FD:5C:79:D1:58:FF:74:57:40:09:21:96:E8:35:3C:00:31:51:75:11\n" +
+		"-----BEGIN CERTIFICATE-----\n"
+	if !strings.HasPrefix(out.String(), expected) {
+		t.Errorf("unexpected annotations:\n%s", out.String())
+	}
+	if strings.Count(out.String(), "# Subject:") != 1 {
+		t.Error("expected only the certificate to be annotated")
+	}
+
+	// The annotated output is still a valid bundle, and its format is
+	// detected as such
+	var types []string
+	err = ReadAsPEM([]io.Reader{bytes.NewReader(out.Bytes())}, "", nil, func(block *pem.Block, format string) error {
+		types = append(types, block.Type)
+		return nil
+	})
+	if err != nil || strings.Join(types, ",") != "CERTIFICATE,PUBLIC KEY" {
+		t.Errorf("unable to read annotated output: %v, %v", types, err)
+	}
+}
@@ -0,0 +1,188 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkcs7
+
+import (
+	"errors"
+)
+
+const (
+	// Maximum nesting depth we're willing to convert, to avoid blowing
+	// the stack on malicious input.
+	maxBERDepth = 64
+
+	berConstructed  = 0x20
+	berOctetString  = 0x04
+	berIndefinite   = 0x80
+	berHighTagForm  = 0x1f
+	berLongLenForm  = 0x80
+	berMaxLenOctets = 4
+)
+
+var errTruncatedBER = errors.New("truncated BER data")
+
+// berToDER converts the first BER-encoded element in data to DER, and
+// returns it along with any remaining data. Indefinite and non-minimal
+// lengths are rewritten to minimal definite lengths, and constructed
+// OCTET STRINGs (as produced by streaming encoders) are flattened into
+// primitive ones. It doesn't reorder SET members, since Go's ASN.1
+// parser doesn't insist on that.
+func berToDER(data []byte) (der, rest []byte, err error) {
+	tag, content, rest, err := berElement(data, 0)
+	if err != nil {
+		return nil, data, err
+	}
+	der = append(append(append([]byte{}, tag...), derLength(len(content))...), content...)
+	return der, rest, nil
+}
+
+// berElement parses a single BER element, returning its (identifier) tag
+// bytes, its DER-converted contents and the remaining data.
+func berElement(data []byte, depth int) (tag, content, rest []byte, err error) {
+	if depth > maxBERDepth {
+		return nil, nil, nil, errors.New("BER data nested too deeply")
+	}
+
+	// Identifier octets
+	if len(data) < 1 {
+		return nil, nil, nil, errTruncatedBER
+	}
+	offset := 1
+	if data[0]&berHighTagForm == berHighTagForm {
+		for {
+			if offset >= len(data) {
+				return nil, nil, nil, errTruncatedBER
+			}
+			offset++
+			if data[offset-1]&0x80 == 0 {
+				break
+			}
+		}
+	}
+	tag = data[:offset]
+	constructed := tag[0]&berConstructed != 0
+
+	// Length octets
+	if offset >= len(data) {
+		return nil, nil, nil, errTruncatedBER
+	}
+	lengthByte := data[offset]
+	offset++
+
+	if lengthByte == berIndefinite {
+		if !constructed {
+			return nil, nil, nil, errors.New("indefinite length on primitive BER element")
+		}
+		rest = data[offset:]
+		for {
+			if len(rest) < 2 {
+				return nil, nil, nil, errTruncatedBER
+			}
+			if rest[0] == 0 && rest[1] == 0 {
+				rest = rest[2:]
+				break
+			}
+			var child []byte
+			child, rest, err = berChild(rest, depth)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			content = append(content, child...)
+		}
+		tag, content, err = flattenOctetString(tag, content, depth)
+		return tag, content, rest, err
+	}
+
+	length := int(lengthByte)
+	if lengthByte&berLongLenForm != 0 {
+		numOctets := int(lengthByte &^ berLongLenForm)
+		if numOctets > berMaxLenOctets {
+			return nil, nil, nil, errors.New("BER length too large")
+		}
+		if offset+numOctets > len(data) {
+			return nil, nil, nil, errTruncatedBER
+		}
+		length = 0
+		for _, b := range data[offset : offset+numOctets] {
+			length = length<<8 | int(b)
+		}
+		offset += numOctets
+	}
+	if length < 0 || length > len(data)-offset {
+		return nil, nil, nil, errTruncatedBER
+	}
+	body := data[offset : offset+length]
+	rest = data[offset+length:]
+
+	if !constructed {
+		return tag, body, rest, nil
+	}
+
+	for len(body) > 0 {
+		var child []byte
+		child, body, err = berChild(body, depth)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		content = append(content, child...)
+	}
+	tag, content, err = flattenOctetString(tag, content, depth)
+	return tag, content, rest, err
+}
+
+// berChild converts a nested element to DER, including its tag and length.
+func berChild(data []byte, depth int) (der, rest []byte, err error) {
+	tag, content, rest, err := berElement(data, depth+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	der = append(append(append([]byte{}, tag...), derLength(len(content))...), content...)
+	return der, rest, nil
+}
+
+// flattenOctetString turns a constructed OCTET STRING into a primitive one,
+// by concatenating the contents of its (already converted) segments.
+func flattenOctetString(tag, content []byte, depth int) ([]byte, []byte, error) {
+	if len(tag) != 1 || tag[0] != berOctetString|berConstructed {
+		return tag, content, nil
+	}
+	var flat []byte
+	for len(content) > 0 {
+		segmentTag, segment, rest, err := berElement(content, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(segmentTag) != 1 || segmentTag[0] != berOctetString {
+			return nil, nil, errors.New("unexpected segment in constructed OCTET STRING")
+		}
+		flat = append(flat, segment...)
+		content = rest
+	}
+	return []byte{berOctetString}, flat, nil
+}
+
+// derLength encodes the given length in minimal (DER) form.
+func derLength(length int) []byte {
+	if length < berLongLenForm {
+		return []byte{byte(length)}
+	}
+	var octets []byte
+	for l := length; l > 0; l >>= 8 {
+		octets = append([]byte{byte(l)}, octets...)
+	}
+	return append([]byte{berLongLenForm | byte(len(octets))}, octets...)
+}
@@ -17,12 +17,18 @@
 package pkcs7
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/asn1"
 	"fmt"
+	"sort"
 )
 
-var signedDataIdentifier = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 7, 2})
+var (
+	signedDataIdentifier = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 7, 2})
+	dataIdentifier       = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 7, 1})
+)
 
 // SignedDataEnvelope represents a wrapped SignedData
 // object found in PEM-encoded PKCS7 blocks.
@@ -43,7 +49,18 @@ type SignedData struct {
 	SignerInfos      []asn1.RawValue `asn1:"set"`
 }
 
+// certsOnlySignedData mirrors SignedData, but carries the certificates as a
+// pre-encoded SET so that marshaling does not reorder them.
+type certsOnlySignedData struct {
+	Version          int
+	DigestAlgorithms []asn1.RawValue `asn1:"set"`
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue   `asn1:"optional"`
+	SignerInfos      []asn1.RawValue `asn1:"set"`
+}
+
 // ParseSignedData parses one (or more) signed data blocks from a byte array.
+// Blocks may be DER or BER-encoded (e.g. with indefinite lengths).
 func ParseSignedData(data []byte) ([]*SignedDataEnvelope, error) {
 	var err error
 	var block *SignedDataEnvelope
@@ -64,22 +81,34 @@ func parseSignedData(data []byte) (*SignedDataEnvelope, []byte, error) {
 	var envelope SignedDataEnvelope
 	rest, err := asn1.Unmarshal(data, &envelope)
 	if err != nil {
-		return nil, data, err
+		// Some tools (notably older Microsoft and Java ones) emit BER,
+		// often with indefinite lengths, which the DER parser rejects.
+		der, berRest, berErr := berToDER(data)
+		if berErr != nil {
+			return nil, data, err
+		}
+		if _, derErr := asn1.Unmarshal(der, &envelope); derErr != nil {
+			return nil, data, err
+		}
+		rest = berRest
 	}
 
 	if !signedDataIdentifier.Equal(envelope.Type) {
 		return nil, data, fmt.Errorf("unexpected object identifier (was %s, expecting %s)", envelope.Type.String(), signedDataIdentifier.String())
 	}
 
-	if envelope.SignedData.Version != 1 {
-		return nil, data, fmt.Errorf("unknown version number in signed data block (was %d, expecting 1)", envelope.SignedData.Version)
+	// Version 1 is PKCS7, CMS (RFC 5652) adds versions 3 to 5.
+	switch envelope.SignedData.Version {
+	case 1, 3, 4, 5:
+	default:
+		return nil, data, fmt.Errorf("unknown version number in signed data block (was %d, expecting 1, 3, 4 or 5)", envelope.SignedData.Version)
 	}
 
 	return &envelope, rest, nil
 }
 
-// ExtractCertificates reads a SignedData type and returns the embedded
-// certificates (if present in the structure).
+// ExtractCertificates reads a SignedData type and returns all embedded X.509
+// certificates (if present in the structure), in the order they appear.
 func ExtractCertificates(data []byte) ([]*x509.Certificate, error) {
 	blocks, err := ParseSignedData(data)
 	if err != nil {
@@ -89,6 +118,10 @@ func ExtractCertificates(data []byte) ([]*x509.Certificate, error) {
 	certs := []*x509.Certificate{}
 	for _, block := range blocks {
 		for _, raw := range block.SignedData.Certificates {
+			if raw.Class != asn1.ClassUniversal {
+				// Other CertificateChoices, such as attribute certificates
+				continue
+			}
 			cert, err := x509.ParseCertificate(raw.FullBytes)
 			if err != nil {
 				return nil, err
@@ -99,3 +132,87 @@ func ExtractCertificates(data []byte) ([]*x509.Certificate, error) {
 
 	return certs, nil
 }
+
+// ExtractCRLs reads a SignedData type and returns the DER encoding of all
+// embedded CRLs (if present in the structure), in the order they appear.
+func ExtractCRLs(data []byte) ([][]byte, error) {
+	blocks, err := ParseSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	crls := [][]byte{}
+	for _, block := range blocks {
+		for _, raw := range block.SignedData.RevocationLists {
+			if raw.Class != asn1.ClassUniversal {
+				// Other RevocationInfoChoices, such as OCSP responses
+				continue
+			}
+			crls = append(crls, raw.FullBytes)
+		}
+	}
+
+	return crls, nil
+}
+
+// BuildCertsOnly builds a degenerate "certs-only" SignedData block (as found
+// in .p7b files) holding the given certificates, with no content and no
+// signers. This is the inverse of ExtractCertificates.
+//
+// Certificates are sorted by their SHA-256 fingerprint before encoding, so
+// the same set of certificates always produces the same bytes regardless of
+// input order. Use BuildCertsOnlyInOrder if the order of the chain matters.
+func BuildCertsOnly(certs []*x509.Certificate) ([]byte, error) {
+	type keyed struct {
+		cert        *x509.Certificate
+		fingerprint [sha256.Size]byte
+	}
+	sorted := make([]keyed, len(certs))
+	for i, cert := range certs {
+		sorted[i] = keyed{cert, sha256.Sum256(cert.Raw)}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].fingerprint[:], sorted[j].fingerprint[:]) < 0
+	})
+
+	ordered := make([]*x509.Certificate, len(sorted))
+	for i := range sorted {
+		ordered[i] = sorted[i].cert
+	}
+	return BuildCertsOnlyInOrder(ordered)
+}
+
+// BuildCertsOnlyInOrder is like BuildCertsOnly, but keeps the certificates in
+// the order given by the caller.
+func BuildCertsOnlyInOrder(certs []*x509.Certificate) ([]byte, error) {
+	contentInfo, err := asn1.Marshal(struct {
+		Type asn1.ObjectIdentifier
+	}{dataIdentifier})
+	if err != nil {
+		return nil, err
+	}
+
+	// The certificates field is a SET OF, which encoding/asn1 would sort by
+	// encoding; build it by hand so that the caller's order is kept.
+	var rawCerts asn1.RawValue
+	if len(certs) > 0 {
+		rawCerts = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true}
+		for _, cert := range certs {
+			rawCerts.Bytes = append(rawCerts.Bytes, cert.Raw...)
+		}
+	}
+
+	return asn1.Marshal(struct {
+		Type       asn1.ObjectIdentifier
+		SignedData certsOnlySignedData `asn1:"tag:0,explicit"`
+	}{
+		Type: signedDataIdentifier,
+		SignedData: certsOnlySignedData{
+			Version:          1,
+			DigestAlgorithms: []asn1.RawValue{},
+			ContentInfo:      asn1.RawValue{FullBytes: contentInfo},
+			Certificates:     rawCerts,
+			SignerInfos:      []asn1.RawValue{},
+		},
+	})
+}
@@ -17,8 +17,14 @@
 package pkcs7
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
 	"encoding/base64"
+	"io/ioutil"
 	"testing"
+	"time"
 )
 
 var testBlock, _ = base64.StdEncoding.DecodeString(`
@@ -45,3 +51,136 @@ func TestExtract(t *testing.T) {
 		t.Fatalf("expected 1 certs, but found %d", len(certs))
 	}
 }
+
+func TestExtractIndefiniteLengthBER(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/indefinite-length.p7b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ExtractCertificates(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 {
+		t.Fatalf("expected 1 certs, but found %d", len(certs))
+	}
+	if certs[0].Subject.CommonName != "example-elliptic-sha1" {
+		t.Fatalf("unexpected certificate: %s", certs[0].Subject.CommonName)
+	}
+}
+
+func TestBERToDERIsIdentityOnDER(t *testing.T) {
+	der, rest, err := berToDER(testBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 || !bytes.Equal(der, testBlock) {
+		t.Fatal("converting DER input should not change it")
+	}
+}
+
+func TestParseTimestampToken(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/timestamp-token.der")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, certs, err := ParseTimestampToken(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.Policy.Equal(asn1.ObjectIdentifier{1, 2, 3, 4, 1}) {
+		t.Errorf("unexpected policy: %s", info.Policy)
+	}
+	if !info.GenTime.Equal(time.Date(2026, 10, 15, 23, 56, 57, 0, time.UTC)) {
+		t.Errorf("unexpected time: %s", info.GenTime)
+	}
+	expected := sha256.Sum256([]byte("hello\n"))
+	if !bytes.Equal(info.MessageImprint.HashedMessage, expected[:]) {
+		t.Errorf("unexpected hashed message: %x", info.MessageImprint.HashedMessage)
+	}
+	if info.Accuracy.Seconds != 1 || info.Accuracy.Millis != 500 || !info.Ordering {
+		t.Errorf("unexpected accuracy/ordering: %+v, %t", info.Accuracy, info.Ordering)
+	}
+
+	if len(certs) != 1 || certs[0].Subject.CommonName != "example-tsa" {
+		t.Fatalf("unexpected certificates: %v", certs)
+	}
+}
+
+func TestBuildCertsOnly(t *testing.T) {
+	certs, err := ExtractCertificates(testBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	built, err := BuildCertsOnly(certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extracted, err := ExtractCertificates(built)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extracted) != 1 || !extracted[0].Equal(certs[0]) {
+		t.Fatal("certificates in built block differ")
+	}
+}
+
+func TestBuildCertsOnlyOrdering(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/windows-chain.p7b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ExtractCertificates(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed := []*x509.Certificate{certs[2], certs[1], certs[0]}
+
+	a, err := BuildCertsOnly(certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := BuildCertsOnly(reversed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Error("BuildCertsOnly output depends on input order")
+	}
+
+	inOrder, err := BuildCertsOnlyInOrder(reversed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extracted, err := ExtractCertificates(inOrder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range reversed {
+		if !extracted[i].Equal(reversed[i]) {
+			t.Errorf("certificate %d not in caller-supplied order", i)
+		}
+	}
+}
+
+func TestExtractChain(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/windows-chain.p7b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ExtractCertificates(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 {
+		t.Fatalf("expected 3 certs, but found %d", len(certs))
+	}
+	expected := []string{"Windows Test Root", "windows.example.com", "Windows Test Intermediate"}
+	for i, cert := range certs {
+		if cert.Subject.CommonName != expected[i] {
+			t.Errorf("unexpected certificate %d: %s", i, cert.Subject.CommonName)
+		}
+	}
+}
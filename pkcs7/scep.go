@@ -0,0 +1,185 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkcs7
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// SCEP message attributes, see RFC 8894, Section 3.2.1.
+var (
+	scepMessageTypeIdentifier   = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 113733, 1, 9, 2})
+	scepPKIStatusIdentifier     = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 113733, 1, 9, 3})
+	scepFailInfoIdentifier      = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 113733, 1, 9, 4})
+	scepTransactionIDIdentifier = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 113733, 1, 9, 7})
+
+	envelopedDataIdentifier = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 7, 3})
+)
+
+// scepMessageTypeCertRep is the messageType of a SCEP CertRep message.
+const scepMessageTypeCertRep = "3"
+
+// SCEP pkiStatus values, as found in CertRep.Status.
+const (
+	SCEPStatusSuccess = "SUCCESS"
+	SCEPStatusFailure = "FAILURE"
+	SCEPStatusPending = "PENDING"
+)
+
+var scepStatusNames = map[string]string{
+	"0": SCEPStatusSuccess,
+	"2": SCEPStatusFailure,
+	"3": SCEPStatusPending,
+}
+
+var scepFailInfoNames = map[string]string{
+	"0": "badAlg",
+	"1": "badMessageCheck",
+	"2": "badRequest",
+	"3": "badTime",
+	"4": "badCertId",
+}
+
+// CertRep is a SCEP CertRep message, the response of a CA to an enrollment
+// request. Refer to RFC 8894, Section 3.3.2 for details.
+type CertRep struct {
+	// Status is the pkiStatus of the response (SUCCESS, FAILURE or
+	// PENDING), and FailInfo the reason for a failure (e.g. "badRequest").
+	Status   string
+	FailInfo string
+
+	TransactionID string
+
+	// Certificates holds the issued certificate and its chain. These can
+	// only be read if the response isn't encrypted, see Encrypted.
+	Certificates []*x509.Certificate
+
+	// SignerCertificates holds the certificates in the outer SignedData,
+	// typically those of the CA (or RA) that signed the response.
+	SignerCertificates []*x509.Certificate
+
+	// Encrypted is set if the certificates are in an EnvelopedData block
+	// (as is normal), which needs the requester's private key to decrypt.
+	Encrypted bool
+}
+
+// signerInfo is the part of a SignerInfo needed to read its attributes.
+// Refer to RFC 2315, Section 9.2 for definition of this type.
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     asn1.RawValue
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   []attribute `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes []attribute `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// ParseCertRep parses a SCEP CertRep message, checking its message type
+// and status. The certificates are extracted if the response holds a
+// (degenerate) SignedData block rather than an encrypted one. The signature
+// on the message is not verified.
+func ParseCertRep(der []byte) (*CertRep, error) {
+	block, _, err := parseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(block.SignedData.SignerInfos) != 1 {
+		return nil, fmt.Errorf("expected one signer in SCEP message, found %d", len(block.SignedData.SignerInfos))
+	}
+	var signer signerInfo
+	if _, err := asn1.Unmarshal(block.SignedData.SignerInfos[0].FullBytes, &signer); err != nil {
+		return nil, fmt.Errorf("unable to parse signer info: %s", err)
+	}
+
+	attributes := map[string]string{}
+	for _, attr := range signer.AuthenticatedAttributes {
+		if len(attr.Values) != 1 {
+			continue
+		}
+		var value string
+		if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &value); err == nil {
+			attributes[attr.Type.String()] = value
+		}
+	}
+
+	messageType, ok := attributes[scepMessageTypeIdentifier.String()]
+	if !ok {
+		return nil, errors.New("not a SCEP message (no messageType attribute)")
+	}
+	if messageType != scepMessageTypeCertRep {
+		return nil, fmt.Errorf("not a SCEP CertRep message (messageType was %s, expecting %s)", messageType, scepMessageTypeCertRep)
+	}
+
+	rep := &CertRep{TransactionID: attributes[scepTransactionIDIdentifier.String()]}
+	status := attributes[scepPKIStatusIdentifier.String()]
+	if rep.Status, ok = scepStatusNames[status]; !ok {
+		return nil, fmt.Errorf("invalid pkiStatus in SCEP CertRep message: '%s'", status)
+	}
+	if failInfo, ok := attributes[scepFailInfoIdentifier.String()]; ok {
+		if rep.FailInfo, ok = scepFailInfoNames[failInfo]; !ok {
+			rep.FailInfo = failInfo
+		}
+	}
+	if rep.Status == SCEPStatusFailure && rep.FailInfo == "" {
+		return nil, errors.New("SCEP CertRep message with FAILURE status has no failInfo")
+	}
+
+	for _, raw := range block.SignedData.Certificates {
+		if raw.Class != asn1.ClassUniversal {
+			continue
+		}
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		rep.SignerCertificates = append(rep.SignerCertificates, cert)
+	}
+
+	if rep.Status != SCEPStatusSuccess {
+		return rep, nil
+	}
+
+	var content encapsulatedContentInfo
+	if _, err := asn1.Unmarshal(block.SignedData.ContentInfo.FullBytes, &content); err != nil {
+		return nil, fmt.Errorf("unable to parse content info: %s", err)
+	}
+	if len(content.Content) == 0 {
+		return nil, errors.New("SCEP CertRep message with SUCCESS status has no content")
+	}
+	var inner struct {
+		Type asn1.ObjectIdentifier
+	}
+	if _, err := asn1.Unmarshal(content.Content, &inner); err == nil && envelopedDataIdentifier.Equal(inner.Type) {
+		rep.Encrypted = true
+		return rep, nil
+	}
+	rep.Certificates, err = ExtractCertificates(content.Content)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificates from SCEP CertRep message: %s", err)
+	}
+	return rep, nil
+}
@@ -0,0 +1,134 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkcs7
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+// buildCertRep builds an (unsigned) SCEP message with the given attributes
+// and content, reusing the certificate in testBlock as signer certificate.
+func buildCertRep(t *testing.T, attributes map[string]string, content []byte) []byte {
+	var attrs []attribute
+	for name, oid := range map[string]asn1.ObjectIdentifier{
+		"messageType":   scepMessageTypeIdentifier,
+		"pkiStatus":     scepPKIStatusIdentifier,
+		"failInfo":      scepFailInfoIdentifier,
+		"transactionID": scepTransactionIDIdentifier,
+	} {
+		if attributes[name] == "" {
+			continue
+		}
+		raw, err := asn1.MarshalWithParams(attributes[name], "printable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs = append(attrs, attribute{Type: oid, Values: []asn1.RawValue{{FullBytes: raw}}})
+	}
+	signer, err := asn1.Marshal(signerInfo{
+		Version:                   1,
+		IssuerAndSerialNumber:     asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true},
+		DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}},
+		AuthenticatedAttributes:   attrs,
+		DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+		EncryptedDigest:           []byte{0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contentInfo, err := asn1.Marshal(encapsulatedContentInfo{Type: dataIdentifier, Content: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := ParseSignedData(testBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(SignedDataEnvelope{
+		Type: signedDataIdentifier,
+		SignedData: SignedData{
+			Version:          1,
+			DigestAlgorithms: []asn1.RawValue{},
+			ContentInfo:      asn1.RawValue{FullBytes: contentInfo},
+			Certificates:     blocks[0].SignedData.Certificates,
+			SignerInfos:      []asn1.RawValue{{FullBytes: signer}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestParseCertRep(t *testing.T) {
+	certs, err := ExtractCertificates(testBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certsOnly, err := BuildCertsOnly(certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := asn1.Marshal(struct {
+		Type asn1.ObjectIdentifier
+	}{envelopedDataIdentifier})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := ParseCertRep(buildCertRep(t, map[string]string{"messageType": "3", "pkiStatus": "0", "transactionID": "1234"}, certsOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != SCEPStatusSuccess || rep.TransactionID != "1234" || rep.Encrypted || len(rep.Certificates) != 1 || len(rep.SignerCertificates) != 1 {
+		t.Errorf("unexpected CertRep: %+v", rep)
+	}
+
+	rep, err = ParseCertRep(buildCertRep(t, map[string]string{"messageType": "3", "pkiStatus": "0"}, envelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.Encrypted || len(rep.Certificates) != 0 {
+		t.Errorf("unexpected CertRep: %+v", rep)
+	}
+
+	rep, err = ParseCertRep(buildCertRep(t, map[string]string{"messageType": "3", "pkiStatus": "2", "failInfo": "2"}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != SCEPStatusFailure || rep.FailInfo != "badRequest" {
+		t.Errorf("unexpected CertRep: %+v", rep)
+	}
+
+	for i, attributes := range []map[string]string{
+		{"pkiStatus": "0"},
+		{"messageType": "19", "pkiStatus": "0"},
+		{"messageType": "3", "pkiStatus": "9"},
+		{"messageType": "3", "pkiStatus": "2"},
+		{"messageType": "3", "pkiStatus": "0"},
+	} {
+		if _, err := ParseCertRep(buildCertRep(t, attributes, nil)); err == nil {
+			t.Errorf("case %d: expected error for invalid CertRep", i)
+		}
+	}
+	if _, err := ParseCertRep(testBlock); err == nil {
+		t.Error("expected error for SignedData without signer")
+	}
+}
@@ -0,0 +1,101 @@
+/*-
+ * Copyright 2020 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pkcs7
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var tstInfoIdentifier = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 9, 16, 1, 4})
+
+// TSTInfo is the signed content of an RFC 3161 time-stamp token.
+// Refer to RFC 3161, Section 2.4.2 for definition of this type.
+type TSTInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint MessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       Accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional"`
+	Nonce          *big.Int  `asn1:"optional"`
+	// TSA is the (optional) GeneralName of the time-stamping authority.
+	TSA        asn1.RawValue    `asn1:"optional,explicit,tag:0"`
+	Extensions []pkix.Extension `asn1:"optional,tag:1"`
+}
+
+// MessageImprint is the hash of the time-stamped data.
+type MessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+// Accuracy is the time deviation around GenTime.
+type Accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type encapsulatedContentInfo struct {
+	Type    asn1.ObjectIdentifier
+	Content []byte `asn1:"explicit,optional,tag:0"`
+}
+
+// ParseTimestampToken parses an RFC 3161 time-stamp token, i.e. a SignedData
+// block wrapping a TSTInfo. Returns the decoded TSTInfo along with the
+// embedded certificates (typically those of the time-stamping authority).
+// The signature on the token is not verified.
+func ParseTimestampToken(der []byte) (*TSTInfo, []*x509.Certificate, error) {
+	block, _, err := parseSignedData(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var content encapsulatedContentInfo
+	if _, err := asn1.Unmarshal(block.SignedData.ContentInfo.FullBytes, &content); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse content info: %s", err)
+	}
+	if !tstInfoIdentifier.Equal(content.Type) {
+		return nil, nil, fmt.Errorf("unexpected content type (was %s, expecting %s)", content.Type.String(), tstInfoIdentifier.String())
+	}
+	if len(content.Content) == 0 {
+		return nil, nil, errors.New("time-stamp token has no content")
+	}
+
+	info := &TSTInfo{}
+	if _, err := asn1.Unmarshal(content.Content, info); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse TSTInfo: %s", err)
+	}
+
+	certs := []*x509.Certificate{}
+	for _, raw := range block.SignedData.Certificates {
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	return info, certs, nil
+}